@@ -0,0 +1,413 @@
+// Package client is a Go client for the store and other RESP servers. One
+// Client is safe for concurrent use: concurrent calls share a small pool of
+// connections and are pipelined automatically, so there is no need to
+// batch by hand for throughput. Pipeline groups commands explicitly when
+// they should go out together.
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by calls on a closed Client.
+var ErrClosed = errors.New("client: closed")
+
+// maxRedirects bounds how many MOVED/ASK replies one call follows.
+const maxRedirects = 16
+
+// Options configures New. Zero values select the defaults noted per field.
+type Options struct {
+	// Addr is the server address, default "127.0.0.1:6379". With Cluster
+	// it is one of the seed nodes, see also Addrs.
+	Addr string
+	// Addrs are additional cluster seed nodes.
+	Addrs []string
+	// Cluster routes each command to the node that owns its key's slot
+	// and follows MOVED and ASK redirections.
+	Cluster bool
+
+	// PoolSize is the number of connections per node, default 4. Each one
+	// carries many in-flight commands.
+	PoolSize int
+	// DialTimeout bounds connecting and the handshake, default 5s.
+	DialTimeout time.Duration
+	// ReadTimeout bounds the wait for each reply, default 30s; a negative
+	// value disables it. Calls are also bounded by their context.
+	ReadTimeout time.Duration
+
+	// MaxRetries is how often a failed command is retried, default 3; a
+	// negative value disables retries. Only commands that cannot have run
+	// or are safe to repeat are retried after network errors.
+	MaxRetries int
+	// MinRetryBackoff and MaxRetryBackoff bound the jittered exponential
+	// backoff between retries, default 8ms and 512ms.
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+
+	// Protocol is 2 or 3, default 2. With 3 the connection is upgraded
+	// with HELLO and falls back to RESP2 if the server does not know it.
+	Protocol int
+	Username string
+	Password string
+	// DB is selected on every new connection.
+	DB int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Addr == "" && len(o.Addrs) == 0 {
+		o.Addr = "127.0.0.1:6379"
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = 4
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	switch {
+	case o.ReadTimeout == 0:
+		o.ReadTimeout = 30 * time.Second
+	case o.ReadTimeout < 0:
+		o.ReadTimeout = 0
+	}
+	switch {
+	case o.MaxRetries == 0:
+		o.MaxRetries = 3
+	case o.MaxRetries < 0:
+		o.MaxRetries = 0
+	}
+	if o.MinRetryBackoff <= 0 {
+		o.MinRetryBackoff = 8 * time.Millisecond
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = 512 * time.Millisecond
+	}
+	if o.Protocol != 3 {
+		o.Protocol = 2
+	}
+	return o
+}
+
+// Client is a handle to a server or cluster.
+type Client struct {
+	opts Options
+
+	mu     sync.Mutex
+	pools  map[string]*pool
+	closed bool
+
+	cluster *slotMap
+}
+
+// New returns a Client. Connections are dialed lazily, so New does not
+// fail when the server is down.
+func New(opts Options) *Client {
+	c := &Client{opts: opts.withDefaults(), pools: make(map[string]*pool)}
+	if c.opts.Cluster {
+		c.cluster = newSlotMap()
+	}
+	return c
+}
+
+// Close closes all connections. In-flight calls fail.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for _, p := range c.pools {
+		p.close()
+	}
+	return nil
+}
+
+func (c *Client) pool(addr string) (*pool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	p, ok := c.pools[addr]
+	if !ok {
+		p = &pool{addr: addr, opts: &c.opts, conns: make([]*conn, c.opts.PoolSize)}
+		c.pools[addr] = p
+	}
+	return p, nil
+}
+
+// pool holds a fixed number of multiplexed connections to one address,
+// picked round-robin and redialed when broken.
+type pool struct {
+	addr string
+	opts *Options
+	next atomic.Uint32
+
+	mu     sync.Mutex
+	conns  []*conn
+	closed bool
+}
+
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	i := int(p.next.Add(1)) % len(p.conns)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	if cn := p.conns[i]; cn != nil && !cn.broken() {
+		return cn, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.opts.DialTimeout)
+	defer cancel()
+	cn, err := dialConn(ctx, p.addr, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[i] = cn
+	return cn, nil
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, cn := range p.conns {
+		if cn != nil {
+			cn.close()
+		}
+	}
+}
+
+// Do sends a command and returns its reply as decoded by Reader. An error
+// reply is returned as an Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.exec(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return replyErr(replies[0])
+}
+
+func replyErr(v any) (any, error) {
+	if e, ok := v.(Error); ok {
+		return nil, e
+	}
+	return v, nil
+}
+
+// exec sends cmds together to one node and waits for all their replies.
+// It retries and, in cluster mode, follows redirections.
+func (c *Client) exec(ctx context.Context, cmds [][]string) ([]any, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	addr, err := c.route(ctx, cmds)
+	if err != nil {
+		return nil, err
+	}
+	asking := false
+	redirects := 0
+	for attempt := 0; ; attempt++ {
+		send := cmds
+		if asking {
+			send = append([][]string{{"ASKING"}}, cmds...)
+		}
+		replies, written, err := c.roundTrip(ctx, addr, send)
+		if asking && err == nil {
+			replies = replies[1:]
+		}
+		asking = false
+
+		retry := false
+		switch {
+		case err != nil:
+			retry = !written || readOnly(cmds)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		default:
+			e, ok := firstError(replies)
+			if !ok {
+				return replies, nil
+			}
+			switch e.Prefix() {
+			case "MOVED":
+				if c.cluster == nil || redirects >= maxRedirects {
+					return replies, nil
+				}
+				slot, to, ok := parseRedirect(e)
+				if !ok {
+					return replies, nil
+				}
+				c.cluster.set(slot, to)
+				addr, retry = to, true
+				// A MOVED means the slot map is stale; refresh it in the
+				// background for the other slots.
+				c.cluster.refreshAsync(c)
+				redirects++
+				attempt-- // redirections do not use up retries
+			case "ASK":
+				if c.cluster == nil || redirects >= maxRedirects {
+					return replies, nil
+				}
+				_, to, ok := parseRedirect(e)
+				if !ok {
+					return replies, nil
+				}
+				addr, asking, retry = to, true, true
+				redirects++
+				attempt--
+			case "LOADING", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN":
+				retry = true
+				err = e
+			default:
+				return replies, nil
+			}
+		}
+		if !retry || attempt >= c.opts.MaxRetries {
+			if err != nil {
+				return nil, err
+			}
+			return replies, nil
+		}
+		if err != nil {
+			if err := c.backoff(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// roundTrip reports whether the commands may have reached the server.
+func (c *Client) roundTrip(ctx context.Context, addr string, cmds [][]string) ([]any, bool, error) {
+	p, err := c.pool(addr)
+	if err != nil {
+		return nil, false, err
+	}
+	cn, err := p.get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	req := newRequest(ctx, cmds...)
+	if err := cn.send(req); err != nil {
+		return nil, false, err
+	}
+	select {
+	case <-req.done:
+		if req.err != nil {
+			return nil, req.written.Load(), req.err
+		}
+		return req.replies, true, nil
+	case <-ctx.Done():
+		return nil, req.written.Load(), ctx.Err()
+	}
+}
+
+func (c *Client) backoff(ctx context.Context, attempt int) error {
+	d := c.opts.MinRetryBackoff << attempt
+	if d <= 0 || d > c.opts.MaxRetryBackoff {
+		d = c.opts.MaxRetryBackoff
+	}
+	d = d/2 + rand.N(d/2+1)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func firstError(replies []any) (Error, bool) {
+	for _, v := range replies {
+		if e, ok := v.(Error); ok {
+			return e, true
+		}
+	}
+	return "", false
+}
+
+// parseRedirect parses "MOVED <slot> <addr>" and "ASK <slot> <addr>".
+func parseRedirect(e Error) (int, string, bool) {
+	f := strings.Fields(string(e))
+	if len(f) != 3 {
+		return 0, "", false
+	}
+	slot, err := strconv.Atoi(f[1])
+	if err != nil || slot < 0 || slot >= numSlots {
+		return 0, "", false
+	}
+	addr := f[2]
+	// Nodes may announce an empty host meaning "the one you talked to".
+	if strings.HasPrefix(addr, ":") {
+		addr = net.JoinHostPort("127.0.0.1", addr[1:])
+	}
+	return slot, addr, true
+}
+
+// readOnlyCommands are safe to resend when it is unknown whether they ran.
+var readOnlyCommands = map[string]bool{
+	"GET": true, "MGET": true, "EXISTS": true, "TTL": true, "PTTL": true,
+	"TYPE": true, "STRLEN": true, "GETRANGE": true, "GETBIT": true,
+	"BITCOUNT": true, "BITPOS": true, "BITFIELD_RO": true, "PFCOUNT": true,
+	"GEOPOS": true, "GEODIST": true, "GEOHASH": true, "GEOSEARCH": true,
+	"SCAN": true, "KEYS": true, "DBSIZE": true, "PING": true, "ECHO": true,
+	"INFO": true, "COMMAND": true, "OBJECT": true, "MEMORY": true,
+	"JSON.GET": true, "JSON.MGET": true, "JSON.TYPE": true,
+	"JSON.STRLEN": true, "JSON.ARRLEN": true, "JSON.OBJKEYS": true,
+	"JSON.OBJLEN": true,
+}
+
+func readOnly(cmds [][]string) bool {
+	for _, cmd := range cmds {
+		if len(cmd) == 0 || !readOnlyCommands[strings.ToUpper(cmd[0])] {
+			return false
+		}
+	}
+	return true
+}
+
+func formatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// Pipeline collects commands and sends them in one batch on Exec. It is
+// not safe for concurrent use.
+type Pipeline struct {
+	c    *Client
+	cmds [][]string
+}
+
+// Pipeline returns an empty pipeline.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Queue appends a command.
+func (p *Pipeline) Queue(args ...string) {
+	p.cmds = append(p.cmds, args)
+}
+
+// Len returns the number of queued commands.
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec sends the queued commands and returns one reply per command; error
+// replies are left in place as Error values. The pipeline is reset. In
+// cluster mode all keys must hash to the same slot.
+func (p *Pipeline) Exec(ctx context.Context) ([]any, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	return p.c.exec(ctx, cmds)
+}
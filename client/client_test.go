@@ -0,0 +1,113 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/kv"
+)
+
+// startServer serves an in-process store over RESP and returns a Client
+// connected to it.
+func startServer(t *testing.T, opts client.Options) *client.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	db, err := kv.Open(kv.WithRESP("tcp://"+addr, map[string]string{"event-loops": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	opts.Addr = addr
+	c := client.New(opts)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// TestHelpersAgainstServer runs every typed helper against the repo's own
+// server, so a helper the server cannot serve fails here.
+func TestHelpersAgainstServer(t *testing.T) {
+	for _, proto := range []int{2, 3} {
+		c := startServer(t, client.Options{Protocol: proto})
+		ctx := context.Background()
+		steps := []struct {
+			name string
+			run  func() (any, error)
+			want any
+		}{
+			{"ping", func() (any, error) { return nil, c.Ping(ctx) }, nil},
+			{"set", func() (any, error) { return nil, c.Set(ctx, "a", "1", 0) }, nil},
+			{"set ttl", func() (any, error) { return nil, c.Set(ctx, "b", "2", time.Minute) }, nil},
+			{"get", func() (any, error) { return c.Get(ctx, "a") }, "1"},
+			{"ttl none", func() (any, error) { return c.TTL(ctx, "a") }, -time.Second},
+			{"ttl set", func() (any, error) { return c.TTL(ctx, "b") }, time.Minute},
+			{"ttl missing", func() (any, error) { return c.TTL(ctx, "nope") }, -2 * time.Second},
+			{"setnx taken", func() (any, error) { return c.SetNX(ctx, "a", "x") }, false},
+			{"setnx", func() (any, error) { return c.SetNX(ctx, "c", "x") }, true},
+			{"incr", func() (any, error) { return c.Incr(ctx, "a") }, int64(2)},
+			{"incrby", func() (any, error) { return c.IncrBy(ctx, "a", 10) }, int64(12)},
+			{"decr", func() (any, error) { return c.Decr(ctx, "a") }, int64(11)},
+			{"expire", func() (any, error) { return c.Expire(ctx, "a", time.Hour) }, true},
+			{"ttl after expire", func() (any, error) { return c.TTL(ctx, "a") }, time.Hour},
+			{"exists", func() (any, error) { return c.Exists(ctx, "a", "b", "nope", "a") }, int64(3)},
+			{"mset", func() (any, error) { return nil, c.MSet(ctx, "m1", "x", "m2", "y") }, nil},
+			{"dbsize", func() (any, error) { return c.DBSize(ctx) }, int64(5)},
+			{"del", func() (any, error) { return c.Del(ctx, "a", "b", "nope") }, int64(2)},
+			{"exists after del", func() (any, error) { return c.Exists(ctx, "a") }, int64(0)},
+			{"flushdb", func() (any, error) { return nil, c.FlushDB(ctx) }, nil},
+			{"dbsize after flush", func() (any, error) { return c.DBSize(ctx) }, int64(0)},
+		}
+		for _, st := range steps {
+			got, err := st.run()
+			if err != nil {
+				t.Fatalf("RESP%d %s: %v", proto, st.name, err)
+			}
+			if got != st.want {
+				t.Fatalf("RESP%d %s: got %v, want %v", proto, st.name, got, st.want)
+			}
+		}
+		if _, err := c.Get(ctx, "a"); !errors.Is(err, client.ErrNil) {
+			t.Fatalf("RESP%d get missing: %v", proto, err)
+		}
+	}
+}
+
+func TestMGetAndScan(t *testing.T) {
+	c := startServer(t, client.Options{})
+	ctx := context.Background()
+	if err := c.MSet(ctx, "k1", "v1", "k2", "v2", "other", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	values, found, err := c.MGet(ctx, "k1", "missing", "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != "v1" || values[2] != "v2" || !found[0] || found[1] || !found[2] {
+		t.Fatalf("MGet = %q %v", values, found)
+	}
+	seen := map[string]bool{}
+	for cursor := uint64(0); ; {
+		keys, next, err := c.Scan(ctx, cursor, "k*", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			seen[k] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 2 || !seen["k1"] || !seen["k2"] {
+		t.Fatalf("Scan found %v", seen)
+	}
+}
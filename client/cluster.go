@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const numSlots = 16384
+
+// Slot returns the cluster hash slot of key. Only the part inside the first
+// non-empty {hash tag} is hashed, so related keys can share a slot.
+func Slot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key) % numSlots)
+}
+
+// crc16 is CRC-16/XMODEM as used for cluster slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// slotMap records which node serves each slot. Slots not known yet go to a
+// seed node, which answers with MOVED if it is the wrong one.
+type slotMap struct {
+	mu    sync.RWMutex
+	nodes [numSlots]string
+	ready bool
+
+	refreshing atomic.Bool
+	lastLoad   atomic.Int64
+}
+
+func newSlotMap() *slotMap {
+	return &slotMap{}
+}
+
+func (m *slotMap) get(slot int) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.nodes[slot]
+}
+
+func (m *slotMap) set(slot int, addr string) {
+	m.mu.Lock()
+	m.nodes[slot] = addr
+	m.mu.Unlock()
+}
+
+func (m *slotMap) loaded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ready
+}
+
+// refresh reloads the map with CLUSTER SLOTS from the first seed or known
+// node that answers.
+func (m *slotMap) refresh(ctx context.Context, c *Client) error {
+	m.lastLoad.Store(time.Now().UnixNano())
+	var lastErr error
+	for _, addr := range m.candidates(c) {
+		replies, _, err := c.roundTrip(ctx, addr, [][]string{{"CLUSTER", "SLOTS"}})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if e, ok := replies[0].(Error); ok {
+			lastErr = e
+			continue
+		}
+		ranges, ok := replies[0].([]any)
+		if !ok {
+			lastErr = errProtocol
+			continue
+		}
+		var nodes [numSlots]string
+		for _, r := range ranges {
+			start, end, node, ok := parseSlotRange(r, addr)
+			if !ok {
+				continue
+			}
+			for s := start; s <= end; s++ {
+				nodes[s] = node
+			}
+		}
+		m.mu.Lock()
+		m.nodes = nodes
+		m.ready = true
+		m.mu.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+// refreshAsync reloads the map in the background, at most once a second.
+func (m *slotMap) refreshAsync(c *Client) {
+	if time.Now().UnixNano()-m.lastLoad.Load() < int64(time.Second) {
+		return
+	}
+	if !m.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer m.refreshing.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.DialTimeout)
+		defer cancel()
+		_ = m.refresh(ctx, c)
+	}()
+}
+
+func (m *slotMap) candidates(c *Client) []string {
+	addrs := c.seeds()
+	seen := make(map[string]bool, len(addrs))
+	for _, a := range addrs {
+		seen[a] = true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, a := range m.nodes {
+		if a != "" && !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// parseSlotRange parses one CLUSTER SLOTS entry: start, end and the master
+// as [host, port, ...]. An empty host means the node that was asked.
+func parseSlotRange(v any, asked string) (int, int, string, bool) {
+	r, ok := v.([]any)
+	if !ok || len(r) < 3 {
+		return 0, 0, "", false
+	}
+	start, ok1 := r[0].(int64)
+	end, ok2 := r[1].(int64)
+	master, ok3 := r[2].([]any)
+	if !ok1 || !ok2 || !ok3 || len(master) < 2 || start < 0 || end >= numSlots || start > end {
+		return 0, 0, "", false
+	}
+	host, _ := master[0].(string)
+	port, ok := master[1].(int64)
+	if !ok {
+		return 0, 0, "", false
+	}
+	if host == "" || host == "?" {
+		host, _, _ = net.SplitHostPort(asked)
+	}
+	return int(start), int(end), net.JoinHostPort(host, strconv.FormatInt(port, 10)), true
+}
+
+func (c *Client) seeds() []string {
+	var addrs []string
+	if c.opts.Addr != "" {
+		addrs = append(addrs, c.opts.Addr)
+	}
+	return append(addrs, c.opts.Addrs...)
+}
+
+// route picks the node for cmds by the key of the first command that has
+// one. Without Cluster every command goes to Addr.
+func (c *Client) route(ctx context.Context, cmds [][]string) (string, error) {
+	seed := c.seeds()[0]
+	if c.cluster == nil {
+		return seed, nil
+	}
+	if !c.cluster.loaded() {
+		// A node that does not support CLUSTER SLOTS still works through
+		// MOVED replies, so a failed load is not fatal and is retried only
+		// in the background.
+		if c.cluster.lastLoad.Load() == 0 {
+			_ = c.cluster.refresh(ctx, c)
+		} else {
+			c.cluster.refreshAsync(c)
+		}
+	}
+	for _, cmd := range cmds {
+		if len(cmd) < 2 || keylessCommands[strings.ToUpper(cmd[0])] {
+			continue
+		}
+		if addr := c.cluster.get(Slot(cmd[1])); addr != "" {
+			return addr, nil
+		}
+		break
+	}
+	return seed, nil
+}
+
+// keylessCommands take arguments that are not keys and go to a seed node.
+var keylessCommands = map[string]bool{
+	"PING": true, "ECHO": true, "INFO": true, "CONFIG": true, "CLIENT": true,
+	"CLUSTER": true, "COMMAND": true, "SCAN": true, "SELECT": true,
+	"HELLO": true, "AUTH": true, "SLOWLOG": true, "LATENCY": true,
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrNil is returned by typed helpers when the server replies with a null,
+// e.g. GET of a missing key.
+var ErrNil = errors.New("client: nil reply")
+
+// String converts a reply to a string.
+func String(v any, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", ErrNil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	}
+	return "", fmt.Errorf("client: unexpected reply type %T", v)
+}
+
+// Int converts an integer reply, or a string holding one, to int64.
+func Int(v any, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return 0, ErrNil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("client: unexpected reply type %T", v)
+}
+
+// Bool converts an integer or boolean reply to bool.
+func Bool(v any, err error) (bool, error) {
+	if b, ok := v.(bool); ok && err == nil {
+		return b, nil
+	}
+	n, err := Int(v, err)
+	return n != 0, err
+}
+
+// Strings converts an array reply to strings; null elements become "".
+func Strings(v any, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]any)
+	if !ok {
+		if v == nil {
+			return nil, ErrNil
+		}
+		return nil, fmt.Errorf("client: unexpected reply type %T", v)
+	}
+	out := make([]string, len(arr))
+	for i, e := range arr {
+		if e == nil {
+			continue
+		}
+		if out[i], err = String(e, nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func ok(v any, err error) error {
+	if err != nil {
+		return err
+	}
+	if s, _ := v.(string); s != "OK" {
+		return fmt.Errorf("client: unexpected reply %v", v)
+	}
+	return nil
+}
+
+// Ping checks the connection.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Get returns the value of key or ErrNil.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return String(c.Do(ctx, "GET", key))
+}
+
+// Set stores value under key. A ttl > 0 sets an expiration with
+// millisecond precision.
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if ttl > 0 {
+		return ok(c.Do(ctx, "SET", key, value, "PX", formatInt(ttl.Milliseconds())))
+	}
+	return ok(c.Do(ctx, "SET", key, value))
+}
+
+// SetNX sets key only if it does not exist and reports whether it did.
+func (c *Client) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return Bool(c.Do(ctx, "SETNX", key, value))
+}
+
+// Incr increments the integer at key and returns the new value.
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	return Int(c.Do(ctx, "INCR", key))
+}
+
+// IncrBy adds delta to the integer at key and returns the new value.
+func (c *Client) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return Int(c.Do(ctx, "INCRBY", key, formatInt(delta)))
+}
+
+// Decr decrements the integer at key and returns the new value.
+func (c *Client) Decr(ctx context.Context, key string) (int64, error) {
+	return Int(c.Do(ctx, "DECR", key))
+}
+
+// Expire sets a timeout in whole seconds and reports whether key exists.
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return Bool(c.Do(ctx, "EXPIRE", key, formatInt(int64(ttl/time.Second))))
+}
+
+// TTL returns the remaining time to live of key. Like the command it
+// returns -1s for a key without expiration and -2s for a missing key.
+func (c *Client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	n, err := Int(c.Do(ctx, "TTL", key))
+	return time.Duration(n) * time.Second, err
+}
+
+// Del removes keys and returns how many existed. In cluster mode the keys
+// must share a slot.
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	return Int(c.Do(ctx, append([]string{"DEL"}, keys...)...))
+}
+
+// Exists returns how many of keys exist.
+func (c *Client) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return Int(c.Do(ctx, append([]string{"EXISTS"}, keys...)...))
+}
+
+// MGet returns the values of keys; missing ones are "" with ok false.
+func (c *Client) MGet(ctx context.Context, keys ...string) ([]string, []bool, error) {
+	v, err := c.Do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := Strings(v, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := make([]bool, len(values))
+	for i, e := range v.([]any) {
+		found[i] = e != nil
+	}
+	return values, found, nil
+}
+
+// MSet sets key/value pairs.
+func (c *Client) MSet(ctx context.Context, pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("client: MSet needs key/value pairs")
+	}
+	return ok(c.Do(ctx, append([]string{"MSET"}, pairs...)...))
+}
+
+// Scan returns one batch of keys matching pattern ("" for all) and the
+// cursor to continue with; iteration is complete when it is 0.
+func (c *Client) Scan(ctx context.Context, cursor uint64, match string, count int) ([]string, uint64, error) {
+	args := []string{"SCAN", strconv.FormatUint(cursor, 10)}
+	if match != "" {
+		args = append(args, "MATCH", match)
+	}
+	if count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(count))
+	}
+	v, err := c.Do(ctx, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	arr, isArr := v.([]any)
+	if !isArr || len(arr) != 2 {
+		return nil, 0, fmt.Errorf("client: unexpected SCAN reply %v", v)
+	}
+	next, err := String(arr[0], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := strconv.ParseUint(next, 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	keys, err := Strings(arr[1], nil)
+	return keys, n, err
+}
+
+// DBSize returns the number of keys in the selected database.
+func (c *Client) DBSize(ctx context.Context) (int64, error) {
+	return Int(c.Do(ctx, "DBSIZE"))
+}
+
+// FlushDB removes all keys from the selected database.
+func (c *Client) FlushDB(ctx context.Context) error {
+	return ok(c.Do(ctx, "FLUSHDB"))
+}
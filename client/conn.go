@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBatchBytes caps how much the writer coalesces into one write.
+const maxBatchBytes = 64 * 1024
+
+var errConnClosed = errors.New("client: connection closed")
+
+// request is one or more commands that are written back to back and
+// answered in order. written tells retries whether the server may already
+// have executed them.
+type request struct {
+	ctx     context.Context
+	cmds    [][]string
+	replies []any
+	err     error
+	written atomic.Bool
+	done    chan struct{}
+}
+
+func newRequest(ctx context.Context, cmds ...[]string) *request {
+	return &request{ctx: ctx, cmds: cmds, done: make(chan struct{})}
+}
+
+func (r *request) finish(err error) {
+	r.err = err
+	close(r.done)
+}
+
+// conn multiplexes concurrent callers over one connection: the writer
+// coalesces whatever is queued into a single write (automatic pipelining)
+// and the reader hands replies back in order.
+type conn struct {
+	nc          net.Conn
+	rd          *Reader
+	readTimeout time.Duration
+
+	queue   chan *request
+	pending chan *request
+
+	mu     sync.RWMutex
+	dead   bool
+	closed chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func dialConn(ctx context.Context, addr string, opts *Options) (*conn, error) {
+	d := net.Dialer{Timeout: opts.DialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		nc:          nc,
+		rd:          NewReader(nc),
+		readTimeout: opts.ReadTimeout,
+		queue:       make(chan *request, 1024),
+		pending:     make(chan *request, 4096),
+		closed:      make(chan struct{}),
+	}
+	if err := c.handshake(ctx, opts); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	go c.writeLoop()
+	go c.readLoop()
+	return c, nil
+}
+
+// handshake runs synchronously before the loops start.
+func (c *conn) handshake(ctx context.Context, opts *Options) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.nc.SetDeadline(deadline)
+		defer c.nc.SetDeadline(time.Time{})
+	}
+	call := func(args ...string) (any, error) {
+		if _, err := c.nc.Write(AppendCommand(nil, args)); err != nil {
+			return nil, err
+		}
+		return c.rd.Read()
+	}
+	if opts.Protocol == 3 {
+		args := []string{"HELLO", "3"}
+		if opts.Password != "" {
+			args = append(args, "AUTH", opts.Username, opts.Password)
+		}
+		v, err := call(args...)
+		if err != nil {
+			return err
+		}
+		if e, ok := v.(Error); ok {
+			// Servers without HELLO keep speaking RESP2.
+			if !isUnknownCommand(e) {
+				return e
+			}
+			if err := c.auth(call, opts); err != nil {
+				return err
+			}
+		}
+	} else if err := c.auth(call, opts); err != nil {
+		return err
+	}
+	if opts.DB != 0 {
+		v, err := call("SELECT", formatInt(int64(opts.DB)))
+		if err != nil {
+			return err
+		}
+		if e, ok := v.(Error); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *conn) auth(call func(args ...string) (any, error), opts *Options) error {
+	if opts.Password == "" {
+		return nil
+	}
+	args := []string{"AUTH", opts.Password}
+	if opts.Username != "" {
+		args = []string{"AUTH", opts.Username, opts.Password}
+	}
+	v, err := call(args...)
+	if err != nil {
+		return err
+	}
+	if e, ok := v.(Error); ok {
+		return e
+	}
+	return nil
+}
+
+func isUnknownCommand(e Error) bool {
+	return e.Prefix() == "ERR" && len(e) > 20 && string(e[:20]) == "ERR unknown command "
+}
+
+// send queues req. The reply arrives through req.done.
+func (c *conn) send(req *request) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.dead {
+		return c.err
+	}
+	select {
+	case c.queue <- req:
+		return nil
+	case <-c.closed:
+		return c.err
+	}
+}
+
+func (c *conn) broken() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail closes the connection and fails everything still queued. Requests
+// already handed to the reader are failed by it.
+func (c *conn) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.closed)
+		_ = c.nc.Close()
+	})
+	c.mu.Lock()
+	c.dead = true
+	c.mu.Unlock()
+	for {
+		select {
+		case req := <-c.queue:
+			req.finish(c.err)
+		default:
+			return
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.fail(errConnClosed)
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.pending)
+	var buf []byte
+	var batch []*request
+	for {
+		var req *request
+		select {
+		case req = <-c.queue:
+		case <-c.closed:
+			return
+		}
+		buf, batch = buf[:0], batch[:0]
+		for {
+			if err := req.ctx.Err(); err != nil {
+				req.finish(err)
+			} else {
+				for _, cmd := range req.cmds {
+					buf = AppendCommand(buf, cmd)
+				}
+				batch = append(batch, req)
+			}
+			if len(buf) >= maxBatchBytes {
+				break
+			}
+			select {
+			case req = <-c.queue:
+				continue
+			default:
+			}
+			break
+		}
+		for _, r := range batch {
+			r.written.Store(true)
+			select {
+			case c.pending <- r:
+			case <-c.closed:
+				r.finish(c.err)
+			}
+		}
+		if len(buf) == 0 {
+			continue
+		}
+		if _, err := c.nc.Write(buf); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+func (c *conn) readLoop() {
+	for req := range c.pending {
+		if c.broken() {
+			req.finish(c.err)
+			continue
+		}
+		if err := c.readReplies(req); err != nil {
+			req.finish(err)
+			c.fail(err)
+			continue
+		}
+		req.finish(nil)
+	}
+}
+
+func (c *conn) readReplies(req *request) error {
+	req.replies = make([]any, 0, len(req.cmds))
+	for len(req.replies) < len(req.cmds) {
+		if c.readTimeout > 0 {
+			_ = c.nc.SetReadDeadline(time.Now().Add(c.readTimeout))
+		}
+		v, err := c.rd.Read()
+		if err != nil {
+			return err
+		}
+		if _, ok := v.(Push); ok {
+			continue
+		}
+		req.replies = append(req.replies, v)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Error is an error reply sent by the server.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Prefix returns the error code, e.g. "MOVED" or "ERR".
+func (e Error) Prefix() string {
+	code, _, _ := strings.Cut(string(e), " ")
+	return code
+}
+
+// Push is an out-of-band RESP3 push message.
+type Push []any
+
+// Status is a simple string reply, returned instead of string by a Reader
+// with KeepStatus set.
+type Status string
+
+var errProtocol = errors.New("client: protocol error")
+
+// Reader decodes RESP2 and RESP3 replies into Go values:
+//
+//	simple, bulk and verbatim strings  string
+//	errors (simple and blob)           Error
+//	integers                           int64
+//	doubles                            float64
+//	big numbers                        *big.Int
+//	booleans                           bool
+//	nulls                              nil
+//	arrays and sets                    []any
+//	maps                               map[string]any (keys formatted with %v)
+//	pushes                             Push
+//
+// Attributes are read and discarded.
+type Reader struct {
+	// KeepStatus decodes simple strings as Status, for callers that show
+	// replies the way the server sent them.
+	KeepStatus bool
+
+	br *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReaderSize(r, 32*1024)}
+}
+
+// Read returns the next reply. An error reply is returned as a value of
+// type Error with a nil error; the error result is for I/O and protocol
+// failures only.
+func (r *Reader) Read() (any, error) {
+	line, err := r.line()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+	body := line[1:]
+	switch line[0] {
+	case '+':
+		if r.KeepStatus {
+			return Status(body), nil
+		}
+		return string(body), nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		return strconv.ParseInt(string(body), 10, 64)
+	case '$', '=', '!':
+		n, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.br, buf); err != nil {
+			return nil, err
+		}
+		s := string(buf[:n])
+		switch line[0] {
+		case '=':
+			// Verbatim strings start with a three-letter format and ':'.
+			if len(s) >= 4 {
+				s = s[4:]
+			}
+		case '!':
+			return Error(s), nil
+		}
+		return s, nil
+	case '_':
+		return nil, nil
+	case '#':
+		return string(body) == "t", nil
+	case ',':
+		switch string(body) {
+		case "inf":
+			return math.Inf(1), nil
+		case "-inf":
+			return math.Inf(-1), nil
+		}
+		return strconv.ParseFloat(string(body), 64)
+	case '(':
+		n, ok := new(big.Int).SetString(string(body), 10)
+		if !ok {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '*', '~', '>':
+		n, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = r.Read(); err != nil {
+				return nil, err
+			}
+		}
+		if line[0] == '>' {
+			return Push(arr), nil
+		}
+		return arr, nil
+	case '%', '|':
+		n, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, errProtocol
+		}
+		m := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			k, err := r.Read()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.Read()
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok {
+				m[s] = v
+			} else {
+				m[fmt.Sprint(k)] = v
+			}
+		}
+		if line[0] == '|' {
+			// An attribute precedes the reply it describes.
+			return r.Read()
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected type byte %q", errProtocol, line[0])
+}
+
+func (r *Reader) line() ([]byte, error) {
+	line, err := r.br.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errProtocol
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// AppendCommand encodes args as a RESP array of bulk strings.
+func AppendCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
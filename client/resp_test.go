@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name, in   string
+		keepStatus bool
+		want       any
+	}{
+		{"simple string", "+OK\r\n", false, "OK"},
+		{"status", "+OK\r\n", true, Status("OK")},
+		{"error", "-ERR unknown command\r\n", false, Error("ERR unknown command")},
+		{"integer", ":-42\r\n", false, int64(-42)},
+		{"bulk", "$5\r\nhe\r\no\r\n", false, "he\r\no"},
+		{"empty bulk", "$0\r\n\r\n", false, ""},
+		{"nil bulk", "$-1\r\n", false, nil},
+		{"nil array", "*-1\r\n", false, nil},
+		{"nested array", "*3\r\n:1\r\n*2\r\n+a\r\n$-1\r\n*0\r\n", false, []any{int64(1), []any{"a", nil}, []any{}}},
+		{"null", "_\r\n", false, nil},
+		{"true", "#t\r\n", false, true},
+		{"false", "#f\r\n", false, false},
+		{"double", ",1.5e3\r\n", false, 1500.0},
+		{"inf", ",-inf\r\n", false, math.Inf(-1)},
+		{"big number", "(3492890328409238509324850943850943825024385\r\n", false, bigInt("3492890328409238509324850943850943825024385")},
+		{"verbatim", "=15\r\ntxt:Some string\r\n", false, "Some string"},
+		{"blob error", "!21\r\nSYNTAX invalid syntax\r\n", false, Error("SYNTAX invalid syntax")},
+		{"set", "~2\r\n+a\r\n+b\r\n", false, []any{"a", "b"}},
+		{"map", "%2\r\n+first\r\n:1\r\n:2\r\n#t\r\n", false, map[string]any{"first": int64(1), "2": true}},
+		{"push", ">2\r\n+message\r\n+hi\r\n", false, Push{"message", "hi"}},
+		{"attribute", "|1\r\n+ttl\r\n:3600\r\n$5\r\nvalue\r\n", false, "value"},
+	}
+	for _, tt := range tests {
+		r := NewReader(strings.NewReader(tt.in))
+		r.KeepStatus = tt.keepStatus
+		got, err := r.Read()
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %#v, %v, want %#v", tt.name, got, err, tt.want)
+		}
+		if _, err := r.Read(); err != io.EOF {
+			t.Errorf("%s: left input behind: %v", tt.name, err)
+		}
+	}
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name, in string
+		err      error
+	}{
+		{"no CR", "+OK\n", errProtocol},
+		{"empty line", "\r\n", errProtocol},
+		{"unknown type", "?1\r\n", errProtocol},
+		{"bad length", "$x\r\n", errProtocol},
+		{"bad array length", "*x\r\n", errProtocol},
+		{"bad big number", "(12a\r\n", errProtocol},
+		{"short bulk", "$10\r\nabc\r\n", io.ErrUnexpectedEOF},
+		{"short array", "*2\r\n:1\r\n", io.EOF},
+		{"line too long", "+" + strings.Repeat("x", 40*1024) + "\r\n", errProtocol},
+	}
+	for _, tt := range tests {
+		if _, err := NewReader(strings.NewReader(tt.in)).Read(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if _, err := NewReader(strings.NewReader(":12x\r\n")).Read(); err == nil {
+		t.Error("bad integer: no error")
+	}
+}
+
+func TestAppendCommand(t *testing.T) {
+	got := string(AppendCommand([]byte("x"), []string{"SET", "k", "", "a\r\nb"}))
+	want := "x*4\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n$4\r\na\r\nb\r\n"
+	if got != want {
+		t.Errorf("%q, want %q", got, want)
+	}
+}
+
+func TestSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		// CRC-16/XMODEM's check value is 0x31c3.
+		{"123456789", 0x31c3},
+		// The examples of CLUSTER KEYSLOT's docs.
+		{"somekey", 11058},
+		{"foo{hash_tag}", 2515},
+		{"hash_tag", 2515},
+		// Only the first tag counts, and an empty one hashes the key.
+		{"{user1000}.following", Slot("user1000")},
+		{"foo{bar}{zap}", Slot("bar")},
+		{"foo{}{bar}", int(crc16("foo{}{bar}") % numSlots)},
+		{"foo{{bar}}zap", Slot("{bar")},
+	}
+	for _, tt := range tests {
+		if got := Slot(tt.key); got != tt.slot {
+			t.Errorf("Slot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
+
+// fakeNode serves RESP2 with reply, which gets each command and whether
+// ASKING came right before it on the connection, and returns its address.
+func fakeNode(t *testing.T, reply func(cmd []string, asking bool) string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				rd := NewReader(nc)
+				asking := false
+				for {
+					v, err := rd.Read()
+					if err != nil {
+						return
+					}
+					var cmd []string
+					for _, arg := range v.([]any) {
+						cmd = append(cmd, arg.(string))
+					}
+					out := "+OK\r\n"
+					switch {
+					case len(cmd) < 2 && !strings.EqualFold(cmd[0], "ASKING"):
+						out = "-ERR wrong number of arguments\r\n"
+					case len(cmd) >= 2:
+						out = reply(cmd, asking)
+					}
+					asking = strings.EqualFold(cmd[0], "ASKING")
+					if _, err := io.WriteString(nc, out); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestClusterRedirects runs two nodes whose CLUSTER SLOTS puts every slot
+// on a: b owns "moved" and imports "asked".
+func TestClusterRedirects(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	count := func(node string, cmd []string) {
+		mu.Lock()
+		calls[node+" "+strings.Join(cmd, " ")]++
+		mu.Unlock()
+	}
+	var a, b string
+	b = fakeNode(t, func(cmd []string, asking bool) string {
+		count("b", cmd)
+		switch {
+		case cmd[1] == "moved":
+			return "$5\r\nfromB\r\n"
+		case cmd[1] == "asked" && asking:
+			return "$6\r\nasked!\r\n"
+		}
+		return "-MOVED " + strconv.Itoa(Slot(cmd[1])) + " " + a + "\r\n"
+	})
+	_, bPort, _ := net.SplitHostPort(b)
+	a = fakeNode(t, func(cmd []string, asking bool) string {
+		count("a", cmd)
+		switch {
+		case strings.EqualFold(cmd[0], "CLUSTER"):
+			_, port, _ := net.SplitHostPort(a)
+			return "*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n$9\r\n127.0.0.1\r\n:" + port + "\r\n"
+		case cmd[1] == "moved":
+			// An empty host means the node that sent the redirect.
+			return "-MOVED " + strconv.Itoa(Slot(cmd[1])) + " :" + bPort + "\r\n"
+		case cmd[1] == "asked":
+			return "-ASK " + strconv.Itoa(Slot(cmd[1])) + " " + b + "\r\n"
+		case cmd[1] == "busy":
+			mu.Lock()
+			n := calls["a GET busy"]
+			mu.Unlock()
+			if n < 3 {
+				return "-TRYAGAIN Multiple keys request during rehashing of slot\r\n"
+			}
+		}
+		return "$5\r\nfromA\r\n"
+	})
+
+	c := New(Options{Addr: a, Cluster: true})
+	defer c.Close()
+	ctx := context.Background()
+	steps := []struct {
+		key, want string
+	}{
+		{"moved", "fromB"},
+		{"moved", "fromB"},
+		{"asked", "asked!"},
+		{"asked", "asked!"},
+		{"other", "fromA"},
+		{"busy", "fromA"},
+	}
+	for _, st := range steps {
+		if got, err := String(c.Do(ctx, "GET", st.key)); got != st.want || err != nil {
+			t.Errorf("GET %s = %q, %v, want %q", st.key, got, err, st.want)
+		}
+	}
+	mu.Lock()
+	want := map[string]int{
+		// MOVED updates the slot map, so only the first GET asks a.
+		"a GET moved": 1, "b GET moved": 2,
+		// ASK does not, so every GET goes to a first.
+		"a GET asked": 2, "b GET asked": 2,
+		"a GET busy": 3,
+	}
+	for k, n := range want {
+		if calls[k] != n {
+			t.Errorf("%s: %d calls, want %d", k, calls[k], n)
+		}
+	}
+	mu.Unlock()
+
+	// Without Cluster a redirect is just an error reply.
+	plain := New(Options{Addr: a})
+	defer plain.Close()
+	var e Error
+	if _, err := plain.Do(ctx, "GET", "moved"); !errors.As(err, &e) || e.Prefix() != "MOVED" {
+		t.Errorf("GET moved without Cluster: %v", err)
+	}
+}
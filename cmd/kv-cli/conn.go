@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+// conn is a plain synchronous connection: the CLI shows replies exactly as
+// sent, including status replies, and streams MONITOR output, neither of
+// which fits the multiplexed client.
+type conn struct {
+	nc  net.Conn
+	rd  *client.Reader
+	w   *bufio.Writer
+	buf []byte
+}
+
+func dial(cfg *config) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", cfg.addr(), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{nc: nc, rd: client.NewReader(nc), w: bufio.NewWriterSize(nc, 64*1024)}
+	c.rd.KeepStatus = true
+	if cfg.password != "" {
+		if err := c.expectOK("AUTH", cfg.password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if cfg.db != 0 {
+		if err := c.expectOK("SELECT", strconv.Itoa(cfg.db)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *conn) expectOK(args ...string) error {
+	v, err := c.do(args...)
+	if err != nil {
+		return err
+	}
+	if e, ok := v.(client.Error); ok {
+		return e
+	}
+	return nil
+}
+
+// do sends one command and reads its reply.
+func (c *conn) do(args ...string) (any, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.rd.Read()
+}
+
+// send buffers a command; pipelined callers flush once and then read one
+// reply per command.
+func (c *conn) send(args ...string) error {
+	c.buf = client.AppendCommand(c.buf[:0], args)
+	_, err := c.w.Write(c.buf)
+	return err
+}
+
+func (c *conn) flush() error {
+	return c.w.Flush()
+}
+
+func (c *conn) read() (any, error) {
+	return c.rd.Read()
+}
+
+func (c *conn) close() {
+	c.nc.Close()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+type outputFormat int
+
+const (
+	// formatTTY annotates types like redis-cli does on a terminal.
+	formatTTY outputFormat = iota
+	// formatRaw prints bare values, one per line.
+	formatRaw
+	formatCSV
+	formatJSON
+)
+
+func isErrorReply(v any) bool {
+	_, ok := v.(client.Error)
+	return ok
+}
+
+// formatReply renders v without a trailing newline.
+func formatReply(v any, f outputFormat) string {
+	switch f {
+	case formatRaw:
+		var b strings.Builder
+		writeRaw(&b, v)
+		return strings.TrimSuffix(b.String(), "\n")
+	case formatCSV:
+		var fields []string
+		return strings.Join(appendCSV(fields, v), ",")
+	case formatJSON:
+		out, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return string(out)
+	}
+	return formatTTYValue(v, 0)
+}
+
+func formatTTYValue(v any, indent int) string {
+	switch v := v.(type) {
+	case nil:
+		return "(nil)"
+	case client.Status:
+		return string(v)
+	case string:
+		return strconv.Quote(v)
+	case client.Error:
+		return "(error) " + string(v)
+	case int64:
+		return "(integer) " + strconv.FormatInt(v, 10)
+	case float64:
+		return "(double) " + formatFloat(v)
+	case bool:
+		if v {
+			return "(true)"
+		}
+		return "(false)"
+	case *big.Int:
+		return "(big number) " + v.String()
+	case []any:
+		return formatTTYList(v, indent, func(i int) string { return strconv.Itoa(i+1) + ") " })
+	case client.Push:
+		return formatTTYList(v, indent, func(i int) string { return strconv.Itoa(i+1) + ") " })
+	case map[string]any:
+		if len(v) == 0 {
+			return "(empty hash)"
+		}
+		keys := sortedKeys(v)
+		width := len(strconv.Itoa(len(keys)))
+		var b strings.Builder
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte('\n')
+				b.WriteString(strings.Repeat(" ", indent))
+			}
+			label := fmt.Sprintf("%*d# ", width, i+1)
+			b.WriteString(label)
+			b.WriteString(strconv.Quote(k))
+			b.WriteString(" => ")
+			b.WriteString(formatTTYValue(v[k], indent+len(label)+len(strconv.Quote(k))+4))
+		}
+		return b.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func formatTTYList(items []any, indent int, label func(int) string) string {
+	if len(items) == 0 {
+		return "(empty array)"
+	}
+	width := len(label(len(items) - 1))
+	var b strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte('\n')
+			b.WriteString(strings.Repeat(" ", indent))
+		}
+		l := label(i)
+		l = strings.Repeat(" ", width-len(l)) + l
+		b.WriteString(l)
+		b.WriteString(formatTTYValue(item, indent+width))
+	}
+	return b.String()
+}
+
+func writeRaw(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte('\n')
+	case client.Status:
+		b.WriteString(string(v) + "\n")
+	case string:
+		b.WriteString(v + "\n")
+	case client.Error:
+		b.WriteString(string(v) + "\n")
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10) + "\n")
+	case float64:
+		b.WriteString(formatFloat(v) + "\n")
+	case []any:
+		for _, item := range v {
+			writeRaw(b, item)
+		}
+	case client.Push:
+		for _, item := range v {
+			writeRaw(b, item)
+		}
+	case map[string]any:
+		for _, k := range sortedKeys(v) {
+			b.WriteString(k + "\n")
+			writeRaw(b, v[k])
+		}
+	default:
+		b.WriteString(fmt.Sprint(v) + "\n")
+	}
+}
+
+func appendCSV(fields []string, v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return append(fields, "NULL")
+	case client.Status:
+		return append(fields, csvQuote(string(v)))
+	case string:
+		return append(fields, csvQuote(v))
+	case client.Error:
+		return append(fields, "ERROR", csvQuote(string(v)))
+	case int64:
+		return append(fields, strconv.FormatInt(v, 10))
+	case float64:
+		return append(fields, formatFloat(v))
+	case []any:
+		for _, item := range v {
+			fields = appendCSV(fields, item)
+		}
+		return fields
+	case client.Push:
+		for _, item := range v {
+			fields = appendCSV(fields, item)
+		}
+		return fields
+	case map[string]any:
+		for _, k := range sortedKeys(v) {
+			fields = append(fields, csvQuote(k))
+			fields = appendCSV(fields, v[k])
+		}
+		return fields
+	}
+	return append(fields, fmt.Sprint(v))
+}
+
+func csvQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// jsonValue converts a reply to something encoding/json handles. Errors
+// become {"error": message}; infinities, which JSON cannot represent, are
+// strings.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case client.Status:
+		return string(v)
+	case client.Error:
+		return map[string]string{"error": string(v)}
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return formatFloat(v)
+		}
+		return v
+	case *big.Int:
+		return json.Number(v.String())
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = jsonValue(item)
+		}
+		return out
+	case client.Push:
+		return jsonValue([]any(v))
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = jsonValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitArgs splits a command line into arguments. Double quotes support
+// \n, \r, \t, \b, \a, \xHH and escaped quotes; single quotes only \'.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var cur strings.Builder
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, fmt.Errorf("unbalanced quotes")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					n, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					cur.WriteByte(byte(n))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						cur.WriteByte('\n')
+					case 'r':
+						cur.WriteByte('\r')
+					case 't':
+						cur.WriteByte('\t')
+					case 'b':
+						cur.WriteByte('\b')
+					case 'a':
+						cur.WriteByte('\a')
+					default:
+						cur.WriteByte(line[i])
+					}
+				case c == '"':
+					// A closing quote must be followed by a space or the end.
+					if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+						return nil, fmt.Errorf("unbalanced quotes")
+					}
+					done = true
+				default:
+					cur.WriteByte(c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					cur.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+						return nil, fmt.Errorf("unbalanced quotes")
+					}
+					done = true
+				default:
+					cur.WriteByte(c)
+				}
+			default:
+				switch c {
+				case ' ', '\t':
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					cur.WriteByte(c)
+				}
+			}
+			if !done || inDouble || inSingle {
+				i++
+			}
+		}
+		args = append(args, cur.String())
+	}
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
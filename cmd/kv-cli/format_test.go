@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+func TestFormatReply(t *testing.T) {
+	big, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	nested := []any{"a", []any{int64(1), int64(2)}, nil}
+	tests := []struct {
+		name string
+		v    any
+		// want holds the TTY, raw, CSV and JSON renderings.
+		want [4]string
+	}{
+		{"status", client.Status("OK"), [4]string{"OK", "OK", `"OK"`, `"OK"`}},
+		{"string", "say \"hi\"\n", [4]string{`"say \"hi\"\n"`, "say \"hi\"\n", `"say ""hi""` + "\n" + `"`, `"say \"hi\"\n"`}},
+		{"error", client.Error("ERR nope"), [4]string{"(error) ERR nope", "ERR nope", `ERROR,"ERR nope"`, `{"error":"ERR nope"}`}},
+		{"integer", int64(-3), [4]string{"(integer) -3", "-3", "-3", "-3"}},
+		{"double", 1.5, [4]string{"(double) 1.5", "1.5", "1.5", "1.5"}},
+		{"inf", math.Inf(1), [4]string{"(double) inf", "inf", "inf", `"inf"`}},
+		{"big number", big, [4]string{"(big number) " + big.String(), big.String(), big.String(), big.String()}},
+		{"nil", nil, [4]string{"(nil)", "", "NULL", "null"}},
+		{"empty array", []any{}, [4]string{"(empty array)", "", "", "[]"}},
+		{"nested", nested, [4]string{
+			"1) \"a\"\n2) 1) (integer) 1\n   2) (integer) 2\n3) (nil)",
+			"a\n1\n2\n",
+			`"a",1,2,NULL`,
+			`["a",[1,2],null]`,
+		}},
+		{"map", map[string]any{"b": int64(2), "a": []any{"x", "y"}}, [4]string{
+			"1# \"a\" => 1) \"x\"\n          2) \"y\"\n2# \"b\" => (integer) 2",
+			"a\nx\ny\nb\n2",
+			`"a","x","y","b",2`,
+			`{"a":["x","y"],"b":2}`,
+		}},
+		{"push", client.Push{"message", "ch", "hi"}, [4]string{
+			"1) \"message\"\n2) \"ch\"\n3) \"hi\"",
+			"message\nch\nhi",
+			`"message","ch","hi"`,
+			`["message","ch","hi"]`,
+		}},
+	}
+	for _, tt := range tests {
+		for i, f := range []outputFormat{formatTTY, formatRaw, formatCSV, formatJSON} {
+			if got := formatReply(tt.v, f); got != tt.want[i] {
+				t.Errorf("%s in format %d: %q, want %q", tt.name, f, got, tt.want[i])
+			}
+		}
+	}
+
+	// Labels line up once an array reaches ten items.
+	long := formatReply(make([]any, 10), formatTTY)
+	if lines := strings.Split(long, "\n"); lines[0] != " 1) (nil)" || lines[9] != "10) (nil)" {
+		t.Errorf("ten items: %q", long)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		// bad reports the line must not split.
+		bad bool
+	}{
+		{"", nil, false},
+		{"  SET  k\tv ", []string{"SET", "k", "v"}, false},
+		{`SET k "a b"`, []string{"SET", "k", "a b"}, false},
+		{`SET k ""`, []string{"SET", "k", ""}, false},
+		{`SET k "\x41\x4a\n\t\"\\"`, []string{"SET", "k", "AJ\n\t\"\\"}, false},
+		{`SET k "\xZZ"`, []string{"SET", "k", "xZZ"}, false},
+		{`SET k 'it\'s \n'`, []string{"SET", "k", `it's \n`}, false},
+		{`SET k ab"c d"`, []string{"SET", "k", "abc d"}, false},
+		{`SET k "a b`, nil, true},
+		{`SET k 'a`, nil, true},
+		{`SET k "a"b`, nil, true},
+		{`SET k 'a'b`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("splitArgs(%q) = %q, want an error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
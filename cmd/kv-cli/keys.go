@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+// scanBatch returns one SCAN batch.
+func scanBatch(c *conn, cfg *config, cursor string) (string, []string, error) {
+	args := []string{"SCAN", cursor}
+	if cfg.pattern != "" {
+		args = append(args, "MATCH", cfg.pattern)
+	}
+	if cfg.count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(cfg.count))
+	}
+	v, err := c.do(args...)
+	if err != nil {
+		return "", nil, err
+	}
+	if e, ok := v.(client.Error); ok {
+		return "", nil, e
+	}
+	arr, ok := v.([]any)
+	if !ok || len(arr) != 2 {
+		return "", nil, fmt.Errorf("unexpected SCAN reply %v", v)
+	}
+	next, _ := arr[0].(string)
+	items, _ := arr[1].([]any)
+	keys := make([]string, 0, len(items))
+	for _, k := range items {
+		if s, ok := k.(string); ok {
+			keys = append(keys, s)
+		}
+	}
+	return next, keys, nil
+}
+
+// forEachBatch runs fn on every SCAN batch, sleeping cfg.interval per 100
+// SCAN calls to go easy on a busy server.
+func forEachBatch(c *conn, cfg *config, fn func(keys []string) error) error {
+	cursor := "0"
+	for calls := 1; ; calls++ {
+		next, keys, err := scanBatch(c, cfg, cursor)
+		if err != nil {
+			return err
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+		if next == "0" || next == "" {
+			return nil
+		}
+		cursor = next
+		if cfg.interval > 0 && calls%100 == 0 {
+			time.Sleep(cfg.interval)
+		}
+	}
+}
+
+func runScan(cfg *config) error {
+	c, err := dial(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return forEachBatch(c, cfg, func(keys []string) error {
+		for _, k := range keys {
+			fmt.Println(k)
+		}
+		return nil
+	})
+}
+
+// keyStats describes one analysis: how a key of a given type is measured.
+type keyStats struct {
+	title string
+	// size returns the command measuring key of type typ, or nil if keys
+	// of that type are only counted.
+	size func(typ, key string) []string
+}
+
+var bigKeysStats = keyStats{
+	title: "biggest keys",
+	size: func(typ, key string) []string {
+		if typ == "string" {
+			return []string{"STRLEN", key}
+		}
+		return nil
+	},
+}
+
+var memKeysStats = keyStats{
+	title: "keys using the most memory",
+	size: func(_, key string) []string {
+		return []string{"MEMORY", "USAGE", key}
+	},
+}
+
+type typeStats struct {
+	keys      int
+	total     int64
+	biggest   string
+	maxSize   int64
+	hasBiggie bool
+}
+
+// runKeyStats samples every key with SCAN, pipelining TYPE and a size
+// command per batch, and prints the largest key per type and totals.
+func runKeyStats(cfg *config, ks keyStats) error {
+	c, err := dial(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	total := int64(0)
+	if v, err := c.do("DBSIZE"); err == nil {
+		total, _ = v.(int64)
+	}
+
+	fmt.Printf("\n# Scanning the entire keyspace to find %s as well as\n", ks.title)
+	fmt.Printf("# average sizes per key type.  You can use -i 0.1 to sleep 0.1 sec\n")
+	fmt.Printf("# per 100 SCAN commands (not usually needed).\n\n")
+
+	stats := make(map[string]*typeStats)
+	sampled, keyBytes := 0, int64(0)
+	err = forEachBatch(c, cfg, func(keys []string) error {
+		types, err := pipeline(c, keys, func(_ int, key string) []string { return []string{"TYPE", key} })
+		if err != nil {
+			return err
+		}
+		sizes, err := pipeline(c, keys, func(i int, key string) []string {
+			return ks.size(replyString(types[i]), key)
+		})
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			typ := replyString(types[i])
+			if typ == "none" {
+				continue // removed since SCAN returned it
+			}
+			sampled++
+			keyBytes += int64(len(key))
+			st := stats[typ]
+			if st == nil {
+				st = &typeStats{}
+				stats[typ] = st
+			}
+			st.keys++
+			size, ok := sizes[i].(int64)
+			if !ok {
+				continue
+			}
+			st.total += size
+			if !st.hasBiggie || size > st.maxSize {
+				st.biggest, st.maxSize, st.hasBiggie = key, size, true
+				pct := 0.0
+				if total > 0 {
+					pct = 100 * float64(sampled) / float64(total)
+				}
+				fmt.Printf("[%05.2f%%] Biggest %-6s found so far %s with %d bytes\n",
+					pct, typ, strconv.Quote(key), size)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n-------- summary -------\n\n")
+	fmt.Printf("Sampled %d keys in the keyspace!\n", sampled)
+	avg := 0.0
+	if sampled > 0 {
+		avg = float64(keyBytes) / float64(sampled)
+	}
+	fmt.Printf("Total key length in bytes is %d (avg len %.2f)\n\n", keyBytes, avg)
+
+	types := make([]string, 0, len(stats))
+	for typ := range stats {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		if st := stats[typ]; st.hasBiggie {
+			fmt.Printf("Biggest %6s found %s has %d bytes\n", typ, strconv.Quote(st.biggest), st.maxSize)
+		}
+	}
+	fmt.Println()
+	for _, typ := range types {
+		st := stats[typ]
+		pct := 100 * float64(st.keys) / float64(sampled)
+		line := fmt.Sprintf("%d %ss", st.keys, typ)
+		if st.hasBiggie {
+			line += fmt.Sprintf(" with %d bytes", st.total)
+		}
+		line += fmt.Sprintf(" (%05.2f%% of keys", pct)
+		if st.hasBiggie {
+			line += fmt.Sprintf(", avg size %.2f", float64(st.total)/float64(st.keys))
+		}
+		fmt.Println(line + ")")
+	}
+	return nil
+}
+
+// pipeline sends one command per key and returns the replies in order; a
+// nil command yields a nil reply without a round trip.
+func pipeline(c *conn, keys []string, cmd func(i int, key string) []string) ([]any, error) {
+	replies := make([]any, len(keys))
+	sent := make([]bool, len(keys))
+	for i, key := range keys {
+		if args := cmd(i, key); args != nil {
+			if err := c.send(args...); err != nil {
+				return nil, err
+			}
+			sent[i] = true
+		}
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if !sent[i] {
+			continue
+		}
+		v, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = v
+	}
+	return replies, nil
+}
+
+func replyString(v any) string {
+	switch v := v.(type) {
+	case client.Status:
+		return string(v)
+	case string:
+		return v
+	}
+	return ""
+}
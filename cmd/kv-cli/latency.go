@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+// latencySampleEvery is the pause between PINGs, as in redis-cli.
+const latencySampleEvery = 10 * time.Millisecond
+
+type latencyWindow struct {
+	min, max, sum time.Duration
+	n             int
+	start         time.Time
+}
+
+func (w *latencyWindow) reset(now time.Time) {
+	*w = latencyWindow{min: math.MaxInt64, start: now}
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.min = min(w.min, d)
+	w.max = max(w.max, d)
+	w.sum += d
+	w.n++
+}
+
+func (w *latencyWindow) String() string {
+	if w.n == 0 {
+		return "no samples"
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return fmt.Sprintf("min: %.3f, max: %.3f, avg: %.3f (%d samples)",
+		ms(w.min), ms(w.max), ms(w.sum)/float64(w.n), w.n)
+}
+
+// runLatency measures PING round trips until interrupted. --latency keeps
+// one running line; --latency-history prints one line per interval,
+// default 15s, and starts over.
+func runLatency(cfg *config) error {
+	c, err := dial(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	interval := cfg.interval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	tty := isTerminal(int(os.Stdout.Fd()))
+	var w latencyWindow
+	w.reset(time.Now())
+	lastPrint := time.Now()
+	for {
+		start := time.Now()
+		v, err := c.do("PING")
+		if err != nil {
+			return err
+		}
+		if e, ok := v.(client.Error); ok {
+			return e
+		}
+		now := time.Now()
+		w.add(now.Sub(start))
+
+		switch {
+		case cfg.history:
+			if now.Sub(w.start) >= interval {
+				fmt.Printf("%s -- %.2f seconds range\n", w.String(), now.Sub(w.start).Seconds())
+				w.reset(now)
+			}
+		case tty:
+			if now.Sub(lastPrint) >= 100*time.Millisecond {
+				fmt.Printf("\x1b[0G\x1b[2K%s", w.String())
+				lastPrint = now
+			}
+		default:
+			// Without a terminal print a line a second rather than redraw.
+			if now.Sub(lastPrint) >= time.Second {
+				fmt.Println(w.String())
+				lastPrint = now
+			}
+		}
+		time.Sleep(latencySampleEvery)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor is a minimal readline: cursor movement, Emacs-style editing
+// keys, history navigation, Tab completion and an inline hint shown after
+// the cursor.
+type lineEditor struct {
+	in  *bufio.Reader
+	out *os.File
+	fd  int
+
+	history []string
+	// complete returns the candidates for Tab; hint returns grey text
+	// shown after the input.
+	complete func(line string) []string
+	hint     func(line string) string
+}
+
+func newLineEditor(in, out *os.File) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, fd: int(in.Fd())}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// readLine returns the edited line, errInterrupted on Ctrl-C or io.EOF on
+// Ctrl-D at an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var buf []rune
+	pos := 0
+	histIdx := len(e.history)
+	saved := ""
+	var matches []string
+	matchIdx := -1
+
+	refresh := func() {
+		var b strings.Builder
+		b.WriteString("\r")
+		b.WriteString(prompt)
+		b.WriteString(string(buf))
+		if pos == len(buf) && e.hint != nil {
+			if h := e.hint(string(buf)); h != "" {
+				b.WriteString("\x1b[90m" + h + "\x1b[0m")
+			}
+		}
+		b.WriteString("\x1b[K\r")
+		if col := len([]rune(prompt)) + pos; col > 0 {
+			b.WriteString("\x1b[" + strconv.Itoa(col) + "C")
+		}
+		e.out.WriteString(b.String())
+	}
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+
+	refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if r != '\t' {
+			matches, matchIdx = nil, -1
+		}
+		switch r {
+		case '\r', '\n':
+			// Redraw without the hint before moving on.
+			hint := e.hint
+			e.hint = nil
+			pos = len(buf)
+			refresh()
+			e.hint = hint
+			e.out.WriteString("\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			e.out.WriteString("^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				e.out.WriteString("\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = append([]rune{}, buf[pos:]...)
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // Ctrl-L
+			e.out.WriteString("\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			histIdx, saved = e.historyMove(histIdx, -1, string(buf), saved, setLine)
+		case 14: // Ctrl-N
+			histIdx, saved = e.historyMove(histIdx, 1, string(buf), saved, setLine)
+		case '\t':
+			if e.complete == nil {
+				break
+			}
+			if matches == nil {
+				matches = e.complete(string(buf))
+			}
+			if len(matches) == 0 {
+				break
+			}
+			matchIdx = (matchIdx + 1) % len(matches)
+			setLine(matches[matchIdx])
+		case 27: // Escape sequence
+			seq := e.readEscape()
+			switch seq {
+			case "[A", "OA":
+				histIdx, saved = e.historyMove(histIdx, -1, string(buf), saved, setLine)
+			case "[B", "OB":
+				histIdx, saved = e.historyMove(histIdx, 1, string(buf), saved, setLine)
+			case "[C", "OC":
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+			case "[F", "OF", "[4~":
+				pos = len(buf)
+			case "[3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		refresh()
+	}
+}
+
+// readEscape reads the rest of a CSI or SS3 sequence after ESC.
+func (e *lineEditor) readEscape() string {
+	first, _, err := e.in.ReadRune()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := []rune{first}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r == '~' {
+			return string(seq)
+		}
+	}
+}
+
+// historyMove steps through history by delta. The line being typed is kept
+// in saved and comes back when stepping past the newest entry.
+func (e *lineEditor) historyMove(idx, delta int, cur, saved string, set func(string)) (int, string) {
+	if idx == len(e.history) {
+		saved = cur
+	}
+	idx += delta
+	switch {
+	case idx < 0:
+		return 0, saved
+	case idx >= len(e.history):
+		set(saved)
+		return len(e.history), saved
+	}
+	set(e.history[idx])
+	return idx, saved
+}
@@ -0,0 +1,177 @@
+// Command kv-cli is a command line client for the server: an interactive
+// shell with history and hints, one-shot commands, mass insertion from
+// RESP files and keyspace and latency diagnostics.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type config struct {
+	host     string
+	port     int
+	db       int
+	password string
+
+	format outputFormat
+
+	pipe     bool
+	scan     bool
+	pattern  string
+	count    int
+	bigkeys  bool
+	memkeys  bool
+	latency  bool
+	history  bool
+	interval time.Duration
+}
+
+func (cfg *config) addr() string {
+	return net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))
+}
+
+func main() {
+	var cfg config
+	var raw, noRaw, csv, jsonOut bool
+	var interval float64
+	flag.StringVar(&cfg.host, "h", "127.0.0.1", "server `host`")
+	flag.IntVar(&cfg.port, "p", 6379, "server `port`")
+	flag.IntVar(&cfg.db, "n", 0, "database `number`")
+	flag.StringVar(&cfg.password, "a", os.Getenv("KVCLI_AUTH"), "`password` for AUTH (or KVCLI_AUTH)")
+	flag.BoolVar(&raw, "raw", false, "print replies without type annotations")
+	flag.BoolVar(&noRaw, "no-raw", false, "force formatted output even if stdout is not a terminal")
+	flag.BoolVar(&csv, "csv", false, "print replies as CSV")
+	flag.BoolVar(&jsonOut, "json", false, "print replies as JSON")
+	flag.BoolVar(&cfg.pipe, "pipe", false, "send raw RESP from stdin to the server (mass insertion)")
+	flag.BoolVar(&cfg.scan, "scan", false, "list all keys using SCAN")
+	flag.StringVar(&cfg.pattern, "pattern", "", "key `pattern` for --scan, --bigkeys and --memkeys")
+	flag.IntVar(&cfg.count, "count", 100, "SCAN COUNT hint for --scan, --bigkeys and --memkeys")
+	flag.BoolVar(&cfg.bigkeys, "bigkeys", false, "sample keys looking for the largest values")
+	flag.BoolVar(&cfg.memkeys, "memkeys", false, "sample keys looking for the keys using the most memory")
+	flag.BoolVar(&cfg.latency, "latency", false, "continuously sample PING latency")
+	flag.BoolVar(&cfg.history, "latency-history", false, "like --latency but print a line per interval")
+	flag.Float64Var(&interval, "i", 0, "`seconds` between --latency-history lines, or to sleep per 100 SCANs")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kv-cli [options] [command [arg ...]]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cfg.interval = time.Duration(interval * float64(time.Second))
+
+	switch {
+	case jsonOut:
+		cfg.format = formatJSON
+	case csv:
+		cfg.format = formatCSV
+	case raw:
+		cfg.format = formatRaw
+	case noRaw || isTerminal(int(os.Stdout.Fd())):
+		cfg.format = formatTTY
+	default:
+		cfg.format = formatRaw
+	}
+
+	os.Exit(run(&cfg, flag.Args()))
+}
+
+func run(cfg *config, args []string) int {
+	var err error
+	switch {
+	case cfg.pipe:
+		err = runPipe(cfg, os.Stdin)
+	case cfg.scan:
+		err = runScan(cfg)
+	case cfg.bigkeys:
+		err = runKeyStats(cfg, bigKeysStats)
+	case cfg.memkeys:
+		err = runKeyStats(cfg, memKeysStats)
+	case cfg.latency || cfg.history:
+		err = runLatency(cfg)
+	case len(args) > 0:
+		return runOnce(cfg, args)
+	case isTerminal(int(os.Stdin.Fd())):
+		err = runREPL(cfg)
+	default:
+		return runLines(cfg, os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kv-cli: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runOnce executes the command given on the command line. An error reply
+// makes the exit status 1.
+func runOnce(cfg *config, args []string) int {
+	c, err := dial(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to %s: %v\n", cfg.addr(), err)
+		return 1
+	}
+	defer c.close()
+	if strings.EqualFold(args[0], "MONITOR") {
+		if err := monitor(c, cfg.format, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "kv-cli: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	v, err := c.do(args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kv-cli: %v\n", err)
+		return 1
+	}
+	fmt.Println(formatReply(v, cfg.format))
+	if isErrorReply(v) {
+		return 1
+	}
+	return 0
+}
+
+// runLines executes one command per input line, as when commands are
+// piped in: "echo 'SET a 1' | kv-cli".
+func runLines(cfg *config, r io.Reader) int {
+	c, err := dial(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to %s: %v\n", cfg.addr(), err)
+		return 1
+	}
+	defer c.close()
+	status := 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	for sc.Scan() {
+		args, err := splitArgs(sc.Text())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid argument(s): %v\n", err)
+			status = 1
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		v, err := c.do(args...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kv-cli: %v\n", err)
+			return 1
+		}
+		fmt.Println(formatReply(v, cfg.format))
+		if isErrorReply(v) {
+			status = 1
+		}
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "kv-cli: %v\n", err)
+		return 1
+	}
+	return status
+}
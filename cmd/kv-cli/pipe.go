@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+// runPipe streams stdin, which must already be RESP, to the server while
+// a second goroutine counts the replies. After the input an ECHO with a
+// random marker is sent; its reply tells that everything was processed.
+func runPipe(cfg *config, in io.Reader) error {
+	c, err := dial(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	var nonce [20]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	marker := hex.EncodeToString(nonce[:])
+
+	type result struct {
+		replies, errors int
+		err             error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		for {
+			v, err := c.read()
+			if err != nil {
+				res.err = err
+				done <- res
+				return
+			}
+			if s, ok := v.(string); ok && s == marker {
+				done <- res
+				return
+			}
+			res.replies++
+			if e, ok := v.(client.Error); ok {
+				res.errors++
+				// Like redis-cli, show the first errors but not a flood.
+				if res.errors <= 10 {
+					fmt.Fprintln(os.Stderr, e)
+				}
+			}
+		}
+	}()
+
+	if _, err := io.Copy(c.w, in); err != nil {
+		return err
+	}
+	if err := c.send("ECHO", marker); err != nil {
+		return err
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "All data transferred. Waiting for the last reply...")
+	res := <-done
+	if res.err != nil {
+		return fmt.Errorf("reading replies: %w", res.err)
+	}
+	fmt.Fprintln(os.Stderr, "Last reply received from server.")
+	fmt.Fprintf(os.Stderr, "errors: %d, replies: %d\n", res.errors, res.replies)
+	if res.errors > 0 {
+		return errors.New("some commands failed")
+	}
+	return nil
+}
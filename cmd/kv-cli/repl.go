@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VoolFI71/go-kv-store/client"
+)
+
+// maxHistory bounds both the in-memory and the saved history.
+const maxHistory = 1000
+
+// commandDoc is what the REPL knows about a command from COMMAND DOCS.
+type commandDoc struct {
+	summary string
+	since   string
+	group   string
+	args    []string // rendered top-level arguments, e.g. "[EX seconds]"
+}
+
+type repl struct {
+	cfg  *config
+	conn *conn
+	ed   *lineEditor
+	docs map[string]commandDoc
+}
+
+func runREPL(cfg *config) error {
+	r := &repl{cfg: cfg, ed: newLineEditor(os.Stdin, os.Stdout)}
+	r.ed.complete = r.complete
+	r.ed.hint = r.hint
+	histFile := historyFile()
+	r.ed.history = loadHistory(histFile)
+	r.connect()
+	defer func() {
+		if r.conn != nil {
+			r.conn.close()
+		}
+	}()
+
+	for {
+		line, err := r.ed.readLine(r.prompt())
+		switch {
+		case errors.Is(err, errInterrupted):
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println("Invalid argument(s)")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if !sensitive(args) {
+			r.ed.addHistory(line)
+			appendHistory(histFile, line)
+		}
+		switch name := strings.ToUpper(args[0]); name {
+		case "QUIT", "EXIT":
+			return nil
+		case "CLEAR":
+			fmt.Print("\x1b[H\x1b[2J")
+			continue
+		case "HELP":
+			r.help(args[1:])
+			continue
+		case "CONNECT":
+			r.reconnectTo(args[1:])
+			continue
+		}
+		r.execute(args)
+	}
+}
+
+func (r *repl) prompt() string {
+	if r.conn == nil {
+		return "not connected> "
+	}
+	if r.cfg.db != 0 {
+		return fmt.Sprintf("%s[%d]> ", r.cfg.addr(), r.cfg.db)
+	}
+	return r.cfg.addr() + "> "
+}
+
+// connect (re)dials and loads command docs the first time it succeeds.
+func (r *repl) connect() bool {
+	c, err := dial(r.cfg)
+	if err != nil {
+		fmt.Printf("Could not connect to %s: %v\n", r.cfg.addr(), err)
+		return false
+	}
+	r.conn = c
+	if r.docs == nil {
+		r.docs = loadDocs(c)
+	}
+	return true
+}
+
+func (r *repl) reconnectTo(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: CONNECT host port")
+		return
+	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Println("Invalid port")
+		return
+	}
+	if r.conn != nil {
+		r.conn.close()
+		r.conn = nil
+	}
+	r.cfg.host, r.cfg.port, r.cfg.db = args[0], port, 0
+	r.docs = nil
+	r.connect()
+}
+
+func (r *repl) execute(args []string) {
+	if r.conn == nil && !r.connect() {
+		return
+	}
+	if strings.EqualFold(args[0], "MONITOR") {
+		// MONITOR takes the connection over; a fresh one is dialed after.
+		err := monitor(r.conn, r.cfg.format, os.Stdout)
+		r.conn.close()
+		r.conn = nil
+		if err != nil {
+			fmt.Println(err)
+		}
+		r.connect()
+		return
+	}
+	v, err := r.conn.do(args...)
+	if err != nil {
+		// Start over with a fresh connection for the next command. The
+		// failed one is not resent as it may have run.
+		r.conn.close()
+		r.conn = nil
+		fmt.Printf("Error: %v\n", err)
+		r.connect()
+		return
+	}
+	if strings.EqualFold(args[0], "SELECT") && !isErrorReply(v) && len(args) == 2 {
+		r.cfg.db, _ = strconv.Atoi(args[1])
+	}
+	fmt.Println(formatReply(v, r.cfg.format))
+}
+
+// help prints "HELP command" from the loaded docs.
+func (r *repl) help(args []string) {
+	if len(args) == 0 {
+		fmt.Println("kv-cli: type a command to run it, HELP <command> for its syntax.")
+		fmt.Println("Tab completes command names; the grey hint shows the arguments.")
+		return
+	}
+	name := strings.ToUpper(args[0])
+	doc, ok := r.docs[name]
+	if !ok {
+		fmt.Printf("No help for '%s'\n", args[0])
+		return
+	}
+	fmt.Printf("\n  %s %s\n  summary: %s\n  since: %s\n  group: %s\n\n",
+		name, strings.Join(doc.args, " "), doc.summary, doc.since, doc.group)
+}
+
+func (r *repl) complete(line string) []string {
+	if strings.ContainsAny(line, " \t") {
+		return nil
+	}
+	prefix := strings.ToUpper(line)
+	var out []string
+	for name := range r.docs {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// hint shows the arguments not typed yet. Arguments are counted by
+// position, which is exact for the required ones and a good guess after.
+func (r *repl) hint(line string) string {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+	doc, ok := r.docs[strings.ToUpper(args[0])]
+	if !ok {
+		return ""
+	}
+	typed := len(args) - 1
+	if !strings.HasSuffix(line, " ") {
+		if typed > 0 {
+			return ""
+		}
+		// Still typing the name.
+		return " " + strings.Join(doc.args, " ")
+	}
+	if typed >= len(doc.args) {
+		return ""
+	}
+	return strings.Join(doc.args[typed:], " ")
+}
+
+// loadDocs reads COMMAND DOCS. Servers without it simply get no hints.
+func loadDocs(c *conn) map[string]commandDoc {
+	docs := make(map[string]commandDoc)
+	v, err := c.do("COMMAND", "DOCS")
+	if err != nil {
+		return docs
+	}
+	var pairs []any
+	switch v := v.(type) {
+	case []any:
+		pairs = v
+	case map[string]any:
+		for k, d := range v {
+			pairs = append(pairs, k, d)
+		}
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, _ := pairs[i].(string)
+		if name == "" {
+			continue
+		}
+		fields := docFields(pairs[i+1])
+		doc := commandDoc{}
+		doc.summary, _ = fields["summary"].(string)
+		doc.since, _ = fields["since"].(string)
+		doc.group, _ = fields["group"].(string)
+		if list, ok := fields["arguments"].([]any); ok {
+			for _, a := range list {
+				doc.args = append(doc.args, renderArg(docFields(a)))
+			}
+		}
+		docs[strings.ToUpper(name)] = doc
+	}
+	return docs
+}
+
+// docFields turns a RESP2 flat key/value array or a RESP3 map into a map.
+func docFields(v any) map[string]any {
+	switch v := v.(type) {
+	case map[string]any:
+		return v
+	case []any:
+		m := make(map[string]any, len(v)/2)
+		for i := 0; i+1 < len(v); i += 2 {
+			if k, ok := v[i].(string); ok {
+				m[k] = v[i+1]
+			} else if k, ok := v[i].(client.Status); ok {
+				m[string(k)] = v[i+1]
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+func renderArg(fields map[string]any) string {
+	name, _ := fields["name"].(string)
+	if token, ok := fields["token"].(string); ok && name == "" {
+		name = token
+	}
+	optional, multiple := false, false
+	if flags, ok := fields["flags"].([]any); ok {
+		for _, f := range flags {
+			switch fmt.Sprint(f) {
+			case "optional":
+				optional = true
+			case "multiple":
+				multiple = true
+			}
+		}
+	}
+	if multiple {
+		name += " [" + name + " ...]"
+	}
+	if optional {
+		name = "[" + name + "]"
+	}
+	return name
+}
+
+// sensitive reports commands that carry a password and stay out of the
+// history file.
+func sensitive(args []string) bool {
+	switch strings.ToUpper(args[0]) {
+	case "AUTH":
+		return true
+	case "HELLO":
+		for _, a := range args {
+			if strings.EqualFold(a, "AUTH") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// historyFile is KVCLI_HISTFILE or ~/.kvcli_history; "" disables saving.
+func historyFile() string {
+	if f, ok := os.LookupEnv("KVCLI_HISTFILE"); ok {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kvcli_history")
+}
+
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+		// Trim the file so it does not grow forever.
+		_ = os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	}
+	return lines
+}
+
+func appendHistory(path, line string) {
+	if path == "" || strings.ContainsAny(line, "\r\n") {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	_, _ = f.WriteString(line + "\n")
+	f.Close()
+}
+
+// monitor streams MONITOR output until Ctrl-C or the connection drops.
+func monitor(c *conn, format outputFormat, w io.Writer) error {
+	v, err := c.do("MONITOR")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, formatReply(v, format))
+	if isErrorReply(v) {
+		return nil
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan struct{})
+	defer func() {
+		signal.Stop(sig)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-sig:
+			c.close()
+		case <-done:
+		}
+	}()
+	for {
+		v, err := c.read()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintln(w, formatReply(v, formatRaw))
+	}
+}
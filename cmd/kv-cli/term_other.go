@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// Without termios support the REPL reads plain lines and output is
+// formatted as if stdout were a pipe.
+func isTerminal(int) bool { return false }
+
+func makeRaw(int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
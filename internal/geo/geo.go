@@ -0,0 +1,297 @@
+// Package geo implements Redis' geohash scores: a point is stored as the
+// 52-bit interleaving of its latitude and longitude, each quantized to 26
+// bits over the Web Mercator range, so nearby points have nearby scores
+// and an area is a handful of score ranges in a sorted set. Encoding,
+// decoding, distances and the choice of ranges follow Redis, so scores,
+// GEOHASH strings and search results match it.
+package geo
+
+import "math"
+
+const (
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+	LonMin = -180.0
+	LonMax = 180.0
+
+	// Step is the bits per coordinate of a score.
+	Step = 26
+
+	earthRadius = 6372797.560856
+	mercatorMax = 20037726.37
+
+	alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// Valid reports whether a point can be indexed.
+func Valid(lon, lat float64) bool {
+	return lon >= LonMin && lon <= LonMax && lat >= LatMin && lat <= LatMax
+}
+
+// Encode returns the score of a valid point.
+func Encode(lon, lat float64) uint64 {
+	return encode(lon, lat, LatMin, LatMax, Step)
+}
+
+// Decode returns the center of the cell a score stands for.
+func Decode(score uint64) (lon, lat float64) {
+	c := decode(score, LatMin, LatMax, Step)
+	lon = min(max((c.lonMin+c.lonMax)/2, LonMin), LonMax)
+	lat = min(max((c.latMin+c.latMax)/2, LatMin), LatMax)
+	return lon, lat
+}
+
+// String returns the standard 11-character geohash of a score. Scores use
+// the Mercator latitude range, so the point is encoded again over ±90.
+func String(score uint64) string {
+	lon, lat := Decode(score)
+	bits := encode(lon, lat, -90, 90, Step)
+	var buf [11]byte
+	for i := range buf {
+		idx := 0
+		// 52 bits make 10 characters and 2 bits; Redis pads the last
+		// character with 0.
+		if i < 10 {
+			idx = int(bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = alphabet[idx]
+	}
+	return string(buf[:])
+}
+
+// Distance returns the haversine distance in meters between two points.
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((rad(lon2) - rad(lon1)) / 2)
+	if v == 0 {
+		return latDistance(lat1, lat2)
+	}
+	u := math.Sin((rad(lat2) - rad(lat1)) / 2)
+	a := u*u + math.Cos(rad(lat1))*math.Cos(rad(lat2))*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func latDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(rad(lat2)-rad(lat1))
+}
+
+func rad(deg float64) float64 { return deg * (math.Pi / 180) }
+func deg(rad float64) float64 { return rad * (180 / math.Pi) }
+
+// hash is a cell: step bits per coordinate, interleaved.
+type hash struct {
+	bits uint64
+	step uint
+}
+
+type cell struct {
+	lonMin, lonMax, latMin, latMax float64
+}
+
+func encode(lon, lat, latMin, latMax float64, step uint) uint64 {
+	scale := float64(uint64(1) << step)
+	top := uint32(1<<step - 1)
+	latOff := min(uint32((lat-latMin)/(latMax-latMin)*scale), top)
+	lonOff := min(uint32((lon-LonMin)/(LonMax-LonMin)*scale), top)
+	return interleave(latOff, lonOff)
+}
+
+func decode(bits uint64, latMin, latMax float64, step uint) cell {
+	scale := float64(uint64(1) << step)
+	lat, lon := float64(squash(bits)), float64(squash(bits>>1))
+	latSpan, lonSpan := latMax-latMin, LonMax-LonMin
+	return cell{
+		lonMin: LonMin + lon/scale*lonSpan,
+		lonMax: LonMin + (lon+1)/scale*lonSpan,
+		latMin: latMin + lat/scale*latSpan,
+		latMax: latMin + (lat+1)/scale*latSpan,
+	}
+}
+
+// interleave puts the latitude bits at even and the longitude bits at odd
+// positions.
+func interleave(lat, lon uint32) uint64 {
+	return spread(lat) | spread(lon)<<1
+}
+
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squash gathers the even bits of x.
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+// move returns the cell dx columns east and dy rows north of h, wrapping
+// around at the edges.
+func (h hash) move(dx, dy int) hash {
+	lon := h.bits & 0xaaaaaaaaaaaaaaaa
+	lat := h.bits & 0x5555555555555555
+	lonMask := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - h.step*2)
+	latMask := uint64(0x5555555555555555) >> (64 - h.step*2)
+	lon = step(lon, dx, latMask) & lonMask
+	lat = step(lat, dy, lonMask) & latMask
+	return hash{lon | lat, h.step}
+}
+
+// step adds d to the coordinate whose bits are v by carrying through the
+// other coordinate's bits, other.
+func step(v uint64, d int, other uint64) uint64 {
+	switch {
+	case d > 0:
+		return v + other + 1
+	case d < 0:
+		return (v | other) - (other + 1)
+	}
+	return v
+}
+
+// Shape is a search area around a center point: a circle of Radius meters,
+// or if Box is set a rectangle Width by Height meters.
+type Shape struct {
+	Lon, Lat      float64
+	Box           bool
+	Radius        float64
+	Width, Height float64
+}
+
+// Range is a score range, Min included and Max excluded.
+type Range struct {
+	Min, Max uint64
+}
+
+// Ranges returns the score ranges holding every point of the shape and
+// some around it: the cell of the center, at a size chosen from the
+// shape's extent, and those of its neighbors that reach into the shape,
+// center first and then north, south, east, west, north-east, north-west,
+// south-east and south-west, without repeats.
+func (sh *Shape) Ranges() []Range {
+	lonMin, latMin, lonMax, latMax := sh.bounds()
+	radius := sh.Radius
+	if sh.Box {
+		radius = math.Hypot(sh.Width/2, sh.Height/2)
+	}
+	steps := estimateSteps(radius, sh.Lat)
+	center, neighbors, area := sh.cells(steps)
+
+	// Near the edge of its cell the center's neighbors may not reach far
+	// enough, then larger cells are used.
+	north := decode(neighbors[0].bits, LatMin, LatMax, steps)
+	south := decode(neighbors[1].bits, LatMin, LatMax, steps)
+	east := decode(neighbors[2].bits, LatMin, LatMax, steps)
+	west := decode(neighbors[3].bits, LatMin, LatMax, steps)
+	if steps > 1 && (north.latMax < latMax || south.latMin > latMin || east.lonMax < lonMax || west.lonMin > lonMin) {
+		steps--
+		center, neighbors, area = sh.cells(steps)
+	}
+
+	// Skip the neighbors the shape does not reach.
+	skip := [8]bool{}
+	if steps >= 2 {
+		if area.latMin < latMin {
+			skip[1], skip[7], skip[6] = true, true, true
+		}
+		if area.latMax > latMax {
+			skip[0], skip[4], skip[5] = true, true, true
+		}
+		if area.lonMin < lonMin {
+			skip[3], skip[7], skip[5] = true, true, true
+		}
+		if area.lonMax > lonMax {
+			skip[2], skip[6], skip[4] = true, true, true
+		}
+	}
+
+	shift := 52 - 2*steps
+	ranges := make([]Range, 0, 9)
+	ranges = append(ranges, Range{center.bits << shift, (center.bits + 1) << shift})
+	last := center
+	for i, h := range neighbors {
+		// Huge radiuses can make adjacent neighbors the same cell.
+		if skip[i] || h == last {
+			continue
+		}
+		ranges = append(ranges, Range{h.bits << shift, (h.bits + 1) << shift})
+		last = h
+	}
+	return ranges
+}
+
+// cells returns the cell of the center at steps bits, its neighbors in
+// Ranges order and the center cell's area.
+func (sh *Shape) cells(steps uint) (hash, [8]hash, cell) {
+	h := hash{encode(sh.Lon, sh.Lat, LatMin, LatMax, steps), steps}
+	return h, [8]hash{
+		h.move(0, 1), h.move(0, -1), h.move(1, 0), h.move(-1, 0),
+		h.move(1, 1), h.move(-1, 1), h.move(1, -1), h.move(-1, -1),
+	}, decode(h.bits, LatMin, LatMax, steps)
+}
+
+// bounds returns the shape's bounding box in degrees.
+func (sh *Shape) bounds() (lonMin, latMin, lonMax, latMax float64) {
+	height, width := sh.Radius, sh.Radius
+	if sh.Box {
+		height, width = sh.Height/2, sh.Width/2
+	}
+	latDelta := deg(height / earthRadius)
+	lonDeltaTop := deg(width / earthRadius / math.Cos(rad(sh.Lat+latDelta)))
+	lonDeltaBottom := deg(width / earthRadius / math.Cos(rad(sh.Lat-latDelta)))
+	// The edge farther from the equator is the wider one in degrees.
+	lonDelta := lonDeltaTop
+	if sh.Lat < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return sh.Lon - lonDelta, sh.Lat - latDelta, sh.Lon + lonDelta, sh.Lat + latDelta
+}
+
+// estimateSteps returns the cell size, in bits per coordinate, for a
+// search of radius meters at lat.
+func estimateSteps(radius, lat float64) uint {
+	if radius == 0 {
+		return Step
+	}
+	steps := 1
+	for ; radius < mercatorMax; steps++ {
+		radius *= 2
+	}
+	// Make sure the radius fits in most cases, and cells are narrower
+	// towards the poles.
+	steps -= 2
+	if lat > 66 || lat < -66 {
+		steps--
+		if lat > 80 || lat < -80 {
+			steps--
+		}
+	}
+	return uint(min(max(steps, 1), Step))
+}
+
+// Contains reports whether the point at score is inside the shape and
+// returns its distance from the center in meters.
+func (sh *Shape) Contains(score uint64) (float64, bool) {
+	lon, lat := Decode(score)
+	if sh.Box {
+		// The latitude distance is cheaper, so it is checked first.
+		if latDistance(lat, sh.Lat) > sh.Height/2 {
+			return 0, false
+		}
+		if Distance(lon, lat, sh.Lon, lat) > sh.Width/2 {
+			return 0, false
+		}
+		return Distance(sh.Lon, sh.Lat, lon, lat), true
+	}
+	dist := Distance(sh.Lon, sh.Lat, lon, lat)
+	return dist, dist <= sh.Radius
+}
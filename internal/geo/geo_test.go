@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// The points of the GEOADD, GEODIST and GEOSEARCH examples in Redis' docs,
+// with the scores and, where the docs show them, geohashes Redis gives.
+var sicily = []struct {
+	name     string
+	lon, lat float64
+	score    uint64
+	hash     string
+}{
+	{"Palermo", 13.361389, 38.115556, 3479099956230698, "sqc8b49rny0"},
+	{"Catania", 15.087269, 37.502669, 3479447370796909, "sqdtr74hyu0"},
+	{"edge1", 12.758489, 38.788135, 3479273021651468, ""},
+	{"edge2", 17.241510, 38.788135, 3481342659049484, ""},
+}
+
+func TestEncode(t *testing.T) {
+	for _, p := range sicily {
+		score := Encode(p.lon, p.lat)
+		if score != p.score {
+			t.Errorf("%s: score %d, want %d", p.name, score, p.score)
+		}
+		if h := String(score); p.hash != "" && h != p.hash {
+			t.Errorf("%s: geohash %s, want %s", p.name, h, p.hash)
+		}
+		// A 26-bit cell is under a meter across at these latitudes.
+		if lon, lat := Decode(score); Distance(lon, lat, p.lon, p.lat) > 1 {
+			t.Errorf("%s: decoded to %v, %v", p.name, lon, lat)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		from, to string
+		// want is GEODIST's reply in meters, rounded to 4 decimals.
+		want string
+	}{
+		{"Palermo", "Catania", "166274.1516"},
+		{"Palermo", "Palermo", "0.0000"},
+	}
+	pos := func(name string) (float64, float64) {
+		for _, p := range sicily {
+			if p.name == name {
+				return Decode(p.score)
+			}
+		}
+		t.Fatalf("no point %s", name)
+		return 0, 0
+	}
+	for _, tt := range tests {
+		lon1, lat1 := pos(tt.from)
+		lon2, lat2 := pos(tt.to)
+		if got := strconv.FormatFloat(Distance(lon1, lat1, lon2, lat2), 'f', 4, 64); got != tt.want {
+			t.Errorf("%s-%s: %s, want %s", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// TestRanges checks on random points that a search's ranges hold every
+// point its shape contains.
+func TestRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		shape Shape
+	}{
+		{"radius 200 km", Shape{Lon: 15, Lat: 37, Radius: 200000}},
+		{"radius 1 m", Shape{Lon: 15, Lat: 37, Radius: 1}},
+		{"box 400x400 km", Shape{Lon: 15, Lat: 37, Box: true, Width: 400000, Height: 400000}},
+		{"box 5000x10 km", Shape{Lon: -70, Lat: -33, Box: true, Width: 5000000, Height: 10000}},
+		{"radius near the pole", Shape{Lon: 0, Lat: 84, Radius: 500000}},
+		{"radius across the antimeridian", Shape{Lon: 179.9, Lat: 0, Radius: 100000}},
+		{"radius of half the earth", Shape{Lon: 0, Lat: 0, Radius: 10000000}},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		ranges := tt.shape.Ranges()
+		if len(ranges) == 0 || len(ranges) > 9 {
+			t.Fatalf("%s: %d ranges", tt.name, len(ranges))
+		}
+		inside := 0
+		for range 20000 {
+			// Points near the center, so many fall inside the shape.
+			lon := tt.shape.Lon + rng.NormFloat64()*math.Max(tt.shape.Radius, tt.shape.Width)/50000
+			lat := tt.shape.Lat + rng.NormFloat64()*math.Max(tt.shape.Radius, tt.shape.Height)/50000
+			lon = math.Mod(lon+540, 360) - 180
+			if !Valid(lon, lat) {
+				continue
+			}
+			score := Encode(lon, lat)
+			if _, ok := tt.shape.Contains(score); !ok {
+				continue
+			}
+			inside++
+			found := false
+			for _, r := range ranges {
+				found = found || score >= r.Min && score < r.Max
+			}
+			if !found {
+				t.Fatalf("%s: %v, %v is inside but in no range", tt.name, lon, lat)
+			}
+		}
+		if inside == 0 {
+			t.Errorf("%s: no point fell inside", tt.name)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name  string
+		shape Shape
+		// want lists the sicily points inside, in order.
+		want []string
+	}{
+		{"radius 200 km", Shape{Lon: 15, Lat: 37, Radius: 200000}, []string{"Palermo", "Catania"}},
+		{"radius 100 km", Shape{Lon: 15, Lat: 37, Radius: 100000}, []string{"Catania"}},
+		{"box 400x400 km", Shape{Lon: 15, Lat: 37, Box: true, Width: 400000, Height: 400000}, []string{"Palermo", "Catania", "edge1", "edge2"}},
+		{"box 400x150 km", Shape{Lon: 15, Lat: 37, Box: true, Width: 400000, Height: 150000}, []string{"Catania"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range sicily {
+			if _, ok := tt.shape.Contains(p.score); ok {
+				got = append(got, p.name)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
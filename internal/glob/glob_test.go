@@ -0,0 +1,48 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		// The examples of KEYS' docs.
+		{"h?llo", "hello", true},
+		{"h?llo", "hallo", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"**a**", "xxa", true},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbx", false},
+		{"?", "", false},
+		{"a/*", "a/b/c", true},
+		{"[b-a]", "a", true},
+		{"[a-]", "-", true},
+		{"[]]", "]", false},
+		{`[\]]`, "]", true},
+		{`[\-]`, "-", true},
+		{"[abc", "b", true},
+		{"[", "a", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\?`, "a?", true},
+		{`\`, `\`, true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+// Package hll implements HyperLogLog values in Redis' byte layout, so a
+// value written here reads the same in Redis and back: a 16-byte header
+// ("HYLL", the encoding, three unused bytes and a little-endian cached
+// cardinality whose top bit marks it stale) followed by 16384 6-bit
+// registers, either packed (dense, 12 KB) or run-length coded (sparse).
+//
+// Elements are hashed with MurmurHash64A and the cardinality is estimated
+// with Ertl's improved estimator, both as in Redis, so counts match too.
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+const (
+	p         = 14
+	registers = 1 << p
+	q         = 64 - p
+	regBits   = 6
+	hdrSize   = 16
+	denseSize = hdrSize + (registers*regBits+7)/8
+
+	encDense  = 0
+	encSparse = 1
+
+	// Sparse opcodes: ZERO 00xxxxxx is a run of up to 64 empty registers,
+	// XZERO 01xxxxxx yyyyyyyy one of up to 16384, and VAL 1vvvvvxx up to 4
+	// registers holding 1..32.
+	sparseValMax    = 32
+	sparseValMaxLen = 4
+	sparseZeroMax   = 64
+
+	// SparseMaxBytes is Redis' default hll-sparse-max-bytes: a sparse
+	// value that would grow past it is converted to dense.
+	SparseMaxBytes = 3000
+
+	alphaInf = 0.721347520444481703680
+	seed     = 0xadc83b19
+)
+
+var (
+	ErrWrongType = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrCorrupt   = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// Registers is the raw register array used to merge values.
+type Registers [registers]uint8
+
+// New returns an empty sparse value.
+func New() []byte {
+	h := make([]byte, hdrSize+2)
+	copy(h, "HYLL")
+	h[4] = encSparse
+	putXZero(h[hdrSize:], registers)
+	return h
+}
+
+// Check reports ErrWrongType unless h has a HyperLogLog header.
+func Check(h []byte) error {
+	if len(h) < hdrSize || string(h[:4]) != "HYLL" || h[4] > encSparse || h[4] == encDense && len(h) != denseSize {
+		return ErrWrongType
+	}
+	return nil
+}
+
+// Add adds elem to h, which must pass Check. The result is h edited in
+// place or, when the sparse encoding changes length or turns dense, a new
+// slice. changed reports whether a register was raised.
+func Add(h []byte, elem string) (out []byte, changed bool, err error) {
+	index, count := patLen(elem)
+	if h[4] == encDense {
+		regs := h[hdrSize:]
+		if denseGet(regs, index) >= count {
+			return h, false, nil
+		}
+		denseSet(regs, index, count)
+		invalidate(h)
+		return h, true, nil
+	}
+	return sparseSet(h, index, count)
+}
+
+// Count returns the estimated cardinality of h, which must pass Check. A
+// stale cache is refreshed in place and cached reports that h changed.
+func Count(h []byte) (n uint64, cached bool, err error) {
+	if h[15]&0x80 == 0 {
+		return binary.LittleEndian.Uint64(h[8:]), false, nil
+	}
+	var histo [64]int
+	if h[4] == encDense {
+		regs := h[hdrSize:]
+		for i := 0; i < registers; i++ {
+			histo[denseGet(regs, i)]++
+		}
+	} else if err := sparseRuns(h[hdrSize:], func(_, n int, val uint8) { histo[val] += n }); err != nil {
+		return 0, false, err
+	}
+	n = estimate(&histo)
+	binary.LittleEndian.PutUint64(h[8:], n)
+	return n, true, nil
+}
+
+// Merge raises regs to the registers of h, which must pass Check. It
+// reports whether h is dense.
+func Merge(regs *Registers, h []byte) (dense bool, err error) {
+	if h[4] == encDense {
+		src := h[hdrSize:]
+		for i := range regs {
+			regs[i] = max(regs[i], denseGet(src, i))
+		}
+		return true, nil
+	}
+	return false, sparseRuns(h[hdrSize:], func(start, n int, val uint8) {
+		for i := start; i < start+n; i++ {
+			regs[i] = max(regs[i], val)
+		}
+	})
+}
+
+// Estimate returns the cardinality of raw registers.
+func Estimate(regs *Registers) uint64 {
+	var histo [64]int
+	for _, r := range regs {
+		histo[r]++
+	}
+	return estimate(&histo)
+}
+
+// Encode builds a value from raw registers, sparse unless dense is set or
+// they do not fit it. The cardinality cache is left stale.
+func Encode(regs *Registers, dense bool) []byte {
+	if !dense {
+		if h, ok := encodeSparse(regs); ok {
+			return h
+		}
+	}
+	h := make([]byte, denseSize)
+	copy(h, "HYLL")
+	h[4] = encDense
+	for i, r := range regs {
+		if r != 0 {
+			denseSet(h[hdrSize:], i, r)
+		}
+	}
+	invalidate(h)
+	return h
+}
+
+func invalidate(h []byte) {
+	h[15] |= 0x80
+}
+
+// patLen returns the register an element maps to and the length of the
+// run of zero bits ending its hash, plus one.
+func patLen(elem string) (int, uint8) {
+	hash := murmur64A(elem, seed)
+	index := int(hash & (registers - 1))
+	hash >>= p
+	// The sentinel bit keeps the count at most q+1.
+	hash |= 1 << q
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// murmur64A is Austin Appleby's MurmurHash64A as Redis uses it, reading
+// words little-endian.
+func murmur64A(data string, seed uint32) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := uint64(seed) ^ uint64(len(data))*m
+	for ; len(data) >= 8; data = data[8:] {
+		k := uint64(data[0]) | uint64(data[1])<<8 | uint64(data[2])<<16 | uint64(data[3])<<24 |
+			uint64(data[4])<<32 | uint64(data[5])<<40 | uint64(data[6])<<48 | uint64(data[7])<<56
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// Dense registers are packed LSB first: register i starts at bit 6*i.
+func denseGet(regs []byte, i int) uint8 {
+	b, fb := i*regBits/8, uint(i*regBits&7)
+	v := uint(regs[b]) >> fb
+	if b+1 < len(regs) {
+		v |= uint(regs[b+1]) << (8 - fb)
+	}
+	return uint8(v & 63)
+}
+
+func denseSet(regs []byte, i int, val uint8) {
+	b, fb := i*regBits/8, uint(i*regBits&7)
+	regs[b] = regs[b]&^byte(63<<fb) | byte(uint(val)<<fb)
+	if b+1 < len(regs) {
+		regs[b+1] = regs[b+1]&^byte(63>>(8-fb)) | byte(uint(val)>>(8-fb))
+	}
+}
+
+func valOp(val uint8, n int) byte {
+	return 0x80 | (val-1)<<2 | byte(n-1)
+}
+
+func putXZero(dst []byte, n int) {
+	dst[0] = byte((n-1)>>8) | 0x40
+	dst[1] = byte(n - 1)
+}
+
+// putZero writes the shortest opcode for a run of n empty registers and
+// returns its length.
+func putZero(dst []byte, n int) int {
+	if n <= sparseZeroMax {
+		dst[0] = byte(n - 1)
+		return 1
+	}
+	putXZero(dst, n)
+	return 2
+}
+
+// opcode decodes the sparse opcode at s[i]: its length in bytes, the
+// registers it spans and their value.
+func opcode(s []byte, i int) (oplen, span int, val uint8, ok bool) {
+	b := s[i]
+	switch {
+	case b&0x80 != 0:
+		return 1, int(b&3) + 1, (b>>2)&0x1f + 1, true
+	case b&0x40 == 0:
+		return 1, int(b&0x3f) + 1, 0, true
+	case i+1 < len(s):
+		return 2, (int(b&0x3f)<<8 | int(s[i+1])) + 1, 0, true
+	}
+	return 0, 0, 0, false
+}
+
+// sparseRuns calls fn for every run of registers in sparse registers s,
+// which must cover exactly all of them.
+func sparseRuns(s []byte, fn func(start, n int, val uint8)) error {
+	idx := 0
+	for i := 0; i < len(s); {
+		oplen, span, val, ok := opcode(s, i)
+		if !ok || idx+span > registers {
+			return ErrCorrupt
+		}
+		fn(idx, span, val)
+		idx += span
+		i += oplen
+	}
+	if idx != registers {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// sparseSet raises register index to count the way Redis does: the opcode
+// covering it is split into at most three, then equal neighbouring VAL
+// opcodes are merged again.
+func sparseSet(h []byte, index int, count uint8) ([]byte, bool, error) {
+	if count > sparseValMax {
+		return promote(h, index, count)
+	}
+	s := h[hdrSize:]
+	first, prev, i := 0, -1, 0
+	var oplen, span int
+	var val uint8
+	for ; i < len(s); i += oplen {
+		var ok bool
+		if oplen, span, val, ok = opcode(s, i); !ok {
+			return h, false, ErrCorrupt
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = i
+		first += span
+	}
+	if i >= len(s) {
+		return h, false, ErrCorrupt
+	}
+	isVal := s[i]&0x80 != 0
+	if isVal && val >= count {
+		return h, false, nil
+	}
+	if span == 1 && (isVal || s[i]&0xc0 == 0) {
+		// A one-register VAL or ZERO becomes the new VAL in place.
+		s[i] = valOp(count, 1)
+	} else {
+		var seq [5]byte
+		n := 0
+		last := first + span - 1
+		if index != first {
+			if isVal {
+				seq[n] = valOp(val, index-first)
+				n++
+			} else {
+				n += putZero(seq[n:], index-first)
+			}
+		}
+		seq[n] = valOp(count, 1)
+		n++
+		if index != last {
+			if isVal {
+				seq[n] = valOp(val, last-index)
+				n++
+			} else {
+				n += putZero(seq[n:], last-index)
+			}
+		}
+		if delta := n - oplen; delta != 0 {
+			if len(h)+delta > SparseMaxBytes {
+				return promote(h, index, count)
+			}
+			out := make([]byte, 0, len(h)+delta)
+			out = append(out, h[:hdrSize+i]...)
+			out = append(out, seq[:n]...)
+			out = append(out, s[i+oplen:]...)
+			h, s = out, out[hdrSize:]
+		} else {
+			copy(s[i:], seq[:n])
+		}
+	}
+	h = mergeVals(h, max(prev, 0))
+	invalidate(h)
+	return h, true, nil
+}
+
+// mergeVals joins adjacent VAL opcodes with the same value over the few
+// opcodes from i on that a set may have split.
+func mergeVals(h []byte, i int) []byte {
+	s := h[hdrSize:]
+	for scan := 5; i < len(s) && scan > 0; scan-- {
+		if s[i]&0x80 == 0 {
+			if s[i]&0x40 != 0 {
+				i += 2
+			} else {
+				i++
+			}
+			continue
+		}
+		if i+1 < len(s) && s[i+1]&0x80 != 0 {
+			_, n1, v1, _ := opcode(s, i)
+			_, n2, v2, _ := opcode(s, i+1)
+			if v1 == v2 && n1+n2 <= sparseValMaxLen {
+				s[i+1] = valOp(v1, n1+n2)
+				copy(s[i:], s[i+1:])
+				s = s[:len(s)-1]
+				h = h[:len(h)-1]
+				// Try the merged opcode against its right neighbour too.
+				continue
+			}
+		}
+		i++
+	}
+	return h
+}
+
+// promote converts a sparse value to dense and sets the register there.
+func promote(h []byte, index int, count uint8) ([]byte, bool, error) {
+	var regs Registers
+	if _, err := Merge(&regs, h); err != nil {
+		return h, false, err
+	}
+	regs[index] = max(regs[index], count)
+	return Encode(&regs, true), true, nil
+}
+
+func encodeSparse(regs *Registers) ([]byte, bool) {
+	h := make([]byte, hdrSize, hdrSize+64)
+	copy(h, "HYLL")
+	h[4] = encSparse
+	var op [2]byte
+	for i := 0; i < registers; {
+		v := regs[i]
+		j := i + 1
+		for j < registers && regs[j] == v {
+			j++
+		}
+		if v > sparseValMax {
+			return nil, false
+		}
+		for n := j - i; n > 0; {
+			if v == 0 {
+				h = append(h, op[:putZero(op[:], n)]...)
+				n = 0
+			} else {
+				k := min(n, sparseValMaxLen)
+				h = append(h, valOp(v, k))
+				n -= k
+			}
+		}
+		if len(h) > SparseMaxBytes {
+			return nil, false
+		}
+		i = j
+	}
+	invalidate(h)
+	return h, true
+}
+
+// estimate is Ertl's estimator over a histogram of register values, as in
+// Redis' hllCount.
+// The histogram has room for any 6-bit value, so a corrupt dense register
+// cannot index past it.
+func estimate(histo *[64]int) uint64 {
+	m := float64(registers)
+	z := m * tau((m-float64(histo[q+1]))/m)
+	for j := q; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histo[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
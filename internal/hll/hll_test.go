@@ -0,0 +1,207 @@
+package hll
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// add returns h with elems added, failing the test on an error.
+func add(t *testing.T, h []byte, elems ...string) []byte {
+	t.Helper()
+	for _, e := range elems {
+		var err error
+		if h, _, err = Add(h, e); err != nil {
+			t.Fatalf("Add(%q): %v", e, err)
+		}
+	}
+	return h
+}
+
+func count(t *testing.T, h []byte) uint64 {
+	t.Helper()
+	n, _, err := Count(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestPatLen checks the hash against values from Redis' C MurmurHash64A,
+// and the register and run length PFADD takes from it.
+func TestPatLen(t *testing.T) {
+	tests := []struct {
+		elem  string
+		hash  uint64
+		index int
+		count uint8
+	}{
+		{"", 0xd8dfea6585bc9732, 5938, 2},
+		{"a", 0x53d2470a9b43b1a7, 12711, 2},
+		{"foo", 0xe64609b8b0141cb4, 7348, 5},
+		{"hello", 0x0f656f01eecfe400, 9216, 1},
+		{"abcdefgh", 0xf3a65df559914567, 1383, 1},
+		{"hello world, HyperLogLog", 0xa2cdb1a55fdfd6a1, 5793, 1},
+	}
+	for _, tt := range tests {
+		if h := murmur64A(tt.elem, seed); h != tt.hash {
+			t.Errorf("murmur64A(%q) = %#x, want %#x", tt.elem, h, tt.hash)
+		}
+		if index, count := patLen(tt.elem); index != tt.index || count != tt.count {
+			t.Errorf("patLen(%q) = %d, %d, want %d, %d", tt.elem, index, count, tt.index, tt.count)
+		}
+	}
+}
+
+// TestSparseBytes compares sparse values with the bytes Redis writes for
+// the same PFADDs.
+func TestSparseBytes(t *testing.T) {
+	const stale = "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80"
+	tests := []struct {
+		elems []string
+		want  string
+	}{
+		{nil, "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"},
+		// XZERO 12711, VAL 2, XZERO 3672.
+		{[]string{"a"}, stale + "\x71\xa6\x84\x4e\x57"},
+		// "foo" splits the leading XZERO: XZERO 7348, VAL 5, XZERO 5362.
+		{[]string{"a", "foo"}, stale + "\x5c\xb3\x90\x54\xf1\x84\x4e\x57"},
+		// A lower count for a set register changes nothing.
+		{[]string{"a", "foo", "a"}, stale + "\x5c\xb3\x90\x54\xf1\x84\x4e\x57"},
+	}
+	for _, tt := range tests {
+		if got := add(t, New(), tt.elems...); string(got) != tt.want {
+			t.Errorf("PFADD %q:\n got %q\nwant %q", tt.elems, got, tt.want)
+		}
+	}
+}
+
+// TestCount runs the examples of Redis' PFADD, PFCOUNT and PFMERGE docs
+// and tests.
+func TestCount(t *testing.T) {
+	tests := []struct {
+		name string
+		sets [][]string
+		want uint64
+	}{
+		{"empty", nil, 0},
+		{"empty string", [][]string{{""}}, 1},
+		{"PFADD", [][]string{{"a", "b", "c", "d", "e", "f", "g"}}, 7},
+		{"repeats", [][]string{{"foo", "bar", "zap"}, {"zap", "zap", "zap"}, {"foo", "bar"}}, 3},
+		{"PFCOUNT union", [][]string{{"foo", "bar", "zap"}, {"1", "2", "3"}}, 6},
+		{"PFMERGE", [][]string{{"foo", "bar", "zap", "a"}, {"a", "b", "c", "foo"}}, 6},
+		{"union of sets", [][]string{{"a", "b", "c"}, {"b", "c", "d"}, {"c", "d", "e"}}, 5},
+	}
+	for _, tt := range tests {
+		var regs Registers
+		for _, set := range tt.sets {
+			if _, err := Merge(&regs, add(t, New(), set...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := Estimate(&regs); got != tt.want {
+			t.Errorf("%s: Estimate = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := count(t, Encode(&regs, false)); got != tt.want {
+			t.Errorf("%s: Count of the merged value = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCountCache(t *testing.T) {
+	h := add(t, New(), "a", "b", "c")
+	if h[15]&0x80 == 0 {
+		t.Fatal("PFADD left the cache valid")
+	}
+	if n, cached, _ := Count(h); n != 3 || !cached {
+		t.Fatalf("Count = %d, %v, want 3, true", n, cached)
+	}
+	if n, cached, _ := Count(h); n != 3 || cached {
+		t.Fatalf("second Count = %d, %v, want 3 from the cache", n, cached)
+	}
+	if h, changed, _ := Add(h, "a"); changed || h[15]&0x80 != 0 {
+		t.Fatal("adding a counted element invalidated the cache")
+	}
+	if h, changed, _ := Add(h, "1"); !changed || h[15]&0x80 == 0 {
+		t.Fatal("adding a new element left the cache valid")
+	}
+}
+
+// TestSparseDense adds the same elements to a sparse and a dense value:
+// they must hold the same registers and counts, including after the
+// sparse one outgrows SparseMaxBytes.
+func TestSparseDense(t *testing.T) {
+	tests := []struct {
+		n int
+		// promoted is set if the sparse value must have turned dense.
+		promoted bool
+	}{
+		{10, false},
+		{100, false},
+		{1000, false},
+		{5000, true},
+		{100000, true},
+	}
+	for _, tt := range tests {
+		sparse, dense := New(), Encode(&Registers{}, true)
+		for i := range tt.n {
+			e := "elem:" + strconv.Itoa(i)
+			sparse, dense = add(t, sparse, e), add(t, dense, e)
+		}
+		if promoted := sparse[4] == encDense; promoted != tt.promoted {
+			t.Errorf("%d elements: dense = %v, want %v (%d bytes)", tt.n, promoted, tt.promoted, len(sparse))
+		}
+		if !tt.promoted && len(sparse) > SparseMaxBytes {
+			t.Errorf("%d elements: sparse value is %d bytes", tt.n, len(sparse))
+		}
+		var rs, rd Registers
+		Merge(&rs, sparse)
+		Merge(&rd, dense)
+		if rs != rd {
+			t.Errorf("%d elements: sparse and dense registers differ", tt.n)
+		}
+		got, want := count(t, sparse), count(t, dense)
+		if got != want {
+			t.Errorf("%d elements: sparse count %d, dense %d", tt.n, got, want)
+		}
+		// The standard error with 16384 registers is 0.81%.
+		if diff := int(got) - tt.n; diff*100 > tt.n*3 || -diff*100 > tt.n*3 {
+			t.Errorf("%d elements: count %d", tt.n, got)
+		}
+		if !bytes.Equal(Encode(&rd, true)[hdrSize:], dense[hdrSize:]) {
+			t.Errorf("%d elements: Encode does not rebuild the dense registers", tt.n)
+		}
+	}
+}
+
+// TestCorrupt breaks values the way Redis' corruption tests do.
+func TestCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(h []byte) []byte
+		err     error
+	}{
+		{"broken magic", func(h []byte) []byte { copy(h, "0123"); return h }, ErrWrongType},
+		{"invalid encoding", func(h []byte) []byte { h[4] = 'x'; return h }, ErrWrongType},
+		{"dense of the wrong length", func(h []byte) []byte { h[4] = encDense; return h }, ErrWrongType},
+		{"short header", func(h []byte) []byte { return h[:hdrSize-1] }, ErrWrongType},
+		{"additional at tail", func(h []byte) []byte { return append(h, "hello"...) }, ErrCorrupt},
+		{"truncated XZERO", func(h []byte) []byte { return h[:len(h)-1] }, ErrCorrupt},
+		{"registers missing", func(h []byte) []byte { return h[:hdrSize+1] }, ErrCorrupt},
+		{"XZERO past the end", func(h []byte) []byte { return append(h[:hdrSize], 0x7f, 0xff, 0x00) }, ErrCorrupt},
+	}
+	for _, tt := range tests {
+		h := tt.corrupt(add(t, New(), "a", "b", "c"))
+		err := Check(h)
+		if err == nil {
+			_, _, err = Count(h)
+			if _, merr := Merge(&Registers{}, h); !errors.Is(merr, tt.err) {
+				t.Errorf("%s: Merge = %v, want %v", tt.name, merr, tt.err)
+			}
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
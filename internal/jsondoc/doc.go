@@ -0,0 +1,225 @@
+package jsondoc
+
+import (
+	"errors"
+	"math"
+)
+
+var errNumberRange = errors.New("ERR result is not a number or out of range")
+
+// Doc is a JSON document stored under a key. It implements storage.Object:
+// every edit adjusts the size it reports by what it adds or removes.
+type Doc struct {
+	root  *Node
+	bytes int64
+}
+
+// New returns a document holding root, which it takes over.
+func New(root *Node) *Doc {
+	return &Doc{root: root, bytes: size(root)}
+}
+
+func (d *Doc) Type() string { return "ReJSON-RL" }
+func (d *Doc) Size() int64  { return d.bytes }
+
+// Select returns the values p matches, in document order. The caller must
+// not change them.
+func (d *Doc) Select(p *Path) []*Node {
+	ms := eval(d.root, p.segs)
+	nodes := make([]*Node, len(ms))
+	for i, m := range ms {
+		nodes[i] = m.node
+	}
+	return nodes
+}
+
+// Set replaces the values p matches with copies of v. If it matches none
+// and its last step names a single member, the member is added to the
+// objects the rest of the path matches. With nx existing values are not
+// replaced, with xx no members are added. It reports whether anything was
+// written.
+func (d *Doc) Set(p *Path, v *Node, nx, xx bool) bool {
+	ms := eval(d.root, p.segs)
+	if len(ms) > 0 {
+		if nx {
+			return false
+		}
+		// In reverse, so a value inside another one is replaced before
+		// the outer one drops it.
+		for i := len(ms) - 1; i >= 0; i-- {
+			nv := v
+			if i > 0 {
+				nv = v.Clone()
+			}
+			d.replace(ms[i], nv)
+		}
+		return true
+	}
+	if xx || len(p.segs) == 0 {
+		return false
+	}
+	last := &p.segs[len(p.segs)-1]
+	if last.recursive || last.kind != selName || len(last.names) != 1 {
+		return false
+	}
+	name, written := last.names[0], false
+	for _, m := range eval(d.root, p.segs[:len(p.segs)-1]) {
+		if m.node.kind != Object {
+			continue
+		}
+		nv := v
+		if written {
+			nv = v.Clone()
+		}
+		m.node.addMember(name, nv)
+		d.bytes += memberSize + int64(len(name)) + 8 + size(nv)
+		written = true
+	}
+	return written
+}
+
+func (d *Doc) replace(m match, v *Node) {
+	if m.parent == nil {
+		d.bytes += size(v) - size(m.node)
+		d.root = v
+		return
+	}
+	// A path such as $[0,0] may match a value twice.
+	if i := m.parent.childIndex(m.node); i >= 0 {
+		d.bytes += size(v) - size(m.node)
+		m.parent.elems[i] = v
+	}
+}
+
+// Delete removes the values p matches, except the root, and returns how
+// many it removed.
+func (d *Doc) Delete(p *Path) int {
+	ms := eval(d.root, p.segs)
+	n := 0
+	// In reverse, so descendants go before their ancestors and array
+	// positions found earlier stay valid.
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+		if m.parent == nil {
+			continue
+		}
+		j := m.parent.childIndex(m.node)
+		if j < 0 {
+			continue
+		}
+		d.bytes -= 8 + size(m.node)
+		if m.parent.kind == Object {
+			d.bytes -= memberSize + int64(len(m.parent.keys[j]))
+		}
+		m.parent.removeChild(j)
+		n++
+	}
+	return n
+}
+
+// NumIncrBy adds delta, a number, to the numbers p matches and returns
+// them, nil for a value that is not a number. Integers stay integers
+// unless delta is a float or the sum overflows. If any result is not
+// finite nothing changes.
+func (d *Doc) NumIncrBy(p *Path, delta *Node) ([]*Node, error) {
+	ms := eval(d.root, p.segs)
+	type result struct {
+		isInt bool
+		i     int64
+		f     float64
+	}
+	results := make([]result, len(ms))
+	for k, m := range ms {
+		n := m.node
+		if !n.isNumber() {
+			continue
+		}
+		if n.kind == Int && delta.kind == Int {
+			a, b := n.int(), delta.int()
+			if sum := a + b; (sum > a) == (b > 0) || b == 0 {
+				results[k] = result{isInt: true, i: sum}
+				continue
+			}
+		}
+		f := n.number() + delta.number()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, errNumberRange
+		}
+		results[k].f = f
+	}
+	nodes := make([]*Node, len(ms))
+	for k, m := range ms {
+		if !m.node.isNumber() {
+			continue
+		}
+		if r := results[k]; r.isInt {
+			m.node.setInt(r.i)
+		} else {
+			m.node.setFloat(r.f)
+		}
+		nodes[k] = m.node
+	}
+	return nodes, nil
+}
+
+// StrAppend appends s to the strings p matches and returns their new
+// lengths, -1 for a value that is not a string.
+func (d *Doc) StrAppend(p *Path, s string) []int {
+	ms := eval(d.root, p.segs)
+	lens := make([]int, len(ms))
+	for k, m := range ms {
+		if m.node.kind != String {
+			lens[k] = -1
+			continue
+		}
+		m.node.str += s
+		d.bytes += int64(len(s))
+		lens[k] = len(m.node.str)
+	}
+	return lens
+}
+
+// ArrAppend appends copies of vals to the arrays p matches and returns
+// their new lengths, -1 for a value that is not an array.
+func (d *Doc) ArrAppend(p *Path, vals []*Node) []int {
+	ms := eval(d.root, p.segs)
+	lens := make([]int, len(ms))
+	for k, m := range ms {
+		if m.node.kind != Array {
+			lens[k] = -1
+			continue
+		}
+		for _, v := range vals {
+			if k > 0 {
+				v = v.Clone()
+			}
+			m.node.elems = append(m.node.elems, v)
+			d.bytes += 8 + size(v)
+		}
+		lens[k] = len(m.node.elems)
+	}
+	return lens
+}
+
+// ArrPop removes the element at index, counted from the end if negative
+// and clamped to the array, from the arrays p matches and returns them,
+// nil for an empty array or a value that is not an array.
+func (d *Doc) ArrPop(p *Path, index int) []*Node {
+	ms := eval(d.root, p.segs)
+	popped := make([]*Node, len(ms))
+	for k, m := range ms {
+		n := m.node
+		if n.kind != Array || len(n.elems) == 0 {
+			continue
+		}
+		i := index
+		if i < 0 {
+			i += len(n.elems)
+		}
+		i = min(max(i, 0), len(n.elems)-1)
+		popped[k] = n.elems[i]
+		d.bytes -= 8 + size(n.elems[i])
+		n.removeChild(i)
+	}
+	return popped
+}
@@ -0,0 +1,44 @@
+package jsondoc
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// TestSetMemberNameOwned checks that a member added by Set does not alias
+// the path argument, which the server parses out of its inbound buffer.
+func TestSetMemberNameOwned(t *testing.T) {
+	tests := []struct {
+		name, path, want string
+	}{
+		{"dot", "$.z", `{"a":1,"z":2}`},
+		{"legacy", ".z", `{"a":1,"z":2}`},
+		{"bare", "z", `{"a":1,"z":2}`},
+		{"quoted", "$['z']", `{"a":1,"z":2}`},
+		{"double quoted", `$["z"]`, `{"a":1,"z":2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := Parse(`{"a":1}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := New(root)
+			buf := []byte(tt.path)
+			p, err := Compile(unsafe.String(&buf[0], len(buf)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, _ := Parse("2")
+			if !d.Set(p, v, false, false) {
+				t.Fatal("Set wrote nothing")
+			}
+			for i := range buf {
+				buf[i] = '#'
+			}
+			if got := string(Append(nil, d.root, nil)); got != tt.want {
+				t.Fatalf("after reusing the buffer got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package jsondoc
+
+import "strings"
+
+// expr is a filter expression, [?(...)], evaluated with @ bound to each
+// candidate value.
+type expr interface {
+	eval(cur, root *Node) bool
+}
+
+type orExpr struct{ l, r expr }
+type andExpr struct{ l, r expr }
+type notExpr struct{ x expr }
+
+// cmpExpr compares two operands; with op "" it tests that l exists.
+type cmpExpr struct {
+	op   string
+	l, r operand
+}
+
+// operand is a literal or a path from @ or $, whose first match is its
+// value.
+type operand struct {
+	lit      *Node
+	fromRoot bool
+	segs     []segment
+}
+
+func (e *orExpr) eval(cur, root *Node) bool  { return e.l.eval(cur, root) || e.r.eval(cur, root) }
+func (e *andExpr) eval(cur, root *Node) bool { return e.l.eval(cur, root) && e.r.eval(cur, root) }
+func (e *notExpr) eval(cur, root *Node) bool { return !e.x.eval(cur, root) }
+
+func (e *cmpExpr) eval(cur, root *Node) bool {
+	l := e.l.value(cur, root)
+	if e.op == "" {
+		return l != nil
+	}
+	return compare(e.op, l, e.r.value(cur, root))
+}
+
+func (o *operand) value(cur, root *Node) *Node {
+	if o.lit != nil {
+		return o.lit
+	}
+	base := cur
+	if o.fromRoot {
+		base = root
+	}
+	if ms := eval(base, o.segs); len(ms) > 0 {
+		return ms[0].node
+	}
+	return nil
+}
+
+// compare applies op to two values, nil for a path that matched nothing.
+// Numbers and strings are ordered; other values of the same type can only
+// be equal, and values of different types never are.
+func compare(op string, a, b *Node) bool {
+	if a == nil || b == nil {
+		eq := a == nil && b == nil
+		return op == "==" && eq || op == "!=" && !eq
+	}
+	var c int
+	switch {
+	case a.isNumber() && b.isNumber():
+		if a.kind == Int && b.kind == Int {
+			c = cmpOrdered(a.int(), b.int())
+		} else {
+			c = cmpOrdered(a.number(), b.number())
+		}
+	case a.kind == String && b.kind == String:
+		c = strings.Compare(a.str, b.str)
+	case a.kind == b.kind && (a.kind == Null || a.kind == Bool):
+		if a.bits != b.bits {
+			return op == "!="
+		}
+		return op == "==" || op == "<=" || op == ">="
+	default:
+		return op == "!="
+	}
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func cmpOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// or parses a || b, the lowest precedence.
+func (p *pathParser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil {
+		p.skipSpace()
+		if !strings.HasPrefix(p.s[p.pos:], "||") {
+			break
+		}
+		p.pos += 2
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = &orExpr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *pathParser) and() (expr, error) {
+	l, err := p.unary()
+	for err == nil {
+		p.skipSpace()
+		if !strings.HasPrefix(p.s[p.pos:], "&&") {
+			break
+		}
+		p.pos += 2
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = &andExpr{l, r}
+		}
+	}
+	return l, err
+}
+
+// unary parses !x, (x), a comparison or an existence test.
+func (p *pathParser) unary() (expr, error) {
+	p.skipSpace()
+	switch {
+	case p.eat('!'):
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	case p.eat('('):
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.eat(')') {
+			return nil, errPathSyntax
+		}
+		return x, nil
+	}
+	l, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	e := &cmpExpr{l: l}
+	for _, op := range [...]string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			e.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if e.op == "" {
+		if l.lit != nil {
+			return nil, errPathSyntax
+		}
+		return e, nil
+	}
+	p.skipSpace()
+	if e.r, err = p.operand(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *pathParser) operand() (operand, error) {
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		p.pos++
+		segs, err := p.segments(true)
+		return operand{fromRoot: c == '$', segs: segs}, err
+	case c == '\'' || c == '"':
+		s, err := p.quoted()
+		return operand{lit: &Node{kind: String, str: s}}, err
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.s) && strings.IndexByte("+-.0123456789eE", p.s[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, err := parseNumber(p.s[start:p.pos])
+		if err != nil {
+			return operand{}, errPathSyntax
+		}
+		return operand{lit: n}, nil
+	}
+	for _, word := range [...]string{"true", "false", "null"} {
+		if strings.HasPrefix(p.s[p.pos:], word) {
+			p.pos += len(word)
+			n, _ := Parse(word)
+			return operand{lit: n}, nil
+		}
+	}
+	return operand{}, errPathSyntax
+}
@@ -0,0 +1,436 @@
+// Package jsondoc implements the JSON documents of the JSON.* commands as
+// parsed trees. A command edits the nodes its path selects in place, so a
+// one-field update neither parses nor serializes the rest of the document,
+// and a Doc keeps its approximate size up to date as it changes. Paths are
+// JSONPath ("$.a[*].b") or RedisJSON's legacy syntax (".a.b", "a[0]"),
+// and output follows RedisJSON's formatting.
+package jsondoc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Kind is the type of a Node.
+type Kind uint8
+
+const (
+	Null Kind = iota
+	Bool
+	Int
+	Float
+	String
+	Array
+	Object
+)
+
+var kindNames = [...]string{"null", "boolean", "integer", "number", "string", "array", "object"}
+
+// String returns the name JSON.TYPE reports.
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Node is a JSON value. Object members keep their insertion order.
+type Node struct {
+	kind Kind
+	// bits holds a Bool, an Int or the IEEE bits of a Float.
+	bits uint64
+	str  string
+	// elems are the elements of an Array or the member values of an
+	// Object, whose names are in keys.
+	elems []*Node
+	keys  []string
+	// index maps member names to positions once an Object has indexMin
+	// members, so big objects are not searched linearly.
+	index map[string]int
+}
+
+const indexMin = 16
+
+// maxDepth bounds the nesting Parse accepts, as RedisJSON does.
+const maxDepth = 128
+
+var (
+	errDepth    = errors.New("ERR recursion limit exceeded")
+	errTrailing = errors.New("ERR trailing characters after JSON value")
+)
+
+// NewArray returns an Array holding elems.
+func NewArray(elems []*Node) *Node {
+	return &Node{kind: Array, elems: elems}
+}
+
+// NewObject returns an Object with the members keys and vals.
+func NewObject(keys []string, vals []*Node) *Node {
+	n := &Node{kind: Object}
+	for i, key := range keys {
+		n.addMember(key, vals[i])
+	}
+	return n
+}
+
+// NewNull returns a null.
+func NewNull() *Node {
+	return &Node{kind: Null}
+}
+
+func (n *Node) Kind() Kind { return n.kind }
+
+// Str returns the value of a String.
+func (n *Node) Str() string { return n.str }
+
+// Len returns the length in bytes of a String and the elements or members
+// of an Array or Object.
+func (n *Node) Len() int {
+	if n.kind == String {
+		return len(n.str)
+	}
+	return len(n.elems)
+}
+
+// Keys returns the member names of an Object. The caller must not change
+// them.
+func (n *Node) Keys() []string { return n.keys }
+
+func (n *Node) int() int64     { return int64(n.bits) }
+func (n *Node) float() float64 { return math.Float64frombits(n.bits) }
+
+// number returns an Int or a Float as a float64.
+func (n *Node) number() float64 {
+	if n.kind == Int {
+		return float64(n.int())
+	}
+	return n.float()
+}
+
+func (n *Node) isNumber() bool { return n.kind == Int || n.kind == Float }
+
+func (n *Node) setInt(v int64) {
+	n.kind, n.bits = Int, uint64(v)
+}
+
+func (n *Node) setFloat(v float64) {
+	n.kind, n.bits = Float, math.Float64bits(v)
+}
+
+// member returns the position of the member key, -1 if there is none.
+func (n *Node) member(key string) int {
+	if n.index != nil {
+		if i, ok := n.index[key]; ok {
+			return i
+		}
+		return -1
+	}
+	for i, k := range n.keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *Node) addMember(key string, v *Node) {
+	if i := n.member(key); i >= 0 {
+		n.elems[i] = v
+		return
+	}
+	// The name may point into a request buffer, as path arguments do.
+	key = strings.Clone(key)
+	n.keys = append(n.keys, key)
+	n.elems = append(n.elems, v)
+	switch {
+	case n.index != nil:
+		n.index[key] = len(n.keys) - 1
+	case len(n.keys) >= indexMin:
+		n.index = make(map[string]int, len(n.keys))
+		for i, k := range n.keys {
+			n.index[k] = i
+		}
+	}
+}
+
+// removeChild removes the element or member at i.
+func (n *Node) removeChild(i int) {
+	if n.kind == Object {
+		if n.index != nil {
+			delete(n.index, n.keys[i])
+			for j := i + 1; j < len(n.keys); j++ {
+				n.index[n.keys[j]] = j - 1
+			}
+		}
+		copy(n.keys[i:], n.keys[i+1:])
+		n.keys[len(n.keys)-1] = ""
+		n.keys = n.keys[:len(n.keys)-1]
+	}
+	copy(n.elems[i:], n.elems[i+1:])
+	n.elems[len(n.elems)-1] = nil
+	n.elems = n.elems[:len(n.elems)-1]
+}
+
+// childIndex returns the position of the child c, -1 if it is not one.
+func (n *Node) childIndex(c *Node) int {
+	for i, e := range n.elems {
+		if e == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// Clone returns a deep copy of n.
+func (n *Node) Clone() *Node {
+	c := *n
+	c.index = nil
+	if n.elems != nil {
+		c.elems = make([]*Node, len(n.elems))
+		for i, e := range n.elems {
+			c.elems[i] = e.Clone()
+		}
+	}
+	if n.keys != nil {
+		c.keys = append([]string(nil), n.keys...)
+		if n.index != nil {
+			c.index = make(map[string]int, len(n.index))
+			for k, i := range n.index {
+				c.index[k] = i
+			}
+		}
+	}
+	return &c
+}
+
+// nodeSize is the memory of a Node besides what it points to, and
+// memberSize the extra cost of an Object member's name beside its bytes.
+const (
+	nodeSize   = int64(unsafe.Sizeof(Node{}))
+	memberSize = int64(unsafe.Sizeof("")) + 8
+)
+
+// size approximates the memory n holds, for Doc.Size.
+func size(n *Node) int64 {
+	s := nodeSize + int64(len(n.str)) + 8*int64(len(n.elems))
+	for _, k := range n.keys {
+		s += memberSize + int64(len(k))
+	}
+	for _, e := range n.elems {
+		s += size(e)
+	}
+	return s
+}
+
+// Parse parses one JSON value. Integers that fit an int64 are kept as
+// such, other numbers as float64.
+func Parse(text string) (*Node, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	n, err := parseValue(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errTrailing
+	}
+	return n, nil
+}
+
+func parseValue(dec *json.Decoder, depth int) (*Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errors.New("ERR invalid JSON: " + err.Error())
+	}
+	switch v := tok.(type) {
+	case nil:
+		return &Node{kind: Null}, nil
+	case bool:
+		n := &Node{kind: Bool}
+		if v {
+			n.bits = 1
+		}
+		return n, nil
+	case string:
+		return &Node{kind: String, str: v}, nil
+	case json.Number:
+		return parseNumber(string(v))
+	}
+	if depth >= maxDepth {
+		return nil, errDepth
+	}
+	n := &Node{kind: Array}
+	if tok == json.Delim('{') {
+		n.kind = Object
+	}
+	for dec.More() {
+		var key string
+		if n.kind == Object {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, errors.New("ERR invalid JSON: " + err.Error())
+			}
+			key = tok.(string)
+		}
+		e, err := parseValue(dec, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if n.kind == Object {
+			n.addMember(key, e)
+		} else {
+			n.elems = append(n.elems, e)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, errors.New("ERR invalid JSON: " + err.Error())
+	}
+	return n, nil
+}
+
+func parseNumber(s string) (*Node, error) {
+	n := &Node{}
+	if !strings.ContainsAny(s, ".eE") {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			n.setInt(v)
+			return n, nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) || math.IsInf(f, 0) {
+		return nil, errors.New("ERR invalid number " + s)
+	}
+	n.setFloat(f)
+	return n, nil
+}
+
+// Format lays out Append's output like JSON.GET's INDENT, NEWLINE and
+// SPACE options. The zero Format is compact.
+type Format struct {
+	Indent, Newline, Space string
+}
+
+// Append appends n serialized as JSON to dst.
+func Append(dst []byte, n *Node, f *Format) []byte {
+	if f == nil {
+		f = &Format{}
+	}
+	return appendNode(dst, n, f, 0)
+}
+
+func appendNode(dst []byte, n *Node, f *Format, depth int) []byte {
+	switch n.kind {
+	case Null:
+		return append(dst, "null"...)
+	case Bool:
+		if n.bits != 0 {
+			return append(dst, "true"...)
+		}
+		return append(dst, "false"...)
+	case Int:
+		return strconv.AppendInt(dst, n.int(), 10)
+	case Float:
+		return appendFloat(dst, n.float())
+	case String:
+		return appendString(dst, n.str)
+	}
+	open, close := byte('['), byte(']')
+	if n.kind == Object {
+		open, close = '{', '}'
+	}
+	dst = append(dst, open)
+	if len(n.elems) == 0 {
+		return append(dst, close)
+	}
+	for i, e := range n.elems {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendBreak(dst, f, depth+1)
+		if n.kind == Object {
+			dst = appendString(dst, n.keys[i])
+			dst = append(dst, ':')
+			dst = append(dst, f.Space...)
+		}
+		dst = appendNode(dst, e, f, depth+1)
+	}
+	dst = appendBreak(dst, f, depth)
+	return append(dst, close)
+}
+
+func appendBreak(dst []byte, f *Format, depth int) []byte {
+	dst = append(dst, f.Newline...)
+	for range depth {
+		dst = append(dst, f.Indent...)
+	}
+	return dst
+}
+
+// appendFloat formats f the way RedisJSON does: the shortest text that
+// reads back the same, always with a fraction or exponent, in exponent
+// form outside 1e-5..1e16.
+func appendFloat(dst []byte, f float64) []byte {
+	if abs := math.Abs(f); abs == 0 || abs >= 1e-5 && abs < 1e16 {
+		mark := len(dst)
+		dst = strconv.AppendFloat(dst, f, 'f', -1, 64)
+		if !strings.Contains(string(dst[mark:]), ".") {
+			dst = append(dst, ".0"...)
+		}
+		return dst
+	}
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mant, exp, _ := strings.Cut(s, "e")
+	exp = strings.TrimPrefix(exp, "+")
+	neg := strings.HasPrefix(exp, "-")
+	exp = strings.TrimLeft(strings.TrimPrefix(exp, "-"), "0")
+	dst = append(dst, mant...)
+	dst = append(dst, 'e')
+	if neg {
+		dst = append(dst, '-')
+	}
+	return append(dst, exp...)
+}
+
+func appendString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && n == 1 {
+				dst = append(dst, "�"...)
+			} else {
+				dst = append(dst, s[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c == '\n':
+			dst = append(dst, '\\', 'n')
+		case c == '\r':
+			dst = append(dst, '\\', 'r')
+		case c == '\t':
+			dst = append(dst, '\\', 't')
+		case c == '\b':
+			dst = append(dst, '\\', 'b')
+		case c == '\f':
+			dst = append(dst, '\\', 'f')
+		case c < 0x20:
+			dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+		i++
+	}
+	return append(dst, '"')
+}
@@ -0,0 +1,380 @@
+package jsondoc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errPathSyntax = errors.New("ERR invalid JSONPath")
+
+// Path is a compiled path. A JSONPath starts with "$" and selects any
+// number of values; a legacy path such as ".a.b", "a[0]" or "." selects
+// the first value it matches, and commands report an error if there is
+// none.
+type Path struct {
+	src    string
+	legacy bool
+	segs   []segment
+}
+
+type selKind uint8
+
+const (
+	selName selKind = iota
+	selWild
+	selIndex
+	selSlice
+	selFilter
+)
+
+// segment is one step of a path: a selector applied to the current values
+// or, if recursive, to them and all their descendants.
+type segment struct {
+	recursive bool
+	kind      selKind
+	names     []string
+	indexes   []int
+	// start, end and step describe a slice; the bounds are optional.
+	start, end, step int
+	hasStart, hasEnd bool
+	filter           expr
+}
+
+// Compile parses a JSONPath or a legacy path.
+func Compile(path string) (*Path, error) {
+	p := &Path{src: path}
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		p.legacy = true
+		switch {
+		case path == "":
+			return nil, errPathSyntax
+		case path == ".":
+			rest = ""
+		case path[0] != '.' && path[0] != '[':
+			rest = "." + path
+		}
+	}
+	ps := &pathParser{s: rest}
+	segs, err := ps.segments(false)
+	if err != nil {
+		return nil, err
+	}
+	p.segs = segs
+	return p, nil
+}
+
+// String returns the path as given to Compile.
+func (p *Path) String() string { return p.src }
+
+// Legacy reports whether p is a legacy path.
+func (p *Path) Legacy() bool { return p.legacy }
+
+// IsRoot reports whether p selects the whole document.
+func (p *Path) IsRoot() bool { return len(p.segs) == 0 }
+
+// match is a selected value and the Array or Object holding it, nil for
+// the root.
+type match struct {
+	node, parent *Node
+}
+
+// eval applies segs to root, in document order.
+func eval(root *Node, segs []segment) []match {
+	cur := []match{{node: root}}
+	for i := range segs {
+		seg := &segs[i]
+		var next []match
+		for _, m := range cur {
+			if seg.recursive {
+				descend(m.node, func(n *Node) {
+					next = seg.apply(n, root, next)
+				})
+			} else {
+				next = seg.apply(m.node, root, next)
+			}
+		}
+		cur = next
+		if len(cur) == 0 {
+			break
+		}
+	}
+	return cur
+}
+
+// descend calls fn for n and its descendants, parents before children.
+func descend(n *Node, fn func(n *Node)) {
+	fn(n)
+	for _, e := range n.elems {
+		descend(e, fn)
+	}
+}
+
+func (seg *segment) apply(n, root *Node, out []match) []match {
+	switch seg.kind {
+	case selName:
+		if n.kind == Object {
+			for _, name := range seg.names {
+				if i := n.member(name); i >= 0 {
+					out = append(out, match{n.elems[i], n})
+				}
+			}
+		}
+	case selWild:
+		for _, e := range n.elems {
+			out = append(out, match{e, n})
+		}
+	case selIndex:
+		if n.kind == Array {
+			for _, i := range seg.indexes {
+				if i < 0 {
+					i += len(n.elems)
+				}
+				if i >= 0 && i < len(n.elems) {
+					out = append(out, match{n.elems[i], n})
+				}
+			}
+		}
+	case selSlice:
+		if n.kind == Array {
+			for _, i := range seg.slice(len(n.elems)) {
+				out = append(out, match{n.elems[i], n})
+			}
+		}
+	case selFilter:
+		for _, e := range n.elems {
+			if seg.filter.eval(e, root) {
+				out = append(out, match{e, n})
+			}
+		}
+	}
+	return out
+}
+
+// slice returns the indexes a slice selects in an array of n elements, as
+// Python slices do.
+func (seg *segment) slice(n int) []int {
+	norm := func(i int) int {
+		if i < 0 {
+			return i + n
+		}
+		return i
+	}
+	var idx []int
+	switch {
+	case seg.step > 0:
+		lo, hi := 0, n
+		if seg.hasStart {
+			lo = min(max(norm(seg.start), 0), n)
+		}
+		if seg.hasEnd {
+			hi = min(max(norm(seg.end), 0), n)
+		}
+		for i := lo; i < hi; i += seg.step {
+			idx = append(idx, i)
+		}
+	case seg.step < 0:
+		hi, lo := n-1, -1
+		if seg.hasStart {
+			hi = min(max(norm(seg.start), -1), n-1)
+		}
+		if seg.hasEnd {
+			lo = min(max(norm(seg.end), -1), n-1)
+		}
+		for i := hi; i > lo; i += seg.step {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+type pathParser struct {
+	s   string
+	pos int
+}
+
+func (p *pathParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *pathParser) eat(c byte) bool {
+	if p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *pathParser) skipSpace() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+// segments parses steps up to the end of the text or, inside a filter, up
+// to the first byte that cannot continue the path.
+func (p *pathParser) segments(inFilter bool) ([]segment, error) {
+	var segs []segment
+	for p.pos < len(p.s) {
+		var seg segment
+		switch p.s[p.pos] {
+		case '.':
+			p.pos++
+			if p.eat('.') {
+				seg.recursive = true
+			}
+			switch {
+			case p.eat('*'):
+				seg.kind = selWild
+			case seg.recursive && p.peek() == '[':
+				if err := p.bracket(&seg); err != nil {
+					return nil, err
+				}
+			default:
+				name := p.name(inFilter)
+				if name == "" {
+					return nil, errPathSyntax
+				}
+				seg.names = []string{name}
+			}
+		case '[':
+			if err := p.bracket(&seg); err != nil {
+				return nil, err
+			}
+		default:
+			if inFilter {
+				return segs, nil
+			}
+			return nil, errPathSyntax
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// name parses a member name after a dot. Outside filters it runs to the
+// next dot or bracket, so legacy paths may name any member.
+func (p *pathParser) name(inFilter bool) string {
+	start := p.pos
+	for ; p.pos < len(p.s); p.pos++ {
+		c := p.s[p.pos]
+		if c == '.' || c == '[' {
+			break
+		}
+		if inFilter && !(c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80) {
+			break
+		}
+	}
+	return p.s[start:p.pos]
+}
+
+// bracket parses [*], [?(filter)], ['name', ...], [index, ...] and
+// [start:end:step].
+func (p *pathParser) bracket(seg *segment) error {
+	p.pos++
+	p.skipSpace()
+	switch c := p.peek(); {
+	case p.eat('*'):
+		seg.kind = selWild
+	case p.eat('?'):
+		p.skipSpace()
+		if !p.eat('(') {
+			return errPathSyntax
+		}
+		e, err := p.or()
+		if err != nil {
+			return err
+		}
+		p.skipSpace()
+		if !p.eat(')') {
+			return errPathSyntax
+		}
+		seg.kind, seg.filter = selFilter, e
+	case c == '\'' || c == '"':
+		for {
+			name, err := p.quoted()
+			if err != nil {
+				return err
+			}
+			seg.names = append(seg.names, name)
+			p.skipSpace()
+			if !p.eat(',') {
+				break
+			}
+			p.skipSpace()
+		}
+	default:
+		first, ok := p.int()
+		p.skipSpace()
+		if p.eat(':') {
+			seg.kind, seg.start, seg.hasStart, seg.step = selSlice, first, ok, 1
+			p.skipSpace()
+			seg.end, seg.hasEnd = p.int()
+			p.skipSpace()
+			if p.eat(':') {
+				p.skipSpace()
+				if step, ok := p.int(); ok {
+					seg.step = step
+				}
+			}
+			break
+		}
+		if !ok {
+			return errPathSyntax
+		}
+		seg.kind, seg.indexes = selIndex, []int{first}
+		for p.eat(',') {
+			p.skipSpace()
+			i, ok := p.int()
+			if !ok {
+				return errPathSyntax
+			}
+			seg.indexes = append(seg.indexes, i)
+			p.skipSpace()
+		}
+	}
+	p.skipSpace()
+	if !p.eat(']') {
+		return errPathSyntax
+	}
+	return nil
+}
+
+func (p *pathParser) int() (int, bool) {
+	start := p.pos
+	p.eat('-')
+	for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+// quoted parses a string in single or double quotes, where a backslash
+// escapes the next byte.
+func (p *pathParser) quoted() (string, error) {
+	q := p.s[p.pos]
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == q:
+			return b.String(), nil
+		case c == '\\' && p.pos < len(p.s):
+			c = p.s[p.pos]
+			p.pos++
+		}
+		b.WriteByte(c)
+	}
+	return "", errPathSyntax
+}
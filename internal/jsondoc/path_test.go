@@ -0,0 +1,193 @@
+package jsondoc
+
+import (
+	"fmt"
+	"testing"
+)
+
+const store = `{"store":{"book":[` +
+	`{"category":"reference","author":"Nigel Rees","title":"Sayings of the Century","price":8.95},` +
+	`{"category":"fiction","author":"Evelyn Waugh","title":"Sword of Honour","price":12.99},` +
+	`{"category":"fiction","author":"Herman Melville","title":"Moby Dick","isbn":"0-553-21311-3","price":8.99},` +
+	`{"category":"fiction","author":"J. R. R. Tolkien","title":"The Lord of the Rings","isbn":"0-395-19395-8","price":22.99}],` +
+	`"bicycle":{"color":"red","price":19.95}},"expensive":10}`
+
+// selectJSON compiles path and returns its matches in doc as a JSON array.
+func selectJSON(t *testing.T, doc, path string) (string, error) {
+	t.Helper()
+	root, err := Parse(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(Append(nil, NewArray(New(root).Select(p)), nil)), nil
+}
+
+// TestSelect runs the examples of Goessner's JSONPath article and the
+// corners of each selector.
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		doc, path, want string
+	}{
+		{store, "$.store.book[*].author", `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{store, "$..author", `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{store, "$.store.*.price", `[19.95]`},
+		{store, "$.store..price", `[8.95,12.99,8.99,22.99,19.95]`},
+		{store, "$..book[2].title", `["Moby Dick"]`},
+		{store, "$..book[-1].title", `["The Lord of the Rings"]`},
+		{store, "$..book[0,1].price", `[8.95,12.99]`},
+		{store, "$..book[:2].price", `[8.95,12.99]`},
+		{store, "$..book[?(@.isbn)].title", `["Moby Dick","The Lord of the Rings"]`},
+		{store, "$..book[?(@.price<10)].price", `[8.95,8.99]`},
+		{store, "$..book[?(@.price > $.expensive && @.category == 'fiction')].title", `["Sword of Honour","The Lord of the Rings"]`},
+		{store, `$..book[?(@.author == "Nigel Rees" || !(@.price < 20))].price`, `[8.95,22.99]`},
+		{store, "$.store['bicycle','missing'].color", `["red"]`},
+		{store, "$.missing", `[]`},
+		{store, "$", `[` + store + `]`},
+		{store, ".store.bicycle.color", `["red"]`},
+		{store, "store.bicycle.color", `["red"]`},
+		{store, ".", `[` + store + `]`},
+		{`[0,1,2,3,4,5]`, "$[1:4]", `[1,2,3]`},
+		{`[0,1,2,3,4,5]`, "$[::2]", `[0,2,4]`},
+		{`[0,1,2,3,4,5]`, "$[-2:]", `[4,5]`},
+		{`[0,1,2,3,4,5]`, "$[::-2]", `[5,3,1]`},
+		{`[0,1,2,3,4,5]`, "$[4:1:-1]", `[4,3,2]`},
+		{`[0,1,2,3,4,5]`, "$[10:20]", `[]`},
+		{`[0,1,2,3,4,5]`, "$[6]", `[]`},
+		{`[0,1,2,3,4,5]`, "$[-7]", `[]`},
+		{`{"a":{"a":{"a":1}}}`, "$..a", `[{"a":{"a":1}},{"a":1},1]`},
+		{`{"a b":1,"c.d":2}`, `$["a b"]`, `[1]`},
+		{`{"a b":1,"c.d":2}`, `$['c.d']`, `[2]`},
+		{`[{"n":null},{"n":false},{}]`, "$[?(@.n == null)]", `[{"n":null}]`},
+		{`[{"n":1},{"n":1.0},{"n":"1"}]`, "$[?(@.n == 1)]", `[{"n":1},{"n":1.0}]`},
+		{`[{"n":"b"},{"n":"a"},{"n":2}]`, "$[?(@.n < 'b')]", `[{"n":"a"}]`},
+	}
+	for _, tt := range tests {
+		got, err := selectJSON(t, tt.doc, tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("%s:\n got %s, %v\nwant %s", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, path := range []string{
+		"",
+		"$.",
+		"$..",
+		"$[",
+		"$[1",
+		"$['a",
+		"$[?(@.a ==)]",
+		"$[?(@.a == 1]",
+		"$a",
+	} {
+		if _, err := Compile(path); err == nil {
+			t.Errorf("Compile(%q) succeeded", path)
+		}
+	}
+}
+
+// TestEdits applies each command's edit and checks the document and that
+// its size matches a fresh parse of the result.
+func TestEdits(t *testing.T) {
+	parse := func(s string) *Node {
+		n, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	tests := []struct {
+		name, doc, path string
+		edit            func(d *Doc, p *Path) string
+		want, result    string
+	}{
+		{"set member", `{"a":1}`, "$.b", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Set(p, parse(`[1,2]`), false, false))
+		}, `{"a":1,"b":[1,2]}`, "true"},
+		{"set nx existing", `{"a":1}`, "$.a", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Set(p, parse(`2`), true, false))
+		}, `{"a":1}`, "false"},
+		{"set xx missing", `{"a":1}`, "$.b", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Set(p, parse(`2`), false, true))
+		}, `{"a":1}`, "false"},
+		{"set every match", `{"a":{"x":1},"b":{"x":2}}`, "$..x", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Set(p, parse(`{"y":"long value"}`), false, false))
+		}, `{"a":{"x":{"y":"long value"}},"b":{"x":{"y":"long value"}}}`, "true"},
+		{"set nested in matched", `{"a":{"a":1}}`, "$..a", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Set(p, parse(`0`), false, false))
+		}, `{"a":0}`, "true"},
+		{"set root", `{"a":1}`, "$", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Set(p, parse(`[true]`), false, false))
+		}, `[true]`, "true"},
+		{"delete", `{"a":[1,2,3],"b":{"a":"x"}}`, "$..a", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Delete(p))
+		}, `{"b":{}}`, "2"},
+		{"delete elements", `[0,1,2,3,4]`, "$[0,2,-1]", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Delete(p))
+		}, `[1,3]`, "3"},
+		{"delete root", `{"a":1}`, "$", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.Delete(p))
+		}, `{"a":1}`, "0"},
+		{"numincrby", `{"a":1,"b":1.5,"c":"x","d":9223372036854775807}`, "$.*", func(d *Doc, p *Path) string {
+			nodes, err := d.NumIncrBy(p, parse(`1`))
+			if err != nil {
+				return err.Error()
+			}
+			return string(Append(nil, NewArray(orNull(nodes)), nil))
+		}, `{"a":2,"b":2.5,"c":"x","d":9.223372036854776e18}`, `[2,2.5,null,9.223372036854776e18]`},
+		{"numincrby float", `{"a":1}`, "$.a", func(d *Doc, p *Path) string {
+			nodes, _ := d.NumIncrBy(p, parse(`0.5`))
+			return string(Append(nil, NewArray(orNull(nodes)), nil))
+		}, `{"a":1.5}`, `[1.5]`},
+		{"numincrby overflow", `{"a":1.7e308,"b":1}`, "$.*", func(d *Doc, p *Path) string {
+			_, err := d.NumIncrBy(p, parse(`1.7e308`))
+			return err.Error()
+		}, `{"a":1.7e308,"b":1}`, errNumberRange.Error()},
+		{"strappend", `{"a":"ab","b":1,"c":{"a":""}}`, "$..a", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.StrAppend(p, "cd"))
+		}, `{"a":"abcd","b":1,"c":{"a":"cd"}}`, "[4 2]"},
+		{"arrappend", `{"a":[],"b":{"a":[1]},"c":{"a":2}}`, "$..a", func(d *Doc, p *Path) string {
+			return fmt.Sprint(d.ArrAppend(p, []*Node{parse(`"x"`), parse(`{"k":[]}`)}))
+		}, `{"a":["x",{"k":[]}],"b":{"a":[1,"x",{"k":[]}]},"c":{"a":2}}`, "[2 3 -1]"},
+		{"arrpop", `{"a":[1,2,3],"b":{"a":[]},"c":{"a":"x"}}`, "$..a", func(d *Doc, p *Path) string {
+			return string(Append(nil, NewArray(orNull(d.ArrPop(p, -1))), nil))
+		}, `{"a":[1,2],"b":{"a":[]},"c":{"a":"x"}}`, `[3,null,null]`},
+		{"arrpop clamped", `[[1,2,3]]`, "$[0]", func(d *Doc, p *Path) string {
+			return string(Append(nil, NewArray(orNull(d.ArrPop(p, 10))), nil))
+		}, `[[1,2]]`, `[3]`},
+		{"arrpop first", `[[1,2,3]]`, "$[0]", func(d *Doc, p *Path) string {
+			return string(Append(nil, NewArray(orNull(d.ArrPop(p, -10))), nil))
+		}, `[[2,3]]`, `[1]`},
+	}
+	for _, tt := range tests {
+		d := New(parse(tt.doc))
+		p, err := Compile(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := tt.edit(d, p)
+		got := string(Append(nil, d.root, nil))
+		if got != tt.want || result != tt.result {
+			t.Errorf("%s:\n got %s, %s\nwant %s, %s", tt.name, got, result, tt.want, tt.result)
+			continue
+		}
+		if want := New(parse(got)).Size(); d.Size() != want {
+			t.Errorf("%s: Size = %d, want %d as parsed", tt.name, d.Size(), want)
+		}
+	}
+}
+
+// orNull replaces nil results with nulls so they serialize.
+func orNull(nodes []*Node) []*Node {
+	for i, n := range nodes {
+		if n == nil {
+			nodes[i] = NewNull()
+		}
+	}
+	return nodes
+}
@@ -92,11 +92,33 @@ func AppendBulkString(buf []byte, s string) []byte {
 	return buf
 }
 
+// AppendBulk is AppendBulkString for a byte slice.
+func AppendBulk(buf []byte, b []byte) []byte {
+	buf = append(buf, RESPBulkString)
+	buf = appendInt(buf, int64(len(b)))
+	buf = append(buf, '\r', '\n')
+	buf = append(buf, b...)
+	buf = append(buf, '\r', '\n')
+	return buf
+}
+
 func AppendNullBulkString(buf []byte) []byte {
 	buf = append(buf, RESPBulkString, '-', '1', '\r', '\n')
 	return buf
 }
 
+func AppendArrayHeader(buf []byte, n int) []byte {
+	buf = append(buf, RESPArray)
+	buf = appendInt(buf, int64(n))
+	buf = append(buf, '\r', '\n')
+	return buf
+}
+
+func AppendNullArray(buf []byte) []byte {
+	buf = append(buf, RESPArray, '-', '1', '\r', '\n')
+	return buf
+}
+
 func AppendInt(buf []byte, n int64) []byte {
 	buf = append(buf, ':')
 	buf = appendInt(buf, n)
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// With shard-affinity every storage shard index is owned by one event loop,
+// in all databases. Single-key commands for a shard owned elsewhere are
+// handed to the owner through gnet's lock-free task queue, so each shard's
+// lock and data stay in one core's cache. The shard locks are still taken,
+// uncontended, because the janitor, INFO and multi-key commands go through
+// them; that also keeps every step safe while loops are being discovered.
+//
+// A connection's replies are put back in pipeline order: the commands read
+// in one OnTraffic pass form a round, and its replies are flushed once all
+// forwarded batches are back.
+type shardOwners struct {
+	mu sync.Mutex
+	// n is the number of event loops; shard i is owned by the loop
+	// discovered (i mod n)-th, and runs wherever it is called until then.
+	n     int
+	loops []*ownerLoop
+	table atomic.Pointer[[storage.ShardCount]*ownerLoop]
+}
+
+type ownerLoop struct {
+	el gnet.EventLoop
+	// exec runs forwarded commands. Only the owning loop touches it.
+	exec *session
+}
+
+func newShardOwners(loops int) *shardOwners {
+	return &shardOwners{n: loops}
+}
+
+// register adds the event loop of a new connection.
+func (o *shardOwners) register(s *server, el gnet.EventLoop) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, l := range o.loops {
+		if l.el == el {
+			return
+		}
+	}
+	ls := s.stats.loopFor(el)
+	o.loops = append(o.loops, &ownerLoop{
+		el:   el,
+		exec: &session{stats: &ls.local, loop: ls},
+	})
+	var table [storage.ShardCount]*ownerLoop
+	for i := range table {
+		if slot := i % o.n; slot < len(o.loops) {
+			table[i] = o.loops[slot]
+		}
+	}
+	o.table.Store(&table)
+}
+
+// forwardTo returns the loop that must run cmd, or nil to run it here.
+func (o *shardOwners) forwardTo(c gnet.Conn, cmd *command, args []string) *ownerLoop {
+	table := o.table.Load()
+	if table == nil || !singleKey(cmd, args) {
+		return nil
+	}
+	owner := table[storage.ShardOf(xxhash.Sum64String(args[cmd.firstKey]))]
+	if owner == nil || owner.el == c.EventLoop() {
+		return nil
+	}
+	return owner
+}
+
+func singleKey(cmd *command, args []string) bool {
+	return cmd != nil && cmd.firstKey > 0 && cmd.lastKey == cmd.firstKey && cmd.arityOK(len(args))
+}
+
+// round collects the replies of one OnTraffic pass that forwarded commands.
+type round struct {
+	conn    gnet.Conn
+	parts   []replyPart
+	batches []*fwdBatch
+	// pending is the number of batches not run yet, plus roundClosed once
+	// the connection has closed. Whoever brings it to exactly roundClosed
+	// releases the round: no OnTraffic is left to do it.
+	pending atomic.Int32
+}
+
+const roundClosed = 1 << 30
+
+// replyPart is one command's reply: sess.out[start:end] when batch is nil,
+// otherwise reply idx of batch.
+type replyPart struct {
+	batch      *fwdBatch
+	idx        int
+	start, end int
+	drop       bool
+}
+
+// fwdBatch is the commands of one round run by one owner loop.
+type fwdBatch struct {
+	s     *server
+	r     *round
+	owner *ownerLoop
+	cmds  []fwdCmd
+	// Arguments are copied out of the inbound buffer into argBuf, which
+	// the strings in args point into.
+	argBuf []byte
+	args   []string
+	out    []byte
+	ends   []int
+}
+
+type fwdCmd struct {
+	cmd    *command
+	lo, hi int // range in args
+	db     int
+	cl     *client
+}
+
+var (
+	roundPool = sync.Pool{New: func() any { return new(round) }}
+	batchPool = sync.Pool{New: func() any { return new(fwdBatch) }}
+)
+
+// forward queues the current command for owner.
+func (sess *session) forward(s *server, c gnet.Conn, owner *ownerLoop, cmd *command) {
+	r := sess.round
+	if r == nil {
+		// Replies before the round go out first.
+		sess.flush(c)
+		r = roundPool.Get().(*round)
+		r.conn = c
+		sess.round = r
+	}
+	var b *fwdBatch
+	for _, rb := range r.batches {
+		if rb.owner == owner {
+			b = rb
+			break
+		}
+	}
+	if b == nil {
+		b = batchPool.Get().(*fwdBatch)
+		b.s, b.r, b.owner = s, r, owner
+		r.batches = append(r.batches, b)
+	}
+	lo := len(b.args)
+	for _, arg := range sess.args {
+		start := len(b.argBuf)
+		b.argBuf = append(b.argBuf, arg...)
+		b.args = append(b.args, unsafe.String(unsafe.SliceData(b.argBuf[start:]), len(arg)))
+	}
+	b.cmds = append(b.cmds, fwdCmd{cmd: cmd, lo: lo, hi: len(b.args), db: sess.db, cl: sess.cl})
+	r.parts = append(r.parts, replyPart{batch: b, idx: len(b.cmds) - 1, drop: sess.replyMode != replyOn})
+	if sess.replyMode == replySkip {
+		sess.replyMode = replyOn
+	}
+	sess.stats.forwarded++
+}
+
+// dispatch sends the round's batches of sess to their owners.
+func (r *round) dispatch(sess *session) {
+	r.pending.Store(int32(len(r.batches)))
+	for _, b := range r.batches {
+		if err := b.owner.el.Execute(context.Background(), b); err != nil {
+			// The engine is stopping; run it here under the shard locks,
+			// with a session of this loop, as the owner's is not ours.
+			b.run(&session{stats: sess.stats, loop: sess.loop})
+			b.done()
+		}
+	}
+}
+
+// Run executes the batch on the owner loop, unless the connection has
+// closed meanwhile, and wakes the connection once the whole round is done.
+func (b *fwdBatch) Run(context.Context) error {
+	if b.r.pending.Load()&roundClosed == 0 {
+		b.run(b.owner.exec)
+		b.owner.exec.loop.publish()
+	}
+	b.done()
+	return nil
+}
+
+func (b *fwdBatch) run(exec *session) {
+	s := b.s
+	b.ends = b.ends[:0]
+	exec.out = b.out[:0]
+	timeAll := s.slowlog.threshold.Load() == 0
+	for _, fc := range b.cmds {
+		exec.args = b.args[fc.lo:fc.hi]
+		exec.db, exec.cl = fc.db, fc.cl
+		exec.cmd = cmdNone
+		if weight := exec.stats.timing(fc.cmd, timeAll); weight == 0 {
+			s.handleCommand(exec, fc.cmd)
+			exec.stats.count(exec.cmd)
+		} else {
+			start := time.Now()
+			s.handleCommand(exec, fc.cmd)
+			s.commandTimed(exec, time.Since(start), weight)
+		}
+		b.ends = append(b.ends, len(exec.out))
+	}
+	b.out = exec.out
+	exec.args, exec.cl, exec.out = nil, nil, nil
+}
+
+// done counts the batch as run. Once pending drops, a read on the
+// connection may finish the round and recycle b, so nothing of it is
+// touched after.
+func (b *fwdBatch) done() {
+	r := b.r
+	conn := r.conn
+	switch r.pending.Add(-1) {
+	case 0:
+		_ = conn.Wake(nil)
+	case roundClosed:
+		r.release()
+	}
+}
+
+func (b *fwdBatch) reply(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = b.ends[i-1]
+	}
+	return b.out[start:b.ends[i]]
+}
+
+// finishRound puts the replies of a completed round in order into
+// sess.out.
+func (sess *session) finishRound() {
+	r := sess.round
+	out := sess.roundOut[:0]
+	for _, p := range r.parts {
+		switch {
+		case p.batch == nil:
+			out = append(out, sess.out[p.start:p.end]...)
+		case !p.drop:
+			out = append(out, p.batch.reply(p.idx)...)
+		}
+	}
+	sess.out, sess.roundOut = out, sess.out[:0]
+	sess.responses = len(r.parts)
+	sess.round = nil
+	r.release()
+}
+
+// abandonRound gives up the round of a closed connection. Batches still
+// queued on their owners are dropped, and the last of them to finish
+// releases the round.
+func (sess *session) abandonRound() {
+	r := sess.round
+	if r == nil {
+		return
+	}
+	sess.round = nil
+	if r.pending.Add(roundClosed) == roundClosed {
+		r.release()
+	}
+}
+
+// release recycles the round and its batches.
+func (r *round) release() {
+	for _, b := range r.batches {
+		clear(b.args)
+		clear(b.cmds)
+		b.s, b.r, b.owner = nil, nil, nil
+		b.cmds, b.argBuf, b.args, b.out, b.ends = b.cmds[:0], b.argBuf[:0], b.args[:0], b.out[:0], b.ends[:0]
+		batchPool.Put(b)
+	}
+	clear(r.batches)
+	r.conn, r.parts, r.batches = nil, r.parts[:0], r.batches[:0]
+	r.pending.Store(0)
+	roundPool.Put(r)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// wakeConn counts the wakes of a connection whose round is done.
+type wakeConn struct {
+	gnet.Conn
+	woken int
+}
+
+func (c *wakeConn) Wake(gnet.AsyncCallback) error {
+	c.woken++
+	return nil
+}
+
+// TestRoundClosedInFlight closes a connection while a round of forwarded
+// SETs is queued on the owner loop, before and after the owner runs it.
+func TestRoundClosedInFlight(t *testing.T) {
+	s := newTestServer(t)
+	ls := s.stats.loopFor(nil)
+	owner := &ownerLoop{exec: &session{stats: &ls.local, loop: ls}}
+	set := lookupCommand("set")
+
+	tests := []struct {
+		name string
+		// runFirst runs the batch before the connection closes.
+		runFirst bool
+	}{
+		{"closed before run", false},
+		{"closed after run", true},
+	}
+	for _, tt := range tests {
+		conn := &wakeConn{}
+		sess := &session{stats: &ls.local, loop: ls, cl: &client{}}
+		sess.args = []string{"SET", tt.name, "v"}
+		sess.forward(s, conn, owner, set)
+		r := sess.round
+		b := r.batches[0]
+		r.pending.Store(int32(len(r.batches)))
+
+		if tt.runFirst {
+			_ = b.Run(context.Background())
+			sess.abandonRound()
+		} else {
+			sess.abandonRound()
+			_ = b.Run(context.Background())
+		}
+
+		_, ran := s.db(0).GetHashed(xxhash.Sum64String(tt.name), tt.name)
+		if ran != tt.runFirst {
+			t.Errorf("%s: SET ran: %v", tt.name, ran)
+		}
+		wantWake := 0
+		if tt.runFirst {
+			wantWake = 1
+		}
+		if conn.woken != wantWake {
+			t.Errorf("%s: %d wakes, want %d", tt.name, conn.woken, wantWake)
+		}
+		if sess.round != nil || b.r != nil || len(b.args) != 0 || r.conn != nil {
+			t.Errorf("%s: round not released", tt.name)
+		}
+	}
+}
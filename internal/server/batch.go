@@ -0,0 +1,131 @@
+package server
+
+import (
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// With pipeline-batch set, runs of pipelined commands that have a batch
+// handler are queued instead of run one by one. A batch is grouped by
+// shard and each group runs under a single lock acquisition, in pipeline
+// order within the group, so commands on one key keep their order. Groups
+// without writes skip the lock and read like single commands. Replies are
+// put back in pipeline order.
+//
+// Batches never outlive an OnTraffic pass: their arguments point into the
+// peeked input, which is discarded at the end of the pass.
+type pipelineBatch struct {
+	cmds  []batchCmd
+	args  []string
+	order []int
+	out   []byte
+	// locked holds the shard of the group being run.
+	locked storage.Locked
+}
+
+type batchCmd struct {
+	cmd        *command
+	hash       uint64
+	shard      int
+	lo, hi     int // range in args
+	start, end int // reply in out
+}
+
+// batchable reports whether the current command may join a batch.
+func (s *server) batchable(sess *session, cmd *command) bool {
+	return cmd != nil && cmd.batch != nil && sess.replyMode == replyOn && s.owners == nil &&
+		cmd.arityOK(len(sess.args)) && (cmd.flags&flagDenyOOM == 0 || !s.oom.Load())
+}
+
+// queue adds the current command to the batch.
+func (b *pipelineBatch) queue(cmd *command, args []string) {
+	hash := xxhash.Sum64String(args[cmd.firstKey])
+	lo := len(b.args)
+	b.args = append(b.args, args...)
+	b.cmds = append(b.cmds, batchCmd{cmd: cmd, hash: hash, shard: storage.ShardOf(hash), lo: lo, hi: len(b.args)})
+}
+
+// runBatch executes the queued commands and appends their replies to
+// sess.out. sess.args is left as it was, the command that ended the batch.
+func (s *server) runBatch(sess *session) {
+	b := &sess.batch
+	if len(b.cmds) == 0 {
+		return
+	}
+	// Counting sort by shard keeps pipeline order within each shard.
+	var starts [storage.ShardCount + 1]int
+	for i := range b.cmds {
+		starts[b.cmds[i].shard+1]++
+	}
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+	if cap(b.order) < len(b.cmds) {
+		b.order = make([]int, len(b.cmds))
+	}
+	b.order = b.order[:len(b.cmds)]
+	for i := range b.cmds {
+		sh := b.cmds[i].shard
+		b.order[starts[sh]] = i
+		starts[sh]++
+	}
+
+	st := s.db(sess.db)
+	args, out := sess.args, sess.out
+	sess.out = b.out[:0]
+	for g := 0; g < len(b.order); {
+		end, writes := g, false
+		for end < len(b.order) && b.cmds[b.order[end]].shard == b.cmds[b.order[g]].shard {
+			writes = writes || b.cmds[b.order[end]].cmd.flags&flagWrite != 0
+			end++
+		}
+		group := b.order[g:end]
+		if writes {
+			st.Lock(b.cmds[group[0]].hash, &b.locked)
+			for _, i := range group {
+				s.runBatched(sess, &b.cmds[i], &b.locked)
+			}
+			b.locked.Unlock()
+		} else {
+			for _, i := range group {
+				s.runBatched(sess, &b.cmds[i], nil)
+			}
+		}
+		g = end
+	}
+	b.out = sess.out
+	for i := range b.cmds {
+		out = append(out, b.out[b.cmds[i].start:b.cmds[i].end]...)
+	}
+	sess.args, sess.out = args, out
+	sess.responses += len(b.cmds)
+
+	clear(b.args)
+	clear(b.cmds)
+	b.cmds, b.args = b.cmds[:0], b.args[:0]
+}
+
+// runBatched runs one command, under l if it is not nil.
+func (s *server) runBatched(sess *session, bc *batchCmd, l *storage.Locked) {
+	sess.args = sess.batch.args[bc.lo:bc.hi]
+	sess.cmd = bc.cmd.id
+	bc.start = len(sess.out)
+	weight := sess.stats.timing(bc.cmd, s.slowlog.threshold.Load() == 0)
+	var start time.Time
+	if weight != 0 {
+		start = time.Now()
+	}
+	if l != nil {
+		bc.cmd.batch(s, sess, l, bc.hash)
+	} else {
+		bc.cmd.handler(s, sess)
+	}
+	bc.end = len(sess.out)
+	if weight == 0 {
+		sess.stats.count(sess.cmd)
+	} else {
+		s.commandTimed(sess, time.Since(start), weight)
+	}
+}
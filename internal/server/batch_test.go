@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// TestPipelineModes sends pipelines larger than a socket read, so
+// commands span reads and gnet's inbound ring, through every way
+// OnTraffic runs them.
+func TestPipelineModes(t *testing.T) {
+	modes := []struct {
+		name   string
+		config map[string]string
+	}{
+		{"default", nil},
+		{"batch", map[string]string{"pipeline-batch": "4096"}},
+		{"affinity", map[string]string{"shard-affinity": "yes", "event-loops": "4"}},
+	}
+	value := func(round, i int) string {
+		return strconv.Itoa(round) + ":" + strconv.Itoa(i) + ":" + strings.Repeat("x", i%300)
+	}
+	const n = 5000
+	for _, mode := range modes {
+		c := startServer(t, mode.config)
+		ctx := context.Background()
+		for round := range 2 {
+			p := c.Pipeline()
+			for i := range n {
+				key := "k" + strconv.Itoa(i)
+				p.Queue("SET", key, value(round, i))
+				p.Queue("GET", key)
+				if i%500 == 0 {
+					// Multi-key commands wait for forwarded ones in
+					// affinity mode and end a batch in batch mode.
+					p.Queue("MGET", key, "k"+strconv.Itoa(i/2))
+				}
+			}
+			res, err := p.Exec(ctx)
+			if err != nil {
+				t.Fatalf("%s: %v", mode.name, err)
+			}
+			j := 0
+			for i := range n {
+				if res[j] != "OK" || res[j+1] != value(round, i) {
+					t.Fatalf("%s round %d: command %d replied %v, %v", mode.name, round, i, res[j], res[j+1])
+				}
+				j += 2
+				if i%500 == 0 {
+					got, _ := res[j].([]any)
+					if len(got) != 2 || got[0] != value(round, i) || got[1] != value(round, i/2) {
+						t.Fatalf("%s round %d: MGET %d replied %v", mode.name, round, i, res[j])
+					}
+					j++
+				}
+			}
+		}
+	}
+}
+
+// TestPipelineBatchOrder interleaves GET, SET and APPEND on a few keys,
+// two of them in one shard, and checks every reply against a model: within
+// a batch a key's commands must run in pipeline order, and APPEND, which
+// has no batch handler, must see the SETs queued before it.
+func TestPipelineBatchOrder(t *testing.T) {
+	keys := []string{"a", "b"}
+	for i := 0; len(keys) < 3; i++ {
+		if k := "a" + strconv.Itoa(i); storage.ShardOf(xxhash.Sum64String(k)) == storage.ShardOf(xxhash.Sum64String("a")) {
+			keys = append(keys, k)
+		}
+	}
+	for _, window := range []string{"1", "2", "4096"} {
+		c := startServer(t, map[string]string{"pipeline-batch": window})
+		rng := rand.New(rand.NewSource(1))
+		model := make(map[string]string)
+		var want []any
+		p := c.Pipeline()
+		for i := range 3000 {
+			key := keys[rng.Intn(len(keys))]
+			switch rng.Intn(5) {
+			case 0, 1:
+				v := strconv.Itoa(i)
+				p.Queue("SET", key, v)
+				model[key] = v
+				want = append(want, "OK")
+			case 2, 3:
+				p.Queue("GET", key)
+				if v, ok := model[key]; ok {
+					want = append(want, v)
+				} else {
+					want = append(want, nil)
+				}
+			case 4:
+				p.Queue("APPEND", key, "+")
+				model[key] += "+"
+				want = append(want, int64(len(model[key])))
+			}
+		}
+		got, err := p.Exec(context.Background())
+		if err != nil {
+			t.Fatalf("window %s: %v", window, err)
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Fatalf("window %s: command %d replied %#v, want %#v", window, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+// TestRunBatchAllocs checks that running a batch of writes and reads,
+// integers among them, allocates nothing once its buffers have grown.
+func TestRunBatchAllocs(t *testing.T) {
+	s := newTestServer(t)
+	ls := s.stats.loopFor(nil)
+	sess := &session{stats: &ls.local, loop: ls, cl: &client{}}
+	args := make([][]string, 64)
+	for i := range args {
+		key := "k" + strconv.Itoa(i%8)
+		switch i % 4 {
+		case 0:
+			args[i] = []string{"SET", key, "value"}
+		case 1:
+			args[i] = []string{"SET", key, "12345"}
+		default:
+			args[i] = []string{"GET", key}
+		}
+	}
+	run := func() {
+		for _, a := range args {
+			sess.batch.queue(lookupCommand(a[0]), a)
+		}
+		s.runBatch(sess)
+		sess.out = sess.out[:0]
+	}
+	run()
+	if n := testing.AllocsPerRun(100, run); n != 0 {
+		t.Errorf("%v allocations per batch", n)
+	}
+}
@@ -0,0 +1,464 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	replyOn = iota
+	replyOff
+	replySkip
+)
+
+type client struct {
+	id        int64
+	conn      gnet.Conn
+	fd        int
+	addr      string
+	laddr     string
+	createdAt time.Time
+
+	mu       sync.Mutex
+	name     string
+	lastCmd  string
+	lastSeen time.Time
+	qbuf     int
+	obuf     int
+	db       int
+	noEvict  bool
+}
+
+type clientRegistry struct {
+	mu      sync.RWMutex
+	clients map[int64]*client
+	nextID  atomic.Int64
+
+	pauseUntil atomic.Int64
+	pauseAll   atomic.Bool
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: make(map[int64]*client)}
+}
+
+func (r *clientRegistry) register(c gnet.Conn) *client {
+	now := time.Now()
+	cl := &client{
+		id:        r.nextID.Add(1),
+		conn:      c,
+		createdAt: now,
+		lastSeen:  now,
+		lastCmd:   "NULL",
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		cl.addr = addr.String()
+	}
+	if addr := c.LocalAddr(); addr != nil {
+		cl.laddr = addr.String()
+	}
+	cl.fd = c.Fd()
+	r.mu.Lock()
+	r.clients[cl.id] = cl
+	r.mu.Unlock()
+	return cl
+}
+
+func (r *clientRegistry) unregister(cl *client) {
+	r.mu.Lock()
+	delete(r.clients, cl.id)
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) count() int {
+	r.mu.RLock()
+	n := len(r.clients)
+	r.mu.RUnlock()
+	return n
+}
+
+func (r *clientRegistry) snapshot() []*client {
+	r.mu.RLock()
+	list := make([]*client, 0, len(r.clients))
+	for _, cl := range r.clients {
+		list = append(list, cl)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// pausedFor reports how long cmd must wait because of CLIENT PAUSE.
+func (r *clientRegistry) pausedFor(cmd *command) time.Duration {
+	until := r.pauseUntil.Load()
+	if until == 0 || cmd == nil || cmd.name == "client" {
+		return 0
+	}
+	wait := time.Duration(until - time.Now().UnixNano())
+	if wait <= 0 {
+		r.pauseUntil.CompareAndSwap(until, 0)
+		return 0
+	}
+	if !r.pauseAll.Load() && cmd.flags&flagWrite == 0 {
+		return 0
+	}
+	return wait
+}
+
+// busy counts clients other than skip that still had unprocessed input
+// after their last event-loop pass.
+func (r *clientRegistry) busy(skip *client) int {
+	n := 0
+	for _, cl := range r.snapshot() {
+		if cl == skip {
+			continue
+		}
+		cl.mu.Lock()
+		if cl.qbuf > 0 {
+			n++
+		}
+		cl.mu.Unlock()
+	}
+	return n
+}
+
+func (r *clientRegistry) wakeAll() {
+	for _, cl := range r.snapshot() {
+		_ = cl.conn.Wake(nil)
+	}
+}
+
+// touch publishes per-batch session state so other event loops can read it.
+func (cl *client) touch(sess *session, qbuf int) {
+	cl.mu.Lock()
+	cl.lastSeen = time.Now()
+	cl.lastCmd = commandName(sess.cmd)
+	cl.qbuf = qbuf
+	cl.obuf = cap(sess.out)
+	cl.mu.Unlock()
+}
+
+func (cl *client) appendInfo(buf []byte, now time.Time) []byte {
+	cl.mu.Lock()
+	name := cl.name
+	lastCmd := cl.lastCmd
+	lastSeen := cl.lastSeen
+	qbuf := cl.qbuf
+	obuf := cl.obuf
+	db := cl.db
+	noEvict := cl.noEvict
+	cl.mu.Unlock()
+
+	flags := "N"
+	if noEvict {
+		flags = "e"
+	}
+	buf = append(buf, "id="...)
+	buf = strconv.AppendInt(buf, cl.id, 10)
+	buf = append(buf, " addr="...)
+	buf = append(buf, cl.addr...)
+	buf = append(buf, " laddr="...)
+	buf = append(buf, cl.laddr...)
+	buf = append(buf, " fd="...)
+	buf = strconv.AppendInt(buf, int64(cl.fd), 10)
+	buf = append(buf, " name="...)
+	buf = append(buf, name...)
+	buf = append(buf, " age="...)
+	buf = strconv.AppendInt(buf, int64(now.Sub(cl.createdAt)/time.Second), 10)
+	buf = append(buf, " idle="...)
+	buf = strconv.AppendInt(buf, int64(now.Sub(lastSeen)/time.Second), 10)
+	buf = append(buf, " flags="...)
+	buf = append(buf, flags...)
+	buf = append(buf, " db="...)
+	buf = strconv.AppendInt(buf, int64(db), 10)
+	buf = append(buf, " sub=0 psub=0 ssub=0 multi=-1 qbuf="...)
+	buf = strconv.AppendInt(buf, int64(qbuf), 10)
+	buf = append(buf, " qbuf-free=0 argv-mem=0 multi-mem=0 obl=0 oll=0 omem="...)
+	buf = strconv.AppendInt(buf, int64(obuf), 10)
+	buf = append(buf, " tot-mem="...)
+	buf = strconv.AppendInt(buf, int64(qbuf+obuf), 10)
+	buf = append(buf, " events=r cmd="...)
+	buf = append(buf, lastCmd...)
+	buf = append(buf, " user=default redir=-1 resp=2\n"...)
+	return buf
+}
+
+func (s *server) handleClient(sess *session) {
+	args := sess.args
+	if len(args) < 2 {
+		sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'CLIENT' command")
+		return
+	}
+
+	sub := args[1]
+	switch {
+	case strings.EqualFold(sub, "ID"):
+		sess.out = resp.AppendInt(sess.out, sess.cl.id)
+	case strings.EqualFold(sub, "SETNAME"):
+		if len(args) != 3 {
+			sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'CLIENT|SETNAME' command")
+			return
+		}
+		if strings.ContainsAny(args[2], " \n") {
+			sess.out = resp.AppendError(sess.out, "ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		name := strings.Clone(args[2])
+		sess.cl.mu.Lock()
+		sess.cl.name = name
+		sess.cl.mu.Unlock()
+		sess.out = resp.AppendString(sess.out, "OK")
+	case strings.EqualFold(sub, "GETNAME"):
+		sess.cl.mu.Lock()
+		name := sess.cl.name
+		sess.cl.mu.Unlock()
+		if name == "" {
+			sess.out = resp.AppendNullBulkString(sess.out)
+		} else {
+			sess.out = resp.AppendBulkString(sess.out, name)
+		}
+	case strings.EqualFold(sub, "INFO"):
+		sess.cl.touch(sess, 0)
+		info := sess.cl.appendInfo(nil, time.Now())
+		sess.out = resp.AppendBulkString(sess.out, string(info))
+	case strings.EqualFold(sub, "LIST"):
+		s.clientList(sess)
+	case strings.EqualFold(sub, "KILL"):
+		s.clientKill(sess)
+	case strings.EqualFold(sub, "PAUSE"):
+		s.clientPause(sess)
+	case strings.EqualFold(sub, "UNPAUSE"):
+		s.clients.pauseUntil.Store(0)
+		s.clients.pauseAll.Store(false)
+		s.clients.wakeAll()
+		sess.out = resp.AppendString(sess.out, "OK")
+	case strings.EqualFold(sub, "REPLY"):
+		if len(args) != 3 {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		switch {
+		case strings.EqualFold(args[2], "ON"):
+			sess.replyMode = replyOn
+			sess.out = resp.AppendString(sess.out, "OK")
+		case strings.EqualFold(args[2], "OFF"):
+			sess.replyMode = replyOff
+		case strings.EqualFold(args[2], "SKIP"):
+			if sess.replyMode == replyOn {
+				sess.replyMode = replySkip
+			}
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		}
+	case strings.EqualFold(sub, "NO-EVICT"):
+		if len(args) != 3 {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		var on bool
+		switch {
+		case strings.EqualFold(args[2], "ON"):
+			on = true
+		case strings.EqualFold(args[2], "OFF"):
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		sess.cl.mu.Lock()
+		sess.cl.noEvict = on
+		sess.cl.mu.Unlock()
+		sess.out = resp.AppendString(sess.out, "OK")
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try CLIENT HELP.")
+	}
+}
+
+func (s *server) clientList(sess *session) {
+	args := sess.args
+	var ids map[int64]bool
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "TYPE") && i+1 < len(args):
+			i++
+			if !strings.EqualFold(args[i], "normal") {
+				if !isClientType(args[i]) {
+					sess.out = resp.AppendError(sess.out, "ERR Unknown client type '"+args[i]+"'")
+					return
+				}
+				sess.out = resp.AppendBulkString(sess.out, "")
+				return
+			}
+		case strings.EqualFold(args[i], "ID") && i+1 < len(args):
+			ids = make(map[int64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || id <= 0 {
+					sess.out = resp.AppendError(sess.out, "ERR Invalid client ID")
+					return
+				}
+				ids[id] = true
+			}
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+
+	sess.cl.touch(sess, 0)
+	now := time.Now()
+	var buf []byte
+	for _, cl := range s.clients.snapshot() {
+		if ids != nil && !ids[cl.id] {
+			continue
+		}
+		buf = cl.appendInfo(buf, now)
+	}
+	sess.out = resp.AppendBulkString(sess.out, string(buf))
+}
+
+func (s *server) clientKill(sess *session) {
+	args := sess.args
+	if len(args) == 3 {
+		for _, cl := range s.clients.snapshot() {
+			if cl.addr == args[2] {
+				s.killClient(sess, cl)
+				sess.out = resp.AppendString(sess.out, "OK")
+				return
+			}
+		}
+		sess.out = resp.AppendError(sess.out, "ERR No such client")
+		return
+	}
+	if len(args) < 4 || len(args)%2 != 0 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+
+	var (
+		id      int64
+		addr    string
+		laddr   string
+		user    string
+		typ     string
+		skipMe  = true
+		matched int64
+	)
+	for i := 2; i < len(args); i += 2 {
+		opt, val := args[i], args[i+1]
+		switch {
+		case strings.EqualFold(opt, "ID"):
+			parsed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || parsed <= 0 {
+				sess.out = resp.AppendError(sess.out, "ERR client-id should be greater than 0")
+				return
+			}
+			id = parsed
+		case strings.EqualFold(opt, "ADDR"):
+			addr = val
+		case strings.EqualFold(opt, "LADDR"):
+			laddr = val
+		case strings.EqualFold(opt, "USER"):
+			user = val
+		case strings.EqualFold(opt, "TYPE"):
+			if !isClientType(val) {
+				sess.out = resp.AppendError(sess.out, "ERR Unknown client type '"+val+"'")
+				return
+			}
+			typ = val
+		case strings.EqualFold(opt, "SKIPME"):
+			switch {
+			case strings.EqualFold(val, "yes"):
+				skipMe = true
+			case strings.EqualFold(val, "no"):
+				skipMe = false
+			default:
+				sess.out = resp.AppendError(sess.out, "ERR syntax error")
+				return
+			}
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+
+	for _, cl := range s.clients.snapshot() {
+		if id != 0 && cl.id != id {
+			continue
+		}
+		if addr != "" && cl.addr != addr {
+			continue
+		}
+		if laddr != "" && cl.laddr != laddr {
+			continue
+		}
+		if user != "" && user != "default" {
+			continue
+		}
+		if typ != "" && !strings.EqualFold(typ, "normal") {
+			continue
+		}
+		if skipMe && cl == sess.cl {
+			continue
+		}
+		s.killClient(sess, cl)
+		matched++
+	}
+	sess.out = resp.AppendInt(sess.out, matched)
+}
+
+// killClient closes cl; gnet's Close is safe to call from any event loop.
+func (s *server) killClient(sess *session, cl *client) {
+	if cl == sess.cl {
+		sess.shouldClose = true
+		return
+	}
+	_ = cl.conn.Close()
+}
+
+func (s *server) clientPause(sess *session) {
+	args := sess.args
+	if len(args) < 3 || len(args) > 4 {
+		sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'CLIENT|PAUSE' command")
+		return
+	}
+	ms, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || ms < 0 {
+		sess.out = resp.AppendError(sess.out, "ERR timeout is not an integer or out of range")
+		return
+	}
+	all := true
+	if len(args) == 4 {
+		switch {
+		case strings.EqualFold(args[3], "WRITE"):
+			all = false
+		case strings.EqualFold(args[3], "ALL"):
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+	now := time.Now()
+	until := now.Add(time.Duration(ms) * time.Millisecond).UnixNano()
+	if s.clients.pauseUntil.Load() <= now.UnixNano() {
+		s.clients.pauseAll.Store(all)
+	} else if all {
+		s.clients.pauseAll.Store(true)
+	}
+	if until > s.clients.pauseUntil.Load() {
+		s.clients.pauseUntil.Store(until)
+	}
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+func isClientType(t string) bool {
+	return strings.EqualFold(t, "normal") || strings.EqualFold(t, "master") ||
+		strings.EqualFold(t, "replica") || strings.EqualFold(t, "slave") ||
+		strings.EqualFold(t, "pubsub")
+}
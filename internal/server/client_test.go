@@ -0,0 +1,131 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+// TestClientKill kills connections spread over both event loops from one
+// of them, by ID, by address and all at once.
+func TestClientKill(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), nil)
+	killer := dial(t, addr)
+	conns := make([]*rawConn, 6)
+	ids := make([]string, len(conns))
+	for i := range conns {
+		conns[i] = dial(t, addr)
+		ids[i] = strconv.FormatInt(conns[i].do("CLIENT", "ID").(int64), 10)
+	}
+	closed := func(c *rawConn) bool {
+		_, err := c.read()
+		return err != nil
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want any
+		// killed are the indexes of conns the command closes.
+		killed []int
+	}{
+		{"by id", []string{"CLIENT", "KILL", "ID", ids[0]}, int64(1), []int{0}},
+		{"by id, other loop", []string{"CLIENT", "KILL", "ID", ids[1]}, int64(1), []int{1}},
+		{"unknown id", []string{"CLIENT", "KILL", "ID", "999999"}, int64(0), nil},
+		{"old form", []string{"CLIENT", "KILL", clientAddr(t, killer, ids[2])}, "OK", []int{2}},
+		{"old form, no such client", []string{"CLIENT", "KILL", "127.0.0.1:1"}, kvclient.Error("ERR No such client"), nil},
+		{"other user", []string{"CLIENT", "KILL", "USER", "alice"}, int64(0), nil},
+		{"all but me", []string{"CLIENT", "KILL", "TYPE", "normal"}, int64(3), []int{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		if got := killer.do(tt.args...); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		for _, i := range tt.killed {
+			if !closed(conns[i]) {
+				t.Errorf("%s: connection %d still open", tt.name, i)
+			}
+		}
+	}
+
+	if got := killer.do("CLIENT", "KILL", "SKIPME", "no"); got != int64(1) {
+		t.Errorf("SKIPME no: %v, want 1", got)
+	}
+	if !closed(killer) {
+		t.Error("SKIPME no left the caller open")
+	}
+}
+
+// TestClientPause checks that WRITE pauses hold writes only, ALL pauses
+// hold everything but CLIENT, and UNPAUSE releases them early.
+func TestClientPause(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), nil)
+	admin, c := dial(t, addr), dial(t, addr)
+
+	tests := []struct {
+		mode     string
+		args     []string
+		held     bool
+		unpaused bool
+	}{
+		{"WRITE", []string{"GET", "k"}, false, false},
+		{"WRITE", []string{"SET", "k", "v"}, true, false},
+		{"WRITE", []string{"SET", "k", "v"}, true, true},
+		{"ALL", []string{"GET", "k"}, true, false},
+		{"ALL", []string{"CLIENT", "ID"}, false, false},
+	}
+	const pause = 300 * time.Millisecond
+	for _, tt := range tests {
+		if got := admin.do("CLIENT", "PAUSE", strconv.Itoa(int(pause/time.Millisecond)), tt.mode); got != "OK" {
+			t.Fatalf("CLIENT PAUSE %s: %v", tt.mode, got)
+		}
+		start := time.Now()
+		c.send(tt.args...)
+		if tt.unpaused {
+			time.Sleep(pause / 6)
+			if got := admin.do("CLIENT", "UNPAUSE"); got != "OK" {
+				t.Fatalf("CLIENT UNPAUSE: %v", got)
+			}
+		}
+		if _, err := c.read(); err != nil {
+			t.Fatalf("%s %v: %v", tt.mode, tt.args, err)
+		}
+		waited := time.Since(start)
+		switch {
+		case !tt.held && waited >= pause/2:
+			t.Errorf("%s %v waited %v, want no wait", tt.mode, tt.args, waited)
+		case tt.held && !tt.unpaused && waited < pause*9/10:
+			t.Errorf("%s %v waited %v, want the pause", tt.mode, tt.args, waited)
+		case tt.unpaused && waited >= pause*2/3:
+			t.Errorf("%s %v waited %v after UNPAUSE", tt.mode, tt.args, waited)
+		}
+		admin.do("CLIENT", "UNPAUSE")
+	}
+
+	for _, args := range [][]string{
+		{"CLIENT", "PAUSE"},
+		{"CLIENT", "PAUSE", "-1"},
+		{"CLIENT", "PAUSE", "10", "READ"},
+	} {
+		if _, ok := admin.do(args...).(kvclient.Error); !ok {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+// clientAddr returns the addr field CLIENT LIST shows for a client ID.
+func clientAddr(t *testing.T, c *rawConn, id string) string {
+	t.Helper()
+	list, _ := c.do("CLIENT", "LIST", "ID", id).(string)
+	for _, f := range strings.Fields(list) {
+		if addr, ok := strings.CutPrefix(f, "addr="); ok {
+			return addr
+		}
+	}
+	t.Fatalf("no client %s in %q", id, list)
+	return ""
+}
@@ -0,0 +1,549 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// Bitmaps are plain string values. Bit 0 is the most significant bit of
+// the first byte, as in Redis, and reads past the end see zeros. Writes
+// edit the value in place under the shard lock via EditHashed.
+
+const (
+	errBitOffset = "ERR bit offset is not an integer or out of range"
+	errNotInt    = "ERR value is not an integer or out of range"
+)
+
+// maxBitOffset bounds bit offsets to the bits of a MaxValueLen value.
+const maxBitOffset = storage.MaxValueLen*8 - 1
+
+func parseBitOffset(arg string) (uint64, bool) {
+	n, err := strconv.ParseUint(arg, 10, 64)
+	return n, err == nil && n <= maxBitOffset
+}
+
+func (s *server) cmdSetBit(sess *session) {
+	offset, ok := parseBitOffset(sess.args[2])
+	if !ok {
+		sess.out = resp.AppendError(sess.out, errBitOffset)
+		return
+	}
+	on := sess.args[3]
+	if on != "0" && on != "1" {
+		sess.out = resp.AppendError(sess.out, "ERR bit is not an integer or out of range")
+		return
+	}
+	key := sess.args[1]
+	var old byte
+	err := s.db(sess.db).EditHashed(xxhash.Sum64String(key), key, int(offset/8)+1, s.defaultExpireAt(), func(value []byte) {
+		mask := byte(0x80) >> (offset % 8)
+		old = value[offset/8] & mask
+		if on == "1" {
+			value[offset/8] |= mask
+		} else {
+			value[offset/8] &^= mask
+		}
+	})
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(min(old, 1)))
+}
+
+func (s *server) cmdGetBit(sess *session) {
+	offset, ok := parseBitOffset(sess.args[2])
+	if !ok {
+		sess.out = resp.AppendError(sess.out, errBitOffset)
+		return
+	}
+	key := sess.args[1]
+	hash := xxhash.Sum64String(key)
+	bit := 0
+	if _, err := s.db(sess.db).ViewHashed(hash, key, func(value []byte) {
+		if offset/8 < uint64(len(value)) && value[offset/8]&(0x80>>(offset%8)) != 0 {
+			bit = 1
+		}
+	}); err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(bit))
+}
+
+// bitRange is an inclusive range of bit positions parsed from start, end
+// and an optional BYTE | BIT unit.
+type bitRange struct {
+	start, end int64
+	byteUnit   bool
+}
+
+func parseBitUnit(args []string) (byteUnit bool, ok bool) {
+	switch {
+	case len(args) == 0, strings.EqualFold(args[0], "BYTE"):
+		return true, len(args) <= 1
+	case strings.EqualFold(args[0], "BIT"):
+		return false, len(args) == 1
+	}
+	return false, false
+}
+
+// resolve turns the range into bit positions within a value of n bytes,
+// counting negative bounds from the end. ok is false for an empty range.
+func (r bitRange) resolve(n int) (start, end int64, ok bool) {
+	total := int64(n)
+	if !r.byteUnit {
+		total *= 8
+	}
+	start, end = r.start, r.end
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if r.byteUnit {
+		return start * 8, end*8 + 7, true
+	}
+	return start, end, true
+}
+
+func (s *server) cmdBitCount(sess *session) {
+	args := sess.args
+	r := bitRange{start: 0, end: -1, byteUnit: true}
+	if len(args) > 2 {
+		var err1, err2 error
+		if len(args) < 4 {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		r.start, err1 = strconv.ParseInt(args[2], 10, 64)
+		r.end, err2 = strconv.ParseInt(args[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			sess.out = resp.AppendError(sess.out, errNotInt)
+			return
+		}
+		var ok bool
+		if r.byteUnit, ok = parseBitUnit(args[4:]); !ok {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+	key := args[1]
+	hash := xxhash.Sum64String(key)
+	var count int64
+	if _, err := s.db(sess.db).ViewHashed(hash, key, func(value []byte) {
+		if start, end, ok := r.resolve(len(value)); ok {
+			count = countBits(value, start, end)
+		}
+	}); err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, count)
+}
+
+// countBits counts the set bits in the inclusive bit range, a word at a
+// time between the partial first and last bytes.
+func countBits(value []byte, start, end int64) int64 {
+	first, last := start/8, end/8
+	if first == last {
+		return int64(bits.OnesCount8(value[first] & (0xff >> (start % 8)) & (0xff << (7 - end%8))))
+	}
+	n := bits.OnesCount8(value[first]&(0xff>>(start%8))) + bits.OnesCount8(value[last]&(0xff<<(7-end%8)))
+	mid := value[first+1 : last]
+	for len(mid) >= 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(mid))
+		mid = mid[8:]
+	}
+	for _, b := range mid {
+		n += bits.OnesCount8(b)
+	}
+	return int64(n)
+}
+
+func (s *server) cmdBitPos(sess *session) {
+	args := sess.args
+	if args[2] != "0" && args[2] != "1" {
+		sess.out = resp.AppendError(sess.out, "ERR The bit argument must be 1 or 0.")
+		return
+	}
+	want := args[2] == "1"
+	r := bitRange{start: 0, end: -1, byteUnit: true}
+	endGiven := len(args) > 4
+	var err1, err2 error
+	if len(args) > 3 {
+		r.start, err1 = strconv.ParseInt(args[3], 10, 64)
+	}
+	if endGiven {
+		r.end, err2 = strconv.ParseInt(args[4], 10, 64)
+	}
+	if err1 != nil || err2 != nil {
+		sess.out = resp.AppendError(sess.out, errNotInt)
+		return
+	}
+	if len(args) > 5 {
+		var ok bool
+		if r.byteUnit, ok = parseBitUnit(args[5:]); !ok {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+	key := args[1]
+	hash := xxhash.Sum64String(key)
+	pos := int64(-1)
+	found, err := s.db(sess.db).ViewHashed(hash, key, func(value []byte) {
+		start, end, ok := r.resolve(len(value))
+		if !ok {
+			return
+		}
+		pos = findBit(value, start, end, want)
+		// Without an explicit end the value is taken as padded with zeros
+		// on the right, so a clear bit always exists.
+		if pos < 0 && !want && !endGiven {
+			pos = end + 1
+		}
+	})
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	if !found && !want {
+		pos = 0
+	}
+	sess.out = resp.AppendInt(sess.out, pos)
+}
+
+// findBit returns the first position in the inclusive bit range holding
+// want, -1 if none. Whole bytes without it are skipped.
+func findBit(value []byte, start, end int64, want bool) int64 {
+	skip := byte(0)
+	if !want {
+		skip = 0xff
+	}
+	for pos := start; pos <= end; {
+		b := value[pos/8]
+		if pos%8 == 0 && pos+7 <= end && b == skip {
+			pos += 8
+			continue
+		}
+		if (b&(0x80>>(pos%8)) != 0) == want {
+			return pos
+		}
+		pos++
+	}
+	return -1
+}
+
+func (s *server) cmdBitOp(sess *session) {
+	args := sess.args
+	op := strings.ToUpper(args[1])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 4 {
+			sess.out = resp.AppendError(sess.out, "ERR BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	dest, srcs := args[2], args[3:]
+	hashes := make([]uint64, len(args)-2)
+	for i, key := range args[2:] {
+		hashes[i] = xxhash.Sum64String(key)
+	}
+	expireAt := s.defaultExpireAt()
+	var n int
+	wrongType := false
+	s.db(sess.db).Atomically(hashes, func(l *storage.Locked) {
+		var result []byte
+		for i, key := range srcs {
+			apply := func(src []byte) { result = applyBitOp(op, result, src, i == 0) }
+			if !l.View(hashes[i+1], key, apply) {
+				if wrongType = isWrongType(l.Type(hashes[i+1], key)); wrongType {
+					return
+				}
+				apply(nil)
+			}
+		}
+		n = len(result)
+		if n == 0 {
+			l.Delete(hashes[0], dest)
+			return
+		}
+		l.Set(hashes[0], dest, unsafe.String(unsafe.SliceData(result), n), expireAt)
+	})
+	if wrongType {
+		sess.out = resp.AppendError(sess.out, errWrongType)
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+// applyBitOp folds src into result. Shorter operands count as zero-padded,
+// so the result is as long as the longest source.
+func applyBitOp(op string, result, src []byte, first bool) []byte {
+	if first {
+		result = append([]byte(nil), src...)
+		if op == "NOT" {
+			for i := range result {
+				result[i] = ^result[i]
+			}
+		}
+		return result
+	}
+	if len(src) > len(result) {
+		result = append(result, make([]byte, len(src)-len(result))...)
+	}
+	for i := range result {
+		var b byte
+		if i < len(src) {
+			b = src[i]
+		}
+		switch op {
+		case "AND":
+			result[i] &= b
+		case "OR":
+			result[i] |= b
+		case "XOR":
+			result[i] ^= b
+		}
+	}
+	return result
+}
+
+// bitfieldOp is one GET, SET or INCRBY of a BITFIELD call.
+type bitfieldOp struct {
+	kind     byte // 'g', 's' or 'i'
+	signed   bool
+	width    uint
+	offset   uint64
+	value    int64
+	overflow byte // 'w'rap, 's'at or 'f'ail
+}
+
+func (s *server) cmdBitField(sess *session) {
+	s.bitfield(sess, false)
+}
+
+func (s *server) cmdBitFieldRO(sess *session) {
+	s.bitfield(sess, true)
+}
+
+func (s *server) bitfield(sess *session, readonly bool) {
+	args := sess.args
+	ops := make([]bitfieldOp, 0, (len(args)-2)/3)
+	overflow := byte('w')
+	size := 0
+	for i := 2; i < len(args); {
+		sub := strings.ToUpper(args[i])
+		if sub == "OVERFLOW" && i+1 < len(args) {
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = 'w'
+			case "SAT":
+				overflow = 's'
+			case "FAIL":
+				overflow = 'f'
+			default:
+				sess.out = resp.AppendError(sess.out, "ERR Invalid OVERFLOW type specified")
+				return
+			}
+			i += 2
+			continue
+		}
+		need := 3
+		if sub == "SET" || sub == "INCRBY" {
+			need = 4
+		} else if sub != "GET" {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		if i+need > len(args) {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		if readonly && sub != "GET" {
+			sess.out = resp.AppendError(sess.out, "ERR BITFIELD_RO only supports the GET subcommand")
+			return
+		}
+		op := bitfieldOp{kind: strings.ToLower(sub)[0], overflow: overflow}
+		var ok bool
+		if op.signed, op.width, ok = parseBitfieldType(args[i+1]); !ok {
+			sess.out = resp.AppendError(sess.out, "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+			return
+		}
+		if op.offset, ok = parseBitfieldOffset(args[i+2], op.width); !ok {
+			sess.out = resp.AppendError(sess.out, errBitOffset)
+			return
+		}
+		if need == 4 {
+			v, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				sess.out = resp.AppendError(sess.out, errNotInt)
+				return
+			}
+			op.value = v
+			size = max(size, int((op.offset+uint64(op.width)+7)/8))
+		}
+		ops = append(ops, op)
+		i += need
+	}
+
+	key := args[1]
+	hash := xxhash.Sum64String(key)
+	// Replies go to a scratch buffer first, as an error may still come
+	// from the storage before any of them.
+	var out []byte
+	run := func(value []byte) {
+		for _, op := range ops {
+			out = op.apply(out, value)
+		}
+	}
+	if size == 0 {
+		found, err := s.db(sess.db).ViewHashed(hash, key, run)
+		if err != nil {
+			sess.out = resp.AppendError(sess.out, err.Error())
+			return
+		}
+		if !found {
+			run(nil)
+		}
+	} else if err := s.db(sess.db).EditHashed(hash, key, size, s.defaultExpireAt(), run); err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(ops))
+	sess.out = append(sess.out, out...)
+}
+
+// parseBitfieldType parses i1..i64 and u1..u63.
+func parseBitfieldType(arg string) (bool, uint, bool) {
+	if len(arg) < 2 {
+		return false, 0, false
+	}
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	if !signed && arg[0] != 'u' && arg[0] != 'U' {
+		return false, 0, false
+	}
+	width, err := strconv.Atoi(arg[1:])
+	if err != nil || width < 1 || width > 64 || !signed && width == 64 {
+		return false, 0, false
+	}
+	return signed, uint(width), true
+}
+
+// parseBitfieldOffset parses a bit offset, or #n for the n-th field of
+// the given width.
+func parseBitfieldOffset(arg string, width uint) (uint64, bool) {
+	scale := uint64(1)
+	if strings.HasPrefix(arg, "#") {
+		arg, scale = arg[1:], uint64(width)
+	}
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || n > maxBitOffset/scale {
+		return 0, false
+	}
+	n *= scale
+	return n, n+uint64(width)-1 <= maxBitOffset
+}
+
+// apply runs the op on value, which is long enough for writes, and
+// appends its reply.
+func (op *bitfieldOp) apply(out, value []byte) []byte {
+	old := getBitfield(value, op.offset, op.width)
+	if op.kind == 'g' {
+		return resp.AppendInt(out, op.decode(old))
+	}
+	var next int64
+	var ok bool
+	if op.kind == 's' {
+		next, ok = op.fit(op.value, 0)
+	} else {
+		next, ok = op.fit(op.decode(old), op.value)
+	}
+	if !ok {
+		return resp.AppendNullBulkString(out)
+	}
+	setBitfield(value, op.offset, op.width, uint64(next))
+	if op.kind == 's' {
+		return resp.AppendInt(out, op.decode(old))
+	}
+	return resp.AppendInt(out, next)
+}
+
+func (op *bitfieldOp) decode(raw uint64) int64 {
+	if op.signed {
+		return int64(raw<<(64-op.width)) >> (64 - op.width)
+	}
+	return int64(raw)
+}
+
+// fit returns v+incr in the field's range under its overflow policy, ok
+// false if it fails. SET passes the new value as v with no increment.
+func (op *bitfieldOp) fit(v, incr int64) (int64, bool) {
+	mask := uint64(math.MaxUint64) >> (64 - op.width)
+	wrapped := (uint64(v) + uint64(incr)) & mask
+	var over, under bool
+	var hi, lo int64
+	if op.signed {
+		hi = int64(mask >> 1)
+		lo = -hi - 1
+		over = incr > 0 && v > hi-incr || incr == 0 && v > hi
+		under = incr < 0 && v < lo-incr || incr == 0 && v < lo
+	} else {
+		// An unsigned SET of a negative value counts as too large, as in
+		// Redis.
+		hi = int64(mask)
+		over = incr > 0 && uint64(incr) > mask-uint64(v) || incr == 0 && uint64(v) > mask
+		under = incr < 0 && uint64(-incr) > uint64(v)
+	}
+	switch {
+	case (over || under) && op.overflow == 'w', !over && !under:
+		return op.decode(wrapped), true
+	case over:
+		return hi, op.overflow == 's'
+	}
+	return lo, op.overflow == 's'
+}
+
+func getBitfield(value []byte, offset uint64, width uint) uint64 {
+	var v uint64
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		v <<= 1
+		if pos/8 < uint64(len(value)) {
+			v |= uint64(value[pos/8]>>(7-pos%8)) & 1
+		}
+	}
+	return v
+}
+
+func setBitfield(value []byte, offset uint64, width uint, v uint64) {
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		mask := byte(0x80) >> (pos % 8)
+		if v>>(uint64(width)-1-i)&1 != 0 {
+			value[pos/8] |= mask
+		} else {
+			value[pos/8] &^= mask
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestBitCommands replays the examples of Redis' bit command docs, whose
+// replies these are, then overflow handling and the corners around them.
+func TestBitCommands(t *testing.T) {
+	steps := []struct {
+		cmd  string
+		want any
+		err  string
+	}{
+		{"SETBIT mykey 7 1", int64(0), ""},
+		{"SETBIT mykey 7 0", int64(1), ""},
+		{"GET mykey", "\x00", ""},
+		{"SETBIT mykey 100 1", int64(0), ""},
+		{"STRLEN mykey", int64(13), ""},
+		{"GETBIT mykey 100", int64(1), ""},
+		{"GETBIT mykey 1000", int64(0), ""},
+		{"SETBIT mykey 7 2", nil, "ERR bit is not an integer or out of range"},
+		{"SETBIT mykey 4294967296 1", nil, "ERR bit offset is not an integer or out of range"},
+
+		{"SET mykey foobar", "OK", ""},
+		{"BITCOUNT mykey", int64(26), ""},
+		{"BITCOUNT mykey 0 0", int64(4), ""},
+		{"BITCOUNT mykey 1 1", int64(6), ""},
+		{"BITCOUNT mykey 1 1 BYTE", int64(6), ""},
+		{"BITCOUNT mykey 5 30 BIT", int64(17), ""},
+		{"BITCOUNT mykey -2 -1", int64(7), ""},
+		{"BITCOUNT missing", int64(0), ""},
+		{"BITCOUNT mykey 0", nil, "ERR syntax error"},
+
+		{"SET mykey \xff\xf0\x00", "OK", ""},
+		{"BITPOS mykey 0", int64(12), ""},
+		{"SET mykey \x00\xff\xf0", "OK", ""},
+		{"BITPOS mykey 1 0", int64(8), ""},
+		{"BITPOS mykey 1 2", int64(16), ""},
+		{"BITPOS mykey 1 2 -1 BYTE", int64(16), ""},
+		{"BITPOS mykey 1 7 15 BIT", int64(8), ""},
+		{"SET mykey \x00\x00\x00", "OK", ""},
+		{"BITPOS mykey 1", int64(-1), ""},
+		{"BITPOS mykey 1 7 -3 BIT", int64(-1), ""},
+		{"SET mykey \xff\xff\xff", "OK", ""},
+		// Past the end of the string counts as clear unless a range ends
+		// the search.
+		{"BITPOS mykey 0", int64(24), ""},
+		{"BITPOS mykey 0 0 -1", int64(-1), ""},
+		{"BITPOS missing 0", int64(0), ""},
+
+		{"SET key1 foobar", "OK", ""},
+		{"SET key2 abcdef", "OK", ""},
+		{"BITOP AND dest key1 key2", int64(6), ""},
+		{"GET dest", "`bc`ab", ""},
+		{"BITOP OR dest key1 key2", int64(6), ""},
+		{"GET dest", "goofev", ""},
+		{"BITOP XOR dest key1 missing", int64(6), ""},
+		{"GET dest", "foobar", ""},
+		{"BITOP NOT dest key1 key2", nil, "ERR BITOP NOT must be called with a single source key."},
+		{"BITOP NOT dest missing", int64(0), ""},
+		{"EXISTS dest", int64(0), ""},
+
+		{"BITFIELD mykey2 INCRBY i5 100 1 GET u4 0", []any{int64(1), int64(0)}, ""},
+		{"BITFIELD mystring SET i8 #0 100 SET i8 #1 200", []any{int64(0), int64(0)}, ""},
+		{"GET mystring", "d\xc8", ""},
+		{"BITFIELD mystring GET i8 #1 GET u8 #1", []any{int64(-56), int64(200)}, ""},
+		{"BITFIELD mykey3 INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{int64(1), int64(1)}, ""},
+		{"BITFIELD mykey3 INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{int64(2), int64(2)}, ""},
+		{"BITFIELD mykey3 INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{int64(3), int64(3)}, ""},
+		{"BITFIELD mykey3 INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{int64(0), int64(3)}, ""},
+		{"BITFIELD mykey3 OVERFLOW FAIL INCRBY u2 102 1 GET u2 102", []any{nil, int64(3)}, ""},
+		{"BITFIELD mykey3 OVERFLOW SAT SET i4 0 100 GET i4 0", []any{int64(0), int64(7)}, ""},
+		{"BITFIELD mykey3 OVERFLOW WRAP INCRBY i4 0 1 OVERFLOW SAT INCRBY i4 0 -100", []any{int64(-8), int64(-8)}, ""},
+		{"BITFIELD mykey4 SET i64 0 -1 GET u63 1", []any{int64(0), int64(9223372036854775807)}, ""},
+		{"BITFIELD mykey4 GET u64 0", nil, "ERR Invalid bitfield type."},
+		{"BITFIELD mykey4 GET i65 0", nil, "ERR Invalid bitfield type."},
+		{"BITFIELD mykey4 GET i8 -1", nil, "ERR bit offset is not an integer or out of range"},
+		{"BITFIELD mykey4 OVERFLOW MAYBE GET i8 0", nil, "ERR Invalid OVERFLOW type specified"},
+		{"SET hello Hello", "OK", ""},
+		{"BITFIELD_RO hello GET i8 16", []any{int64(108)}, ""},
+		{"BITFIELD_RO hello SET i8 16 1", nil, "ERR BITFIELD_RO only supports the GET subcommand"},
+
+		{"GEOADD g 13.36 38.11 a", int64(1), ""},
+		{"SETBIT g 0 1", nil, wrongType},
+		{"GETBIT g 0", nil, wrongType},
+		{"BITCOUNT g", nil, wrongType},
+		{"BITPOS g 1", nil, wrongType},
+		{"BITOP AND dest g", nil, wrongType},
+		{"BITFIELD g GET u8 0", nil, wrongType},
+		{"BITFIELD_RO g GET u8 0", nil, wrongType},
+	}
+	for _, mode := range []map[string]string{nil, {"pipeline-batch": "64"}} {
+		c := startServer(t, mode)
+		for _, st := range steps {
+			got, err := c.Do(context.Background(), strings.Fields(st.cmd)...)
+			switch {
+			case st.err != "":
+				if err == nil || !strings.HasPrefix(err.Error(), st.err) {
+					t.Errorf("%v %q: got %v, %v, want error %q", mode, st.cmd, got, err, st.err)
+				}
+			case err != nil || !reflect.DeepEqual(got, st.want):
+				t.Errorf("%v %q: got %#v, %v, want %#v", mode, st.cmd, got, err, st.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VoolFI71/go-kv-store/internal/geo"
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// Geospatial indexes are sorted sets scored by 52-bit geohashes, see
+// package geo. A search scans the score ranges of up to nine cells around
+// the center and filters the points by their exact distance.
+
+const (
+	errNotFloat = "ERR value is not a valid float"
+	errGeoUnit  = "ERR unsupported unit provided. please use M, KM, FT, MI"
+)
+
+// parseGeoUnit returns the meters in unit.
+func parseGeoUnit(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func parseFloatArg(arg string) (float64, bool) {
+	f, err := strconv.ParseFloat(arg, 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// parseLonLat parses a point, replying with the error if it is invalid.
+func parseLonLat(sess *session, lonArg, latArg string) (lon, lat float64, ok bool) {
+	lon, lonOK := parseFloatArg(lonArg)
+	lat, latOK := parseFloatArg(latArg)
+	if !lonOK || !latOK {
+		sess.out = resp.AppendError(sess.out, errNotFloat)
+		return 0, 0, false
+	}
+	if !geo.Valid(lon, lat) {
+		sess.out = resp.AppendError(sess.out, fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lon, lat))
+		return 0, 0, false
+	}
+	return lon, lat, true
+}
+
+// cmdGeoAdd parses GEOADD key [NX | XX] [CH] longitude latitude member
+// [longitude latitude member ...].
+func (s *server) cmdGeoAdd(sess *session) {
+	args := sess.args
+	nx, xx, ch := false, false, false
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch opt := args[i]; {
+		case strings.EqualFold(opt, "NX"):
+			nx = true
+		case strings.EqualFold(opt, "XX"):
+			xx = true
+		case strings.EqualFold(opt, "CH"):
+			ch = true
+		default:
+			break options
+		}
+	}
+	if nx && xx {
+		sess.out = resp.AppendError(sess.out, "ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	points := args[i:]
+	if len(points) == 0 || len(points)%3 != 0 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+		return
+	}
+	members := make([]string, len(points)/3)
+	scores := make([]float64, len(points)/3)
+	for j := range members {
+		lon, lat, ok := parseLonLat(sess, points[3*j], points[3*j+1])
+		if !ok {
+			return
+		}
+		members[j], scores[j] = points[3*j+2], float64(geo.Encode(lon, lat))
+	}
+	key := args[1]
+	added, changed, err := s.db(sess.db).ZAddHashed(xxhash.Sum64String(key), key, members, scores, nx, xx, s.defaultExpireAt())
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	if ch {
+		added += changed
+	}
+	sess.out = resp.AppendInt(sess.out, int64(added))
+}
+
+// geoScores returns the scores of members at key, ok false for a missing
+// one. It replies with the error if key is not a sorted set.
+func (s *server) geoScores(sess *session, key string, members []string) (scores []uint64, ok []bool, err error) {
+	scores, ok = make([]uint64, len(members)), make([]bool, len(members))
+	_, err = s.db(sess.db).ZViewHashed(xxhash.Sum64String(key), key, func(z *storage.ZSet) {
+		for i, member := range members {
+			score, found := z.Score(member)
+			scores[i], ok[i] = uint64(score), found
+		}
+	})
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+	}
+	return scores, ok, err
+}
+
+func (s *server) cmdGeoPos(sess *session) {
+	members := sess.args[2:]
+	scores, ok, err := s.geoScores(sess, sess.args[1], members)
+	if err != nil {
+		return
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(members))
+	for i := range members {
+		if !ok[i] {
+			sess.out = resp.AppendNullArray(sess.out)
+			continue
+		}
+		sess.out = appendGeoCoord(sess.out, scores[i])
+	}
+}
+
+func (s *server) cmdGeoHash(sess *session) {
+	members := sess.args[2:]
+	scores, ok, err := s.geoScores(sess, sess.args[1], members)
+	if err != nil {
+		return
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(members))
+	for i := range members {
+		if !ok[i] {
+			sess.out = resp.AppendNullBulkString(sess.out)
+			continue
+		}
+		sess.out = resp.AppendBulkString(sess.out, geo.String(scores[i]))
+	}
+}
+
+func (s *server) cmdGeoDist(sess *session) {
+	args := sess.args
+	unit := 1.0
+	switch len(args) {
+	case 4:
+	case 5:
+		var ok bool
+		if unit, ok = parseGeoUnit(args[4]); !ok {
+			sess.out = resp.AppendError(sess.out, errGeoUnit)
+			return
+		}
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	scores, ok, err := s.geoScores(sess, args[1], args[2:4])
+	if err != nil {
+		return
+	}
+	if !ok[0] || !ok[1] {
+		sess.out = resp.AppendNullBulkString(sess.out)
+		return
+	}
+	lon1, lat1 := geo.Decode(scores[0])
+	lon2, lat2 := geo.Decode(scores[1])
+	sess.out = resp.AppendBulkString(sess.out, formatGeoDist(geo.Distance(lon1, lat1, lon2, lat2)/unit))
+}
+
+// geoSearch is a parsed GEOSEARCH or GEOSEARCHSTORE query.
+type geoSearch struct {
+	shape geo.Shape
+	// unit is the meters in the unit of the radius or box, in which
+	// distances are replied and stored.
+	unit     float64
+	byMember bool
+	member   string
+	// order is 1 for ASC, -1 for DESC and 0 for unsorted.
+	order int
+	count int
+	any   bool
+
+	withCoord, withDist, withHash bool
+	storeDist                     bool
+}
+
+type geoHit struct {
+	member string
+	score  uint64
+	dist   float64
+}
+
+// parseGeoSearch parses the options of GEOSEARCH, after the key, or of
+// GEOSEARCHSTORE if store is set, replying with the error if they are
+// invalid.
+func parseGeoSearch(sess *session, args []string, store bool) (*geoSearch, bool) {
+	q := &geoSearch{}
+	fromLonLat, byRadius, byBox := false, false, false
+	fail := func(msg string) (*geoSearch, bool) {
+		sess.out = resp.AppendError(sess.out, msg)
+		return nil, false
+	}
+	for i := 0; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch arg := args[i]; {
+		case !store && strings.EqualFold(arg, "WITHDIST"):
+			q.withDist = true
+		case !store && strings.EqualFold(arg, "WITHHASH"):
+			q.withHash = true
+		case !store && strings.EqualFold(arg, "WITHCOORD"):
+			q.withCoord = true
+		case store && strings.EqualFold(arg, "STOREDIST"):
+			q.storeDist = true
+		case strings.EqualFold(arg, "ANY"):
+			q.any = true
+		case strings.EqualFold(arg, "ASC"):
+			q.order = 1
+		case strings.EqualFold(arg, "DESC"):
+			q.order = -1
+		case strings.EqualFold(arg, "COUNT") && left > 0:
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return fail(errNotInt)
+			}
+			if n <= 0 {
+				return fail("ERR COUNT must be > 0")
+			}
+			q.count = int(min(n, math.MaxInt32))
+			i++
+		case strings.EqualFold(arg, "FROMMEMBER") && left > 0:
+			if q.byMember || fromLonLat {
+				return fail("ERR syntax error")
+			}
+			q.byMember, q.member = true, args[i+1]
+			i++
+		case strings.EqualFold(arg, "FROMLONLAT") && left > 1:
+			if q.byMember || fromLonLat {
+				return fail("ERR syntax error")
+			}
+			lon, lat, ok := parseLonLat(sess, args[i+1], args[i+2])
+			if !ok {
+				return nil, false
+			}
+			fromLonLat, q.shape.Lon, q.shape.Lat = true, lon, lat
+			i += 2
+		case strings.EqualFold(arg, "BYRADIUS") && left > 1:
+			if byRadius || byBox {
+				return fail("ERR syntax error")
+			}
+			radius, ok := parseFloatArg(args[i+1])
+			if !ok {
+				return fail(errNotFloat)
+			}
+			if radius < 0 {
+				return fail("ERR radius cannot be negative")
+			}
+			if q.unit, ok = parseGeoUnit(args[i+2]); !ok {
+				return fail(errGeoUnit)
+			}
+			byRadius, q.shape.Radius = true, radius*q.unit
+			i += 2
+		case strings.EqualFold(arg, "BYBOX") && left > 2:
+			if byRadius || byBox {
+				return fail("ERR syntax error")
+			}
+			width, wOK := parseFloatArg(args[i+1])
+			height, hOK := parseFloatArg(args[i+2])
+			if !wOK || !hOK {
+				return fail(errNotFloat)
+			}
+			if width < 0 || height < 0 {
+				return fail("ERR height or width cannot be negative")
+			}
+			var ok bool
+			if q.unit, ok = parseGeoUnit(args[i+3]); !ok {
+				return fail(errGeoUnit)
+			}
+			byBox, q.shape.Box = true, true
+			q.shape.Width, q.shape.Height = width*q.unit, height*q.unit
+			i += 3
+		default:
+			return fail("ERR syntax error")
+		}
+	}
+	switch {
+	case q.byMember == fromLonLat:
+		return fail("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + sess.args[0])
+	case byRadius == byBox:
+		return fail("ERR exactly one of BYRADIUS and BYBOX can be specified for " + sess.args[0])
+	case q.any && q.count == 0:
+		return fail("ERR the ANY argument requires COUNT argument")
+	}
+	// The closest COUNT points need sorting; ANY takes the first found.
+	if q.count > 0 && q.order == 0 && !q.any {
+		q.order = 1
+	}
+	return q, true
+}
+
+// run searches z and returns the points found, sorted and limited as the
+// query asks. It reports false if the FROMMEMBER member is missing.
+func (q *geoSearch) run(z *storage.ZSet) ([]geoHit, bool) {
+	if q.byMember {
+		score, ok := z.Score(q.member)
+		if !ok {
+			return nil, false
+		}
+		q.shape.Lon, q.shape.Lat = geo.Decode(uint64(score))
+	}
+	limit := 0
+	if q.any {
+		limit = q.count
+	}
+	var hits []geoHit
+	for _, r := range q.shape.Ranges() {
+		if limit > 0 && len(hits) >= limit {
+			break
+		}
+		z.RangeByScore(float64(r.Min), float64(r.Max), func(member string, score float64) bool {
+			if dist, ok := q.shape.Contains(uint64(score)); ok {
+				hits = append(hits, geoHit{member, uint64(score), dist})
+			}
+			return limit == 0 || len(hits) < limit
+		})
+	}
+	if q.order != 0 {
+		slices.SortStableFunc(hits, func(a, b geoHit) int {
+			return q.order * cmp.Compare(a.dist, b.dist)
+		})
+	}
+	if q.count > 0 && len(hits) > q.count {
+		hits = hits[:q.count]
+	}
+	return hits, true
+}
+
+// cmdGeoSearch parses GEOSEARCH key <FROMMEMBER member | FROMLONLAT
+// longitude latitude> <BYRADIUS radius unit | BYBOX width height unit>
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH].
+func (s *server) cmdGeoSearch(sess *session) {
+	q, ok := parseGeoSearch(sess, sess.args[2:], false)
+	if !ok {
+		return
+	}
+	key := sess.args[1]
+	var hits []geoHit
+	found := true
+	_, err := s.db(sess.db).ZViewHashed(xxhash.Sum64String(key), key, func(z *storage.ZSet) {
+		hits, found = q.run(z)
+	})
+	switch {
+	case err != nil:
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	case !found:
+		sess.out = resp.AppendError(sess.out, "ERR could not decode requested zset member")
+		return
+	}
+	with := 1
+	for _, on := range []bool{q.withDist, q.withHash, q.withCoord} {
+		if on {
+			with++
+		}
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(hits))
+	for _, h := range hits {
+		if with == 1 {
+			sess.out = resp.AppendBulkString(sess.out, h.member)
+			continue
+		}
+		sess.out = resp.AppendArrayHeader(sess.out, with)
+		sess.out = resp.AppendBulkString(sess.out, h.member)
+		if q.withDist {
+			sess.out = resp.AppendBulkString(sess.out, formatGeoDist(h.dist/q.unit))
+		}
+		if q.withHash {
+			sess.out = resp.AppendInt(sess.out, int64(h.score))
+		}
+		if q.withCoord {
+			sess.out = appendGeoCoord(sess.out, h.score)
+		}
+	}
+}
+
+// cmdGeoSearchStore is GEOSEARCH from source into destination, which is
+// replaced by a sorted set of the points found, scored by geohash or with
+// STOREDIST by distance. Both keys are locked for the whole search.
+func (s *server) cmdGeoSearchStore(sess *session) {
+	q, ok := parseGeoSearch(sess, sess.args[3:], true)
+	if !ok {
+		return
+	}
+	dest, src := sess.args[1], sess.args[2]
+	hashes := []uint64{xxhash.Sum64String(dest), xxhash.Sum64String(src)}
+	var hits []geoHit
+	found := true
+	var err error
+	s.db(sess.db).Atomically(hashes, func(l *storage.Locked) {
+		if _, err = l.ZView(hashes[1], src, func(z *storage.ZSet) { hits, found = q.run(z) }); err != nil || !found {
+			return
+		}
+		members := make([]string, len(hits))
+		scores := make([]float64, len(hits))
+		for i, h := range hits {
+			members[i], scores[i] = h.member, float64(h.score)
+			if q.storeDist {
+				scores[i] = h.dist / q.unit
+			}
+		}
+		l.ZStore(hashes[0], dest, members, scores, s.defaultExpireAt())
+	})
+	switch {
+	case err != nil:
+		sess.out = resp.AppendError(sess.out, err.Error())
+	case !found:
+		sess.out = resp.AppendError(sess.out, "ERR could not decode requested zset member")
+	default:
+		sess.out = resp.AppendInt(sess.out, int64(len(hits)))
+	}
+}
+
+// appendGeoCoord appends the longitude and latitude of score like Redis
+// prints them: up to 17 decimals without trailing zeros.
+func appendGeoCoord(out []byte, score uint64) []byte {
+	lon, lat := geo.Decode(score)
+	out = resp.AppendArrayHeader(out, 2)
+	out = resp.AppendBulkString(out, formatGeoCoord(lon))
+	return resp.AppendBulkString(out, formatGeoCoord(lat))
+}
+
+func formatGeoCoord(f float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(f, 'f', 17, 64), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func formatGeoDist(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestGeoCommands replays the examples of Redis' GEO command docs, whose
+// replies these are, then the corners around them.
+func TestGeoCommands(t *testing.T) {
+	palermo := []any{"13.36138933897018433", "38.11555639549629859"}
+	catania := []any{"15.08726745843887329", "37.50266842333162032"}
+	steps := []struct {
+		cmd  string
+		want any
+		err  string
+	}{
+		{"GEOADD Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", int64(2), ""},
+		{"GEODIST Sicily Palermo Catania", "166274.1516", ""},
+		{"GEODIST Sicily Palermo Catania km", "166.2742", ""},
+		{"GEODIST Sicily Palermo Catania mi", "103.3182", ""},
+		{"GEODIST Sicily Foo Bar", nil, ""},
+		{"GEOHASH Sicily Palermo Catania", []any{"sqc8b49rny0", "sqdtr74hyu0"}, ""},
+		{"GEOPOS Sicily Palermo Catania NonExisting", []any{palermo, catania, nil}, ""},
+		{"GEOADD Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2", int64(2), ""},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC", []any{"Catania", "Palermo"}, ""},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHCOORD WITHDIST", []any{
+			[]any{"Catania", "56.4413", catania},
+			[]any{"Palermo", "190.4424", palermo},
+			[]any{"edge2", "279.7403", []any{"17.24151045083999634", "38.78813451624225195"}},
+			[]any{"edge1", "279.7405", []any{"12.7584877610206604", "38.78813451624225195"}},
+		}, ""},
+		{"GEOADD Sicily 13.583333 37.316667 Agrigento", int64(1), ""},
+		{"GEOSEARCH Sicily FROMMEMBER Agrigento BYRADIUS 100 km", []any{"Agrigento", "Palermo"}, ""},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km DESC COUNT 1 WITHDIST", []any{[]any{"Palermo", "190.4424"}}, ""},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 1 m", []any{}, ""},
+		{"GEOSEARCH missing FROMLONLAT 15 37 BYRADIUS 200 km", []any{}, ""},
+		{"GEOSEARCHSTORE dst Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3", int64(3), ""},
+		{"GEOSEARCH dst FROMLONLAT 15 37 BYBOX 400 400 km ASC", []any{"Catania", "Agrigento", "Palermo"}, ""},
+		{"GEOSEARCHSTORE dst Sicily FROMLONLAT 0 0 BYRADIUS 1 km", int64(0), ""},
+		{"EXISTS dst", int64(0), ""},
+		{"GEOADD Sicily XX CH 13.361389 38.115556 Palermo 1 1 New", int64(0), ""},
+		{"GEOADD Sicily NX CH 1 1 Palermo 1 1 New", int64(1), ""},
+		{"GEOPOS Sicily Palermo", []any{palermo}, ""},
+		{"GEOADD Sicily 200 0 bad", nil, "ERR invalid longitude,latitude pair 200.000000,0.000000"},
+		{"GEOADD Sicily 0 86 bad", nil, "ERR invalid longitude,latitude pair"},
+		{"GEOADD Sicily NX XX 1 1 bad", nil, "ERR XX and NX options at the same time are not compatible"},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200", nil, "ERR syntax error"},
+		{"GEOSEARCH Sicily FROMMEMBER nobody BYRADIUS 200 km", nil, "ERR could not decode requested zset member"},
+		{"SET s v", "OK", ""},
+		{"GEOPOS s a", nil, wrongType},
+		{"GEOSEARCH s FROMLONLAT 15 37 BYRADIUS 200 km", nil, wrongType},
+	}
+	c := startServer(t, nil)
+	for _, st := range steps {
+		got, err := c.Do(context.Background(), strings.Fields(st.cmd)...)
+		switch {
+		case st.err != "":
+			if err == nil || !strings.HasPrefix(err.Error(), st.err) {
+				t.Errorf("%s: got %v, %v, want error %q", st.cmd, got, err, st.err)
+			}
+		case err != nil || !reflect.DeepEqual(got, st.want):
+			t.Errorf("%s: got %#v, %v, want %#v", st.cmd, got, err, st.want)
+		}
+	}
+}
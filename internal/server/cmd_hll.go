@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/VoolFI71/go-kv-store/internal/hll"
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// HyperLogLogs are string values in Redis' layout, see package hll. Dense
+// registers and same-size sparse edits are changed in place in the slab;
+// only a sparse value that grows is copied.
+
+func (s *server) cmdPFAdd(sess *session) {
+	key, elems := sess.args[1], sess.args[2:]
+	changed := false
+	var err error
+	_, werr := s.db(sess.db).UpdateHashed(xxhash.Sum64String(key), key, s.defaultExpireAt(), func(value []byte, exists bool) []byte {
+		h := value
+		if !exists {
+			h, changed = hll.New(), true
+		} else if err = hll.Check(h); err != nil {
+			return nil
+		}
+		for _, elem := range elems {
+			var raised bool
+			if h, raised, err = hll.Add(h, elem); err != nil {
+				return nil
+			}
+			changed = changed || raised
+		}
+		if !changed {
+			return nil
+		}
+		return h
+	})
+	if werr != nil {
+		err = werr
+	}
+	switch {
+	case err != nil:
+		sess.out = resp.AppendError(sess.out, err.Error())
+	case changed:
+		sess.out = resp.AppendInt(sess.out, 1)
+	default:
+		sess.out = resp.AppendInt(sess.out, 0)
+	}
+}
+
+// cmdPFCount uses and refreshes the cached cardinality of a single key;
+// several keys are merged into scratch registers under their shard locks.
+func (s *server) cmdPFCount(sess *session) {
+	keys := sess.args[1:]
+	var n uint64
+	var err error
+	if len(keys) == 1 {
+		key := keys[0]
+		_, werr := s.db(sess.db).UpdateHashed(xxhash.Sum64String(key), key, 0, func(value []byte, exists bool) []byte {
+			if !exists {
+				return nil
+			}
+			if err = hll.Check(value); err != nil {
+				return nil
+			}
+			var refreshed bool
+			if n, refreshed, err = hll.Count(value); err != nil || !refreshed {
+				return nil
+			}
+			return value
+		})
+		if werr != nil {
+			err = werr
+		}
+	} else {
+		var regs hll.Registers
+		err = s.mergeHLLs(sess, keys, &regs, nil)
+		n = hll.Estimate(&regs)
+	}
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+// cmdPFMerge folds the sources into destkey, including what it already
+// holds. The result is dense if any input was.
+func (s *server) cmdPFMerge(sess *session) {
+	var regs hll.Registers
+	err := s.mergeHLLs(sess, sess.args[1:], &regs, func(l *storage.Locked, hash uint64, dest string, dense bool) {
+		_, expireAt, _ := l.Get(hash, dest)
+		if ttl := s.defaultExpireAt(); ttl != 0 {
+			expireAt = ttl
+		}
+		h := hll.Encode(&regs, dense)
+		l.Set(hash, dest, string(h), expireAt)
+	})
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+// mergeHLLs merges keys into regs with all their shards locked and, if
+// store is set, calls it before unlocking to write keys[0].
+func (s *server) mergeHLLs(sess *session, keys []string, regs *hll.Registers, store func(l *storage.Locked, hash uint64, dest string, dense bool)) error {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxhash.Sum64String(key)
+	}
+	var err error
+	s.db(sess.db).Atomically(hashes, func(l *storage.Locked) {
+		dense := false
+		for i, key := range keys {
+			found := l.View(hashes[i], key, func(value []byte) {
+				if err == nil {
+					if err = hll.Check(value); err == nil {
+						var d bool
+						d, err = hll.Merge(regs, value)
+						dense = dense || d
+					}
+				}
+			})
+			if !found && err == nil && isWrongType(l.Type(hashes[i], key)) {
+				err = errors.New(errWrongType)
+			}
+		}
+		if err == nil && store != nil {
+			store(l, hashes[0], keys[0], dense)
+		}
+	})
+	return err
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VoolFI71/go-kv-store/internal/jsondoc"
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// JSON documents are Objects holding a parsed tree, see package jsondoc.
+// Commands edit the nodes their path selects in place under the shard
+// lock. A JSONPath ("$...") replies with an array holding a result per
+// match; a legacy path replies with the result for its first match and
+// fails if there is none, like RedisJSON.
+
+const errJSONNoKey = "ERR could not perform this operation on a key that doesn't exist"
+
+// compileJSONPath compiles a path argument, replying with the error.
+func compileJSONPath(sess *session, arg string) (*jsondoc.Path, bool) {
+	p, err := jsondoc.Compile(arg)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return nil, false
+	}
+	return p, true
+}
+
+// optionalJSONPath compiles args[i] if present, the root legacy path if
+// not.
+func optionalJSONPath(sess *session, args []string, i int) (*jsondoc.Path, bool) {
+	if i < len(args) {
+		return compileJSONPath(sess, args[i])
+	}
+	return compileJSONPath(sess, ".")
+}
+
+// viewJSON calls fn with the document at key, nil if missing, under the
+// shard read lock, and replies with the error if key holds another type
+// or fn fails.
+func (s *server) viewJSON(sess *session, key string, fn func(doc *jsondoc.Doc) error) {
+	var err error
+	found, verr := s.db(sess.db).ViewObjectHashed(xxhash.Sum64String(key), key, func(obj storage.Object) {
+		doc, ok := obj.(*jsondoc.Doc)
+		if !ok {
+			err = errors.New(errWrongType)
+			return
+		}
+		err = fn(doc)
+	})
+	if verr == nil && !found {
+		verr = fn(nil)
+	}
+	if verr != nil {
+		err = verr
+	}
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+	}
+}
+
+// updateJSON calls fn with the document at key, nil if missing, under the
+// shard lock and stores the document it returns as UpdateObjectHashed
+// does. It replies with the error if key holds another type or fn fails.
+func (s *server) updateJSON(sess *session, key string, fn func(doc *jsondoc.Doc) (*jsondoc.Doc, error)) bool {
+	err := s.db(sess.db).UpdateObjectHashed(xxhash.Sum64String(key), key, s.defaultExpireAt(), func(obj storage.Object) (storage.Object, error) {
+		var doc *jsondoc.Doc
+		if obj != nil {
+			var ok bool
+			if doc, ok = obj.(*jsondoc.Doc); !ok {
+				return obj, errors.New(errWrongType)
+			}
+		}
+		next, err := fn(doc)
+		if next == nil {
+			return nil, err
+		}
+		return next, err
+	})
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return false
+	}
+	return true
+}
+
+// checkLegacy fails unless the first match of a legacy path is of one of
+// kinds, want naming them for the error.
+func checkLegacy(doc *jsondoc.Doc, p *jsondoc.Path, want string, kinds ...jsondoc.Kind) error {
+	nodes := doc.Select(p)
+	if len(nodes) == 0 {
+		return errJSONPath(p)
+	}
+	for _, k := range kinds {
+		if nodes[0].Kind() == k {
+			return nil
+		}
+	}
+	return fmt.Errorf("WRONGTYPE wrong type of path value - expected %s but found %s", want, nodes[0].Kind())
+}
+
+func errJSONPath(p *jsondoc.Path) error {
+	return fmt.Errorf("ERR Path '%s' does not exist", p)
+}
+
+// appendJSON appends n serialized as a bulk string, a null for nil.
+func appendJSON(buf []byte, n *jsondoc.Node) []byte {
+	if n == nil {
+		return resp.AppendNullBulkString(buf)
+	}
+	return resp.AppendBulk(buf, jsondoc.Append(nil, n, nil))
+}
+
+// appendJSONLens replies with the lengths an edit returned, -1 for a value
+// of the wrong type.
+func appendJSONLens(buf []byte, lens []int, legacy bool) []byte {
+	if legacy {
+		return resp.AppendInt(buf, int64(lens[0]))
+	}
+	buf = resp.AppendArrayHeader(buf, len(lens))
+	for _, n := range lens {
+		if n < 0 {
+			buf = resp.AppendNullBulkString(buf)
+		} else {
+			buf = resp.AppendInt(buf, int64(n))
+		}
+	}
+	return buf
+}
+
+// cmdJSONSet parses JSON.SET key path value [NX | XX]. A missing key can
+// only be created at the root.
+func (s *server) cmdJSONSet(sess *session) {
+	args := sess.args
+	nx, xx := false, false
+	switch {
+	case len(args) == 4:
+	case len(args) == 5 && strings.EqualFold(args[4], "NX"):
+		nx = true
+	case len(args) == 5 && strings.EqualFold(args[4], "XX"):
+		xx = true
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	p, ok := compileJSONPath(sess, args[2])
+	if !ok {
+		return
+	}
+	v, err := jsondoc.Parse(args[3])
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	written := false
+	if !s.updateJSON(sess, args[1], func(doc *jsondoc.Doc) (*jsondoc.Doc, error) {
+		if doc == nil {
+			if !p.IsRoot() {
+				return nil, errors.New("ERR new objects must be created at the root")
+			}
+			if xx {
+				return nil, nil
+			}
+			written = true
+			return jsondoc.New(v), nil
+		}
+		written = doc.Set(p, v, nx, xx)
+		return doc, nil
+	}) {
+		return
+	}
+	if written {
+		sess.out = resp.AppendString(sess.out, "OK")
+	} else {
+		sess.out = resp.AppendNullBulkString(sess.out)
+	}
+}
+
+// cmdJSONGet parses JSON.GET key [INDENT indent] [NEWLINE newline]
+// [SPACE space] [path ...]. Several paths reply with an object keyed by
+// path.
+func (s *server) cmdJSONGet(sess *session) {
+	args := sess.args
+	var f jsondoc.Format
+	i := 2
+options:
+	for ; i+1 < len(args); i += 2 {
+		switch opt := args[i]; {
+		case strings.EqualFold(opt, "INDENT"):
+			f.Indent = args[i+1]
+		case strings.EqualFold(opt, "NEWLINE"):
+			f.Newline = args[i+1]
+		case strings.EqualFold(opt, "SPACE"):
+			f.Space = args[i+1]
+		default:
+			break options
+		}
+	}
+	pathArgs := args[i:]
+	if len(pathArgs) == 0 {
+		pathArgs = []string{"."}
+	}
+	paths := make([]*jsondoc.Path, len(pathArgs))
+	legacy := true
+	for j, arg := range pathArgs {
+		p, ok := compileJSONPath(sess, arg)
+		if !ok {
+			return
+		}
+		paths[j], legacy = p, legacy && p.Legacy()
+	}
+	s.viewJSON(sess, args[1], func(doc *jsondoc.Doc) error {
+		if doc == nil {
+			sess.out = resp.AppendNullBulkString(sess.out)
+			return nil
+		}
+		results := make([]*jsondoc.Node, len(paths))
+		for j, p := range paths {
+			nodes := doc.Select(p)
+			switch {
+			case !legacy:
+				results[j] = jsondoc.NewArray(nodes)
+			case len(nodes) == 0:
+				return errJSONPath(p)
+			default:
+				results[j] = nodes[0]
+			}
+		}
+		res := results[0]
+		if len(paths) > 1 {
+			res = jsondoc.NewObject(pathArgs, results)
+		}
+		sess.out = resp.AppendBulk(sess.out, jsondoc.Append(nil, res, &f))
+		return nil
+	})
+}
+
+// cmdJSONDel removes the values a path selects and replies with how many;
+// the root removes the key.
+func (s *server) cmdJSONDel(sess *session) {
+	args := sess.args
+	if len(args) > 3 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	p, ok := optionalJSONPath(sess, args, 2)
+	if !ok {
+		return
+	}
+	n := 0
+	if !s.updateJSON(sess, args[1], func(doc *jsondoc.Doc) (*jsondoc.Doc, error) {
+		switch {
+		case doc == nil:
+			return nil, nil
+		case p.IsRoot():
+			n = 1
+			return nil, nil
+		}
+		n = doc.Delete(p)
+		return doc, nil
+	}) {
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+// cmdJSONMGet parses JSON.MGET key [key ...] path. A key that is missing
+// or not a document replies null.
+func (s *server) cmdJSONMGet(sess *session) {
+	args := sess.args
+	p, ok := compileJSONPath(sess, args[len(args)-1])
+	if !ok {
+		return
+	}
+	keys := args[1 : len(args)-1]
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxhash.Sum64String(key)
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(keys))
+	s.db(sess.db).ViewObjectsHashed(hashes, keys, func(obj storage.Object) {
+		doc, ok := obj.(*jsondoc.Doc)
+		if !ok {
+			sess.out = resp.AppendNullBulkString(sess.out)
+			return
+		}
+		nodes := doc.Select(p)
+		switch {
+		case !p.Legacy():
+			sess.out = appendJSON(sess.out, jsondoc.NewArray(nodes))
+		case len(nodes) == 0:
+			sess.out = resp.AppendNullBulkString(sess.out)
+		default:
+			sess.out = appendJSON(sess.out, nodes[0])
+		}
+	})
+}
+
+func (s *server) cmdJSONType(sess *session) {
+	args := sess.args
+	if len(args) > 3 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	p, ok := optionalJSONPath(sess, args, 2)
+	if !ok {
+		return
+	}
+	s.viewJSON(sess, args[1], func(doc *jsondoc.Doc) error {
+		if doc == nil {
+			sess.out = resp.AppendNullBulkString(sess.out)
+			return nil
+		}
+		nodes := doc.Select(p)
+		switch {
+		case !p.Legacy():
+			sess.out = resp.AppendArrayHeader(sess.out, len(nodes))
+			for _, n := range nodes {
+				sess.out = resp.AppendBulkString(sess.out, n.Kind().String())
+			}
+		case len(nodes) == 0:
+			sess.out = resp.AppendNullBulkString(sess.out)
+		default:
+			sess.out = resp.AppendString(sess.out, nodes[0].Kind().String())
+		}
+		return nil
+	})
+}
+
+// cmdJSONNumIncrBy replies with the new values serialized: the first for a
+// legacy path, an array with null for non-numbers for a JSONPath.
+func (s *server) cmdJSONNumIncrBy(sess *session) {
+	args := sess.args
+	p, ok := compileJSONPath(sess, args[2])
+	if !ok {
+		return
+	}
+	delta, err := jsondoc.Parse(args[3])
+	if err != nil || delta.Kind() != jsondoc.Int && delta.Kind() != jsondoc.Float {
+		sess.out = resp.AppendError(sess.out, "ERR value is not a number")
+		return
+	}
+	var out []byte
+	if !s.updateJSON(sess, args[1], func(doc *jsondoc.Doc) (*jsondoc.Doc, error) {
+		if doc == nil {
+			return nil, errors.New(errJSONNoKey)
+		}
+		if p.Legacy() {
+			if err := checkLegacy(doc, p, "a number", jsondoc.Int, jsondoc.Float); err != nil {
+				return doc, err
+			}
+		}
+		nodes, err := doc.NumIncrBy(p, delta)
+		if err != nil {
+			return doc, err
+		}
+		if p.Legacy() {
+			out = jsondoc.Append(nil, nodes[0], nil)
+			return doc, nil
+		}
+		for i, n := range nodes {
+			if n == nil {
+				nodes[i] = jsondoc.NewNull()
+			}
+		}
+		out = jsondoc.Append(nil, jsondoc.NewArray(nodes), nil)
+		return doc, nil
+	}) {
+		return
+	}
+	sess.out = resp.AppendBulk(sess.out, out)
+}
+
+// cmdJSONStrAppend parses JSON.STRAPPEND key [path] value, value being a
+// JSON string.
+func (s *server) cmdJSONStrAppend(sess *session) {
+	args := sess.args
+	if len(args) > 4 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	p, ok := optionalJSONPath(sess, args[:len(args)-1], 2)
+	if !ok {
+		return
+	}
+	v, err := jsondoc.Parse(args[len(args)-1])
+	if err != nil || v.Kind() != jsondoc.String {
+		sess.out = resp.AppendError(sess.out, "ERR value must be a JSON string")
+		return
+	}
+	var lens []int
+	if !s.updateJSON(sess, args[1], func(doc *jsondoc.Doc) (*jsondoc.Doc, error) {
+		if doc == nil {
+			return nil, errors.New(errJSONNoKey)
+		}
+		if p.Legacy() {
+			if err := checkLegacy(doc, p, "string", jsondoc.String); err != nil {
+				return doc, err
+			}
+		}
+		lens = doc.StrAppend(p, v.Str())
+		return doc, nil
+	}) {
+		return
+	}
+	sess.out = appendJSONLens(sess.out, lens, p.Legacy())
+}
+
+func (s *server) cmdJSONArrAppend(sess *session) {
+	args := sess.args
+	p, ok := compileJSONPath(sess, args[2])
+	if !ok {
+		return
+	}
+	vals := make([]*jsondoc.Node, len(args)-3)
+	for i, arg := range args[3:] {
+		v, err := jsondoc.Parse(arg)
+		if err != nil {
+			sess.out = resp.AppendError(sess.out, err.Error())
+			return
+		}
+		vals[i] = v
+	}
+	var lens []int
+	if !s.updateJSON(sess, args[1], func(doc *jsondoc.Doc) (*jsondoc.Doc, error) {
+		if doc == nil {
+			return nil, errors.New(errJSONNoKey)
+		}
+		if p.Legacy() {
+			if err := checkLegacy(doc, p, "array", jsondoc.Array); err != nil {
+				return doc, err
+			}
+		}
+		lens = doc.ArrAppend(p, vals)
+		return doc, nil
+	}) {
+		return
+	}
+	sess.out = appendJSONLens(sess.out, lens, p.Legacy())
+}
+
+// cmdJSONArrPop parses JSON.ARRPOP key [path [index]], index defaulting
+// to the last element.
+func (s *server) cmdJSONArrPop(sess *session) {
+	args := sess.args
+	if len(args) > 4 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	p, ok := optionalJSONPath(sess, args, 2)
+	if !ok {
+		return
+	}
+	index := -1
+	if len(args) == 4 {
+		var err error
+		if index, err = strconv.Atoi(args[3]); err != nil {
+			sess.out = resp.AppendError(sess.out, errNotInt)
+			return
+		}
+	}
+	var popped []*jsondoc.Node
+	if !s.updateJSON(sess, args[1], func(doc *jsondoc.Doc) (*jsondoc.Doc, error) {
+		if doc == nil {
+			return nil, errors.New(errJSONNoKey)
+		}
+		if p.Legacy() {
+			if err := checkLegacy(doc, p, "array", jsondoc.Array); err != nil {
+				return doc, err
+			}
+		}
+		popped = doc.ArrPop(p, index)
+		return doc, nil
+	}) {
+		return
+	}
+	if p.Legacy() {
+		sess.out = appendJSON(sess.out, popped[0])
+		return
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(popped))
+	for _, n := range popped {
+		sess.out = appendJSON(sess.out, n)
+	}
+}
+
+func (s *server) cmdJSONObjKeys(sess *session) {
+	args := sess.args
+	if len(args) > 3 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	p, ok := optionalJSONPath(sess, args, 2)
+	if !ok {
+		return
+	}
+	s.viewJSON(sess, args[1], func(doc *jsondoc.Doc) error {
+		if doc == nil {
+			sess.out = resp.AppendNullArray(sess.out)
+			return nil
+		}
+		if p.Legacy() {
+			if err := checkLegacy(doc, p, "object", jsondoc.Object); err != nil {
+				return err
+			}
+			sess.out = appendJSONKeys(sess.out, doc.Select(p)[0])
+			return nil
+		}
+		nodes := doc.Select(p)
+		sess.out = resp.AppendArrayHeader(sess.out, len(nodes))
+		for _, n := range nodes {
+			if n.Kind() != jsondoc.Object {
+				sess.out = resp.AppendNullArray(sess.out)
+				continue
+			}
+			sess.out = appendJSONKeys(sess.out, n)
+		}
+		return nil
+	})
+}
+
+func appendJSONKeys(buf []byte, n *jsondoc.Node) []byte {
+	keys := n.Keys()
+	buf = resp.AppendArrayHeader(buf, len(keys))
+	for _, key := range keys {
+		buf = resp.AppendBulkString(buf, key)
+	}
+	return buf
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/glob"
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// keysBatch is how many keys KEYS collects per storage scan step.
+const keysBatch = 1024
+
+func (s *server) cmdType(sess *session) {
+	key := sess.args[1]
+	sess.out = resp.AppendString(sess.out, s.db(sess.db).TypeHashed(xxhash.Sum64String(key), key))
+}
+
+// cmdDel removes keys with their shards locked together, so other clients
+// see all of them gone or none.
+func (s *server) cmdDel(sess *session) {
+	keys := sess.args[1:]
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxhash.Sum64String(key)
+	}
+	n := 0
+	s.db(sess.db).Atomically(hashes, func(l *storage.Locked) {
+		for i, hash := range hashes {
+			if l.Delete(hash, keys[i]) {
+				n++
+			}
+		}
+	})
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+// cmdExists counts the live keys among its arguments, a key given twice
+// counting twice as in Redis.
+func (s *server) cmdExists(sess *session) {
+	n := 0
+	for _, key := range sess.args[1:] {
+		if _, ok := s.db(sess.db).ExpireAtHashed(xxhash.Sum64String(key), key); ok {
+			n++
+		}
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+func (s *server) cmdTTL(sess *session) {
+	s.ttl(sess, time.Second)
+}
+
+func (s *server) cmdPTTL(sess *session) {
+	s.ttl(sess, time.Millisecond)
+}
+
+// ttl replies with the time to live of a key in unit, rounded to the
+// nearest, -1 if it has no expiration and -2 if it is missing.
+func (s *server) ttl(sess *session, unit time.Duration) {
+	key := sess.args[1]
+	expireAt, ok := s.db(sess.db).ExpireAtHashed(xxhash.Sum64String(key), key)
+	switch {
+	case !ok:
+		sess.out = resp.AppendInt(sess.out, -2)
+	case expireAt == 0:
+		sess.out = resp.AppendInt(sess.out, -1)
+	default:
+		left := expireAt - time.Now().UnixNano()
+		sess.out = resp.AppendInt(sess.out, max(left+int64(unit)/2, 0)/int64(unit))
+	}
+}
+
+// cmdScan implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+func (s *server) cmdScan(sess *session) {
+	args := sess.args
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, "ERR invalid cursor")
+		return
+	}
+	match, count, typ := "", 10, ""
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		switch opt := args[i]; {
+		case strings.EqualFold(opt, "MATCH"):
+			match = args[i+1]
+		case strings.EqualFold(opt, "COUNT"):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+				return
+			}
+			if n < 1 {
+				sess.out = resp.AppendError(sess.out, "ERR syntax error")
+				return
+			}
+			count = n
+		case strings.EqualFold(opt, "TYPE"):
+			typ = strings.ToLower(args[i+1])
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+
+	var keys []string
+	next := s.db(sess.db).Scan(cursor, count, func(key, keyType string) {
+		if (match == "" || glob.Match(match, key)) && (typ == "" || typ == keyType) {
+			keys = append(keys, key)
+		}
+	})
+	sess.out = resp.AppendArrayHeader(sess.out, 2)
+	sess.out = resp.AppendBulkString(sess.out, strconv.FormatUint(next, 10))
+	sess.out = resp.AppendArrayHeader(sess.out, len(keys))
+	for _, key := range keys {
+		sess.out = resp.AppendBulkString(sess.out, key)
+	}
+}
+
+// cmdKeys walks the whole database in batches, so other clients of the
+// same event loop wait until it is done, as with Redis. A shard that grows
+// between batches may repeat keys, which are dropped.
+func (s *server) cmdKeys(sess *session) {
+	pattern := sess.args[1]
+	all := pattern == "*"
+	db := s.db(sess.db)
+	var keys []string
+	seen := make(map[string]struct{})
+	cursor := uint64(0)
+	for {
+		cursor = db.Scan(cursor, keysBatch, func(key, _ string) {
+			if _, dup := seen[key]; dup {
+				return
+			}
+			if all || glob.Match(pattern, key) {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(keys))
+	for _, key := range keys {
+		sess.out = resp.AppendBulkString(sess.out, key)
+	}
+}
+
+func (s *server) handleMemory(sess *session) {
+	args := sess.args
+	switch sub := args[1]; {
+	case strings.EqualFold(sub, "USAGE"):
+		// SAMPLES is accepted for compatibility; sizes are exact.
+		if len(args) != 3 && !(len(args) == 5 && strings.EqualFold(args[3], "SAMPLES")) {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		key := args[2]
+		size, ok := s.db(sess.db).MemoryUsageHashed(xxhash.Sum64String(key), key)
+		if !ok {
+			sess.out = resp.AppendNullBulkString(sess.out)
+			return
+		}
+		sess.out = resp.AppendInt(sess.out, size)
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try MEMORY HELP.")
+	}
+}
+
+func (s *server) handleObject(sess *session) {
+	args := sess.args
+	switch sub := args[1]; {
+	case strings.EqualFold(sub, "ENCODING"):
+		if len(args) != 3 {
+			sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'OBJECT|ENCODING' command")
+			return
+		}
+		key := args[2]
+		encoding, ok := s.db(sess.db).EncodingHashed(xxhash.Sum64String(key), key)
+		if !ok {
+			sess.out = resp.AppendNullBulkString(sess.out)
+			return
+		}
+		sess.out = resp.AppendBulkString(sess.out, encoding)
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try OBJECT HELP.")
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+)
+
+func (s *server) cmdPing(sess *session) {
+	if len(sess.args) > 2 {
+		sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'PING' command")
+		return
+	}
+	if len(sess.args) == 2 {
+		sess.out = resp.AppendBulkString(sess.out, sess.args[1])
+		return
+	}
+	sess.out = resp.AppendString(sess.out, "PONG")
+}
+
+func (s *server) cmdQuit(sess *session) {
+	sess.out = resp.AppendString(sess.out, "OK")
+	sess.shouldClose = true
+}
+
+func (s *server) cmdMonitor(sess *session) {
+	if !sess.monitoring {
+		sess.monitoring = true
+		s.monitors.add(sess.conn, sess.cl)
+	}
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+func (s *server) cmdEcho(sess *session) {
+	sess.out = resp.AppendBulkString(sess.out, sess.args[1])
+}
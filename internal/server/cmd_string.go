@@ -0,0 +1,523 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+func (s *server) cmdGet(sess *session) {
+	key := sess.args[1]
+	hash := xxhash.Sum64String(key)
+	out, ok, err := s.db(sess.db).AppendHashed(sess.out, hash, key, resp.AppendBulk)
+	sess.out = out
+	if ok {
+		sess.stats.hits++
+	} else {
+		sess.stats.misses++
+		sess.out = appendMissing(sess.out, err != nil)
+	}
+}
+
+// cmdSet parses SET key value [NX | XX] [GET] [EX seconds | PX
+// milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds |
+// KEEPTTL]. The plain form skips option handling.
+func (s *server) cmdSet(sess *session) {
+	key := sess.args[1]
+	value := sess.args[2]
+	hash := xxhash.Sum64String(key)
+	if len(sess.args) > 3 {
+		opts, ok := parseSetOptions(sess)
+		if !ok {
+			return
+		}
+		s.db(sess.db).Atomically([]uint64{hash}, func(l *storage.Locked) {
+			s.setLocked(sess, l, hash, &opts)
+		})
+		return
+	}
+	if ttl := s.defaultTTL.Load(); ttl > 0 {
+		s.db(sess.db).SetHashedWithTTLSeconds(hash, key, value, ttl)
+	} else {
+		s.db(sess.db).SetHashed(hash, key, value)
+	}
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+func (s *server) batchGet(sess *session, l *storage.Locked, hash uint64) {
+	out, ok := l.Append(sess.out, hash, sess.args[1], resp.AppendBulk)
+	sess.out = out
+	if ok {
+		sess.stats.hits++
+	} else {
+		sess.stats.misses++
+		sess.out = appendMissing(sess.out, isWrongType(l.Type(hash, sess.args[1])))
+	}
+}
+
+func (s *server) batchSet(sess *session, l *storage.Locked, hash uint64) {
+	if len(sess.args) > 3 {
+		if opts, ok := parseSetOptions(sess); ok {
+			s.setLocked(sess, l, hash, &opts)
+		}
+		return
+	}
+	var expireAt int64
+	if ttl := s.defaultTTL.Load(); ttl > 0 {
+		expireAt = l.Now() + ttl*int64(time.Second)
+	}
+	l.Set(hash, sess.args[1], sess.args[2], expireAt)
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+// setOptions are the options of SET after the value.
+type setOptions struct {
+	nx, xx, get, keepTTL bool
+	// expire is set by EX, PX, EXAT and PXAT, which give expireAt.
+	expire   bool
+	expireAt int64
+}
+
+// parseSetOptions parses the options of SET, replying with the error.
+func parseSetOptions(sess *session) (setOptions, bool) {
+	var o setOptions
+	args := sess.args
+	for i := 3; i < len(args); i++ {
+		switch opt := args[i]; {
+		case strings.EqualFold(opt, "NX") && !o.xx:
+			o.nx = true
+		case strings.EqualFold(opt, "XX") && !o.nx:
+			o.xx = true
+		case strings.EqualFold(opt, "GET"):
+			o.get = true
+		case strings.EqualFold(opt, "KEEPTTL") && !o.expire:
+			o.keepTTL = true
+		case i+1 < len(args) && !o.expire && !o.keepTTL:
+			expireAt, ok, errMsg := parseExpireAt(opt, args[i+1], "set")
+			if !ok {
+				sess.out = resp.AppendError(sess.out, "ERR syntax error")
+				return o, false
+			}
+			if errMsg != "" {
+				sess.out = resp.AppendError(sess.out, errMsg)
+				return o, false
+			}
+			o.expire, o.expireAt = true, expireAt
+			i++
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return o, false
+		}
+	}
+	return o, true
+}
+
+// setLocked runs SET with options under the key's shard lock. With GET
+// a key of another type fails and nothing is written.
+func (s *server) setLocked(sess *session, l *storage.Locked, hash uint64, o *setOptions) {
+	key, value := sess.args[1], sess.args[2]
+	typ := l.Type(hash, key)
+	if o.get && typ != "none" && typ != "string" {
+		sess.out = resp.AppendError(sess.out, errWrongType)
+		return
+	}
+	var old string
+	var hadOld bool
+	if o.get {
+		old, _, hadOld = l.Get(hash, key)
+	}
+	exists := typ != "none"
+	written := !(o.nx && exists || o.xx && !exists)
+	if written {
+		expireAt := o.expireAt
+		switch {
+		case o.keepTTL:
+			expireAt = l.ExpireAt(hash, key)
+		case !o.expire:
+			expireAt = s.defaultExpireAt()
+		}
+		l.Set(hash, key, value, expireAt)
+	}
+	switch {
+	case o.get:
+		sess.out = appendValue(sess.out, old, hadOld)
+	case written:
+		sess.out = resp.AppendString(sess.out, "OK")
+	default:
+		sess.out = resp.AppendNullBulkString(sess.out)
+	}
+}
+
+// parseExpireAt turns EX seconds, PX milliseconds, EXAT unix-time-seconds
+// or PXAT unix-time-milliseconds into Unix nanoseconds. ok is false if opt
+// is none of them; errMsg is the reply to an invalid time for cmd.
+func parseExpireAt(opt, arg, cmd string) (expireAt int64, ok bool, errMsg string) {
+	var unit time.Duration
+	abs := false
+	switch {
+	case strings.EqualFold(opt, "EX"):
+		unit = time.Second
+	case strings.EqualFold(opt, "PX"):
+		unit = time.Millisecond
+	case strings.EqualFold(opt, "EXAT"):
+		unit, abs = time.Second, true
+	case strings.EqualFold(opt, "PXAT"):
+		unit, abs = time.Millisecond, true
+	default:
+		return 0, false, ""
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, true, "ERR value is not an integer or out of range"
+	}
+	now := time.Now().UnixNano()
+	if n <= 0 || n > (math.MaxInt64-now)/int64(unit) {
+		return 0, true, "ERR invalid expire time in '" + cmd + "' command"
+	}
+	expireAt = n * int64(unit)
+	if !abs {
+		expireAt += now
+	}
+	return expireAt, true, ""
+}
+
+func (s *server) cmdIncr(sess *session) {
+	s.incrBy(sess, 1)
+}
+
+func (s *server) cmdDecr(sess *session) {
+	s.incrBy(sess, -1)
+}
+
+func (s *server) cmdIncrBy(sess *session) {
+	delta, err := strconv.ParseInt(sess.args[2], 10, 64)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+		return
+	}
+	s.incrBy(sess, delta)
+}
+
+func (s *server) cmdDecrBy(sess *session) {
+	delta, err := strconv.ParseInt(sess.args[2], 10, 64)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+		return
+	}
+	if delta == math.MinInt64 {
+		sess.out = resp.AppendError(sess.out, "ERR decrement would overflow")
+		return
+	}
+	s.incrBy(sess, -delta)
+}
+
+// incrBy adds delta under the shard lock, refreshing the default TTL in the
+// same step.
+func (s *server) incrBy(sess *session, delta int64) {
+	key := sess.args[1]
+	value, err := s.db(sess.db).IncrByHashed(xxhash.Sum64String(key), key, delta, s.defaultExpireAt())
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, value)
+}
+
+func (s *server) cmdIncrByFloat(sess *session) {
+	delta, err := strconv.ParseFloat(sess.args[2], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		sess.out = resp.AppendError(sess.out, "ERR value is not a valid float")
+		return
+	}
+	key := sess.args[1]
+	value, err := s.db(sess.db).IncrByFloatHashed(xxhash.Sum64String(key), key, delta, s.defaultExpireAt())
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendBulkString(sess.out, value)
+}
+
+// defaultExpireAt is the expiration the default TTL gives a key written
+// now, 0 if there is none.
+func (s *server) defaultExpireAt() int64 {
+	if ttl := s.defaultTTL.Load(); ttl > 0 {
+		return time.Now().Add(time.Duration(ttl) * time.Second).UnixNano()
+	}
+	return 0
+}
+
+func (s *server) cmdExpire(sess *session) {
+	seconds, err := strconv.ParseInt(sess.args[2], 10, 64)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+		return
+	}
+	key := sess.args[1]
+	hash := xxhash.Sum64String(key)
+	if s.db(sess.db).SetExpireHashed(hash, key, seconds) {
+		sess.out = resp.AppendInt(sess.out, 1)
+	} else {
+		sess.out = resp.AppendInt(sess.out, 0)
+	}
+}
+
+func (s *server) cmdStrlen(sess *session) {
+	key := sess.args[1]
+	hash := xxhash.Sum64String(key)
+	n := 0
+	if _, err := s.db(sess.db).ViewHashed(hash, key, func(value []byte) { n = len(value) }); err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+func (s *server) cmdAppend(sess *session) {
+	key := sess.args[1]
+	n, err := s.db(sess.db).AppendValueHashed(xxhash.Sum64String(key), key, sess.args[2], s.defaultExpireAt())
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+func (s *server) cmdGetRange(sess *session) {
+	start, err1 := strconv.Atoi(sess.args[2])
+	end, err2 := strconv.Atoi(sess.args[3])
+	if err1 != nil || err2 != nil {
+		sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+		return
+	}
+	key := sess.args[1]
+	hash := xxhash.Sum64String(key)
+	found, err := s.db(sess.db).ViewHashed(hash, key, func(value []byte) {
+		sess.out = resp.AppendBulk(sess.out, substr(value, start, end))
+	})
+	switch {
+	case found:
+	case err != nil:
+		sess.out = resp.AppendError(sess.out, err.Error())
+	default:
+		sess.out = resp.AppendBulkString(sess.out, "")
+	}
+}
+
+// substr applies GETRANGE's inclusive, end-relative-if-negative bounds.
+func substr(value []byte, start, end int) []byte {
+	n := len(value)
+	if start < 0 && end < 0 && start > end {
+		return nil
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		return nil
+	}
+	return value[start : end+1]
+}
+
+func (s *server) cmdSetRange(sess *session) {
+	offset, err := strconv.Atoi(sess.args[2])
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+		return
+	}
+	if offset < 0 || offset > storage.MaxValueLen {
+		sess.out = resp.AppendError(sess.out, "ERR offset is out of range")
+		return
+	}
+	key := sess.args[1]
+	n, err := s.db(sess.db).SetRangeHashed(xxhash.Sum64String(key), key, offset, sess.args[3], s.defaultExpireAt())
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = resp.AppendInt(sess.out, int64(n))
+}
+
+func (s *server) cmdGetDel(sess *session) {
+	key := sess.args[1]
+	value, ok, err := s.db(sess.db).GetDelHashed(xxhash.Sum64String(key), key)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = appendValue(sess.out, value, ok)
+}
+
+func (s *server) cmdGetSet(sess *session) {
+	key := sess.args[1]
+	value, ok, err := s.db(sess.db).GetSetHashed(xxhash.Sum64String(key), key, sess.args[2], s.defaultExpireAt())
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = appendValue(sess.out, value, ok)
+}
+
+// cmdGetEx parses GETEX key [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST].
+func (s *server) cmdGetEx(sess *session) {
+	args := sess.args
+	key := args[1]
+	hash := xxhash.Sum64String(key)
+	if len(args) == 2 {
+		out, ok, err := s.db(sess.db).AppendHashed(sess.out, hash, key, resp.AppendBulk)
+		sess.out = out
+		if !ok {
+			sess.out = appendMissing(sess.out, err != nil)
+		}
+		return
+	}
+	var expireAt int64
+	switch opt := args[2]; {
+	case len(args) == 3 && strings.EqualFold(opt, "PERSIST"):
+	case len(args) == 4:
+		var ok bool
+		var errMsg string
+		expireAt, ok, errMsg = parseExpireAt(opt, args[3], "getex")
+		if !ok {
+			errMsg = "ERR syntax error"
+		}
+		if errMsg != "" {
+			sess.out = resp.AppendError(sess.out, errMsg)
+			return
+		}
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	value, ok, err := s.db(sess.db).GetExHashed(hash, key, expireAt)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.out = appendValue(sess.out, value, ok)
+}
+
+func (s *server) cmdSetNX(sess *session) {
+	key := sess.args[1]
+	if s.db(sess.db).SetNXHashed(xxhash.Sum64String(key), key, sess.args[2], s.defaultExpireAt()) {
+		sess.out = resp.AppendInt(sess.out, 1)
+	} else {
+		sess.out = resp.AppendInt(sess.out, 0)
+	}
+}
+
+func (s *server) cmdSetEx(sess *session) {
+	seconds, err := strconv.ParseInt(sess.args[2], 10, 64)
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, "ERR value is not an integer or out of range")
+		return
+	}
+	now := time.Now().UnixNano()
+	if seconds <= 0 || seconds > (math.MaxInt64-now)/int64(time.Second) {
+		sess.out = resp.AppendError(sess.out, "ERR invalid expire time in 'setex' command")
+		return
+	}
+	key := sess.args[1]
+	s.db(sess.db).SetHashedWithExpireAt(xxhash.Sum64String(key), key, sess.args[3], now+seconds*int64(time.Second))
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+// cmdMGet reads every key under one read lock per shard involved.
+func (s *server) cmdMGet(sess *session) {
+	keys := sess.args[1:]
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxhash.Sum64String(key)
+	}
+	sess.out = resp.AppendArrayHeader(sess.out, len(keys))
+	s.db(sess.db).ViewMultiHashed(hashes, keys, func(value []byte, ok bool) {
+		if ok {
+			sess.stats.hits++
+			sess.out = resp.AppendBulk(sess.out, value)
+		} else {
+			sess.stats.misses++
+			sess.out = resp.AppendNullBulkString(sess.out)
+		}
+	})
+}
+
+func (s *server) cmdMSet(sess *session) {
+	s.mset(sess, false)
+}
+
+func (s *server) cmdMSetNX(sess *session) {
+	s.mset(sess, true)
+}
+
+// mset writes all pairs with their shards locked together, each once, so
+// other clients see all of them or none. With nx nothing is written if any
+// key exists.
+func (s *server) mset(sess *session, nx bool) {
+	args := sess.args
+	if len(args)%2 == 0 {
+		sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for '"+strings.ToUpper(args[0])+"' command")
+		return
+	}
+	hashes := make([]uint64, len(args)/2)
+	for i := range hashes {
+		hashes[i] = xxhash.Sum64String(args[1+2*i])
+	}
+	expireAt := s.defaultExpireAt()
+	written := true
+	s.db(sess.db).Atomically(hashes, func(l *storage.Locked) {
+		if nx {
+			for i, hash := range hashes {
+				if l.Exists(hash, args[1+2*i]) {
+					written = false
+					return
+				}
+			}
+		}
+		for i, hash := range hashes {
+			l.Set(hash, args[1+2*i], args[2+2*i], expireAt)
+		}
+	})
+	switch {
+	case !nx:
+		sess.out = resp.AppendString(sess.out, "OK")
+	case written:
+		sess.out = resp.AppendInt(sess.out, 1)
+	default:
+		sess.out = resp.AppendInt(sess.out, 0)
+	}
+}
+
+// errWrongType is the reply to a string read of a key of another type.
+const errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// appendMissing replies to a read that found no string at a key: nil if
+// there was none, WRONGTYPE if it holds something else.
+func appendMissing(buf []byte, wrongType bool) []byte {
+	if wrongType {
+		return resp.AppendError(buf, errWrongType)
+	}
+	return resp.AppendNullBulkString(buf)
+}
+
+// isWrongType reports whether a string read that found nothing missed a
+// key of type typ because it holds something else.
+func isWrongType(typ string) bool {
+	return typ != "none" && typ != "string"
+}
+
+func appendValue(buf []byte, value string, ok bool) []byte {
+	if ok {
+		return resp.AppendBulkString(buf, value)
+	}
+	return resp.AppendNullBulkString(buf)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const wrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// TestStringCommands runs one session of string commands, each step seeing
+// the keys the earlier ones left, with and without pipeline batches.
+func TestStringCommands(t *testing.T) {
+	steps := []struct {
+		cmd  string
+		want any
+		// err is a prefix of the expected error reply.
+		err string
+	}{
+		{"SET s hello", "OK", ""},
+		{"SET s x NX", nil, ""},
+		{"SET s world XX GET", "hello", ""},
+		{"SET n 1 XX", nil, ""},
+		{"SET s v NX XX", nil, "ERR syntax error"},
+		{"SET s v EX 10 KEEPTTL", nil, "ERR syntax error"},
+		{"SET s v EX 0", nil, "ERR invalid expire time in 'set' command"},
+		{"SET s v EX ten", nil, "ERR value is not an integer or out of range"},
+		{"SET t v EX 100", "OK", ""},
+		{"TTL t", int64(100), ""},
+		{"SET t w KEEPTTL", "OK", ""},
+		{"TTL t", int64(100), ""},
+		{"SET t w", "OK", ""},
+		{"TTL t", int64(-1), ""},
+		{"STRLEN s", int64(5), ""},
+		{"STRLEN missing", int64(0), ""},
+		{"GETRANGE s 1 -2", "orl", ""},
+		{"GETRANGE s 10 20", "", ""},
+		{"GETRANGE missing 0 1", "", ""},
+		{"APPEND s !", int64(6), ""},
+		{"SETRANGE s 0 W", int64(6), ""},
+		{"GET s", "World!", ""},
+		{"GEOADD g 13.36 38.11 a", int64(1), ""},
+		{"GET g", nil, wrongType},
+		{"STRLEN g", nil, wrongType},
+		{"GETRANGE g 0 1", nil, wrongType},
+		{"APPEND g x", nil, wrongType},
+		{"SETRANGE g 0 x", nil, wrongType},
+		{"INCR g", nil, wrongType},
+		{"SET g v GET", nil, wrongType},
+		{"MGET s g missing", []any{"World!", nil, nil}, ""},
+		{"SET g v", "OK", ""},
+		{"GET g", "v", ""},
+		{"SET i 007", "OK", ""},
+		{"INCR i", nil, "ERR value is not an integer or out of range"},
+		{"SET i -0", "OK", ""},
+		{"INCRBY i 1", nil, "ERR value is not an integer or out of range"},
+		{"SET i -7", "OK", ""},
+		{"INCRBY i 2", int64(-5), ""},
+		{"GETDEL s", "World!", ""},
+		{"EXISTS s", int64(0), ""},
+	}
+	for _, mode := range []map[string]string{nil, {"pipeline-batch": "64"}} {
+		c := startServer(t, mode)
+		for _, st := range steps {
+			got, err := c.Do(context.Background(), strings.Fields(st.cmd)...)
+			switch {
+			case st.err != "":
+				if err == nil || !strings.HasPrefix(err.Error(), st.err) {
+					t.Errorf("%v %s: got %v, %v, want error %q", mode, st.cmd, got, err, st.err)
+				}
+			case err != nil || !reflect.DeepEqual(got, st.want):
+				t.Errorf("%v %s: got %#v, %v, want %#v", mode, st.cmd, got, err, st.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+type commandFlags uint32
+
+const (
+	flagWrite commandFlags = 1 << iota
+	flagReadonly
+	flagFast
+	flagAdmin
+	flagNoScript
+	flagLoading
+	flagStale
+	flagPubsub
+	flagDenyOOM
+)
+
+var commandFlagNames = [...]string{"write", "readonly", "fast", "admin", "noscript", "loading", "stale", "pubsub", "denyoom"}
+
+type commandHandler func(s *server, sess *session)
+
+// batchHandler runs a command of a pipeline batch with its shard locked,
+// see batch.go.
+type batchHandler func(s *server, sess *session, l *storage.Locked, hash uint64)
+
+// command describes one entry of the dispatch table. arity follows Redis:
+// a positive value is the exact argument count including the name, a
+// negative one is the minimum. firstKey/lastKey/step locate key arguments
+// (lastKey -1 means "up to the last argument"). args is the usage line
+// after the name, as shown in the docs; COMMAND DOCS derives the argument
+// list from it.
+type command struct {
+	name     string
+	arity    int
+	flags    commandFlags
+	firstKey int
+	lastKey  int
+	step     int
+	group    string
+	since    string
+	args     string
+	summary  string
+	handler  commandHandler
+	batch    batchHandler
+
+	id       int
+	upper    string
+	arityErr string
+	docArgs  []docArg
+}
+
+// docArg is one top-level argument of a usage line.
+type docArg struct {
+	name     string
+	typ      string
+	optional bool
+	multiple bool
+}
+
+// parseDocArgs splits a usage line such as "key [EX seconds] [NX | XX]"
+// into its top-level arguments. Nested groups are kept as one block.
+func parseDocArgs(usage string) []docArg {
+	var args []docArg
+	depth, start := 0, 0
+	flush := func(tok string) {
+		if tok == "" {
+			return
+		}
+		if tok == "..." {
+			if len(args) > 0 {
+				args[len(args)-1].multiple = true
+			}
+			return
+		}
+		var a docArg
+		if strings.HasPrefix(tok, "[") && strings.HasSuffix(tok, "]") {
+			a.optional = true
+			tok = tok[1 : len(tok)-1]
+		}
+		if rest, ok := strings.CutSuffix(tok, " ..."); ok {
+			a.multiple = true
+			tok = rest
+		}
+		a.name = tok
+		switch {
+		case tok == "key":
+			a.typ = "key"
+		case strings.Contains(tok, "|"):
+			a.typ = "oneof"
+		case strings.Contains(tok, " "):
+			a.typ = "block"
+		case strings.ToUpper(tok) == tok:
+			a.typ = "pure-token"
+		default:
+			a.typ = "string"
+		}
+		args = append(args, a)
+	}
+	for i := 0; i < len(usage); i++ {
+		switch usage[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ' ':
+			if depth == 0 {
+				flush(usage[start:i])
+				start = i + 1
+			}
+		}
+	}
+	flush(usage[start:])
+	return args
+}
+
+const maxCommandNameLen = 32
+
+// commandTable must not be read by handlers directly: doing so creates an
+// initialization cycle. They go through commandIndex and commandsByName.
+var commandTable = []command{
+	{name: "get", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key", summary: "Returns the string value of a key.", handler: (*server).cmdGet, batch: (*server).batchGet},
+	{name: "set", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]", summary: "Sets the string value of a key, ignoring its type.", handler: (*server).cmdSet, batch: (*server).batchSet},
+	{name: "setnx", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key value", summary: "Set the string value of a key only when the key doesn't exist.", handler: (*server).cmdSetNX},
+	{name: "setex", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "2.0.0", args: "key seconds value", summary: "Sets the string value and expiration time of a key.", handler: (*server).cmdSetEx},
+	{name: "getset", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key value", summary: "Returns the previous string value of a key after setting it to a new value.", handler: (*server).cmdGetSet},
+	{name: "getdel", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "6.2.0", args: "key", summary: "Returns the string value of a key after deleting the key.", handler: (*server).cmdGetDel},
+	{name: "getex", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "6.2.0", args: "key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]", summary: "Returns the string value of a key after setting its expiration time.", handler: (*server).cmdGetEx},
+	{name: "mget", arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: -1, step: 1, group: "string", since: "1.0.0", args: "key [key ...]", summary: "Atomically returns the string values of one or more keys.", handler: (*server).cmdMGet},
+	{name: "mset", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 2, group: "string", since: "1.0.1", args: "key value [key value ...]", summary: "Atomically creates or modifies the string values of one or more keys.", handler: (*server).cmdMSet},
+	{name: "msetnx", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 2, group: "string", since: "1.0.1", args: "key value [key value ...]", summary: "Atomically modifies the string values of one or more keys only when all keys don't exist.", handler: (*server).cmdMSetNX},
+	{name: "append", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "2.0.0", args: "key value", summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.", handler: (*server).cmdAppend},
+	{name: "getrange", arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "2.4.0", args: "key start end", summary: "Returns a substring of the string stored at a key.", handler: (*server).cmdGetRange},
+	{name: "setrange", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "2.2.0", args: "key offset value", summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.", handler: (*server).cmdSetRange},
+	{name: "setbit", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.2.0", args: "key offset value", summary: "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist.", handler: (*server).cmdSetBit},
+	{name: "getbit", arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.2.0", args: "key offset", summary: "Returns a bit value by offset.", handler: (*server).cmdGetBit},
+	{name: "bitcount", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.6.0", args: "key [start end [BYTE | BIT]]", summary: "Counts the number of set bits (population counting) in a string.", handler: (*server).cmdBitCount},
+	{name: "bitpos", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.8.7", args: "key bit [start [end [BYTE | BIT]]]", summary: "Finds the first set (1) or clear (0) bit in a string.", handler: (*server).cmdBitPos},
+	{name: "bitop", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 2, lastKey: -1, step: 1, group: "bitmap", since: "2.6.0", args: "AND | OR | XOR | NOT destkey key [key ...]", summary: "Performs bitwise operations on multiple strings, and stores the result.", handler: (*server).cmdBitOp},
+	{name: "bitfield", arity: -2, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "3.2.0", args: "key [GET encoding offset] [OVERFLOW WRAP | SAT | FAIL] [SET encoding offset value] [INCRBY encoding offset increment] [...]", summary: "Performs arbitrary bitfield integer operations on strings.", handler: (*server).cmdBitField},
+	{name: "bitfield_ro", arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "6.0.0", args: "key [GET encoding offset ...]", summary: "Performs arbitrary read-only bitfield integer operations on strings.", handler: (*server).cmdBitFieldRO},
+	{name: "pfadd", arity: -2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "hyperloglog", since: "2.8.9", args: "key [element [element ...]]", summary: "Adds elements to a HyperLogLog key. Creates the key if it doesn't exist.", handler: (*server).cmdPFAdd},
+	{name: "pfcount", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1, group: "hyperloglog", since: "2.8.9", args: "key [key ...]", summary: "Returns the approximated cardinality of the set(s) observed by the HyperLogLog key(s).", handler: (*server).cmdPFCount},
+	{name: "pfmerge", arity: -2, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1, group: "hyperloglog", since: "2.8.9", args: "destkey [sourcekey [sourcekey ...]]", summary: "Merges one or more HyperLogLog values into a single key.", handler: (*server).cmdPFMerge},
+	{name: "geoadd", arity: -5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", args: "key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]", summary: "Adds one or more members to a geospatial index. The key is created if it doesn't exist.", handler: (*server).cmdGeoAdd},
+	{name: "geopos", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", args: "key [member [member ...]]", summary: "Returns the longitude and latitude of members from a geospatial index.", handler: (*server).cmdGeoPos},
+	{name: "geodist", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", args: "key member1 member2 [M | KM | FT | MI]", summary: "Returns the distance between two members of a geospatial index.", handler: (*server).cmdGeoDist},
+	{name: "geohash", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", args: "key [member [member ...]]", summary: "Returns members from a geospatial index as geohash strings.", handler: (*server).cmdGeoHash},
+	{name: "geosearch", arity: -7, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "6.2.0", args: "key <FROMMEMBER member | FROMLONLAT longitude latitude> <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>> [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]", summary: "Queries a geospatial index for members inside an area of a box or a circle.", handler: (*server).cmdGeoSearch},
+	{name: "geosearchstore", arity: -8, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1, group: "geo", since: "6.2.0", args: "destination source <FROMMEMBER member | FROMLONLAT longitude latitude> <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>> [ASC | DESC] [COUNT count [ANY]] [STOREDIST]", summary: "Queries a geospatial index for members inside an area of a box or a circle, optionally stores the result.", handler: (*server).cmdGeoSearchStore},
+	{name: "json.set", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key path value [NX | XX]", summary: "Sets or updates the JSON value at a path.", handler: (*server).cmdJSONSet},
+	{name: "json.get", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key [INDENT indent] [NEWLINE newline] [SPACE space] [path [path ...]]", summary: "Gets the value at one or more paths in JSON serialized form.", handler: (*server).cmdJSONGet},
+	{name: "json.del", arity: -2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key [path]", summary: "Deletes a value.", handler: (*server).cmdJSONDel},
+	{name: "json.mget", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: -2, step: 1, group: "json", since: "1.0.0", args: "key [key ...] path", summary: "Returns the values at a path from one or more keys.", handler: (*server).cmdJSONMGet},
+	{name: "json.type", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key [path]", summary: "Returns the type of the JSON value at a path.", handler: (*server).cmdJSONType},
+	{name: "json.numincrby", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key path value", summary: "Increments the numeric value at a path by a value.", handler: (*server).cmdJSONNumIncrBy},
+	{name: "json.strappend", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key [path] value", summary: "Appends a string to a JSON string value at a path.", handler: (*server).cmdJSONStrAppend},
+	{name: "json.arrappend", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key path value [value ...]", summary: "Appends one or more JSON values to the arrays at a path.", handler: (*server).cmdJSONArrAppend},
+	{name: "json.arrpop", arity: -2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key [path [index]]", summary: "Removes and returns the element at an index in the arrays at a path.", handler: (*server).cmdJSONArrPop},
+	{name: "json.objkeys", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1, group: "json", since: "1.0.0", args: "key [path]", summary: "Returns the member names of the objects at a path.", handler: (*server).cmdJSONObjKeys},
+	{name: "incr", arity: 2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key", summary: "Increments the integer value of a key by one.", handler: (*server).cmdIncr},
+	{name: "incrby", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key increment", summary: "Increments the integer value of a key by a number.", handler: (*server).cmdIncrBy},
+	{name: "incrbyfloat", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "2.6.0", args: "key increment", summary: "Increments the floating point value of a key by a number.", handler: (*server).cmdIncrByFloat},
+	{name: "decr", arity: 2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key", summary: "Decrements the integer value of a key by one.", handler: (*server).cmdDecr},
+	{name: "decrby", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", args: "key decrement", summary: "Decrements a number from the integer value of a key.", handler: (*server).cmdDecrBy},
+	{name: "del", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1, group: "generic", since: "1.0.0", args: "key [key ...]", summary: "Deletes one or more keys.", handler: (*server).cmdDel},
+	{name: "exists", arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: -1, step: 1, group: "generic", since: "1.0.0", args: "key [key ...]", summary: "Determines whether one or more keys exist.", handler: (*server).cmdExists},
+	{name: "ttl", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", args: "key", summary: "Returns the expiration time in seconds of a key.", handler: (*server).cmdTTL},
+	{name: "pttl", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.6.0", args: "key", summary: "Returns the expiration time in milliseconds of a key.", handler: (*server).cmdPTTL},
+	{name: "expire", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", args: "key seconds", summary: "Sets the expiration time of a key in seconds.", handler: (*server).cmdExpire},
+	{name: "move", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", args: "key db", summary: "Moves a key to another database.", handler: (*server).cmdMove},
+	{name: "strlen", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "2.2.0", args: "key", summary: "Returns the length of a string value.", handler: (*server).cmdStrlen},
+	{name: "type", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", args: "key", summary: "Determines the type of value stored at a key.", handler: (*server).cmdType},
+	{name: "scan", arity: -2, flags: flagReadonly, group: "generic", since: "2.8.0", args: "cursor [MATCH pattern] [COUNT count] [TYPE type]", summary: "Iterates over the key names in the database.", handler: (*server).cmdScan},
+	{name: "keys", arity: 2, flags: flagReadonly, group: "generic", since: "1.0.0", args: "pattern", summary: "Returns all key names that match a pattern.", handler: (*server).cmdKeys},
+	{name: "memory", arity: -2, flags: flagReadonly, group: "server", since: "4.0.0", args: "USAGE key", summary: "A container for memory diagnostics commands.", handler: (*server).handleMemory},
+	{name: "object", arity: -2, flags: flagReadonly, group: "generic", since: "2.2.3", args: "ENCODING key", summary: "A container for object introspection commands.", handler: (*server).handleObject},
+	{name: "dbsize", arity: 1, flags: flagReadonly | flagFast, group: "server", since: "1.0.0", summary: "Returns the number of keys in the database.", handler: (*server).cmdDBSize},
+	{name: "flushdb", arity: -1, flags: flagWrite, group: "server", since: "1.0.0", args: "[ASYNC | SYNC]", summary: "Removes all keys from the current database.", handler: (*server).cmdFlushDB},
+	{name: "flushall", arity: -1, flags: flagWrite, group: "server", since: "1.0.0", args: "[ASYNC | SYNC]", summary: "Removes all keys from all databases.", handler: (*server).cmdFlushAll},
+	{name: "swapdb", arity: 3, flags: flagWrite | flagFast, group: "server", since: "4.0.0", args: "index1 index2", summary: "Swaps two Redis databases.", handler: (*server).cmdSwapDB},
+	{name: "select", arity: 2, flags: flagLoading | flagStale | flagFast, group: "connection", since: "1.0.0", args: "index", summary: "Changes the selected database.", handler: (*server).cmdSelect},
+	{name: "ping", arity: -1, flags: flagFast | flagStale, group: "connection", since: "1.0.0", args: "[message]", summary: "Returns the server's liveliness response.", handler: (*server).cmdPing},
+	{name: "echo", arity: 2, flags: flagFast | flagStale, group: "connection", since: "1.0.0", args: "message", summary: "Returns the given string.", handler: (*server).cmdEcho},
+	{name: "quit", arity: -1, flags: flagFast | flagLoading | flagStale, group: "connection", since: "1.0.0", summary: "Closes the connection.", handler: (*server).cmdQuit},
+	{name: "exit", arity: -1, flags: flagFast | flagLoading | flagStale, group: "connection", since: "1.0.0", summary: "Alias of QUIT.", handler: (*server).cmdQuit},
+	{name: "config", arity: -2, flags: flagAdmin | flagNoScript | flagLoading | flagStale, group: "server", since: "2.0.0", args: "subcommand [arg ...]", summary: "A container for server configuration commands.", handler: (*server).handleConfig},
+	{name: "client", arity: -2, flags: flagNoScript | flagLoading | flagStale, group: "connection", since: "2.4.0", args: "subcommand [arg ...]", summary: "A container for client connection commands.", handler: (*server).handleClient},
+	{name: "info", arity: -1, flags: flagLoading | flagStale, group: "server", since: "1.0.0", args: "[section ...]", summary: "Returns information and statistics about the server.", handler: (*server).handleInfo},
+	{name: "slowlog", arity: -2, flags: flagAdmin | flagLoading | flagStale, group: "server", since: "2.2.12", args: "subcommand [arg ...]", summary: "A container for slow log commands.", handler: (*server).handleSlowlog},
+	{name: "latency", arity: -2, flags: flagAdmin | flagNoScript | flagLoading | flagStale, group: "server", since: "2.8.13", args: "subcommand [arg ...]", summary: "A container for latency diagnostics commands.", handler: (*server).handleLatency},
+	{name: "monitor", arity: 1, flags: flagAdmin | flagNoScript | flagLoading | flagStale, group: "server", since: "1.0.0", summary: "Listens for all requests received by the server in real-time.", handler: (*server).cmdMonitor},
+	{name: "shutdown", arity: -1, flags: flagAdmin | flagNoScript | flagLoading | flagStale, group: "server", since: "1.0.0", args: "[NOSAVE | SAVE] [NOW] [FORCE] [ABORT]", summary: "Stops the server after draining in-flight pipelines.", handler: (*server).handleShutdown},
+	{name: "command", arity: -1, flags: flagLoading | flagStale, group: "server", since: "2.8.13", args: "[subcommand [arg ...]]", summary: "Returns detailed information about all commands.", handler: (*server).handleCommandInfo},
+}
+
+// cmdNone marks "no command executed"; table entries get ids from 1.
+const cmdNone = 0
+
+var (
+	// commandIndex maps a command id to its entry; slot 0 is cmdNone.
+	commandIndex   []*command
+	commandsByName map[string]*command
+	cmdCount       int
+)
+
+func init() {
+	cmdCount = len(commandTable) + 1
+	commandIndex = make([]*command, cmdCount)
+	commandsByName = make(map[string]*command, len(commandTable))
+	for i := range commandTable {
+		cmd := &commandTable[i]
+		cmd.id = i + 1
+		cmd.upper = strings.ToUpper(cmd.name)
+		cmd.arityErr = "ERR wrong number of arguments for '" + cmd.upper + "' command"
+		if len(cmd.name) > maxCommandNameLen {
+			panic("command name too long: " + cmd.name)
+		}
+		cmd.docArgs = parseDocArgs(cmd.args)
+		commandIndex[cmd.id] = cmd
+		commandsByName[cmd.name] = cmd
+	}
+}
+
+func commandName(id int) string {
+	if id == cmdNone {
+		return "NULL"
+	}
+	return commandIndex[id].name
+}
+
+// lookupCommand finds a command case-insensitively without allocating: the
+// lowered name lives on the stack and the map lookup with string(bytes)
+// does not copy.
+func lookupCommand(name string) *command {
+	if len(name) > maxCommandNameLen {
+		return nil
+	}
+	var lower [maxCommandNameLen]byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return commandsByName[string(lower[:len(name)])]
+}
+
+// lookup short-circuits lookupCommand when a pipeline repeats the same
+// command spelled in all-lower or all-upper case, which is the common case.
+func (sess *session) lookup(name string) *command {
+	if last := sess.lastLookup; last != nil && (name == last.upper || name == last.name) {
+		return last
+	}
+	cmd := lookupCommand(name)
+	if cmd != nil {
+		sess.lastLookup = cmd
+	}
+	return cmd
+}
+
+func (cmd *command) arityOK(argc int) bool {
+	if cmd.arity >= 0 {
+		return argc == cmd.arity
+	}
+	return argc >= -cmd.arity
+}
+
+// keyPositions returns the indexes of key arguments in args.
+func (cmd *command) keyPositions(args []string) []int {
+	if cmd.firstKey == 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	var keys []int
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.step {
+		keys = append(keys, i)
+	}
+	return keys
+}
+
+func (cmd *command) aclCategories() []string {
+	cats := []string{"@" + cmd.group}
+	if cmd.flags&flagWrite != 0 {
+		cats = append(cats, "@write")
+	}
+	if cmd.flags&flagReadonly != 0 {
+		cats = append(cats, "@read")
+	}
+	if cmd.flags&flagAdmin != 0 {
+		cats = append(cats, "@admin", "@dangerous")
+	}
+	if cmd.flags&flagFast != 0 {
+		cats = append(cats, "@fast")
+	} else {
+		cats = append(cats, "@slow")
+	}
+	return cats
+}
+
+func appendCommandInfo(buf []byte, cmd *command) []byte {
+	buf = resp.AppendArrayHeader(buf, 10)
+	buf = resp.AppendBulkString(buf, cmd.name)
+	buf = resp.AppendInt(buf, int64(cmd.arity))
+	n := 0
+	for i := range commandFlagNames {
+		if cmd.flags&(1<<i) != 0 {
+			n++
+		}
+	}
+	buf = resp.AppendArrayHeader(buf, n)
+	for i, name := range commandFlagNames {
+		if cmd.flags&(1<<i) != 0 {
+			buf = resp.AppendString(buf, name)
+		}
+	}
+	buf = resp.AppendInt(buf, int64(cmd.firstKey))
+	buf = resp.AppendInt(buf, int64(cmd.lastKey))
+	buf = resp.AppendInt(buf, int64(cmd.step))
+	cats := cmd.aclCategories()
+	buf = resp.AppendArrayHeader(buf, len(cats))
+	for _, cat := range cats {
+		buf = resp.AppendString(buf, cat)
+	}
+	buf = resp.AppendArrayHeader(buf, 0) // tips
+	buf = resp.AppendArrayHeader(buf, 0) // key specs
+	buf = resp.AppendArrayHeader(buf, 0) // subcommands
+	return buf
+}
+
+func appendCommandDocs(buf []byte, cmd *command) []byte {
+	buf = resp.AppendBulkString(buf, cmd.name)
+	n := 6
+	if len(cmd.docArgs) > 0 {
+		n += 2
+	}
+	buf = resp.AppendArrayHeader(buf, n)
+	buf = resp.AppendBulkString(buf, "summary")
+	buf = resp.AppendBulkString(buf, cmd.summary)
+	buf = resp.AppendBulkString(buf, "since")
+	buf = resp.AppendBulkString(buf, cmd.since)
+	buf = resp.AppendBulkString(buf, "group")
+	buf = resp.AppendBulkString(buf, cmd.group)
+	if len(cmd.docArgs) == 0 {
+		return buf
+	}
+	buf = resp.AppendBulkString(buf, "arguments")
+	buf = resp.AppendArrayHeader(buf, len(cmd.docArgs))
+	for _, a := range cmd.docArgs {
+		var flags []string
+		if a.optional {
+			flags = append(flags, "optional")
+		}
+		if a.multiple {
+			flags = append(flags, "multiple")
+		}
+		fields := 4
+		if len(flags) > 0 {
+			fields = 6
+		}
+		buf = resp.AppendArrayHeader(buf, fields)
+		buf = resp.AppendBulkString(buf, "name")
+		buf = resp.AppendBulkString(buf, a.name)
+		buf = resp.AppendBulkString(buf, "type")
+		buf = resp.AppendBulkString(buf, a.typ)
+		if len(flags) > 0 {
+			buf = resp.AppendBulkString(buf, "flags")
+			buf = resp.AppendArrayHeader(buf, len(flags))
+			for _, f := range flags {
+				buf = resp.AppendString(buf, f)
+			}
+		}
+	}
+	return buf
+}
+
+func (s *server) handleCommandInfo(sess *session) {
+	args := sess.args
+	if len(args) == 1 {
+		sess.out = resp.AppendArrayHeader(sess.out, len(commandIndex)-1)
+		for _, cmd := range commandIndex[1:] {
+			sess.out = appendCommandInfo(sess.out, cmd)
+		}
+		return
+	}
+
+	switch sub := args[1]; {
+	case strings.EqualFold(sub, "COUNT"):
+		sess.out = resp.AppendInt(sess.out, int64(len(commandIndex)-1))
+	case strings.EqualFold(sub, "INFO"):
+		names := args[2:]
+		if len(names) == 0 {
+			sess.out = resp.AppendArrayHeader(sess.out, len(commandIndex)-1)
+			for _, cmd := range commandIndex[1:] {
+				sess.out = appendCommandInfo(sess.out, cmd)
+			}
+			return
+		}
+		sess.out = resp.AppendArrayHeader(sess.out, len(names))
+		for _, name := range names {
+			if cmd := lookupCommand(name); cmd != nil {
+				sess.out = appendCommandInfo(sess.out, cmd)
+			} else {
+				sess.out = resp.AppendNullArray(sess.out)
+			}
+		}
+	case strings.EqualFold(sub, "DOCS"):
+		var cmds []*command
+		if len(args) == 2 {
+			cmds = commandIndex[1:]
+		} else {
+			for _, name := range args[2:] {
+				if cmd := lookupCommand(name); cmd != nil {
+					cmds = append(cmds, cmd)
+				}
+			}
+		}
+		sess.out = resp.AppendArrayHeader(sess.out, 2*len(cmds))
+		for _, cmd := range cmds {
+			sess.out = appendCommandDocs(sess.out, cmd)
+		}
+	case strings.EqualFold(sub, "GETKEYS"):
+		if len(args) < 3 {
+			sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'COMMAND|GETKEYS' command")
+			return
+		}
+		target := args[2:]
+		cmd := lookupCommand(target[0])
+		if cmd == nil {
+			sess.out = resp.AppendError(sess.out, "ERR Invalid command specified")
+			return
+		}
+		if !cmd.arityOK(len(target)) {
+			sess.out = resp.AppendError(sess.out, "ERR Invalid number of arguments specified for command")
+			return
+		}
+		keys := cmd.keyPositions(target)
+		if len(keys) == 0 {
+			sess.out = resp.AppendError(sess.out, "ERR The command has no key arguments")
+			return
+		}
+		sess.out = resp.AppendArrayHeader(sess.out, len(keys))
+		for _, pos := range keys {
+			sess.out = resp.AppendBulkString(sess.out, target[pos])
+		}
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try COMMAND HELP.")
+	}
+}
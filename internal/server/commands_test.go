@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestArityMatchesUsage checks every table entry's arity against its usage
+// line: an exact arity takes no optional arguments, and a minimum counts
+// the required ones. Usage lines with top-level alternatives or <...>
+// groups, and containers documenting one subcommand, are not parsed well
+// enough to check.
+func TestArityMatchesUsage(t *testing.T) {
+	for i := range commandTable {
+		cmd := &commandTable[i]
+		if strings.Contains(cmd.args, "<") || strings.HasPrefix(cmd.summary, "A container for") && !strings.HasPrefix(cmd.args, "subcommand") {
+			continue
+		}
+		required, fixed := 1, true
+		skip := false
+		for _, a := range cmd.docArgs {
+			switch {
+			case a.name == "|":
+				skip = true
+			case a.optional || a.multiple:
+				fixed = false
+			default:
+				required++
+			}
+		}
+		switch {
+		case skip:
+		case cmd.arity > 0 && (!fixed || required != cmd.arity):
+			t.Errorf("%s: arity %d, usage %q", cmd.name, cmd.arity, cmd.args)
+		case cmd.arity < 0 && required != -cmd.arity:
+			t.Errorf("%s: arity %d, usage %q needs %d arguments", cmd.name, cmd.arity, cmd.args, required)
+		}
+	}
+}
+
+func TestArity(t *testing.T) {
+	c := startServer(t, nil)
+	tests := []struct {
+		cmd  string
+		want any
+		// err is a prefix of the expected error reply.
+		err string
+	}{
+		{"SET k 1", "OK", ""},
+		{"SET k 1 EX 10", "OK", ""},
+		{"SET k 1 NX GET PX 100", "1", ""},
+		{"SET k", nil, "ERR wrong number of arguments for 'SET'"},
+		{"GET", nil, "ERR wrong number of arguments for 'GET'"},
+		{"GET k extra", nil, "ERR wrong number of arguments for 'GET'"},
+		{"MGET", nil, "ERR wrong number of arguments for 'MGET'"},
+		{"MSET a", nil, "ERR wrong number of arguments for 'MSET'"},
+		{"MSET a 1 b", nil, "ERR wrong number of arguments for 'MSET'"},
+		{"DEL", nil, "ERR wrong number of arguments for 'DEL'"},
+		{"EXISTS k k", int64(2), ""},
+		{"TTL k extra", nil, "ERR wrong number of arguments for 'TTL'"},
+		{"GETRANGE k 0", nil, "ERR wrong number of arguments for 'GETRANGE'"},
+		{"BITOP AND d", nil, "ERR wrong number of arguments for 'BITOP'"},
+		{"GEOADD g 13.36 38.11", nil, "ERR wrong number of arguments for 'GEOADD'"},
+		{"JSON.SET j $", nil, "ERR wrong number of arguments for 'JSON.SET'"},
+		{"DBSIZE extra", nil, "ERR wrong number of arguments for 'DBSIZE'"},
+		{"CLIENT", nil, "ERR wrong number of arguments for 'CLIENT'"},
+		{"PING", "PONG", ""},
+		{"FLUSHDB ASYNC", "OK", ""},
+		{"NOPE", nil, "ERR unknown command 'NOPE'"},
+	}
+	for _, tt := range tests {
+		got, err := c.Do(context.Background(), strings.Fields(tt.cmd)...)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, %v, want error %q", tt.cmd, got, err, tt.err)
+			}
+		case err != nil || got != tt.want:
+			t.Errorf("%s: got %v, %v, want %v", tt.cmd, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,742 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/glob"
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+)
+
+// configParam is one entry of the configuration registry. Every parameter
+// is also a command-line flag of the same name and a directive of the
+// config file. Immutable parameters are only applied at startup.
+type configParam struct {
+	name    string
+	usage   string
+	def     string
+	mutable bool
+	isBool  bool
+	get     func(s *server) string
+	set     func(s *server, v string) error
+	// check validates a value without applying it; only immutable
+	// parameters need it, for reloads.
+	check func(v string) error
+}
+
+// serverConfig holds the parameters that are fixed once the server runs.
+type serverConfig struct {
+	// mu serializes CONFIG SET and CONFIG REWRITE.
+	mu          sync.Mutex
+	file        string
+	flags       map[string]string
+	embedded    bool
+	pprofAddr   string
+	metricsAddr string
+	gcReset     bool
+	// eventLoops is 0 for one per CPU.
+	eventLoops    int
+	shardAffinity bool
+}
+
+var configParams = []*configParam{
+	{
+		name: "addr", usage: "listen address", def: "tcp://0.0.0.0:6379",
+		get:   func(s *server) string { return s.addr },
+		set:   func(s *server, v string) error { s.addr = v; return checkListenAddr(v) },
+		check: checkListenAddr,
+	},
+	{
+		name: "pprof", usage: "pprof server address, also serves /metrics (empty to disable)", def: "localhost:9090",
+		get:   func(s *server) string { return s.cfg.pprofAddr },
+		set:   func(s *server, v string) error { s.cfg.pprofAddr = v; return checkHTTPAddr(v) },
+		check: checkHTTPAddr,
+	},
+	{
+		name: "metrics-addr", usage: "dedicated Prometheus /metrics address (empty to serve only beside pprof)",
+		get:   func(s *server) string { return s.cfg.metricsAddr },
+		set:   func(s *server, v string) error { s.cfg.metricsAddr = v; return checkHTTPAddr(v) },
+		check: checkHTTPAddr,
+	},
+	{
+		name: "databases", usage: "number of logical databases", def: "16",
+		get: func(s *server) string { return strconv.Itoa(s.numDBs) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 1)
+			if err != nil {
+				return err
+			}
+			if n > 1<<16 {
+				return errors.New("argument must be at most 65536")
+			}
+			s.numDBs = int(n)
+			return nil
+		},
+		check: func(v string) error {
+			_, err := parseConfigInt(v, 1)
+			return err
+		},
+	},
+	{
+		name: "event-loops", usage: "number of event loops (0 for one per CPU)", def: "0",
+		get: func(s *server) string { return strconv.Itoa(s.cfg.eventLoops) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 0)
+			if err != nil {
+				return err
+			}
+			if n > 1024 {
+				return errors.New("argument must be at most 1024")
+			}
+			s.cfg.eventLoops = int(n)
+			return nil
+		},
+		check: func(v string) error {
+			_, err := parseConfigInt(v, 0)
+			return err
+		},
+	},
+	{
+		name: "shard-affinity", usage: "experimental: give each event loop its own shards and forward single-key commands to the owner", def: "no", isBool: true,
+		get: func(s *server) string { return formatConfigBool(s.cfg.shardAffinity) },
+		set: func(s *server, v string) error {
+			b, err := parseConfigBool(v)
+			s.cfg.shardAffinity = b
+			return err
+		},
+		check: func(v string) error {
+			_, err := parseConfigBool(v)
+			return err
+		},
+	},
+	{
+		name: "gc-reset", usage: "force GC and free OS memory on startup", def: "no", isBool: true,
+		get: func(s *server) string { return formatConfigBool(s.cfg.gcReset) },
+		set: func(s *server, v string) error {
+			b, err := parseConfigBool(v)
+			s.cfg.gcReset = b
+			return err
+		},
+		check: func(v string) error {
+			_, err := parseConfigBool(v)
+			return err
+		},
+	},
+	{
+		name: "gogc", usage: "set GOGC for server", def: "1000", mutable: true,
+		get: func(s *server) string { return strconv.FormatInt(s.gogc.Load(), 10) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, -1)
+			if err != nil {
+				return err
+			}
+			s.gogc.Store(n)
+			debug.SetGCPercent(int(n))
+			return nil
+		},
+	},
+	{
+		name: "ttl", usage: "default TTL for keys in seconds (0 to disable)", def: "15", mutable: true,
+		get: func(s *server) string { return strconv.FormatInt(s.defaultTTL.Load(), 10) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 0)
+			if err != nil {
+				return err
+			}
+			s.defaultTTL.Store(n)
+			return nil
+		},
+	},
+	{
+		name: "maxmemory", usage: "refuse writes once the Go heap exceeds this many bytes, accepts kb/mb/gb (0 for no limit)", def: "0", mutable: true,
+		get: func(s *server) string { return strconv.FormatInt(s.maxmemory.Load(), 10) },
+		set: func(s *server, v string) error {
+			n, err := parseMemory(v)
+			if err != nil {
+				return err
+			}
+			s.maxmemory.Store(n)
+			s.checkMemory()
+			return nil
+		},
+	},
+	{
+		name: "shutdown-timeout", usage: "seconds to wait for clients to finish in-flight pipelines on shutdown", def: "10", mutable: true,
+		get: func(s *server) string { return strconv.FormatInt(s.shutdownTimeout.Load(), 10) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 0)
+			if err != nil {
+				return err
+			}
+			s.shutdownTimeout.Store(n)
+			return nil
+		},
+	},
+	{
+		name: "slowlog-log-slower-than", usage: "log commands slower than this many microseconds (negative to disable)", def: "10000", mutable: true,
+		get: func(s *server) string {
+			threshold := s.slowlog.threshold.Load()
+			if threshold < 0 {
+				return "-1"
+			}
+			return strconv.FormatInt(threshold/int64(time.Microsecond), 10)
+		},
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, -1<<62)
+			if err != nil {
+				return err
+			}
+			s.slowlog.setThreshold(n)
+			return nil
+		},
+	},
+	{
+		name: "slowlog-max-len", usage: "maximum number of SLOWLOG entries", def: "128", mutable: true,
+		get: func(s *server) string { return strconv.FormatInt(s.slowlog.maxLen.Load(), 10) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 0)
+			if err != nil {
+				return err
+			}
+			s.slowlog.maxLen.Store(n)
+			return nil
+		},
+	},
+	{
+		name: "pipeline-batch", usage: "run up to this many pipelined GET/SET commands grouped by shard, one lock per shard (0 to disable)", def: "0", mutable: true,
+		get: func(s *server) string { return strconv.FormatInt(s.pipelineBatch.Load(), 10) },
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 0)
+			if err != nil {
+				return err
+			}
+			s.pipelineBatch.Store(n)
+			return nil
+		},
+	},
+	{
+		name: "latency-monitor-threshold", usage: "record latency events slower than this many milliseconds (0 to disable)", def: "0", mutable: true,
+		get: func(s *server) string {
+			return strconv.FormatInt(s.latency.threshold.Load()/int64(time.Millisecond), 10)
+		},
+		set: func(s *server, v string) error {
+			n, err := parseConfigInt(v, 0)
+			if err != nil {
+				return err
+			}
+			s.latency.setThreshold(n)
+			return nil
+		},
+	},
+}
+
+func lookupConfigParam(name string) *configParam {
+	name = strings.ToLower(name)
+	for _, p := range configParams {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func checkListenAddr(v string) error {
+	scheme, addr, ok := strings.Cut(v, "://")
+	if !ok {
+		return errors.New("address must look like tcp://host:port or unix:///path")
+	}
+	switch scheme {
+	case "tcp", "tcp4", "tcp6":
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return err
+		}
+	case "unix":
+		if addr == "" {
+			return errors.New("empty unix socket path")
+		}
+	default:
+		return fmt.Errorf("unsupported network %q", scheme)
+	}
+	return nil
+}
+
+func checkHTTPAddr(v string) error {
+	if v == "" {
+		return nil
+	}
+	_, _, err := net.SplitHostPort(v)
+	return err
+}
+
+// sameValue compares two spellings of a value, so that "yes" and
+// "true" are not reported as a change on reload.
+func (p *configParam) sameValue(a, b string) bool {
+	if p.isBool {
+		x, errX := parseConfigBool(a)
+		y, errY := parseConfigBool(b)
+		return errX == nil && errY == nil && x == y
+	}
+	return a == b
+}
+
+func parseConfigInt(v string, min int64) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < min {
+		return 0, fmt.Errorf("argument must be greater than or equal to %d", min)
+	}
+	return n, nil
+}
+
+func parseConfigBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+func formatConfigBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseMemory accepts a byte count with the redis.conf unit suffixes:
+// k/m/g are powers of 1000, kb/mb/gb powers of 1024.
+func parseMemory(v string) (int64, error) {
+	lower := strings.ToLower(v)
+	mul := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mul    int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"b", 1}} {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			mul = unit.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// configFlag exposes a configParam as a command-line flag. Values are only
+// recorded here and applied together with the other sources once the
+// server exists.
+type configFlag struct {
+	p      *configParam
+	values map[string]string
+}
+
+func (f *configFlag) String() string {
+	if f == nil || f.p == nil {
+		return ""
+	}
+	return f.p.def
+}
+
+func (f *configFlag) Set(v string) error {
+	f.values[f.p.name] = v
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool { return f.p.isBool }
+
+// registerConfigFlags returns the map that collects the values of the
+// flags actually given on the command line.
+func registerConfigFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	for _, p := range configParams {
+		fs.Var(&configFlag{p: p, values: values}, p.name, p.usage)
+	}
+	return values
+}
+
+// configEnvName maps a parameter to its environment variable, e.g.
+// slowlog-max-len to KV_SLOWLOG_MAX_LEN.
+func configEnvName(name string) string {
+	return "KV_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+type configDirective struct {
+	line  int
+	name  string
+	value string
+}
+
+// readConfigFile parses a redis.conf-style file: one "name value" per line,
+// '#' comments, values optionally double-quoted.
+func readConfigFile(file string) ([]configDirective, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var directives []configDirective
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields, err := splitConfigLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '%s <value>'", file, n, fields[0])
+		}
+		name := strings.ToLower(fields[0])
+		if lookupConfigParam(name) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown option '%s'", file, n, fields[0])
+		}
+		directives = append(directives, configDirective{line: n, name: name, value: fields[1]})
+	}
+	return directives, sc.Err()
+}
+
+func splitConfigLine(line string) ([]string, error) {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" && line[0] != '#' {
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, errors.New("unbalanced quotes")
+			}
+			v, _ := strconv.Unquote(quoted)
+			fields = append(fields, v)
+			line = line[len(quoted):]
+		} else {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+		}
+		line = strings.TrimLeft(line, " \t")
+	}
+	return fields, nil
+}
+
+func formatConfigValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\"#") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+type configValue struct {
+	value  string
+	source string
+}
+
+// resolveConfig computes every parameter from, in increasing precedence,
+// its default, the config file, KV_* environment variables and
+// command-line flags.
+func (s *server) resolveConfig() (map[string]configValue, error) {
+	values := make(map[string]configValue, len(configParams))
+	for _, p := range configParams {
+		values[p.name] = configValue{p.def, "default"}
+	}
+	if file := s.cfg.file; file != "" {
+		directives, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range directives {
+			values[d.name] = configValue{d.value, file + ":" + strconv.Itoa(d.line)}
+		}
+	}
+	for _, p := range configParams {
+		env := configEnvName(p.name)
+		if v, ok := os.LookupEnv(env); ok && !s.cfg.embedded {
+			values[p.name] = configValue{v, "$" + env}
+		}
+		if v, ok := s.cfg.flags[p.name]; ok {
+			values[p.name] = configValue{v, "-" + p.name}
+		}
+	}
+	return values, nil
+}
+
+// loadConfig applies the resolved configuration at startup, reporting
+// every invalid value at once.
+func (s *server) loadConfig(file string, flags map[string]string) error {
+	s.cfg.file = file
+	s.cfg.flags = flags
+	values, err := s.resolveConfig()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range configParams {
+		v := values[p.name]
+		if err := p.set(s, v.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid %s %q: %v", v.source, p.name, v.value, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reloadConfig re-reads the config file on SIGHUP. Mutable parameters are
+// applied all-or-nothing; changed immutable ones are only reported because
+// they need a restart.
+func (s *server) reloadConfig() error {
+	if s.cfg.file == "" {
+		return errors.New("no config file given with -config")
+	}
+	values, err := s.resolveConfig()
+	if err != nil {
+		return err
+	}
+
+	s.cfg.mu.Lock()
+	defer s.cfg.mu.Unlock()
+	var errs []error
+	for _, p := range configParams {
+		if v := values[p.name]; !p.mutable && p.check != nil {
+			if err := p.check(v.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid %s %q: %v", v.source, p.name, v.value, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	var applied []*configParam
+	var old []string
+	for _, p := range configParams {
+		v := values[p.name]
+		cur := p.get(s)
+		if p.sameValue(cur, v.value) {
+			continue
+		}
+		if !p.mutable {
+			log.Printf("config reload: %s changed to %q in %s, restart required", p.name, v.value, v.source)
+			continue
+		}
+		if err := p.set(s, v.value); err != nil {
+			for i := len(applied) - 1; i >= 0; i-- {
+				_ = applied[i].set(s, old[i])
+			}
+			return fmt.Errorf("%s: invalid %s %q: %v", v.source, p.name, v.value, err)
+		}
+		applied = append(applied, p)
+		old = append(old, cur)
+	}
+	for i, p := range applied {
+		if now := p.get(s); now != old[i] {
+			log.Printf("config reload: %s %s -> %s", p.name, old[i], now)
+		}
+	}
+	return nil
+}
+
+// checkMemory refreshes the flag that makes write commands fail with OOM.
+func (s *server) checkMemory() {
+	max := s.maxmemory.Load()
+	if max <= 0 {
+		s.oom.Store(false)
+		return
+	}
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	s.oom.Store(int64(sample[0].Value.Uint64()) > max)
+}
+
+func (s *server) watchMemory() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkMemory()
+		case <-s.stopped:
+			return
+		}
+	}
+}
+
+func (s *server) handleConfig(sess *session) {
+	args := sess.args
+	switch sub := args[1]; {
+	case strings.EqualFold(sub, "GET"):
+		if len(args) < 3 {
+			sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'CONFIG|GET' command")
+			return
+		}
+		var matched []*configParam
+		for _, p := range configParams {
+			for _, pattern := range args[2:] {
+				if glob.Match(strings.ToLower(pattern), p.name) {
+					matched = append(matched, p)
+					break
+				}
+			}
+		}
+		sess.out = resp.AppendArrayHeader(sess.out, 2*len(matched))
+		for _, p := range matched {
+			sess.out = resp.AppendBulkString(sess.out, p.name)
+			sess.out = resp.AppendBulkString(sess.out, p.get(s))
+		}
+	case strings.EqualFold(sub, "SET"):
+		if len(args) < 4 || len(args)%2 != 0 {
+			sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'CONFIG|SET' command")
+			return
+		}
+		if err := s.configSet(args[2:]); err != nil {
+			sess.out = resp.AppendError(sess.out, err.Error())
+			return
+		}
+		sess.out = resp.AppendString(sess.out, "OK")
+	case strings.EqualFold(sub, "RESETSTAT"):
+		s.resetStats()
+		sess.out = resp.AppendString(sess.out, "OK")
+	case strings.EqualFold(sub, "REWRITE"):
+		if err := s.rewriteConfig(); err != nil {
+			sess.out = resp.AppendError(sess.out, err.Error())
+			return
+		}
+		sess.out = resp.AppendString(sess.out, "OK")
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try CONFIG HELP.")
+	}
+}
+
+// configSet applies name/value pairs all-or-nothing: if one of them fails
+// the ones already applied are restored.
+func (s *server) configSet(pairs []string) error {
+	s.cfg.mu.Lock()
+	defer s.cfg.mu.Unlock()
+
+	params := make([]*configParam, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		p := lookupConfigParam(pairs[i])
+		if p == nil {
+			return errors.New("ERR Unknown option or number of arguments for CONFIG SET - '" + pairs[i] + "'")
+		}
+		if !p.mutable {
+			return errors.New("ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - can't set immutable config")
+		}
+		for _, seen := range params {
+			if seen == p {
+				return errors.New("ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - duplicate parameter")
+			}
+		}
+		params = append(params, p)
+	}
+
+	old := make([]string, len(params))
+	for i, p := range params {
+		old[i] = p.get(s)
+		if err := p.set(s, pairs[2*i+1]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				_ = params[j].set(s, old[j])
+			}
+			return errors.New("ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - " + err.Error())
+		}
+	}
+	return nil
+}
+
+// rewriteConfig updates known directives in place, keeps comments and
+// appends parameters that differ from their defaults. The file is replaced
+// atomically.
+func (s *server) rewriteConfig() error {
+	s.cfg.mu.Lock()
+	defer s.cfg.mu.Unlock()
+
+	file := s.cfg.file
+	if file == "" {
+		return errors.New("ERR The server is running without a config file")
+	}
+	var lines []string
+	if data, err := os.ReadFile(file); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+
+	written := make(map[string]bool, len(configParams))
+	out := make([]string, 0, len(lines)+len(configParams))
+	for _, line := range lines {
+		fields, err := splitConfigLine(line)
+		if err != nil || len(fields) == 0 {
+			out = append(out, line)
+			continue
+		}
+		p := lookupConfigParam(fields[0])
+		if p == nil {
+			out = append(out, line)
+			continue
+		}
+		if written[p.name] {
+			continue
+		}
+		written[p.name] = true
+		out = append(out, p.name+" "+formatConfigValue(p.get(s)))
+	}
+	header := false
+	for _, p := range configParams {
+		v := p.get(s)
+		if written[p.name] || v == p.def {
+			continue
+		}
+		if !header {
+			out = append(out, "# Generated by CONFIG REWRITE")
+			header = true
+		}
+		out = append(out, p.name+" "+formatConfigValue(v))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".kv-config-*")
+	if err != nil {
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+	_, err = tmp.WriteString(strings.Join(out, "\n") + "\n")
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+	return nil
+}
+
+func (s *server) resetStats() {
+	s.stats.reset()
+	for _, db := range s.databases() {
+		db.ResetStats()
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+// writeConfig writes lines to a config file in dir and returns its path.
+func writeConfig(t *testing.T, dir string, lines ...string) string {
+	t.Helper()
+	file := filepath.Join(dir, "kv.conf")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     []string
+		env      map[string]string
+		flags    map[string]string
+		embedded bool
+		// want maps parameters to their value and its source; a source
+		// of "file" stands for the file and line.
+		want map[string][2]string
+	}{
+		{"defaults", nil, nil, nil, false, map[string][2]string{
+			"ttl": {"15", "default"}, "pprof": {"localhost:9090", "default"},
+		}},
+		{"file", []string{"# comment", "", "ttl 30", `pprof ""`, "TTL 31 # last one wins"}, nil, nil, false, map[string][2]string{
+			"ttl": {"31", "file:5"}, "pprof": {"", "file:4"},
+		}},
+		{"environment over file", []string{"ttl 30", "databases 4"}, map[string]string{"KV_TTL": "40", "KV_SLOWLOG_MAX_LEN": "7"}, nil, false, map[string][2]string{
+			"ttl": {"40", "$KV_TTL"}, "slowlog-max-len": {"7", "$KV_SLOWLOG_MAX_LEN"}, "databases": {"4", "file:2"},
+		}},
+		{"flags over environment", []string{"ttl 30"}, map[string]string{"KV_TTL": "40"}, map[string]string{"ttl": "50"}, false, map[string][2]string{
+			"ttl": {"50", "-ttl"},
+		}},
+		{"embedded ignores environment", nil, map[string]string{"KV_TTL": "40", "KV_DATABASES": "2"}, map[string]string{"databases": "3"}, true, map[string][2]string{
+			"ttl": {"15", "default"}, "databases": {"3", "-databases"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			s := newServer()
+			s.cfg.embedded = tt.embedded
+			file := ""
+			if tt.file != nil {
+				file = writeConfig(t, t.TempDir(), tt.file...)
+			}
+			if err := s.loadConfig(file, tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			values, err := s.resolveConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				source := strings.Replace(want[1], "file", file, 1)
+				if got := values[name]; got.value != want[0] || got.source != source {
+					t.Errorf("%s = %q from %s, want %q from %s", name, got.value, got.source, want[0], source)
+				}
+				if got := lookupConfigParam(name).get(s); got != want[0] {
+					t.Errorf("%s applied as %q, want %q", name, got, want[0])
+				}
+			}
+		})
+	}
+}
+
+// TestConfigErrors checks that startup reports every bad value with where
+// it came from, and that file syntax errors name the line.
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  []string
+		flags map[string]string
+		// errs are substrings the error must contain; "file" stands for
+		// the config file's path.
+		errs []string
+	}{
+		{"unknown option", []string{"ttl 1", "maxclients 10"}, nil, []string{"file:2: unknown option 'maxclients'"}},
+		{"missing value", []string{"ttl"}, nil, []string{"file:1: expected 'ttl <value>'"}},
+		{"extra value", []string{"ttl 1 2"}, nil, []string{"file:1: expected 'ttl <value>'"}},
+		{"unbalanced quotes", []string{`pprof "localhost:1`}, nil, []string{"file:1: unbalanced quotes"}},
+		{"every invalid value", []string{"ttl soon", "databases 0", "addr localhost:1"}, map[string]string{"maxmemory": "lots"}, []string{
+			`file:1: invalid ttl "soon": argument couldn't be parsed into an integer`,
+			`file:2: invalid databases "0": argument must be greater than or equal to 1`,
+			`file:3: invalid addr "localhost:1": address must look like tcp://host:port or unix:///path`,
+			`-maxmemory: invalid maxmemory "lots": argument must be a memory value`,
+		}},
+		{"bad boolean", nil, map[string]string{"shard-affinity": "maybe"}, []string{`-shard-affinity: invalid shard-affinity "maybe": argument must be 'yes' or 'no'`}},
+	}
+	for _, tt := range tests {
+		file := writeConfig(t, t.TempDir(), tt.file...)
+		err := newServer().loadConfig(file, tt.flags)
+		if err == nil {
+			t.Errorf("%s: loaded", tt.name)
+			continue
+		}
+		for _, want := range tt.errs {
+			if want = strings.Replace(want, "file", file, 1); !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not contain %q", tt.name, err, want)
+			}
+		}
+	}
+}
+
+// TestReloadConfig edits the file between reloads: mutable parameters
+// change together or not at all, immutable ones and those set by flags
+// keep their values.
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "ttl 30", "slowlog-max-len 10", "databases 16")
+	s := newServer()
+	if err := s.loadConfig(file, map[string]string{"latency-monitor-threshold": "5"}); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		file []string
+		// err is a substring of the expected error, "" for success.
+		err  string
+		want map[string]string
+	}{
+		{[]string{"ttl 60", "slowlog-max-len 20", "databases 4", "latency-monitor-threshold 9"}, "", map[string]string{
+			"ttl": "60", "slowlog-max-len": "20", "databases": "16", "latency-monitor-threshold": "5",
+		}},
+		{[]string{"ttl 70", "slowlog-max-len many"}, `invalid slowlog-max-len "many"`, map[string]string{
+			"ttl": "60", "slowlog-max-len": "20",
+		}},
+		{[]string{"ttl 70", "addr nowhere"}, `invalid addr "nowhere"`, map[string]string{"ttl": "60"}},
+		{[]string{"ttl 70", "bogus 1"}, "unknown option 'bogus'", map[string]string{"ttl": "60"}},
+		// Parameters left out of the file return to their defaults.
+		{[]string{"ttl 70"}, "", map[string]string{"ttl": "70", "slowlog-max-len": "128"}},
+	}
+	for i, st := range steps {
+		writeConfig(t, dir, st.file...)
+		err := s.reloadConfig()
+		if st.err == "" && err != nil || st.err != "" && (err == nil || !strings.Contains(err.Error(), st.err)) {
+			t.Errorf("reload %d: %v, want error %q", i, err, st.err)
+		}
+		for name, want := range st.want {
+			if got := lookupConfigParam(name).get(s); got != want {
+				t.Errorf("reload %d: %s = %q, want %q", i, name, got, want)
+			}
+		}
+	}
+	if err := newServer().reloadConfig(); err == nil {
+		t.Error("reload without a config file succeeded")
+	}
+}
+
+func TestConfigSetAndRewrite(t *testing.T) {
+	file := writeConfig(t, t.TempDir(), "# keep me", "ttl 30", "TTL 31", `pprof ""`)
+	s := newServer()
+	if err := s.loadConfig(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pairs []string
+		err   string
+	}{
+		{[]string{"ttl", "45", "slowlog-max-len", "7"}, ""},
+		{[]string{"ttl", "1", "databases", "2"}, "can't set immutable config"},
+		{[]string{"ttl", "2", "gogc", "x"}, "argument couldn't be parsed into an integer"},
+		{[]string{"ttl", "3", "TTL", "4"}, "duplicate parameter"},
+		{[]string{"nosuch", "1"}, "Unknown option"},
+	}
+	for _, tt := range tests {
+		err := s.configSet(tt.pairs)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("CONFIG SET %q: %v, want %q", tt.pairs, err, tt.err)
+		}
+	}
+	// The failed sets must have left ttl alone.
+	if got := lookupConfigParam("ttl").get(s); got != "45" {
+		t.Fatalf("ttl = %s after failed CONFIG SETs", got)
+	}
+	if err := s.rewriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# keep me\nttl 45\npprof \"\"\n# Generated by CONFIG REWRITE\nslowlog-max-len 7\n"
+	if string(data) != want {
+		t.Errorf("rewritten file:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+// TestConfigCommand checks over the wire that CONFIG SET takes effect on
+// the running server and CONFIG GET reports it.
+func TestConfigCommand(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), nil)
+	c := dial(t, addr)
+	oom := kvclient.Error("OOM command not allowed when used memory > 'maxmemory'.")
+	tests := []struct {
+		args []string
+		want any
+	}{
+		{[]string{"CONFIG", "GET", "slowlog-*"}, []any{"slowlog-log-slower-than", "10000", "slowlog-max-len", "128"}},
+		{[]string{"CONFIG", "GET", "MAXMEMORY", "nosuch"}, []any{"maxmemory", "0"}},
+		{[]string{"CONFIG", "SET", "maxmemory", "1", "slowlog-max-len", "5"}, "OK"},
+		{[]string{"CONFIG", "GET", "maxmemory", "slowlog-max-len"}, []any{"maxmemory", "1", "slowlog-max-len", "5"}},
+		{[]string{"SET", "k", "v"}, oom},
+		{[]string{"GET", "k"}, nil},
+		{[]string{"CONFIG", "SET", "maxmemory", "0", "databases", "2"}, kvclient.Error("ERR CONFIG SET failed (possibly related to argument 'databases') - can't set immutable config")},
+		{[]string{"SET", "k", "v"}, oom},
+		{[]string{"CONFIG", "SET", "maxmemory", "0"}, "OK"},
+		{[]string{"SET", "k", "v"}, "OK"},
+		{[]string{"CONFIG", "SET", "slowlog-log-slower-than", "0"}, "OK"},
+		{[]string{"SLOWLOG", "LEN"}, int64(1)},
+		{[]string{"CONFIG", "SET", "ttl"}, kvclient.Error("ERR wrong number of arguments for 'CONFIG|SET' command")},
+		{[]string{"CONFIG", "REWRITE"}, kvclient.Error("ERR The server is running without a config file")},
+		{[]string{"CONFIG", "NOPE"}, kvclient.Error("ERR unknown subcommand 'NOPE'. Try CONFIG HELP.")},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: %#v, want %#v", tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestConfigRewriteCommand rewrites through CONFIG REWRITE and checks the
+// file loads back into the values set.
+func TestConfigRewriteCommand(t *testing.T) {
+	file := writeConfig(t, t.TempDir(), "slowlog-max-len 9")
+	s := newServer()
+	if err := s.loadConfig(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"CONFIG", "SET", "slowlog-max-len", "11", "maxmemory", "2mb"},
+		{"CONFIG", "REWRITE"},
+	} {
+		if got := runHandler(t, s.handleConfig, args...); got != "OK" {
+			t.Fatalf("%v: %v", args, got)
+		}
+	}
+	loaded := newServer()
+	if err := loaded.loadConfig(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"slowlog-max-len": "11", "maxmemory": "2097152", "ttl": lookupConfigParam("ttl").def} {
+		if got := lookupConfigParam(name).get(loaded); got != want {
+			t.Errorf("%s = %s after REWRITE and reload, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// db0Capacity keeps the historical preallocation for the default database;
+// the others, and databases emptied by FLUSH*, grow on demand.
+const db0Capacity = 5_000_000
+
+// openDatabases creates the database table; db0, if not nil, is used as
+// database 0 instead of a fresh storage.
+func (s *server) openDatabases(n int, db0 *storage.Storage) {
+	dbs := make([]storage.Storage, n)
+	for i := range dbs {
+		if i == 0 && db0 != nil {
+			dbs[0] = *db0
+			continue
+		}
+		capacity := 0
+		if i == 0 {
+			capacity = db0Capacity
+		}
+		dbs[i] = s.newDB(capacity)
+	}
+	s.dbs.Store(&dbs)
+}
+
+func (s *server) newDB(capacity int) storage.Storage {
+	st := storage.NewWithCapacity(capacity)
+	st.SetLatencyHook(s.latency.observe)
+	return st
+}
+
+// db returns the storage behind index i. The table never changes once
+// open: SWAPDB and FLUSH* change the storages' contents in place, so a
+// storage shared with an embedding program stays database 0.
+func (s *server) db(i int) storage.Storage {
+	return (*s.dbs.Load())[i]
+}
+
+func (s *server) databases() []storage.Storage {
+	return *s.dbs.Load()
+}
+
+// expireStats sums the active expire cycle counters of all databases.
+func (s *server) expireStats() storage.ExpireStats {
+	var total storage.ExpireStats
+	for _, db := range s.databases() {
+		st := db.ExpireStats()
+		total.Cycles += st.Cycles
+		total.FastCycles += st.FastCycles
+		total.TimeCapped += st.TimeCapped
+		total.Nanos += st.Nanos
+	}
+	return total
+}
+
+// shardStats sums the per-shard statistics of all databases.
+func (s *server) shardStats() []storage.ShardStats {
+	var total []storage.ShardStats
+	for _, db := range s.databases() {
+		for i, sh := range db.Stats() {
+			if total == nil {
+				total = make([]storage.ShardStats, storage.ShardCount)
+			}
+			t := &total[i]
+			t.Keys += sh.Keys
+			t.Expires += sh.Expires
+			t.Bytes += sh.Bytes
+			t.Expired += sh.Expired
+			t.ExpiredStale += sh.ExpiredStale
+			t.ArenaBytes += sh.ArenaBytes
+			t.ArenaFree += sh.ArenaFree
+			t.Compactions += sh.Compactions
+			t.JanitorRuns += sh.JanitorRuns
+			t.JanitorNanos += sh.JanitorNanos
+			t.LockWaits += sh.LockWaits
+			t.LockWaitNanos += sh.LockWaitNanos
+			t.ReadFallbacks += sh.ReadFallbacks
+		}
+	}
+	return total
+}
+
+var (
+	errDBIndexNotInt = errors.New(errNotInt)
+	errDBIndexRange  = errors.New("ERR DB index is out of range")
+)
+
+func (s *server) parseDBIndex(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, errDBIndexNotInt
+	}
+	if n < 0 || n >= len(s.databases()) {
+		return 0, errDBIndexRange
+	}
+	return n, nil
+}
+
+func (s *server) cmdSelect(sess *session) {
+	n, err := s.parseDBIndex(sess.args[1])
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	sess.db = n
+	sess.cl.mu.Lock()
+	sess.cl.db = n
+	sess.cl.mu.Unlock()
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+// cmdSwapDB swaps the contents of two databases rather than their places
+// in the table, so a database 0 shared with an embedding program keeps
+// being the one clients of index 0 see.
+func (s *server) cmdSwapDB(sess *session) {
+	a, err := s.parseDBIndex(sess.args[1])
+	if err == errDBIndexNotInt {
+		err = errors.New("ERR invalid first DB index")
+	}
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	b, err := s.parseDBIndex(sess.args[2])
+	if err == errDBIndexNotInt {
+		err = errors.New("ERR invalid second DB index")
+	}
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	s.db(a).SwapContents(s.db(b))
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+func (s *server) cmdMove(sess *session) {
+	dst, err := s.parseDBIndex(sess.args[2])
+	if err != nil {
+		sess.out = resp.AppendError(sess.out, err.Error())
+		return
+	}
+	if dst == sess.db {
+		sess.out = resp.AppendError(sess.out, "ERR source and destination objects are the same")
+		return
+	}
+	key := sess.args[1]
+	dbs := s.databases()
+	if dbs[sess.db].MoveHashed(xxhash.Sum64String(key), key, dbs[dst]) {
+		sess.out = resp.AppendInt(sess.out, 1)
+	} else {
+		sess.out = resp.AppendInt(sess.out, 0)
+	}
+}
+
+func (s *server) cmdDBSize(sess *session) {
+	sess.out = resp.AppendInt(sess.out, int64(s.db(sess.db).Len()))
+}
+
+// parseFlushMode returns whether the flush should run in the background.
+func parseFlushMode(args []string) (async bool, ok bool) {
+	switch {
+	case len(args) == 1:
+		return false, true
+	case len(args) == 2 && strings.EqualFold(args[1], "ASYNC"):
+		return true, true
+	case len(args) == 2 && strings.EqualFold(args[1], "SYNC"):
+		return false, true
+	}
+	return false, false
+}
+
+func (s *server) cmdFlushDB(sess *session) {
+	async, ok := parseFlushMode(sess.args)
+	if !ok {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	s.flush([]int{sess.db}, async)
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+func (s *server) cmdFlushAll(sess *session) {
+	async, ok := parseFlushMode(sess.args)
+	if !ok {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	all := make([]int, len(s.databases()))
+	for i := range all {
+		all[i] = i
+	}
+	s.flush(all, async)
+	sess.out = resp.AppendString(sess.out, "OK")
+}
+
+// flush empties the given databases in place, so storages shared with
+// embedding programs stay shared. Both modes swap in empty shards before
+// replying; SYNC releases the old data on the event loop, ASYNC hands it
+// to a background goroutine, see Storage.FlushAsync.
+func (s *server) flush(indexes []int, async bool) {
+	dbs := s.databases()
+	for _, i := range indexes {
+		if async {
+			dbs[i].FlushAsync()
+		} else {
+			dbs[i].Flush()
+		}
+	}
+}
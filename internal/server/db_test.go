@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// inDB runs cmds on one connection after SELECT db and returns their
+// replies, errors included.
+func inDB(t *testing.T, c *kvclient.Client, db string, cmds ...[]string) []any {
+	t.Helper()
+	p := c.Pipeline()
+	p.Queue("SELECT", db)
+	for _, cmd := range cmds {
+		p.Queue(cmd...)
+	}
+	res, err := p.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0] != "OK" {
+		t.Fatalf("SELECT %s: %v", db, res[0])
+	}
+	return res[1:]
+}
+
+func TestDatabases(t *testing.T) {
+	st := storage.NewWithCapacity(0)
+	c, _ := serveStorage(t, st, map[string]string{"databases": "4"})
+	// The embedding program writes database 0 directly.
+	st.SetHashed(xxhash.Sum64String("shared"), "shared", "from st")
+
+	steps := []struct {
+		db   string
+		cmds [][]string
+		want []any
+	}{
+		{"1", [][]string{{"SET", "k", "one"}, {"SET", "ttl", "x"}, {"EXPIRE", "ttl", "100"}, {"DBSIZE"}}, []any{"OK", "OK", int64(1), int64(2)}},
+		{"0", [][]string{{"GET", "k"}, {"GET", "shared"}, {"DBSIZE"}}, []any{nil, "from st", int64(1)}},
+		{"1", [][]string{{"MOVE", "ttl", "2"}, {"MOVE", "ttl", "2"}, {"MOVE", "k", "1"}}, []any{int64(1), int64(0), kvclient.Error("ERR source and destination objects are the same")}},
+		{"2", [][]string{{"GET", "ttl"}, {"TTL", "ttl"}}, []any{"x", int64(100)}},
+		// MOVE does not overwrite a key in the destination.
+		{"2", [][]string{{"SET", "k", "two"}, {"MOVE", "k", "1"}, {"GET", "k"}}, []any{"OK", int64(0), "two"}},
+		{"0", [][]string{{"SWAPDB", "0", "1"}, {"GET", "k"}, {"GET", "shared"}}, []any{"OK", "one", nil}},
+		{"1", [][]string{{"GET", "shared"}, {"SWAPDB", "1", "1"}, {"GET", "shared"}}, []any{"from st", "OK", "from st"}},
+		{"3", [][]string{{"SELECT", "4"}, {"SELECT", "x"}, {"MOVE", "k", "-1"}}, []any{
+			kvclient.Error("ERR DB index is out of range"),
+			kvclient.Error("ERR value is not an integer or out of range"),
+			kvclient.Error("ERR DB index is out of range"),
+		}},
+		{"3", [][]string{{"SWAPDB", "x", "0"}, {"SWAPDB", "0", "x"}, {"SWAPDB", "0", "9"}}, []any{
+			kvclient.Error("ERR invalid first DB index"),
+			kvclient.Error("ERR invalid second DB index"),
+			kvclient.Error("ERR DB index is out of range"),
+		}},
+		{"2", [][]string{{"FLUSHDB", "ASYNC"}, {"DBSIZE"}, {"FLUSHDB", "LATER"}}, []any{"OK", int64(0), kvclient.Error("ERR syntax error")}},
+		{"1", [][]string{{"DBSIZE"}, {"FLUSHALL", "SYNC"}, {"DBSIZE"}}, []any{int64(1), "OK", int64(0)}},
+		{"0", [][]string{{"DBSIZE"}}, []any{int64(0)}},
+	}
+	for i, step := range steps {
+		got := inDB(t, c, step.db, step.cmds...)
+		for j := range got {
+			if err, ok := got[j].(error); ok {
+				got[j] = kvclient.Error(err.Error())
+			}
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("step %d in db %s: %#v, want %#v", i, step.db, got, step.want)
+		}
+		if i == 5 {
+			// SWAPDB swaps contents, so st is still database 0 and now
+			// holds what database 1 had.
+			if v, _ := st.GetHashed(xxhash.Sum64String("k"), "k"); v != "one" {
+				t.Fatalf("st after SWAPDB: k = %q, want one", v)
+			}
+		}
+	}
+}
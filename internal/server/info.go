@@ -0,0 +1,257 @@
+package server
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+)
+
+// serverVersion is reported as redis_version so client libraries enable
+// the feature set they expect from a modern server.
+const serverVersion = "7.2.0"
+
+var defaultInfoSections = []string{"server", "clients", "memory", "persistence", "stats", "keyspace"}
+var allInfoSections = []string{"server", "clients", "memory", "persistence", "stats", "commandstats", "keyspace"}
+
+func (s *server) handleInfo(sess *session) {
+	sections := defaultInfoSections
+	if len(sess.args) > 1 {
+		sections = sections[:0:0]
+		for _, arg := range sess.args[1:] {
+			switch name := strings.ToLower(arg); name {
+			case "all", "everything":
+				sections = append(sections, allInfoSections...)
+			case "default":
+				sections = append(sections, defaultInfoSections...)
+			default:
+				sections = append(sections, name)
+			}
+		}
+	}
+
+	var buf []byte
+	for _, section := range sections {
+		buf = s.appendInfoSection(buf, section)
+	}
+	sess.out = resp.AppendBulkString(sess.out, string(buf))
+}
+
+func (s *server) appendInfoSection(buf []byte, section string) []byte {
+	start := len(buf)
+	if start > 0 {
+		buf = append(buf, "\r\n"...)
+	}
+	switch section {
+	case "server":
+		buf = s.appendServerInfo(buf)
+	case "clients":
+		buf = s.appendClientsInfo(buf)
+	case "memory":
+		buf = s.appendMemoryInfo(buf)
+	case "persistence":
+		buf = s.appendPersistenceInfo(buf)
+	case "stats":
+		buf = s.appendStatsInfo(buf)
+	case "commandstats":
+		buf = s.appendCommandStatsInfo(buf)
+	case "keyspace":
+		buf = s.appendKeyspaceInfo(buf)
+	default:
+		return buf[:start]
+	}
+	return buf
+}
+
+func appendInfoLine(buf []byte, key string, value int64) []byte {
+	buf = append(buf, key...)
+	buf = append(buf, ':')
+	buf = strconv.AppendInt(buf, value, 10)
+	return append(buf, "\r\n"...)
+}
+
+func appendInfoString(buf []byte, key, value string) []byte {
+	buf = append(buf, key...)
+	buf = append(buf, ':')
+	buf = append(buf, value...)
+	return append(buf, "\r\n"...)
+}
+
+func (s *server) appendServerInfo(buf []byte) []byte {
+	uptime := time.Since(s.stats.startedAt)
+	buf = append(buf, "# Server\r\n"...)
+	buf = appendInfoString(buf, "redis_version", serverVersion)
+	buf = appendInfoString(buf, "redis_mode", "standalone")
+	buf = appendInfoString(buf, "os", runtime.GOOS+" "+runtime.GOARCH)
+	buf = appendInfoString(buf, "multiplexing_api", "gnet")
+	buf = appendInfoString(buf, "go_version", runtime.Version())
+	buf = appendInfoLine(buf, "process_id", int64(os.Getpid()))
+	buf = appendInfoString(buf, "tcp_addr", s.addr)
+	buf = appendInfoLine(buf, "uptime_in_seconds", int64(uptime/time.Second))
+	buf = appendInfoLine(buf, "uptime_in_days", int64(uptime/(24*time.Hour)))
+	buf = appendInfoLine(buf, "gomaxprocs", int64(runtime.GOMAXPROCS(0)))
+	buf = appendInfoLine(buf, "event_loops", int64(s.eventLoops()))
+	buf = appendInfoString(buf, "shard_affinity", formatConfigBool(s.cfg.shardAffinity))
+	buf = appendInfoString(buf, "config_file", s.cfg.file)
+	return buf
+}
+
+func (s *server) appendClientsInfo(buf []byte) []byte {
+	paused := int64(0)
+	if s.clients.pauseUntil.Load() > time.Now().UnixNano() {
+		paused = 1
+	}
+	buf = append(buf, "# Clients\r\n"...)
+	buf = appendInfoLine(buf, "connected_clients", int64(s.clients.count()))
+	buf = appendInfoLine(buf, "blocked_clients", 0)
+	buf = appendInfoLine(buf, "paused_clients", paused)
+	return buf
+}
+
+func (s *server) appendMemoryInfo(buf []byte) []byte {
+	ms := readMemStats()
+	shards := s.shardStats()
+	var dataBytes, arenaBytes, arenaFree int64
+	var compactions uint64
+	for _, sh := range shards {
+		dataBytes += sh.Bytes
+		arenaBytes += sh.ArenaBytes
+		arenaFree += sh.ArenaFree
+		compactions += sh.Compactions
+	}
+
+	buf = append(buf, "# Memory\r\n"...)
+	buf = appendInfoLine(buf, "used_memory", int64(ms.heapAlloc))
+	buf = appendInfoString(buf, "used_memory_human", humanBytes(int64(ms.heapAlloc)))
+	buf = appendInfoLine(buf, "used_memory_rss", processRSS(ms.sys))
+	buf = appendInfoLine(buf, "used_memory_dataset", dataBytes)
+	buf = appendInfoLine(buf, "maxmemory", s.maxmemory.Load())
+	buf = appendInfoString(buf, "maxmemory_human", humanBytes(s.maxmemory.Load()))
+	buf = appendInfoString(buf, "maxmemory_policy", "noeviction")
+	buf = appendInfoLine(buf, "go_heap_alloc", int64(ms.heapAlloc))
+	buf = appendInfoLine(buf, "go_heap_inuse", int64(ms.heapInuse))
+	buf = appendInfoLine(buf, "go_heap_objects", int64(ms.heapObjects))
+	buf = appendInfoLine(buf, "go_sys", int64(ms.sys))
+	buf = appendInfoLine(buf, "go_num_gc", int64(ms.numGC))
+	buf = appendInfoLine(buf, "go_gc_pause_total_ns", int64(ms.gcPauseNs))
+	buf = appendInfoString(buf, "mem_allocator", "slab")
+	buf = appendInfoLine(buf, "arena_slab_bytes", arenaBytes)
+	buf = appendInfoLine(buf, "arena_free_bytes", arenaFree)
+	buf = appendInfoLine(buf, "arena_compactions", int64(compactions))
+	for i, sh := range shards {
+		buf = append(buf, "shard"...)
+		buf = strconv.AppendInt(buf, int64(i), 10)
+		buf = append(buf, ":keys="...)
+		buf = strconv.AppendInt(buf, int64(sh.Keys), 10)
+		buf = append(buf, ",bytes="...)
+		buf = strconv.AppendInt(buf, sh.Bytes, 10)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func (s *server) appendPersistenceInfo(buf []byte) []byte {
+	buf = append(buf, "# Persistence\r\n"...)
+	buf = appendInfoLine(buf, "loading", 0)
+	buf = appendInfoLine(buf, "rdb_enabled", 0)
+	buf = appendInfoLine(buf, "rdb_bgsave_in_progress", 0)
+	buf = appendInfoLine(buf, "rdb_last_save_time", 0)
+	buf = appendInfoLine(buf, "aof_enabled", 0)
+	buf = appendInfoLine(buf, "aof_rewrite_in_progress", 0)
+	return buf
+}
+
+func (s *server) appendStatsInfo(buf []byte) []byte {
+	var expired uint64
+	keys, stale := 0, 0
+	for _, sh := range s.shardStats() {
+		expired += sh.Expired
+		keys += sh.Keys
+		stale += sh.ExpiredStale
+	}
+	stalePerc := 0.0
+	if keys > 0 {
+		stalePerc = 100 * float64(stale) / float64(keys)
+	}
+	cycles := s.expireStats()
+	snap := s.stats.snapshot()
+	buf = append(buf, "# Stats\r\n"...)
+	buf = appendInfoLine(buf, "total_connections_received", int64(s.stats.connections.Load()))
+	buf = appendInfoLine(buf, "total_commands_processed", int64(snap.commands))
+	buf = appendInfoLine(buf, "total_net_input_bytes", int64(snap.netIn))
+	buf = appendInfoLine(buf, "total_net_output_bytes", int64(snap.netOut))
+	buf = appendInfoLine(buf, "total_forwarded_commands", int64(snap.forwarded))
+	buf = appendInfoLine(buf, "expired_keys", int64(expired))
+	buf = appendInfoLine(buf, "expired_stale_keys", int64(stale))
+	buf = appendInfoString(buf, "expired_stale_perc", strconv.FormatFloat(stalePerc, 'f', 2, 64))
+	buf = appendInfoLine(buf, "expired_time_cap_reached_count", int64(cycles.TimeCapped))
+	buf = appendInfoLine(buf, "expire_cycles", int64(cycles.Cycles))
+	buf = appendInfoLine(buf, "expire_fast_cycles", int64(cycles.FastCycles))
+	buf = appendInfoLine(buf, "expire_cycle_cpu_milliseconds", int64(cycles.Nanos/1e6))
+	buf = appendInfoLine(buf, "evicted_keys", 0)
+	buf = appendInfoLine(buf, "keyspace_hits", int64(snap.hits))
+	buf = appendInfoLine(buf, "keyspace_misses", int64(snap.misses))
+	return buf
+}
+
+func (s *server) appendCommandStatsInfo(buf []byte) []byte {
+	snap := s.stats.snapshot()
+	buf = append(buf, "# Commandstats\r\n"...)
+	for i := cmdNone + 1; i < cmdCount; i++ {
+		calls := snap.calls[i]
+		if calls == 0 {
+			continue
+		}
+		usec := snap.nanos[i] / 1000
+		buf = append(buf, "cmdstat_"...)
+		buf = append(buf, commandIndex[i].name...)
+		buf = append(buf, ":calls="...)
+		buf = strconv.AppendUint(buf, calls, 10)
+		buf = append(buf, ",usec="...)
+		buf = strconv.AppendUint(buf, usec, 10)
+		buf = append(buf, ",usec_per_call="...)
+		buf = strconv.AppendFloat(buf, float64(usec)/float64(calls), 'f', 2, 64)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func (s *server) appendKeyspaceInfo(buf []byte) []byte {
+	buf = append(buf, "# Keyspace\r\n"...)
+	for i, db := range s.databases() {
+		keys, expires := 0, 0
+		for _, sh := range db.Stats() {
+			keys += sh.Keys
+			expires += sh.Expires
+		}
+		if keys == 0 {
+			continue
+		}
+		buf = append(buf, "db"...)
+		buf = strconv.AppendInt(buf, int64(i), 10)
+		buf = append(buf, ":keys="...)
+		buf = strconv.AppendInt(buf, int64(keys), 10)
+		buf = append(buf, ",expires="...)
+		buf = strconv.AppendInt(buf, int64(expires), 10)
+		buf = append(buf, ",avg_ttl=0\r\n"...)
+	}
+	return buf
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	value := float64(n)
+	suffix := "BKMGTP"
+	i := 0
+	for value >= unit && i < len(suffix)-1 {
+		value /= unit
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + suffix[i:i+1]
+}
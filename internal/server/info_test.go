@@ -0,0 +1,122 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+// infoFields parses an INFO reply into its section headers and fields.
+func infoFields(t *testing.T, reply any) (sections []string, fields map[string]string) {
+	t.Helper()
+	text, ok := reply.(string)
+	if !ok {
+		t.Fatalf("INFO replied %v", reply)
+	}
+	fields = make(map[string]string)
+	for _, line := range strings.Split(text, "\r\n") {
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			sections = append(sections, name)
+		} else if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return sections, fields
+}
+
+func TestInfoSections(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), nil)
+	c := dial(t, addr)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "Server Clients Memory Persistence Stats Keyspace"},
+		{[]string{"default"}, "Server Clients Memory Persistence Stats Keyspace"},
+		{[]string{"all"}, "Server Clients Memory Persistence Stats Commandstats Keyspace"},
+		{[]string{"everything"}, "Server Clients Memory Persistence Stats Commandstats Keyspace"},
+		{[]string{"MEMORY"}, "Memory"},
+		{[]string{"stats", "keyspace"}, "Stats Keyspace"},
+		{[]string{"commandstats"}, "Commandstats"},
+		{[]string{"nosuchsection"}, ""},
+	}
+	for _, tt := range tests {
+		sections, _ := infoFields(t, c.do(append([]string{"INFO"}, tt.args...)...))
+		if got := strings.Join(sections, " "); got != tt.want {
+			t.Errorf("INFO %v: sections %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestInfoFields runs known commands on one connection, so each INFO is
+// served after the loop published the counters of the ones before it.
+func TestInfoFields(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), nil)
+	c := dial(t, addr)
+	c.do("CONFIG", "RESETSTAT")
+	c.do("SET", "a", "1")
+	c.do("SETEX", "b", "100", "2")
+	c.do("SELECT", "2")
+	c.do("SET", "c", "3")
+	c.do("GET", "c")
+	c.do("GET", "missing")
+	c.do("GET", "missing")
+
+	_, fields := infoFields(t, c.do("INFO", "all"))
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"redis_version", serverVersion},
+		{"event_loops", "2"},
+		{"connected_clients", "1"},
+		{"paused_clients", "0"},
+		{"maxmemory_policy", "noeviction"},
+		{"rdb_enabled", "0"},
+		{"keyspace_hits", "1"},
+		{"keyspace_misses", "2"},
+		{"db0", "keys=2,expires=1,avg_ttl=0"},
+		{"db2", "keys=1,expires=0,avg_ttl=0"},
+		{"db1", ""},
+		{"cmdstat_set", "calls=2,"},
+		{"cmdstat_setex", "calls=1,"},
+		{"cmdstat_get", "calls=3,"},
+		{"cmdstat_select", "calls=1,"},
+		{"cmdstat_info", ""},
+	}
+	for _, tt := range tests {
+		got, ok := fields[tt.field]
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("%s: %q, want no field", tt.field, got)
+		case tt.want != "" && !strings.HasPrefix(got, tt.want):
+			t.Errorf("%s: %q, want %q", tt.field, got, tt.want)
+		}
+	}
+	for _, field := range []string{"used_memory", "used_memory_rss", "go_heap_objects", "go_sys", "total_commands_processed", "total_net_input_bytes"} {
+		if n, err := strconv.ParseInt(fields[field], 10, 64); err != nil || n <= 0 {
+			t.Errorf("%s: %q, want a positive number", field, fields[field])
+		}
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.00K"},
+		{1536, "1.50K"},
+		{5 << 20, "5.00M"},
+		{3 << 30, "3.00G"},
+	}
+	for _, tt := range tests {
+		if got := humanBytes(tt.n); got != tt.want {
+			t.Errorf("humanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
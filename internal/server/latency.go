@@ -0,0 +1,205 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+)
+
+const latencyHistoryLen = 160
+
+type latencySample struct {
+	at     int64
+	millis int64
+}
+
+type latencySeries struct {
+	samples []latencySample
+	max     int64
+}
+
+// latencyMonitor mirrors Redis' LATENCY subsystem: every event slower than
+// the threshold is kept as one sample per second, the slowest one winning.
+type latencyMonitor struct {
+	// threshold is in nanoseconds; zero disables monitoring.
+	threshold atomic.Int64
+
+	mu     sync.Mutex
+	events map[string]*latencySeries
+}
+
+func newLatencyMonitor(thresholdMillis int64) *latencyMonitor {
+	m := &latencyMonitor{events: make(map[string]*latencySeries)}
+	m.setThreshold(thresholdMillis)
+	return m
+}
+
+func (m *latencyMonitor) setThreshold(millis int64) {
+	if millis < 0 {
+		millis = 0
+	}
+	m.threshold.Store(millis * int64(time.Millisecond))
+}
+
+func (m *latencyMonitor) observe(event string, elapsed time.Duration) {
+	threshold := m.threshold.Load()
+	if threshold == 0 || int64(elapsed) < threshold {
+		return
+	}
+	m.add(event, elapsed)
+}
+
+func (m *latencyMonitor) add(event string, elapsed time.Duration) {
+	now := time.Now().Unix()
+	millis := int64(elapsed / time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := m.events[event]
+	if series == nil {
+		series = &latencySeries{}
+		m.events[event] = series
+	}
+	if millis > series.max {
+		series.max = millis
+	}
+	if n := len(series.samples); n > 0 && series.samples[n-1].at == now {
+		if millis > series.samples[n-1].millis {
+			series.samples[n-1].millis = millis
+		}
+		return
+	}
+	if len(series.samples) == latencyHistoryLen {
+		copy(series.samples, series.samples[1:])
+		series.samples = series.samples[:latencyHistoryLen-1]
+	}
+	series.samples = append(series.samples, latencySample{at: now, millis: millis})
+}
+
+func (m *latencyMonitor) sortedEvents() []string {
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *server) handleLatency(sess *session) {
+	args := sess.args
+	if len(args) < 2 {
+		sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'LATENCY' command")
+		return
+	}
+	m := s.latency
+	switch sub := args[1]; {
+	case strings.EqualFold(sub, "LATEST"):
+		m.mu.Lock()
+		names := m.sortedEvents()
+		sess.out = resp.AppendArrayHeader(sess.out, len(names))
+		for _, name := range names {
+			series := m.events[name]
+			last := series.samples[len(series.samples)-1]
+			sess.out = resp.AppendArrayHeader(sess.out, 4)
+			sess.out = resp.AppendBulkString(sess.out, name)
+			sess.out = resp.AppendInt(sess.out, last.at)
+			sess.out = resp.AppendInt(sess.out, last.millis)
+			sess.out = resp.AppendInt(sess.out, series.max)
+		}
+		m.mu.Unlock()
+	case strings.EqualFold(sub, "HISTORY"):
+		if len(args) != 3 {
+			sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'LATENCY|HISTORY' command")
+			return
+		}
+		m.mu.Lock()
+		series := m.events[strings.ToLower(args[2])]
+		if series == nil {
+			sess.out = resp.AppendArrayHeader(sess.out, 0)
+		} else {
+			sess.out = resp.AppendArrayHeader(sess.out, len(series.samples))
+			for _, sample := range series.samples {
+				sess.out = resp.AppendArrayHeader(sess.out, 2)
+				sess.out = resp.AppendInt(sess.out, sample.at)
+				sess.out = resp.AppendInt(sess.out, sample.millis)
+			}
+		}
+		m.mu.Unlock()
+	case strings.EqualFold(sub, "RESET"):
+		m.mu.Lock()
+		reset := 0
+		if len(args) == 2 {
+			reset = len(m.events)
+			m.events = make(map[string]*latencySeries)
+		} else {
+			for _, name := range args[2:] {
+				name = strings.ToLower(name)
+				if _, ok := m.events[name]; ok {
+					delete(m.events, name)
+					reset++
+				}
+			}
+		}
+		m.mu.Unlock()
+		sess.out = resp.AppendInt(sess.out, int64(reset))
+	case strings.EqualFold(sub, "DOCTOR"):
+		sess.out = resp.AppendBulkString(sess.out, s.latencyDoctor())
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try LATENCY HELP.")
+	}
+}
+
+var latencyAdvice = map[string]string{
+	"command":         "Slow commands: check SLOWLOG GET for the offending calls and avoid large multi-key operations.",
+	"eventloop-batch": "Large pipelined batches: a single OnTraffic pass is processing too much input; consider smaller client pipelines.",
+	"janitor-cycle":   "Expire cycles are slow: many keys expire at once; spread TTLs so the janitor is not behind (see expired_stale_keys).",
+	"shard-lock":      "A shard lock was held for a long time by the janitor; other commands on that shard were stalled.",
+	"shard-compact":   "Arena compaction copied a large shard under its lock; heavy overwrite or delete churn fragments the slabs.",
+}
+
+func (s *server) latencyDoctor() string {
+	m := s.latency
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	threshold := m.threshold.Load()
+	if threshold == 0 {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this instance. " +
+			"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+	}
+	if len(m.events) == 0 {
+		return "I have no latency reports to show you at this time. Latency spikes above " +
+			strconv.FormatInt(threshold/int64(time.Millisecond), 10) + " milliseconds are being tracked.\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("Latency spikes detected for the following events:\n\n")
+	for i, name := range m.sortedEvents() {
+		series := m.events[name]
+		var sum int64
+		for _, sample := range series.samples {
+			sum += sample.millis
+		}
+		b.WriteString(strconv.Itoa(i + 1))
+		b.WriteString(". ")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strconv.Itoa(len(series.samples)))
+		b.WriteString(" latency spikes (average ")
+		b.WriteString(strconv.FormatInt(sum/int64(len(series.samples)), 10))
+		b.WriteString("ms, worst ")
+		b.WriteString(strconv.FormatInt(series.max, 10))
+		b.WriteString("ms).\n")
+		if advice, ok := latencyAdvice[name]; ok {
+			b.WriteString("   ")
+			b.WriteString(advice)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+)
+
+// runHandler runs handler for args on a fresh session and parses its reply.
+func runHandler(t *testing.T, handler func(*session), args ...string) any {
+	t.Helper()
+	sess := &session{cl: &client{}}
+	sess.args = args
+	handler(sess)
+	v, err := kvclient.NewReader(bytes.NewReader(sess.out)).Read()
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return v
+}
+
+func TestLatency(t *testing.T) {
+	s := newTestServer(t)
+	m := s.latency
+	if got := runHandler(t, s.handleLatency, "LATENCY", "DOCTOR").(string); !strings.Contains(got, "monitoring is disabled") {
+		t.Errorf("DOCTOR while disabled: %q", got)
+	}
+	m.observe("command", time.Hour)
+	if got := runHandler(t, s.handleLatency, "LATENCY", "LATEST"); len(got.([]any)) != 0 {
+		t.Fatalf("threshold 0 recorded %v", got)
+	}
+
+	m.setThreshold(5)
+	start := time.Now().Unix()
+	m.observe("command", 2*time.Millisecond)
+	m.observe("command", 7*time.Millisecond)
+	m.observe("command", 12*time.Millisecond)
+	m.observe("command", 9*time.Millisecond)
+	m.observe("shard-lock", 6*time.Millisecond)
+	sameSecond := time.Now().Unix() == start
+
+	latest := runHandler(t, s.handleLatency, "LATENCY", "LATEST").([]any)
+	if len(latest) != 2 {
+		t.Fatalf("LATEST: %v, want two events", latest)
+	}
+	tests := []struct {
+		event     string
+		last, max int64
+		oneSample bool
+	}{
+		{"command", 12, 12, sameSecond},
+		{"shard-lock", 6, 6, true},
+	}
+	for i, tt := range tests {
+		ev := latest[i].([]any)
+		if ev[0] != tt.event || ev[2] != tt.last && tt.oneSample || ev[3] != tt.max {
+			t.Errorf("LATEST %s: %v, want last %d, max %d", tt.event, ev, tt.last, tt.max)
+		}
+		history := runHandler(t, s.handleLatency, "LATENCY", "HISTORY", strings.ToUpper(tt.event)).([]any)
+		if tt.oneSample && len(history) != 1 {
+			t.Errorf("HISTORY %s: %v, want spikes of one second merged", tt.event, history)
+		}
+	}
+	if got := runHandler(t, s.handleLatency, "LATENCY", "DOCTOR").(string); !strings.Contains(got, "2. shard-lock: 1 latency spikes (average 6ms, worst 6ms).") {
+		t.Errorf("DOCTOR: %q", got)
+	}
+
+	resets := []struct {
+		args []string
+		want int64
+	}{
+		{[]string{"LATENCY", "RESET", "nosuch"}, 0},
+		{[]string{"LATENCY", "RESET", "SHARD-LOCK"}, 1},
+		{[]string{"LATENCY", "RESET"}, 1},
+		{[]string{"LATENCY", "RESET"}, 0},
+	}
+	for _, tt := range resets {
+		if got := runHandler(t, s.handleLatency, tt.args...); got != tt.want {
+			t.Errorf("%v: %v, want %d", tt.args, got, tt.want)
+		}
+	}
+	if got := runHandler(t, s.handleLatency, "LATENCY", "HISTORY", "command"); len(got.([]any)) != 0 {
+		t.Errorf("HISTORY after RESET: %v", got)
+	}
+}
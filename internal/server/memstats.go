@@ -0,0 +1,54 @@
+package server
+
+import (
+	"runtime"
+	"runtime/metrics"
+)
+
+// memStats holds the runtime figures INFO memory and /metrics report.
+// They come from runtime/metrics, which unlike runtime.ReadMemStats does
+// not stop the world, so scrapes and INFO in a loop cost the event loops
+// nothing.
+type memStats struct {
+	heapAlloc   uint64
+	heapInuse   uint64
+	heapObjects uint64
+	sys         uint64
+	numGC       uint64
+	gcPauseNs   uint64
+}
+
+var memStatNames = [...]string{
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/heap/unused:bytes",
+	"/gc/heap/objects:objects",
+	"/memory/classes/total:bytes",
+	"/gc/cycles/total:gc-cycles",
+	"/cpu/classes/gc/pause:cpu-seconds",
+}
+
+func readMemStats() memStats {
+	var samples [len(memStatNames)]metrics.Sample
+	for i, name := range memStatNames {
+		samples[i].Name = name
+	}
+	metrics.Read(samples[:])
+	v := func(i int) uint64 {
+		if samples[i].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return samples[i].Value.Uint64()
+	}
+	ms := memStats{
+		heapAlloc:   v(0),
+		heapInuse:   v(0) + v(1),
+		heapObjects: v(2),
+		sys:         v(3),
+		numGC:       v(4),
+	}
+	// The runtime counts pause CPU time as GOMAXPROCS times the pause.
+	if s := samples[5].Value; s.Kind() == metrics.KindFloat64 {
+		ms.gcPauseNs = uint64(s.Float64() * 1e9 / float64(runtime.GOMAXPROCS(0)))
+	}
+	return ms
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// metricsHandler renders the Prometheus text exposition format by hand;
+// everything it reads is already aggregated per event loop or per shard.
+func (s *server) metricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(s.appendMetrics(make([]byte, 0, 64*1024)))
+}
+
+func (s *server) appendMetrics(buf []byte) []byte {
+	snap := s.stats.snapshot()
+	shards := s.shardStats()
+
+	buf = appendMetricHeader(buf, "kv_uptime_seconds", "gauge", "Seconds since the server started.")
+	buf = appendMetricFloat(buf, "kv_uptime_seconds", "", time.Since(s.stats.startedAt).Seconds())
+
+	buf = appendMetricHeader(buf, "kv_connected_clients", "gauge", "Currently open client connections.")
+	buf = appendMetricUint(buf, "kv_connected_clients", "", uint64(s.clients.count()))
+	buf = appendMetricHeader(buf, "kv_connections_received_total", "counter", "Accepted client connections.")
+	buf = appendMetricUint(buf, "kv_connections_received_total", "", s.stats.connections.Load())
+
+	buf = appendMetricHeader(buf, "kv_net_input_bytes_total", "counter", "Bytes of commands consumed from clients.")
+	buf = appendMetricUint(buf, "kv_net_input_bytes_total", "", snap.netIn)
+	buf = appendMetricHeader(buf, "kv_net_output_bytes_total", "counter", "Bytes of replies written to clients.")
+	buf = appendMetricUint(buf, "kv_net_output_bytes_total", "", snap.netOut)
+	buf = appendMetricHeader(buf, "kv_forwarded_commands_total", "counter", "Commands handed to the event loop owning their shard.")
+	buf = appendMetricUint(buf, "kv_forwarded_commands_total", "", snap.forwarded)
+
+	buf = appendMetricHeader(buf, "kv_keyspace_hits_total", "counter", "Successful key lookups.")
+	buf = appendMetricUint(buf, "kv_keyspace_hits_total", "", snap.hits)
+	buf = appendMetricHeader(buf, "kv_keyspace_misses_total", "counter", "Failed key lookups.")
+	buf = appendMetricUint(buf, "kv_keyspace_misses_total", "", snap.misses)
+
+	buf = appendMetricHeader(buf, "kv_commands_total", "counter", "Processed commands by name.")
+	for i := cmdNone + 1; i < cmdCount; i++ {
+		if snap.calls[i] != 0 {
+			buf = appendMetricUint(buf, "kv_commands_total", `cmd="`+commandIndex[i].name+`"`, snap.calls[i])
+		}
+	}
+
+	buf = appendMetricHeader(buf, "kv_command_duration_seconds", "histogram", "Command execution latency.")
+	for i := cmdNone + 1; i < cmdCount; i++ {
+		if snap.calls[i] == 0 {
+			continue
+		}
+		label := `cmd="` + commandIndex[i].name + `"`
+		var cumulative uint64
+		for b := 0; b < latencyBuckets; b++ {
+			cumulative += snap.buckets[i][b]
+			le := "+Inf"
+			if b < latencyBuckets-1 {
+				le = strconv.FormatFloat(float64(uint64(1)<<b)/1e6, 'g', -1, 64)
+			}
+			buf = appendMetricUint(buf, "kv_command_duration_seconds_bucket", label+`,le="`+le+`"`, cumulative)
+		}
+		buf = appendMetricFloat(buf, "kv_command_duration_seconds_sum", label, float64(snap.nanos[i])/1e9)
+		// The histogram counts sampled timings by weight, so its count
+		// is the +Inf bucket rather than the exact kv_commands_total.
+		buf = appendMetricUint(buf, "kv_command_duration_seconds_count", label, cumulative)
+	}
+
+	var expired, stale, janitorRuns, janitorNanos, lockWaits, lockWaitNanos, readFallbacks uint64
+	buf = appendMetricHeader(buf, "kv_keys", "gauge", "Keys stored per shard.")
+	for i, sh := range shards {
+		buf = appendMetricUint(buf, "kv_keys", `shard="`+strconv.Itoa(i)+`"`, uint64(sh.Keys))
+		expired += sh.Expired
+		stale += uint64(sh.ExpiredStale)
+		janitorRuns += sh.JanitorRuns
+		janitorNanos += sh.JanitorNanos
+		lockWaits += sh.LockWaits
+		lockWaitNanos += sh.LockWaitNanos
+		readFallbacks += sh.ReadFallbacks
+	}
+	buf = appendMetricHeader(buf, "kv_keys_bytes", "gauge", "Approximate bytes used by keys and values per shard.")
+	for i, sh := range shards {
+		buf = appendMetricUint(buf, "kv_keys_bytes", `shard="`+strconv.Itoa(i)+`"`, uint64(sh.Bytes))
+	}
+
+	var arenaBytes, arenaFree int64
+	var compactions uint64
+	for _, sh := range shards {
+		arenaBytes += sh.ArenaBytes
+		arenaFree += sh.ArenaFree
+		compactions += sh.Compactions
+	}
+	buf = appendMetricHeader(buf, "kv_arena_slab_bytes", "gauge", "Slab memory holding keys and values.")
+	buf = appendMetricUint(buf, "kv_arena_slab_bytes", "", uint64(arenaBytes))
+	buf = appendMetricHeader(buf, "kv_arena_free_bytes", "gauge", "Slab memory freed and waiting for reuse or compaction.")
+	buf = appendMetricUint(buf, "kv_arena_free_bytes", "", uint64(arenaFree))
+	buf = appendMetricHeader(buf, "kv_arena_compactions_total", "counter", "Shard arena compactions.")
+	buf = appendMetricUint(buf, "kv_arena_compactions_total", "", compactions)
+
+	buf = appendMetricHeader(buf, "kv_expired_keys_total", "counter", "Keys removed because their TTL elapsed.")
+	buf = appendMetricUint(buf, "kv_expired_keys_total", "", expired)
+	buf = appendMetricHeader(buf, "kv_expired_stale_keys", "gauge", "Keys past their TTL not reclaimed yet.")
+	buf = appendMetricUint(buf, "kv_expired_stale_keys", "", stale)
+	cycles := s.expireStats()
+	buf = appendMetricHeader(buf, "kv_expire_cycles_total", "counter", "Active expire cycles by kind.")
+	buf = appendMetricUint(buf, "kv_expire_cycles_total", `kind="slow"`, cycles.Cycles-cycles.FastCycles)
+	buf = appendMetricUint(buf, "kv_expire_cycles_total", `kind="fast"`, cycles.FastCycles)
+	buf = appendMetricHeader(buf, "kv_expire_cycle_time_capped_total", "counter", "Expire cycles that ran out of time with keys still due.")
+	buf = appendMetricUint(buf, "kv_expire_cycle_time_capped_total", "", cycles.TimeCapped)
+	buf = appendMetricHeader(buf, "kv_expire_cycle_seconds_total", "counter", "Time spent in active expire cycles.")
+	buf = appendMetricFloat(buf, "kv_expire_cycle_seconds_total", "", float64(cycles.Nanos)/1e9)
+
+	buf = appendMetricHeader(buf, "kv_janitor_runs_total", "counter", "Shard lock acquisitions by the janitor to remove due keys.")
+	buf = appendMetricUint(buf, "kv_janitor_runs_total", "", janitorRuns)
+	buf = appendMetricHeader(buf, "kv_janitor_scan_seconds_total", "counter", "Time the janitor held shard locks removing due keys.")
+	buf = appendMetricFloat(buf, "kv_janitor_scan_seconds_total", "", float64(janitorNanos)/1e9)
+
+	buf = appendMetricHeader(buf, "kv_shard_lock_waits_total", "counter", "Shard lock acquisitions that had to wait.")
+	buf = appendMetricUint(buf, "kv_shard_lock_waits_total", "", lockWaits)
+	buf = appendMetricHeader(buf, "kv_shard_lock_wait_seconds_total", "counter", "Time spent waiting for contended shard locks.")
+	buf = appendMetricFloat(buf, "kv_shard_lock_wait_seconds_total", "", float64(lockWaitNanos)/1e9)
+	buf = appendMetricHeader(buf, "kv_shard_read_fallbacks_total", "counter", "Lock-free reads that gave up on concurrent writes and took the read lock.")
+	buf = appendMetricUint(buf, "kv_shard_read_fallbacks_total", "", readFallbacks)
+
+	ms := readMemStats()
+	buf = appendMetricHeader(buf, "go_goroutines", "gauge", "Number of goroutines.")
+	buf = appendMetricUint(buf, "go_goroutines", "", uint64(runtime.NumGoroutine()))
+	buf = appendMetricHeader(buf, "go_gc_cycles_total", "counter", "Completed GC cycles.")
+	buf = appendMetricUint(buf, "go_gc_cycles_total", "", uint64(ms.numGC))
+	buf = appendMetricHeader(buf, "go_gc_pause_seconds_total", "counter", "Total stop-the-world GC pause time.")
+	buf = appendMetricFloat(buf, "go_gc_pause_seconds_total", "", float64(ms.gcPauseNs)/1e9)
+	buf = appendMetricHeader(buf, "go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	buf = appendMetricUint(buf, "go_memstats_heap_alloc_bytes", "", ms.heapAlloc)
+	buf = appendMetricHeader(buf, "go_memstats_heap_objects", "gauge", "Number of allocated heap objects.")
+	buf = appendMetricUint(buf, "go_memstats_heap_objects", "", ms.heapObjects)
+	buf = appendMetricHeader(buf, "go_memstats_sys_bytes", "gauge", "Bytes obtained from the OS.")
+	buf = appendMetricUint(buf, "go_memstats_sys_bytes", "", ms.sys)
+	buf = appendMetricHeader(buf, "process_resident_memory_bytes", "gauge", "Resident set size.")
+	buf = appendMetricUint(buf, "process_resident_memory_bytes", "", uint64(processRSS(ms.sys)))
+	return buf
+}
+
+func appendMetricHeader(buf []byte, name, typ, help string) []byte {
+	buf = append(buf, "# HELP "...)
+	buf = append(buf, name...)
+	buf = append(buf, ' ')
+	buf = append(buf, help...)
+	buf = append(buf, "\n# TYPE "...)
+	buf = append(buf, name...)
+	buf = append(buf, ' ')
+	buf = append(buf, typ...)
+	return append(buf, '\n')
+}
+
+func appendMetricName(buf []byte, name, labels string) []byte {
+	buf = append(buf, name...)
+	if labels != "" {
+		buf = append(buf, '{')
+		buf = append(buf, labels...)
+		buf = append(buf, '}')
+	}
+	return append(buf, ' ')
+}
+
+func appendMetricUint(buf []byte, name, labels string, v uint64) []byte {
+	buf = appendMetricName(buf, name, labels)
+	buf = strconv.AppendUint(buf, v, 10)
+	return append(buf, '\n')
+}
+
+func appendMetricFloat(buf []byte, name, labels string, v float64) []byte {
+	buf = appendMetricName(buf, name, labels)
+	buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+	return append(buf, '\n')
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// TestMetrics records known timings on an event loop's counters, publishes
+// them and checks the exposition /metrics serves for them.
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	s.db(0).SetHashed(xxhash.Sum64String("k"), "k", "value")
+	ls := s.stats.loopFor(nil)
+	set, get := lookupCommand("set").id, lookupCommand("get").id
+	// 32 SETs of which two were timed, at 3µs and 100µs, standing for
+	// timingSample runs each; one GET hit timed at 1µs.
+	for range 30 {
+		ls.local.count(set)
+	}
+	ls.local.record(set, 3*time.Microsecond, timingSample)
+	ls.local.record(set, 100*time.Microsecond, timingSample)
+	ls.local.record(get, time.Microsecond, 1)
+	ls.local.hits++
+	ls.local.netIn += 1000
+	ls.publish()
+
+	rec := httptest.NewRecorder()
+	s.metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	samples := make(map[string]string)
+	typed := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			typed[strings.Fields(rest)[0]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		series, value, ok := strings.Cut(line, " ")
+		if _, err := strconv.ParseFloat(value, 64); !ok || err != nil {
+			t.Errorf("malformed sample %q", line)
+			continue
+		}
+		samples[series] = value
+		name, _, _ := strings.Cut(series, "{")
+		family := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if !typed[name] && !typed[family] {
+			t.Errorf("%s has no TYPE line before it", name)
+		}
+	}
+
+	tests := []struct {
+		series string
+		want   string
+	}{
+		{`kv_commands_total{cmd="set"}`, "32"},
+		{`kv_commands_total{cmd="get"}`, "1"},
+		{`kv_commands_total{cmd="del"}`, ""},
+		{`kv_keyspace_hits_total`, "1"},
+		{`kv_net_input_bytes_total`, "1000"},
+		{`kv_command_duration_seconds_bucket{cmd="set",le="2e-06"}`, "0"},
+		{`kv_command_duration_seconds_bucket{cmd="set",le="4e-06"}`, "16"},
+		{`kv_command_duration_seconds_bucket{cmd="set",le="0.000128"}`, "32"},
+		{`kv_command_duration_seconds_bucket{cmd="set",le="+Inf"}`, "32"},
+		{`kv_command_duration_seconds_count{cmd="set"}`, "32"},
+		{`kv_command_duration_seconds_sum{cmd="set"}`, "0.001648"},
+		{`kv_command_duration_seconds_bucket{cmd="get",le="1e-06"}`, "0"},
+		{`kv_command_duration_seconds_bucket{cmd="get",le="2e-06"}`, "1"},
+		{`kv_command_duration_seconds_count{cmd="get"}`, "1"},
+		{`kv_keys{shard="` + strconv.FormatUint(xxhash.Sum64String("k")%storage.ShardCount, 10) + `"}`, "1"},
+		{`kv_connected_clients`, "0"},
+	}
+	for _, tt := range tests {
+		got, ok := samples[tt.series]
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("%s = %s, want no sample", tt.series, got)
+		case tt.want != "" && got != tt.want:
+			t.Errorf("%s = %q, want %s", tt.series, got, tt.want)
+		}
+	}
+	for _, series := range []string{"go_memstats_heap_alloc_bytes", "go_memstats_sys_bytes", "process_resident_memory_bytes", "go_goroutines"} {
+		if v, _ := strconv.ParseFloat(samples[series], 64); v <= 0 {
+			t.Errorf("%s = %q, want a positive value", series, samples[series])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// monitorMaxPending bounds the bytes queued towards one MONITOR client;
+// a monitor that cannot keep up is disconnected instead of buffering.
+const monitorMaxPending = 8 << 20
+
+type monitor struct {
+	conn    gnet.Conn
+	cl      *client
+	pending atomic.Int64
+	dropped atomic.Bool
+}
+
+type monitorHub struct {
+	// active lets the command path skip all MONITOR work with one load.
+	active atomic.Int32
+
+	mu       sync.RWMutex
+	monitors []*monitor
+}
+
+func (h *monitorHub) add(c gnet.Conn, cl *client) {
+	h.mu.Lock()
+	h.monitors = append(h.monitors, &monitor{conn: c, cl: cl})
+	h.active.Store(int32(len(h.monitors)))
+	h.mu.Unlock()
+}
+
+func (h *monitorHub) remove(cl *client) {
+	h.mu.Lock()
+	for i, m := range h.monitors {
+		if m.cl == cl {
+			h.monitors = append(h.monitors[:i], h.monitors[i+1:]...)
+			break
+		}
+	}
+	h.active.Store(int32(len(h.monitors)))
+	h.mu.Unlock()
+}
+
+// broadcast hands one batch of formatted lines to every monitor. buf must
+// not be reused by the caller: it is written asynchronously by the
+// monitors' own event loops.
+func (h *monitorHub) broadcast(buf []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, m := range h.monitors {
+		if m.dropped.Load() {
+			continue
+		}
+		if m.pending.Add(int64(len(buf))) > monitorMaxPending {
+			m.dropped.Store(true)
+			_ = m.conn.Close()
+			continue
+		}
+		size := int64(len(buf))
+		mon := m
+		err := m.conn.AsyncWrite(buf, func(gnet.Conn, error) error {
+			mon.pending.Add(-size)
+			return nil
+		})
+		if err != nil {
+			m.pending.Add(-size)
+		}
+	}
+}
+
+func appendMonitorLine(buf []byte, db int, addr string, args []string) []byte {
+	now := time.Now()
+	buf = append(buf, '+')
+	buf = strconv.AppendInt(buf, now.Unix(), 10)
+	buf = append(buf, '.')
+	usec := now.Nanosecond() / 1000
+	for div := 100000; div > 1 && usec < div; div /= 10 {
+		buf = append(buf, '0')
+	}
+	buf = strconv.AppendInt(buf, int64(usec), 10)
+	buf = append(buf, " ["...)
+	buf = strconv.AppendInt(buf, int64(db), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, addr...)
+	buf = append(buf, ']')
+	for _, arg := range args {
+		buf = append(buf, ' ')
+		buf = appendQuoted(buf, arg)
+	}
+	return append(buf, '\r', '\n')
+}
+
+// appendQuoted escapes s the way Redis' sdscatrepr does.
+func appendQuoted(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\', '"':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\a':
+			buf = append(buf, '\\', 'a')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		default:
+			if c < 0x20 || c > 0x7e {
+				buf = append(buf, '\\', 'x', hex[c>>4], hex[c&0xf])
+			} else {
+				buf = append(buf, c)
+			}
+		}
+	}
+	return append(buf, '"')
+}
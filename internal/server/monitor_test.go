@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// monitorConn is a MONITOR connection whose writes complete only when
+// drained, like the socket of a client that stopped reading.
+type monitorConn struct {
+	gnet.Conn
+	queued  []gnet.AsyncCallback
+	written int
+	closed  int
+}
+
+func (c *monitorConn) AsyncWrite(buf []byte, cb gnet.AsyncCallback) error {
+	c.written += len(buf)
+	c.queued = append(c.queued, cb)
+	return nil
+}
+
+func (c *monitorConn) Close() error {
+	c.closed++
+	return nil
+}
+
+func (c *monitorConn) drain() {
+	for _, cb := range c.queued {
+		_ = cb(c, nil)
+	}
+	c.queued = nil
+}
+
+// TestMonitorSlowConsumer broadcasts to a monitor that keeps reading and
+// one that stopped: only the latter is dropped, once, past the limit.
+func TestMonitorSlowConsumer(t *testing.T) {
+	var h monitorHub
+	fast, slow := &monitorConn{}, &monitorConn{}
+	h.add(fast, &client{})
+	h.add(slow, &client{})
+	batch := make([]byte, 1<<20)
+	const batches = monitorMaxPending/(1<<20) + 3
+	for i := range batches {
+		h.broadcast(batch)
+		fast.drain()
+		if want := i >= monitorMaxPending/(1<<20); slow.closed != 0 != want {
+			t.Fatalf("batch %d: slow monitor closed %d times", i, slow.closed)
+		}
+	}
+	if slow.closed != 1 || slow.written != monitorMaxPending {
+		t.Errorf("slow monitor: closed %d times after %d bytes, want once after %d", slow.closed, slow.written, monitorMaxPending)
+	}
+	if fast.closed != 0 || fast.written != batches*len(batch) {
+		t.Errorf("fast monitor: closed %d times, %d bytes written", fast.closed, fast.written)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), nil)
+	mon, c := dial(t, addr), dial(t, addr)
+	if got := mon.do("MONITOR"); got != "OK" {
+		t.Fatalf("MONITOR: %v", got)
+	}
+	c.do("SELECT", "3")
+	c.do("SET", "k", "a \"b\"\n\x01")
+	for _, want := range []string{
+		`"SELECT" "3"`,
+		`[3 ` + c.nc.LocalAddr().String() + `] "SET" "k" "a \"b\"\n\x01"`,
+	} {
+		line, err := mon.read()
+		if err != nil || !strings.HasSuffix(line.(string), want) {
+			t.Errorf("monitor read %q, %v, want a line ending %s", line, err, want)
+		}
+	}
+}
+
+func TestAppendQuoted(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", `""`},
+		{"plain", `"plain"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"\r\n\t\a\b", `"\r\n\t\a\b"`},
+		{"\x00\x7f\xff", `"\x00\x7f\xff"`},
+	}
+	for _, tt := range tests {
+		if got := string(appendQuoted(nil, tt.in)); got != tt.want {
+			t.Errorf("appendQuoted(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
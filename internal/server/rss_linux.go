@@ -0,0 +1,26 @@
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// processRSS returns the resident set size, or sys where it is unknown.
+func processRSS(sys uint64) int64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return int64(sys)
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return int64(sys)
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return int64(sys)
+	}
+	return pages * int64(os.Getpagesize())
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package server
+
+// processRSS returns the resident set size, or sys where it is unknown.
+func processRSS(sys uint64) int64 {
+	return int64(sys)
+}
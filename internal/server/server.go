@@ -0,0 +1,436 @@
+// Package server implements the RESP server behind cmd/gnet.
+package server
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	maxResponsesBeforeFlush = 4096
+	maxBytesBeforeFlush     = 64 * 1024
+)
+
+type session struct {
+	args        []string
+	out         []byte
+	responses   int
+	shouldClose bool
+
+	conn       gnet.Conn
+	cl         *client
+	db         int
+	cmd        int
+	lastLookup *command
+	// stats are the counters of the connection's event loop, which it
+	// shares with the loop's other connections.
+	stats       *loopCounters
+	loop        *loopStats
+	replyMode   int
+	monitoring  bool
+	monitorBuf  []byte
+	wakePending atomic.Bool
+	// round is set while replies wait for commands forwarded to other
+	// event loops; roundOut is the spare buffer they are assembled in.
+	round    *round
+	roundOut []byte
+	batch    pipelineBatch
+}
+
+type server struct {
+	gnet.BuiltinEventEngine
+	dbs        atomic.Pointer[[]storage.Storage]
+	numDBs     int
+	cfg        serverConfig
+	defaultTTL atomic.Int64
+	gogc       atomic.Int64
+	maxmemory  atomic.Int64
+	// pipelineBatch is the most commands run as one batch, 0 for none.
+	pipelineBatch atomic.Int64
+	oom           atomic.Bool
+	addr          string
+	eng           gnet.Engine
+	closing       atomic.Bool
+	shutdown      shutdownState
+	onBoot        func()
+	stopped       chan struct{}
+
+	shutdownTimeout atomic.Int64
+	clients         *clientRegistry
+	stats           serverStats
+	slowlog         *slowlog
+	latency         *latencyMonitor
+	monitors        monitorHub
+	// owners is set in shard-affinity mode.
+	owners *shardOwners
+}
+
+func newServer() *server {
+	return &server{
+		clients: newClientRegistry(),
+		slowlog: newSlowlog(0, 0),
+		latency: newLatencyMonitor(0),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Main runs the standalone server configured from flags, KV_* environment
+// variables and the -config file. It does not return.
+func Main() {
+	configFile := flag.String("config", "", "config file to load and to write with CONFIG REWRITE")
+	flags := registerConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	srv := newServer()
+	if err := srv.loadConfig(*configFile, flags); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	if srv.cfg.gcReset {
+		runtime.GC()
+		debug.FreeOSMemory()
+	}
+	srv.openDatabases(srv.numDBs, nil)
+	srv.stats.startedAt = time.Now()
+	go srv.watchMemory()
+	go srv.watchSignals()
+
+	if srv.cfg.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", srv.metricsHandler)
+		go func() {
+			log.Printf("Starting metrics server on %s", srv.cfg.metricsAddr)
+			if err := http.ListenAndServe(srv.cfg.metricsAddr, mux); err != nil {
+				log.Printf("Warning: metrics server failed to start: %v", err)
+			}
+		}()
+	}
+
+	if srv.cfg.pprofAddr != "" {
+		// The pprof handlers are on http.DefaultServeMux, registered by
+		// cmd/gnet's net/http/pprof import; /metrics is served beside them
+		// without adding to the global mux.
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", srv.metricsHandler)
+		mux.Handle("/", http.DefaultServeMux)
+		go func() {
+			log.Printf("Starting pprof server on %s", srv.cfg.pprofAddr)
+			if err := http.ListenAndServe(srv.cfg.pprofAddr, mux); err != nil {
+				log.Printf("Warning: pprof server failed to start: %v", err)
+			}
+		}()
+	}
+
+	if err := srv.run(); err != nil {
+		log.Fatalf("gnet run failed: %v", err)
+	}
+	code := srv.exitCode()
+	log.Printf("server stopped, exit status %d", code)
+	os.Exit(code)
+}
+
+// Serve runs a server until ctx is done, with database 0 backed by st so
+// that in-process users of st and RESP clients share data. config
+// overrides parameters the way command-line flags do; the environment and
+// config files are not consulted. started, if not nil, is called once the
+// listener is up. HTTP endpoints and signal handling are left to the
+// embedding program.
+func Serve(ctx context.Context, st storage.Storage, config map[string]string, started func()) error {
+	srv := newServer()
+	srv.cfg.embedded = true
+	srv.onBoot = started
+	if err := srv.loadConfig("", config); err != nil {
+		return err
+	}
+	srv.openDatabases(srv.numDBs, &st)
+	srv.stats.startedAt = time.Now()
+	go srv.watchMemory()
+	go func() {
+		select {
+		case <-ctx.Done():
+			srv.beginShutdown("context done", 0, nil, nil)
+		case <-srv.stopped:
+		}
+	}()
+	return srv.run()
+}
+
+// eventLoops is the number of gnet event loops the server runs.
+func (s *server) eventLoops() int {
+	if s.cfg.eventLoops > 0 {
+		return s.cfg.eventLoops
+	}
+	return runtime.NumCPU()
+}
+
+func (s *server) run() error {
+	defer close(s.stopped)
+	loops := s.eventLoops()
+	if s.cfg.shardAffinity {
+		s.owners = newShardOwners(loops)
+	}
+	return gnet.Run(s, s.addr, gnet.WithMulticore(true), gnet.WithNumEventLoop(loops), gnet.WithReuseAddr(true))
+}
+
+func (s *server) OnBoot(eng gnet.Engine) gnet.Action {
+	s.eng = eng
+	if s.onBoot != nil {
+		s.onBoot()
+	}
+	return gnet.None
+}
+
+func (s *server) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
+	if s.closing.Load() {
+		return nil, gnet.Close
+	}
+	loop := s.stats.loopFor(c.EventLoop())
+	c.SetContext(&session{
+		args:  make([]string, 0, 64),
+		out:   make([]byte, 0, 64*1024),
+		conn:  c,
+		cl:    s.clients.register(c),
+		stats: &loop.local,
+		loop:  loop,
+	})
+	s.stats.connections.Add(1)
+	if s.owners != nil {
+		s.owners.register(s, c.EventLoop())
+	}
+	return nil, gnet.None
+}
+
+func (s *server) OnClose(c gnet.Conn, err error) gnet.Action {
+	if sess, ok := c.Context().(*session); ok {
+		s.clients.unregister(sess.cl)
+		sess.abandonRound()
+		if sess.monitoring {
+			s.monitors.remove(sess.cl)
+		}
+	}
+	return gnet.None
+}
+
+func (sess *session) flush(c gnet.Conn) {
+	if len(sess.out) > 0 {
+		_, _ = c.Write(sess.out)
+		sess.stats.netOut += uint64(len(sess.out))
+		sess.out = sess.out[:0]
+		sess.responses = 0
+	}
+}
+
+// waitUnpause re-triggers OnTraffic once CLIENT PAUSE expires; the
+// pending input stays in the inbound buffer until then.
+func (sess *session) waitUnpause(c gnet.Conn, wait time.Duration) {
+	if !sess.wakePending.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(wait, func() {
+		sess.wakePending.Store(false)
+		_ = c.Wake(nil)
+	})
+}
+
+func (s *server) OnTraffic(c gnet.Conn) gnet.Action {
+	sess := c.Context().(*session)
+	batchStart := time.Now()
+	defer func() {
+		sess.cl.touch(sess, c.InboundBuffered())
+		sess.loop.publish()
+		if len(sess.monitorBuf) > 0 {
+			s.monitors.broadcast(sess.monitorBuf)
+			sess.monitorBuf = nil
+		}
+		s.latency.observe("eventloop-batch", time.Since(batchStart))
+	}()
+
+	if sess.round != nil {
+		if sess.round.pending.Load() != 0 {
+			return gnet.None
+		}
+		sess.finishRound()
+		sess.flush(c)
+	}
+
+	timeAll := s.slowlog.threshold.Load() == 0
+
+	// The input is peeked once and discarded at the end of the pass: a peek
+	// that spans gnet's inbound ring copies it, so peeking per command would
+	// copy the rest of the pipeline every time.
+	buf, err := c.Peek(-1)
+	if err != nil {
+		return gnet.None
+	}
+	off := 0
+	closing := false
+	for off < len(buf) {
+		consumed, parseErr, ok := resp.ParseArrayBytes(buf[off:], &sess.args)
+		if parseErr != nil {
+			if sess.round != nil {
+				break // answer the round first
+			}
+			s.runBatch(sess)
+			sess.out = resp.AppendError(sess.out, "ERR invalid command format")
+			off = len(buf)
+			sess.flush(c)
+			closing = true
+			break
+		}
+		if !ok {
+			break
+		}
+
+		var cmd *command
+		if len(sess.args) > 0 {
+			cmd = sess.lookup(sess.args[0])
+		}
+		if wait := s.clients.pausedFor(cmd); wait > 0 {
+			s.runBatch(sess)
+			if sess.round == nil {
+				sess.flush(c)
+			}
+			sess.waitUnpause(c, wait)
+			break
+		}
+
+		if s.owners != nil {
+			if owner := s.owners.forwardTo(c, cmd, sess.args); owner != nil {
+				if s.monitors.active.Load() != 0 && !sess.monitoring {
+					sess.monitorBuf = appendMonitorLine(sess.monitorBuf, sess.db, sess.cl.addr, sess.args)
+				}
+				sess.forward(s, c, owner, cmd)
+				off += consumed
+				sess.stats.netIn += uint64(consumed)
+				continue
+			}
+			// Anything beyond a single key may depend on forwarded
+			// commands, so it waits for the round to finish.
+			if sess.round != nil && cmd != nil && !singleKey(cmd, sess.args) {
+				break
+			}
+		}
+
+		if window := s.pipelineBatch.Load(); window > 0 && s.batchable(sess, cmd) {
+			if s.monitors.active.Load() != 0 && !sess.monitoring {
+				sess.monitorBuf = appendMonitorLine(sess.monitorBuf, sess.db, sess.cl.addr, sess.args)
+			}
+			sess.batch.queue(cmd, sess.args)
+			off += consumed
+			sess.stats.netIn += uint64(consumed)
+			if int64(len(sess.batch.cmds)) < window && off < len(buf) {
+				continue
+			}
+			s.runBatch(sess)
+			if off == len(buf) || len(sess.out) >= maxBytesBeforeFlush || sess.responses >= maxResponsesBeforeFlush {
+				sess.flush(c)
+			}
+			continue
+		}
+		s.runBatch(sess)
+
+		mark := len(sess.out)
+		skip := sess.replyMode == replySkip
+		sess.cmd = cmdNone
+		var action gnet.Action
+		if weight := sess.stats.timing(cmd, timeAll); weight == 0 {
+			action = s.handleCommand(sess, cmd)
+			sess.stats.count(sess.cmd)
+		} else {
+			start := time.Now()
+			action = s.handleCommand(sess, cmd)
+			s.commandTimed(sess, time.Since(start), weight)
+		}
+		if s.monitors.active.Load() != 0 && !sess.monitoring && sess.cmd != cmdNone {
+			sess.monitorBuf = appendMonitorLine(sess.monitorBuf, sess.db, sess.cl.addr, sess.args)
+		}
+		if skip {
+			if sess.replyMode == replySkip {
+				sess.replyMode = replyOn
+			}
+			sess.out = sess.out[:mark]
+		} else if sess.replyMode == replyOff {
+			sess.out = sess.out[:mark]
+		}
+		if sess.round != nil {
+			sess.round.parts = append(sess.round.parts, replyPart{start: mark, end: len(sess.out)})
+		}
+		off += consumed
+		sess.stats.netIn += uint64(consumed)
+		sess.responses++
+
+		if sess.round != nil {
+			continue
+		}
+		if sess.shouldClose || off == len(buf) || len(sess.out) >= maxBytesBeforeFlush || sess.responses >= maxResponsesBeforeFlush {
+			sess.flush(c)
+			if action == gnet.Close || sess.shouldClose {
+				closing = true
+				break
+			}
+		}
+	}
+	if len(sess.batch.cmds) > 0 {
+		s.runBatch(sess)
+		sess.flush(c)
+	}
+	if off > 0 { // Discard(0) would drop everything
+		_, _ = c.Discard(off)
+	}
+	if closing {
+		return gnet.Close
+	}
+
+	if sess.round != nil {
+		sess.round.dispatch(sess)
+		return gnet.None
+	}
+
+	if sess.shouldClose {
+		sess.flush(c)
+		return gnet.Close
+	}
+
+	return gnet.None
+}
+
+// commandTimed records the time of the command just run for sess.
+func (s *server) commandTimed(sess *session, elapsed time.Duration, weight uint64) {
+	sess.stats.record(sess.cmd, elapsed, weight)
+	if s.slowlog.shouldLog(elapsed) && sess.cmd != cmdNone {
+		s.slowlog.add(sess.args, sess.cl, elapsed)
+	}
+	s.latency.observe("command", elapsed)
+}
+
+func (s *server) handleCommand(sess *session, cmd *command) gnet.Action {
+	args := sess.args
+	if len(args) == 0 {
+		return gnet.None
+	}
+	if cmd == nil {
+		sess.out = resp.AppendError(sess.out, "ERR unknown command '"+args[0]+"'")
+		return gnet.None
+	}
+	sess.cmd = cmd.id
+	if !cmd.arityOK(len(args)) {
+		sess.out = resp.AppendError(sess.out, cmd.arityErr)
+		return gnet.None
+	}
+	if cmd.flags&flagDenyOOM != 0 && s.oom.Load() {
+		sess.out = resp.AppendError(sess.out, "OOM command not allowed when used memory > 'maxmemory'.")
+		return gnet.None
+	}
+	cmd.handler(s, sess)
+	return gnet.None
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+// startServer serves a fresh store with config on a free port and returns
+// a client for it.
+func startServer(t *testing.T, config map[string]string) *kvclient.Client {
+	t.Helper()
+	c, _ := serveStorage(t, storage.NewWithCapacity(0), config)
+	return c
+}
+
+// serveStorage is startServer with st as database 0; it also returns the
+// server's address.
+func serveStorage(t *testing.T, st storage.Storage, config map[string]string) (*kvclient.Client, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := map[string]string{"addr": "tcp://" + addr, "ttl": "0", "event-loops": "2"}
+	for k, v := range config {
+		cfg[k] = v
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, st, cfg, func() { close(ready) }) }()
+	select {
+	case <-ready:
+	case err := <-served:
+		cancel()
+		t.Fatal(err)
+	}
+	c := kvclient.New(kvclient.Options{Addr: addr})
+	t.Cleanup(func() {
+		c.Close()
+		cancel()
+		<-served
+		st.Close()
+	})
+	return c, addr
+}
+
+// rawConn is a connection of its own, for commands that change or close
+// the connection or answer out of band.
+type rawConn struct {
+	t  *testing.T
+	nc net.Conn
+	rd *kvclient.Reader
+}
+
+func dial(t *testing.T, addr string) *rawConn {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	return &rawConn{t: t, nc: nc, rd: kvclient.NewReader(nc)}
+}
+
+// send writes a command without waiting for its reply.
+func (c *rawConn) send(args ...string) {
+	c.t.Helper()
+	if _, err := c.nc.Write(kvclient.AppendCommand(nil, args)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next reply, with a deadline so a missing one fails the
+// test instead of hanging it; err is set once the connection is closed.
+func (c *rawConn) read() (any, error) {
+	c.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c.rd.Read()
+}
+
+// do sends a command and returns its reply.
+func (c *rawConn) do(args ...string) any {
+	c.t.Helper()
+	c.send(args...)
+	v, err := c.read()
+	if err != nil {
+		c.t.Fatalf("%v: %v", args, err)
+	}
+	return v
+}
+
+// newTestServer returns a server with one database and the default
+// config, for calling its handlers without a listener.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	s := newServer()
+	s.cfg.embedded = true
+	if err := s.loadConfig("", nil); err != nil {
+		t.Fatal(err)
+	}
+	s.openDatabases(1, nil)
+	t.Cleanup(func() { s.db(0).Close() })
+	return s
+}
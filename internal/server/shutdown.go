@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	shutdownSave = 1 << iota
+	shutdownNoSave
+	shutdownNow
+	shutdownForce
+)
+
+// shutdownState tracks a shutdown in progress. abort is non-nil only while
+// clients are being drained, which is the window SHUTDOWN ABORT can cancel.
+type shutdownState struct {
+	mu       sync.Mutex
+	active   bool
+	abort    chan struct{}
+	caller   gnet.Conn
+	callerCl *client
+	exitCode int
+}
+
+func (s *server) handleShutdown(sess *session) {
+	flags := 0
+	abort := false
+	for _, arg := range sess.args[1:] {
+		switch {
+		case strings.EqualFold(arg, "NOSAVE"):
+			flags |= shutdownNoSave
+		case strings.EqualFold(arg, "SAVE"):
+			flags |= shutdownSave
+		case strings.EqualFold(arg, "NOW"):
+			flags |= shutdownNow
+		case strings.EqualFold(arg, "FORCE"):
+			flags |= shutdownForce
+		case strings.EqualFold(arg, "ABORT"):
+			abort = true
+		default:
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+	}
+	if abort {
+		if flags != 0 {
+			sess.out = resp.AppendError(sess.out, "ERR syntax error")
+			return
+		}
+		if !s.abortShutdown() {
+			sess.out = resp.AppendError(sess.out, "ERR No shutdown in progress.")
+			return
+		}
+		sess.out = resp.AppendString(sess.out, "OK")
+		return
+	}
+	if flags&shutdownSave != 0 && flags&shutdownNoSave != 0 {
+		sess.out = resp.AppendError(sess.out, "ERR syntax error")
+		return
+	}
+	if flags&shutdownSave != 0 {
+		// There is no persistence layer to write a snapshot with.
+		log.Printf("SHUTDOWN SAVE requested but this server has no persistence")
+		if flags&shutdownForce == 0 {
+			sess.out = resp.AppendError(sess.out, "ERR Errors trying to SHUTDOWN. Check logs.")
+			return
+		}
+	}
+	if !s.beginShutdown("SHUTDOWN", flags, sess.conn, sess.cl) {
+		sess.out = resp.AppendError(sess.out, "ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	// On success the caller gets no reply: its connection is closed when
+	// the server stops, like in Redis.
+}
+
+// beginShutdown starts the shutdown sequence in the background so the
+// event loops keep serving the in-flight pipelines being drained.
+func (s *server) beginShutdown(reason string, flags int, caller gnet.Conn, callerCl *client) bool {
+	st := &s.shutdown
+	st.mu.Lock()
+	if st.active {
+		st.mu.Unlock()
+		log.Printf("%s ignored: shutdown already in progress", reason)
+		return false
+	}
+	st.active = true
+	st.abort = make(chan struct{})
+	st.caller = caller
+	st.callerCl = callerCl
+	abort := st.abort
+	st.mu.Unlock()
+
+	go s.runShutdown(reason, flags, abort)
+	return true
+}
+
+func (s *server) abortShutdown() bool {
+	st := &s.shutdown
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.abort == nil {
+		return false
+	}
+	close(st.abort)
+	st.abort = nil
+	st.active = false
+	if st.caller != nil {
+		_ = st.caller.AsyncWrite(resp.AppendError(nil, "ERR Errors trying to SHUTDOWN. Check logs."), nil)
+		st.caller = nil
+	}
+	st.callerCl = nil
+	s.closing.Store(false)
+	return true
+}
+
+func (s *server) runShutdown(reason string, flags int, abort chan struct{}) {
+	log.Printf("%s received, shutting down", reason)
+	s.closing.Store(true)
+
+	s.shutdown.mu.Lock()
+	caller := s.shutdown.callerCl
+	s.shutdown.mu.Unlock()
+
+	if flags&shutdownNow == 0 {
+		timeout := time.Duration(s.shutdownTimeout.Load()) * time.Second
+		if !s.drainClients(caller, timeout, abort) {
+			log.Printf("shutdown aborted")
+			return
+		}
+	}
+
+	s.shutdown.mu.Lock()
+	if s.shutdown.abort != abort {
+		// Aborted between the end of draining and here.
+		s.shutdown.mu.Unlock()
+		log.Printf("shutdown aborted")
+		return
+	}
+	s.shutdown.abort = nil
+	s.shutdown.mu.Unlock()
+
+	for _, db := range s.databases() {
+		db.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.eng.Stop(ctx); err != nil {
+		log.Printf("stopping event loops: %v", err)
+		s.setExitCode(1)
+	}
+}
+
+// drainClients waits until no client other than skip has unprocessed input,
+// so pipelines already received are answered before the event loops stop.
+// It returns false if the shutdown was aborted.
+func (s *server) drainClients(skip *client, timeout time.Duration, abort chan struct{}) bool {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	idle := 0
+	for {
+		select {
+		case <-abort:
+			return false
+		case <-deadline:
+			busy := s.clients.busy(skip)
+			log.Printf("shutdown-timeout of %s reached with %d clients still sending; closing them", timeout, busy)
+			s.setExitCode(1)
+			return true
+		case <-ticker.C:
+		}
+		// Two idle polls in a row, so data already in flight on the socket
+		// gets a chance to reach the event loop.
+		if s.clients.busy(skip) == 0 {
+			idle++
+			if idle == 2 {
+				return true
+			}
+		} else {
+			idle = 0
+		}
+	}
+}
+
+func (s *server) setExitCode(code int) {
+	s.shutdown.mu.Lock()
+	s.shutdown.exitCode = code
+	s.shutdown.mu.Unlock()
+}
+
+func (s *server) exitCode() int {
+	s.shutdown.mu.Lock()
+	defer s.shutdown.mu.Unlock()
+	return s.shutdown.exitCode
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+func TestShutdownErrors(t *testing.T) {
+	c := startServer(t, nil)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		// Without persistence SAVE cannot succeed, so it fails unless
+		// FORCE is given, like a failed save in Redis.
+		{[]string{"SHUTDOWN", "SAVE"}, "ERR Errors trying to SHUTDOWN. Check logs."},
+		{[]string{"SHUTDOWN", "SAVE", "NOW"}, "ERR Errors trying to SHUTDOWN. Check logs."},
+		{[]string{"SHUTDOWN", "SAVE", "NOSAVE"}, "ERR syntax error"},
+		{[]string{"SHUTDOWN", "ABORT", "NOW"}, "ERR syntax error"},
+		{[]string{"SHUTDOWN", "LATER"}, "ERR syntax error"},
+		{[]string{"SHUTDOWN", "ABORT"}, "ERR No shutdown in progress."},
+	}
+	for _, tt := range tests {
+		var e kvclient.Error
+		if _, err := c.Do(context.Background(), tt.args...); !errors.As(err, &e) || string(e) != tt.want {
+			t.Errorf("%v: %v, want %s", tt.args, err, tt.want)
+		}
+	}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("PING after failed SHUTDOWNs: %v", err)
+	}
+}
+
+// TestShutdownDrain shuts down while a client has half a command buffered:
+// the shutdown waits for it, can be aborted meanwhile, and once the
+// command is complete answers it before stopping.
+func TestShutdownDrain(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), map[string]string{"shutdown-timeout": "30"})
+	busy, caller, other := dial(t, addr), dial(t, addr), dial(t, addr)
+	if _, err := busy.nc.Write([]byte("*1\r\n$4\r\nPI")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the partial command to be buffered", func() bool {
+		list, _ := other.do("CLIENT", "LIST").(string)
+		return strings.Contains(list, "qbuf=10 ")
+	})
+
+	caller.send("SHUTDOWN")
+	time.Sleep(50 * time.Millisecond)
+	if got := other.do("SHUTDOWN", "ABORT"); got != "OK" {
+		t.Fatalf("SHUTDOWN ABORT: %v", got)
+	}
+	if got, err := caller.read(); got != kvclient.Error("ERR Errors trying to SHUTDOWN. Check logs.") {
+		t.Fatalf("aborted SHUTDOWN replied %v, %v", got, err)
+	}
+	if got := other.do("PING"); got != "PONG" {
+		t.Fatalf("PING after ABORT: %v", got)
+	}
+
+	caller.send("SHUTDOWN")
+	time.Sleep(50 * time.Millisecond)
+	if _, err := busy.nc.Write([]byte("NG\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := busy.read(); got != "PONG" {
+		t.Fatalf("drained PING replied %v, %v", got, err)
+	}
+	for _, c := range []*rawConn{busy, caller, other} {
+		if got, err := c.read(); err == nil {
+			t.Fatalf("read %v after shutdown, want the connection closed", got)
+		}
+	}
+}
+
+// waitFor polls cond until it holds, for what happens on an event loop.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for range 200 {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
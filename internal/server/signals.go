@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func (s *server) watchSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range ch {
+		if sig != syscall.SIGHUP {
+			reason := "SIGINT"
+			if sig == syscall.SIGTERM {
+				reason = "SIGTERM"
+			}
+			if !s.beginShutdown(reason, 0, nil, nil) {
+				// A second signal while draining means "stop now".
+				log.Printf("exiting immediately")
+				os.Exit(1)
+			}
+			continue
+		}
+		if err := s.reloadConfig(); err != nil {
+			log.Printf("config reload failed, keeping current settings:\n%v", err)
+			continue
+		}
+		log.Printf("config reloaded from %s", s.cfg.file)
+	}
+}
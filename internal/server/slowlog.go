@@ -0,0 +1,149 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/resp"
+)
+
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	at       int64
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+type slowlog struct {
+	// threshold is in nanoseconds; negative disables the log.
+	threshold atomic.Int64
+	maxLen    atomic.Int64
+
+	mu      sync.Mutex
+	nextID  int64
+	entries []slowlogEntry
+}
+
+func newSlowlog(slowerThanMicros, maxLen int64) *slowlog {
+	l := &slowlog{}
+	l.setThreshold(slowerThanMicros)
+	l.maxLen.Store(maxLen)
+	return l
+}
+
+func (l *slowlog) setThreshold(micros int64) {
+	if micros < 0 {
+		l.threshold.Store(-1)
+		return
+	}
+	l.threshold.Store(micros * int64(time.Microsecond))
+}
+
+func (l *slowlog) shouldLog(elapsed time.Duration) bool {
+	threshold := l.threshold.Load()
+	return threshold >= 0 && int64(elapsed) >= threshold
+}
+
+// add copies args because they alias the connection's inbound buffer.
+func (l *slowlog) add(args []string, cl *client, elapsed time.Duration) {
+	n := len(args)
+	if n > slowlogMaxArgs {
+		n = slowlogMaxArgs
+	}
+	saved := make([]string, n)
+	for i := 0; i < n; i++ {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			saved[i] = "... (" + strconv.Itoa(len(args)-slowlogMaxArgs+1) + " more arguments)"
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowlogMaxArgLen {
+			saved[i] = arg[:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)"
+		} else {
+			saved[i] = strings.Clone(arg)
+		}
+	}
+	cl.mu.Lock()
+	name := cl.name
+	cl.mu.Unlock()
+
+	l.mu.Lock()
+	ent := slowlogEntry{
+		id:       l.nextID,
+		at:       time.Now().Unix(),
+		duration: elapsed,
+		args:     saved,
+		addr:     cl.addr,
+		name:     name,
+	}
+	l.nextID++
+	l.entries = append(l.entries, ent)
+	if max := int(l.maxLen.Load()); max >= 0 && len(l.entries) > max {
+		drop := len(l.entries) - max
+		copy(l.entries, l.entries[drop:])
+		l.entries = l.entries[:max]
+	}
+	l.mu.Unlock()
+}
+
+func (s *server) handleSlowlog(sess *session) {
+	args := sess.args
+	if len(args) < 2 {
+		sess.out = resp.AppendError(sess.out, "ERR wrong number of arguments for 'SLOWLOG' command")
+		return
+	}
+	l := s.slowlog
+	switch sub := args[1]; {
+	case strings.EqualFold(sub, "GET"):
+		count := 10
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < -1 {
+				sess.out = resp.AppendError(sess.out, "ERR count should be greater than or equal to -1")
+				return
+			}
+			count = n
+		}
+		l.mu.Lock()
+		if count == -1 || count > len(l.entries) {
+			count = len(l.entries)
+		}
+		sess.out = resp.AppendArrayHeader(sess.out, count)
+		for i := len(l.entries) - 1; i >= len(l.entries)-count; i-- {
+			ent := &l.entries[i]
+			sess.out = resp.AppendArrayHeader(sess.out, 6)
+			sess.out = resp.AppendInt(sess.out, ent.id)
+			sess.out = resp.AppendInt(sess.out, ent.at)
+			sess.out = resp.AppendInt(sess.out, int64(ent.duration/time.Microsecond))
+			sess.out = resp.AppendArrayHeader(sess.out, len(ent.args))
+			for _, arg := range ent.args {
+				sess.out = resp.AppendBulkString(sess.out, arg)
+			}
+			sess.out = resp.AppendBulkString(sess.out, ent.addr)
+			sess.out = resp.AppendBulkString(sess.out, ent.name)
+		}
+		l.mu.Unlock()
+	case strings.EqualFold(sub, "LEN"):
+		l.mu.Lock()
+		n := len(l.entries)
+		l.mu.Unlock()
+		sess.out = resp.AppendInt(sess.out, int64(n))
+	case strings.EqualFold(sub, "RESET"):
+		l.mu.Lock()
+		l.entries = l.entries[:0]
+		l.mu.Unlock()
+		sess.out = resp.AppendString(sess.out, "OK")
+	default:
+		sess.out = resp.AppendError(sess.out, "ERR unknown subcommand '"+sub+"'. Try SLOWLOG HELP.")
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	kvclient "github.com/VoolFI71/go-kv-store/client"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+)
+
+// TestSlowlog logs every command, with slowlog-log-slower-than 0, and
+// checks what SLOWLOG GET reports of them.
+func TestSlowlog(t *testing.T) {
+	_, addr := serveStorage(t, storage.NewWithCapacity(0), map[string]string{
+		"slowlog-log-slower-than": "0",
+		"slowlog-max-len":         "3",
+	})
+	c := dial(t, addr)
+	c.do("CLIENT", "SETNAME", "tester")
+	c.do("SLOWLOG", "RESET")
+	long := strings.Repeat("x", slowlogMaxArgLen+10)
+	c.do("SET", "k", long)
+	many := []string{"MGET"}
+	for i := range slowlogMaxArgs + 5 {
+		many = append(many, "k"+strconv.Itoa(i))
+	}
+	c.do(many...)
+
+	entries, ok := c.do("SLOWLOG", "GET", "-1").([]any)
+	if !ok || len(entries) != 3 {
+		t.Fatalf("SLOWLOG GET -1: %v, want 3 entries", entries)
+	}
+	wantArgs := [][]string{
+		append(many[:slowlogMaxArgs-1:slowlogMaxArgs-1], "... (7 more arguments)"),
+		{"SET", "k", long[:slowlogMaxArgLen] + "... (10 more bytes)"},
+		{"SLOWLOG", "RESET"},
+	}
+	var lastID int64 = -1
+	for i, e := range entries {
+		ent := e.([]any)
+		id, micros := ent[0].(int64), ent[2].(int64)
+		if lastID >= 0 && id != lastID-1 {
+			t.Errorf("entry %d: id %d after %d, want newest first", i, id, lastID)
+		}
+		lastID = id
+		if micros < 0 {
+			t.Errorf("entry %d: duration %d", i, micros)
+		}
+		var args []string
+		for _, a := range ent[3].([]any) {
+			args = append(args, a.(string))
+		}
+		if !reflect.DeepEqual(args, wantArgs[i]) {
+			t.Errorf("entry %d: args %q, want %q", i, args, wantArgs[i])
+		}
+		if ent[5] != "tester" || !strings.HasPrefix(ent[4].(string), "127.0.0.1:") {
+			t.Errorf("entry %d: client %v %v", i, ent[4], ent[5])
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want any
+	}{
+		// The SLOWLOG GET above was logged too, evicting the oldest entry.
+		{[]string{"SLOWLOG", "LEN"}, int64(3)},
+		{[]string{"SLOWLOG", "GET", "-2"}, kvclient.Error("ERR count should be greater than or equal to -1")},
+		{[]string{"CONFIG", "SET", "slowlog-log-slower-than", "-1"}, "OK"},
+		{[]string{"SLOWLOG", "RESET"}, "OK"},
+		{[]string{"GET", "k"}, long},
+		{[]string{"SLOWLOG", "LEN"}, int64(0)},
+		{[]string{"SLOWLOG", "NOPE"}, kvclient.Error("ERR unknown subcommand 'NOPE'. Try SLOWLOG HELP.")},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v: %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
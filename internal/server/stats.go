@@ -0,0 +1,239 @@
+package server
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// latencyBuckets are powers of two in microseconds: bucket i counts
+// commands that took at most 2^i µs, the last one is +Inf.
+const latencyBuckets = 22
+
+func latencyBucket(elapsed time.Duration) int {
+	b := bits.Len64(uint64(elapsed / time.Microsecond))
+	if b >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return b
+}
+
+// Fast commands, and the single-key ones a pipeline batches (SET), are
+// timed one in timingSample per event loop, each timing standing for
+// timingSample runs in the histograms and commandstats; their call counts
+// stay exact. Other commands, which may legitimately be slow, are always
+// timed, so SLOWLOG sees every one of them, and so is every command while
+// slowlog-log-slower-than is 0.
+const timingSample = 16
+
+// loopCounters are an event loop's counters not published yet: plain
+// fields written only on the loop's goroutine, by all of its connections,
+// and added to loopStats once per OnTraffic, so the per-command path stays
+// free of shared writes. cmds is indexed by command id.
+type loopCounters struct {
+	commands uint64
+	hits     uint64
+	misses   uint64
+	netIn    uint64
+	netOut   uint64
+	// forwarded counts commands handed to another event loop.
+	forwarded uint64
+	// untimed counts the fast commands run since the last one timed.
+	untimed uint64
+	cmds    []cmdCounters
+}
+
+type cmdCounters struct {
+	calls   uint64
+	nanos   uint64
+	buckets [latencyBuckets]uint64
+}
+
+type commandStats struct {
+	calls   atomic.Uint64
+	nanos   atomic.Uint64
+	buckets [latencyBuckets]atomic.Uint64
+}
+
+// loopStats is written only by the connections of one event loop, so its
+// atomics never bounce between cores; readers sum across loops.
+type loopStats struct {
+	local     loopCounters
+	commands  atomic.Uint64
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	netIn     atomic.Uint64
+	netOut    atomic.Uint64
+	forwarded atomic.Uint64
+	cmds      []commandStats
+	_         [64]byte
+}
+
+type serverStats struct {
+	startedAt   time.Time
+	connections atomic.Uint64
+	loops       sync.Map
+}
+
+type statsSnapshot struct {
+	commands  uint64
+	hits      uint64
+	misses    uint64
+	netIn     uint64
+	netOut    uint64
+	forwarded uint64
+	calls     []uint64
+	nanos     []uint64
+	buckets   [][latencyBuckets]uint64
+}
+
+// timing returns how many runs a timing of the next command stands for,
+// 0 if it is not to be timed.
+func (lc *loopCounters) timing(cmd *command, all bool) uint64 {
+	switch {
+	case cmd == nil:
+		return 0
+	case all || cmd.flags&flagFast == 0 && cmd.batch == nil:
+		return 1
+	}
+	if lc.untimed++; lc.untimed < timingSample {
+		return 0
+	}
+	lc.untimed = 0
+	return timingSample
+}
+
+// count records a run of cmd that was not timed.
+func (lc *loopCounters) count(cmd int) {
+	if cmd == cmdNone {
+		return
+	}
+	lc.commands++
+	lc.cmds[cmd].calls++
+}
+
+// record records a run of cmd timed at elapsed, which stands for weight
+// runs in the latency statistics.
+func (lc *loopCounters) record(cmd int, elapsed time.Duration, weight uint64) {
+	if cmd == cmdNone {
+		return
+	}
+	lc.commands++
+	cc := &lc.cmds[cmd]
+	cc.calls++
+	cc.nanos += uint64(elapsed) * weight
+	cc.buckets[latencyBucket(elapsed)] += weight
+}
+
+func (st *serverStats) loopFor(el gnet.EventLoop) *loopStats {
+	if ls, ok := st.loops.Load(el); ok {
+		return ls.(*loopStats)
+	}
+	ls, _ := st.loops.LoadOrStore(el, &loopStats{
+		local: loopCounters{cmds: make([]cmdCounters, cmdCount)},
+		cmds:  make([]commandStats, cmdCount),
+	})
+	return ls.(*loopStats)
+}
+
+// publish adds the loop's local counters to its shared ones. Only the
+// loop's goroutine may call it.
+func (ls *loopStats) publish() {
+	local := &ls.local
+	if local.netIn != 0 {
+		ls.netIn.Add(local.netIn)
+		local.netIn = 0
+	}
+	if local.netOut != 0 {
+		ls.netOut.Add(local.netOut)
+		local.netOut = 0
+	}
+	if local.forwarded != 0 {
+		ls.forwarded.Add(local.forwarded)
+		local.forwarded = 0
+	}
+	if local.commands == 0 {
+		return
+	}
+	ls.commands.Add(local.commands)
+	local.commands = 0
+	if local.hits != 0 {
+		ls.hits.Add(local.hits)
+		local.hits = 0
+	}
+	if local.misses != 0 {
+		ls.misses.Add(local.misses)
+		local.misses = 0
+	}
+	for i := range local.cmds {
+		cc := &local.cmds[i]
+		if cc.calls == 0 {
+			continue
+		}
+		cs := &ls.cmds[i]
+		cs.calls.Add(cc.calls)
+		if cc.nanos != 0 {
+			cs.nanos.Add(cc.nanos)
+		}
+		for b, n := range cc.buckets {
+			if n != 0 {
+				cs.buckets[b].Add(n)
+			}
+		}
+		*cc = cmdCounters{}
+	}
+}
+
+func (st *serverStats) snapshot() *statsSnapshot {
+	snap := &statsSnapshot{
+		calls:   make([]uint64, cmdCount),
+		nanos:   make([]uint64, cmdCount),
+		buckets: make([][latencyBuckets]uint64, cmdCount),
+	}
+	st.loops.Range(func(_, v any) bool {
+		ls := v.(*loopStats)
+		snap.commands += ls.commands.Load()
+		snap.hits += ls.hits.Load()
+		snap.misses += ls.misses.Load()
+		snap.netIn += ls.netIn.Load()
+		snap.netOut += ls.netOut.Load()
+		snap.forwarded += ls.forwarded.Load()
+		for i := range ls.cmds {
+			cs := &ls.cmds[i]
+			snap.calls[i] += cs.calls.Load()
+			snap.nanos[i] += cs.nanos.Load()
+			for b := range cs.buckets {
+				snap.buckets[i][b] += cs.buckets[b].Load()
+			}
+		}
+		return true
+	})
+	return snap
+}
+
+// reset zeroes the counters for CONFIG RESETSTAT. Increments racing with it
+// may survive, which is acceptable for statistics.
+func (st *serverStats) reset() {
+	st.connections.Store(0)
+	st.loops.Range(func(_, v any) bool {
+		ls := v.(*loopStats)
+		ls.commands.Store(0)
+		ls.hits.Store(0)
+		ls.misses.Store(0)
+		ls.netIn.Store(0)
+		ls.netOut.Store(0)
+		ls.forwarded.Store(0)
+		for i := range ls.cmds {
+			cs := &ls.cmds[i]
+			cs.calls.Store(0)
+			cs.nanos.Store(0)
+			for b := range cs.buckets {
+				cs.buckets[b].Store(0)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Keys and values live in large byte slabs instead of one heap string
+// each, so the GC sees a few pointer-free allocations per shard rather
+// than millions of objects to mark.
+const (
+	slabSize = 256 << 10
+	// Records up to maxSmall bytes are carved from shared slabs by size
+	// class; larger ones get a slab of their own.
+	maxSmall = 16 << 10
+	// A shard is compacted once at least half of its slab space, and more
+	// than compactMinBytes, sits in free lists.
+	compactMinBytes = 4 * slabSize
+)
+
+// classSizes are the record sizes handed out for small records: steps of
+// 8 up to 128 bytes, then four steps per power of two, so rounding wastes
+// at most a fifth of a record.
+var classSizes = func() []uint32 {
+	var sizes []uint32
+	for n := uint32(8); n <= 128; n += 8 {
+		sizes = append(sizes, n)
+	}
+	for base := uint32(128); base < maxSmall; base *= 2 {
+		for i := uint32(1); i <= 4; i++ {
+			sizes = append(sizes, base+base*i/4)
+		}
+	}
+	return sizes
+}()
+
+func sizeClass(n uint32) int {
+	return sort.Search(len(classSizes), func(i int) bool { return classSizes[i] >= n })
+}
+
+// A loc addresses a record: the slab index in the high 32 bits, the byte
+// offset in the low 32.
+func makeLoc(slab int, off uint32) uint64 { return uint64(slab)<<32 | uint64(off) }
+func locSlab(loc uint64) int              { return int(loc >> 32) }
+func locOff(loc uint64) uint32            { return uint32(loc) }
+
+// arena is a shard's record allocator. It is guarded by the shard lock.
+type arena struct {
+	// Optimistic readers load slabs without the lock, so each slot is
+	// replaced atomically and the slice only grows by copying.
+	slabs []atomic.Pointer[[]byte]
+	// freeSlabs are indexes of released large-record slabs, reused first.
+	freeSlabs []int
+	// cur is the slab small records are bump-allocated from, -1 for none.
+	cur    int
+	curOff uint32
+	free   [][]uint64 // per size class
+
+	slabBytes   int64
+	freeBytes   int64
+	compactions uint64
+}
+
+func newArena() arena {
+	return arena{cur: -1, free: make([][]uint64, len(classSizes))}
+}
+
+// alloc reserves room for n bytes and returns its loc and capacity.
+func (a *arena) alloc(n uint32) (uint64, uint32) {
+	if n > maxSmall {
+		size := (n + 7) &^ 7
+		idx := a.newSlab(int(size))
+		return makeLoc(idx, 0), size
+	}
+	class := sizeClass(n)
+	size := classSizes[class]
+	if list := a.free[class]; len(list) > 0 {
+		loc := list[len(list)-1]
+		a.free[class] = list[:len(list)-1]
+		a.freeBytes -= int64(size)
+		return loc, size
+	}
+	if a.cur < 0 || a.curOff+size > slabSize {
+		// The tail of the old slab is too small for this class; hand it to
+		// the free lists so it is not lost until the next compaction.
+		if a.cur >= 0 {
+			a.releaseTail()
+		}
+		a.cur = a.newSlab(slabSize)
+		a.curOff = 0
+	}
+	loc := makeLoc(a.cur, a.curOff)
+	a.curOff += size
+	return loc, size
+}
+
+// releaseTail files the unused end of the current slab under the largest
+// classes that fit.
+func (a *arena) releaseTail() {
+	for rest := slabSize - a.curOff; rest >= classSizes[0]; {
+		class := sizeClass(rest)
+		if class == len(classSizes) || classSizes[class] > rest {
+			class--
+		}
+		size := classSizes[class]
+		a.free[class] = append(a.free[class], makeLoc(a.cur, a.curOff))
+		a.freeBytes += int64(size)
+		a.curOff += size
+		rest -= size
+	}
+}
+
+func (a *arena) newSlab(size int) int {
+	a.slabBytes += int64(size)
+	slab := make([]byte, size)
+	if n := len(a.freeSlabs); n > 0 {
+		idx := a.freeSlabs[n-1]
+		a.freeSlabs = a.freeSlabs[:n-1]
+		a.slabs[idx].Store(&slab)
+		return idx
+	}
+	if len(a.slabs) == cap(a.slabs) {
+		grown := make([]atomic.Pointer[[]byte], len(a.slabs), 2*cap(a.slabs)+16)
+		for i := range a.slabs {
+			grown[i].Store(a.slabs[i].Load())
+		}
+		a.slabs = grown
+	}
+	a.slabs = a.slabs[:len(a.slabs)+1]
+	a.slabs[len(a.slabs)-1].Store(&slab)
+	return len(a.slabs) - 1
+}
+
+// release returns a record's space. Large records free their slab.
+func (a *arena) release(loc uint64, capacity uint32) {
+	if capacity > maxSmall {
+		idx := locSlab(loc)
+		a.slabs[idx].Store(nil)
+		a.freeSlabs = append(a.freeSlabs, idx)
+		a.slabBytes -= int64(capacity)
+		return
+	}
+	class := sizeClass(capacity)
+	a.free[class] = append(a.free[class], loc)
+	a.freeBytes += int64(capacity)
+}
+
+// bytes returns the n bytes at loc. The slice aliases the slab and is only
+// valid under the shard lock.
+func (a *arena) bytes(loc uint64, n uint32) []byte {
+	off := locOff(loc)
+	slab := *a.slabs[locSlab(loc)].Load()
+	return slab[off : off+n : off+n]
+}
+
+func (a *arena) needsCompaction() bool {
+	return a.freeBytes > compactMinBytes && a.freeBytes*2 > a.slabBytes
+}
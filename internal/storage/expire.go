@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Keys with a TTL are also kept in a per-shard min-heap ordered by
+// expireAt, so the janitor removes exactly the keys that are due instead
+// of sampling. Effort adapts like Redis' active expire cycle: a slow cycle
+// every expireInterval may spend slowCycleBudget, and while a backlog is
+// left behind, fast cycles of fastCycleBudget follow every fastInterval.
+const (
+	expireInterval  = 100 * time.Millisecond
+	slowCycleBudget = 25 * time.Millisecond
+	fastInterval    = 2 * time.Millisecond
+	fastCycleBudget = time.Millisecond
+
+	// expireBatch is how many keys are removed per shard lock, so other
+	// commands get the shard between batches.
+	expireBatch = 64
+)
+
+// expiry is a heap item. entry.heapPos points back at it, 1-based.
+type expiry struct {
+	at int64
+	id uint32
+}
+
+// ExpireStats describes the janitor's active expire cycles.
+type ExpireStats struct {
+	Cycles     uint64
+	FastCycles uint64
+	// TimeCapped counts cycles that ran out of budget with keys still due.
+	TimeCapped uint64
+	Nanos      uint64
+}
+
+type expireCycles struct {
+	cycles     atomic.Uint64
+	fastCycles atomic.Uint64
+	timeCapped atomic.Uint64
+	nanos      atomic.Uint64
+}
+
+// ExpireStats returns the cumulative expire cycle counters.
+func (s Storage) ExpireStats() ExpireStats {
+	return ExpireStats{
+		Cycles:     s.cycles.cycles.Load(),
+		FastCycles: s.cycles.fastCycles.Load(),
+		TimeCapped: s.cycles.timeCapped.Load(),
+		Nanos:      s.cycles.nanos.Load(),
+	}
+}
+
+func (s Storage) startJanitor() {
+	defer close(s.done)
+	timer := time.NewTimer(expireInterval)
+	defer timer.Stop()
+	fast := false
+	for {
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			return
+		}
+		budget := slowCycleBudget
+		if fast {
+			budget = fastCycleBudget
+			s.cycles.fastCycles.Add(1)
+		}
+		fast = s.expireCycle(budget)
+		if fast {
+			timer.Reset(fastInterval)
+		} else {
+			timer.Reset(expireInterval)
+		}
+	}
+}
+
+// expireCycle removes due keys and compacts fragmented arenas, shard by
+// shard. A shard's lock is only taken if its earliest expiration is due
+// or it needs compaction. It reports whether the budget ran out first.
+func (s Storage) expireCycle(budget time.Duration) bool {
+	hook := s.events.latency.Load()
+	cycleStart := time.Now()
+	now := cycleStart.UnixNano()
+	capped := false
+	for _, shard := range s.shards {
+		if shard.compact.Load() {
+			shard.lock()
+			start := time.Now()
+			shard.compactLocked()
+			compacted := time.Since(start)
+			shard.unlock()
+			if hook != nil {
+				(*hook)("shard-compact", compacted)
+			}
+		}
+		for shard.nextExpire.Load() <= now {
+			if time.Since(cycleStart) >= budget {
+				capped = true
+				break
+			}
+			shard.lock()
+			start := time.Now()
+			shard.expireLocked(now, expireBatch)
+			held := time.Since(start)
+			shard.janitorRuns++
+			shard.janitorNanos += uint64(held)
+			shard.unlock()
+			if hook != nil {
+				(*hook)("shard-lock", held)
+			}
+		}
+		if capped {
+			break
+		}
+	}
+	elapsed := time.Since(cycleStart)
+	s.cycles.cycles.Add(1)
+	s.cycles.nanos.Add(uint64(elapsed))
+	if capped {
+		s.cycles.timeCapped.Add(1)
+	}
+	if hook != nil {
+		(*hook)("janitor-cycle", elapsed)
+	}
+	return capped
+}
+
+// expireLocked removes up to limit keys due at now.
+func (shard *Shard) expireLocked(now int64, limit int) {
+	for ; limit > 0 && len(shard.expiry) > 0 && shard.expiry[0].at <= now; limit-- {
+		shard.removeLocked(shard.expiry[0].id)
+		shard.expired++
+	}
+}
+
+// dueLocked counts keys past their TTL that are still stored. Only heap
+// subtrees whose root is due are visited.
+func (shard *Shard) dueLocked(now int64) int {
+	n := 0
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(shard.expiry) || shard.expiry[i].at > now {
+			continue
+		}
+		n++
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	return n
+}
+
+func (shard *Shard) setExpireLocked(id uint32, expireAt int64) {
+	e := &shard.entries[id]
+	switch {
+	case e.heapPos == 0 && expireAt != 0:
+		shard.expiry = append(shard.expiry, expiry{at: expireAt, id: id})
+		e.heapPos = uint32(len(shard.expiry))
+		shard.heapUp(len(shard.expiry) - 1)
+	case e.heapPos != 0 && expireAt == 0:
+		shard.heapRemove(int(e.heapPos) - 1)
+	case e.heapPos != 0:
+		i := int(e.heapPos) - 1
+		shard.expiry[i].at = expireAt
+		shard.heapFix(i)
+	}
+	e.expireAt = expireAt
+	shard.syncNextExpire()
+}
+
+func (shard *Shard) heapRemove(i int) {
+	shard.entries[shard.expiry[i].id].heapPos = 0
+	last := len(shard.expiry) - 1
+	if i != last {
+		shard.expiry[i] = shard.expiry[last]
+		shard.entries[shard.expiry[i].id].heapPos = uint32(i + 1)
+	}
+	shard.expiry = shard.expiry[:last]
+	if i != last {
+		shard.heapFix(i)
+	}
+	shard.syncNextExpire()
+}
+
+func (shard *Shard) heapFix(i int) {
+	if !shard.heapDown(i) {
+		shard.heapUp(i)
+	}
+}
+
+func (shard *Shard) heapUp(i int) {
+	h := shard.expiry
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h[parent].at <= h[i].at {
+			break
+		}
+		shard.heapSwap(i, parent)
+		i = parent
+	}
+}
+
+func (shard *Shard) heapDown(i int) bool {
+	h := shard.expiry
+	start := i
+	for {
+		child := 2*i + 1
+		if child >= len(h) {
+			break
+		}
+		if right := child + 1; right < len(h) && h[right].at < h[child].at {
+			child = right
+		}
+		if h[i].at <= h[child].at {
+			break
+		}
+		shard.heapSwap(i, child)
+		i = child
+	}
+	return i > start
+}
+
+func (shard *Shard) heapSwap(i, j int) {
+	h := shard.expiry
+	h[i], h[j] = h[j], h[i]
+	shard.entries[h[i].id].heapPos = uint32(i + 1)
+	shard.entries[h[j].id].heapPos = uint32(j + 1)
+}
+
+// syncNextExpire publishes the earliest expiration for the janitor to
+// check without the lock.
+func (shard *Shard) syncNextExpire() {
+	next := int64(math.MaxInt64)
+	if len(shard.expiry) > 0 {
+		next = shard.expiry[0].at
+	}
+	if shard.nextExpire.Load() != next {
+		shard.nextExpire.Store(next)
+	}
+}
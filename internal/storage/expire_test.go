@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// checkHeap verifies the heap order, the back pointers and nextExpire of
+// shard against the keys that have a TTL.
+func checkHeap(t *testing.T, shard *Shard) {
+	t.Helper()
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	h := shard.expiry
+	for i, x := range h {
+		if i > 0 && h[(i-1)/2].at > x.at {
+			t.Fatalf("heap order broken at %d", i)
+		}
+		e := &shard.entries[x.id]
+		if int(e.heapPos) != i+1 || e.expireAt != x.at {
+			t.Fatalf("item %d: entry has heapPos %d, expireAt %d, want %d, %d", i, e.heapPos, e.expireAt, i+1, x.at)
+		}
+	}
+	ttls := 0
+	for id := 1; id < len(shard.entries); id++ {
+		if e := &shard.entries[id]; e.loc != freeLoc && e.expireAt != 0 {
+			ttls++
+		}
+	}
+	if ttls != len(h) {
+		t.Fatalf("%d keys have a TTL, the heap holds %d", ttls, len(h))
+	}
+	next := int64(math.MaxInt64)
+	if len(h) > 0 {
+		next = h[0].at
+	}
+	if got := shard.nextExpire.Load(); got != next {
+		t.Fatalf("nextExpire = %d, want %d", got, next)
+	}
+}
+
+// TestExpiryHeap sets, changes, clears and deletes TTLs at random and
+// checks the heap after every step.
+func TestExpiryHeap(t *testing.T) {
+	s := NewWithCapacity(0)
+	// Only this test changes the keys.
+	s.Close()
+	rng := rand.New(rand.NewSource(1))
+	base := time.Now().Add(time.Hour).UnixNano()
+	for op := range 20000 {
+		// Few keys, so most of them share a shard with others.
+		key := "k" + strconv.Itoa(rng.Intn(500))
+		hash := xxhash.Sum64String(key)
+		at := base + rng.Int63n(int64(time.Hour))
+		switch rng.Intn(5) {
+		case 0:
+			s.SetHashedWithExpireAt(hash, key, "v", at)
+		case 1:
+			// SET without options drops the TTL.
+			s.SetHashed(hash, key, "v")
+		case 2:
+			del(s, key)
+		case 3:
+			s.SetExpireHashed(hash, key, 1+rng.Int63n(3600))
+		case 4:
+			// PERSIST, or a new expireAt for an existing TTL.
+			shard := s.shardForHash(hash)
+			shard.lock()
+			if id := shard.find(hash, key); id != 0 {
+				if rng.Intn(2) == 0 {
+					at = 0
+				}
+				shard.setExpireLocked(id, at)
+			}
+			shard.unlock()
+		}
+		checkHeap(t, s.shardForHash(hash))
+		if op%1000 == 0 {
+			for _, shard := range s.shards {
+				checkHeap(t, shard)
+			}
+		}
+	}
+}
+
+// TestExpireCycle checks that a cycle removes exactly the due keys, in
+// batches, and stops when its budget runs out.
+func TestExpireCycle(t *testing.T) {
+	tests := []struct {
+		name               string
+		due, future, plain int
+		budget             time.Duration
+		capped             bool
+	}{
+		{"nothing due", 0, 100, 100, time.Second, false},
+		{"few due", 100, 100, 1000, time.Second, false},
+		// Several batches of expireBatch keys per shard.
+		{"many due", 20000, 1000, 1000, time.Second, false},
+		{"no budget", 1000, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		s := NewWithCapacity(0)
+		s.Close()
+		now := time.Now()
+		add := func(prefix string, n int, expireAt int64) {
+			for i := range n {
+				key := prefix + strconv.Itoa(i)
+				s.SetHashedWithExpireAt(xxhash.Sum64String(key), key, "v", expireAt)
+			}
+		}
+		add("due", tt.due, now.Add(-time.Second).UnixNano())
+		add("future", tt.future, now.Add(time.Hour).UnixNano())
+		add("plain", tt.plain, 0)
+		stale := 0
+		for _, st := range s.Stats() {
+			stale += st.ExpiredStale
+		}
+		if stale != tt.due {
+			t.Errorf("%s: ExpiredStale = %d, want %d", tt.name, stale, tt.due)
+		}
+
+		if capped := s.expireCycle(tt.budget); capped != tt.capped {
+			t.Errorf("%s: capped = %v, want %v", tt.name, capped, tt.capped)
+		}
+		want, expired := tt.future+tt.plain, tt.due
+		if tt.capped {
+			want, expired = tt.due+tt.future+tt.plain, 0
+		}
+		if s.Len() != want {
+			t.Errorf("%s: %d keys left, want %d", tt.name, s.Len(), want)
+		}
+		got := 0
+		for _, st := range s.Stats() {
+			got += int(st.Expired)
+		}
+		if got != expired {
+			t.Errorf("%s: Expired = %d, want %d", tt.name, got, expired)
+		}
+		if st := s.ExpireStats(); st.Cycles != 1 || tt.capped != (st.TimeCapped == 1) {
+			t.Errorf("%s: ExpireStats = %+v", tt.name, st)
+		}
+		for _, shard := range s.shards {
+			checkHeap(t, shard)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package storage
+
+import "math/bits"
+
+// The shard index is an open-addressing table in the Swiss-table layout:
+// slots come in groups of eight, and each group has one control word with
+// a byte per slot. A full slot's control byte holds 7 bits of the hash, so
+// one word compare (SWAR, no assembly) filters a whole group before any
+// entry is touched. Slots hold entry ids; the full hash lives in the entry.
+const (
+	groupSlots = 8
+
+	ctrlEmpty   = 0x80
+	ctrlDeleted = 0xfe
+
+	lsbs = 0x0101010101010101
+	msbs = 0x8080808080808080
+
+	// migrateGroups is how many old groups each write moves while the
+	// index is resizing, so a grow never rehashes a shard in one go.
+	migrateGroups = 16
+)
+
+// Within a shard the low bits of every hash are equal, so the group is
+// picked from the bits above them and the control byte from the top ones.
+func h1(hash uint64) uint64 { return hash >> 6 }
+func h2(hash uint64) uint8  { return uint8(hash >> 57) }
+
+type table struct {
+	ctrl  []uint64
+	slots []uint32
+	mask  uint64 // groups - 1
+	// used counts full slots, growthLeft the empty ones that may still be
+	// filled before the load limit of 7/8.
+	used       int
+	growthLeft int
+}
+
+func newTable(capacity int) table {
+	groups := 1
+	for groups*groupSlots*7/8 < capacity {
+		groups *= 2
+	}
+	t := table{
+		ctrl:       make([]uint64, groups),
+		slots:      make([]uint32, groups*groupSlots),
+		mask:       uint64(groups - 1),
+		growthLeft: groups * groupSlots * 7 / 8,
+	}
+	for i := range t.ctrl {
+		t.ctrl[i] = lsbs * ctrlEmpty
+	}
+	return t
+}
+
+// matchByte returns a mask with the high bit set in each byte of ctrl equal
+// to b. It can report a false positive next to a true match, which the
+// caller filters by comparing the hash anyway.
+func matchByte(ctrl uint64, b uint8) uint64 {
+	x := ctrl ^ (lsbs * uint64(b))
+	return (x - lsbs) &^ x & msbs
+}
+
+func matchEmpty(ctrl uint64) uint64 {
+	return ctrl &^ (ctrl << 6) & msbs
+}
+
+func matchEmptyOrDeleted(ctrl uint64) uint64 {
+	return ctrl & msbs
+}
+
+func (t *table) setCtrl(slot uint64, b uint8) {
+	shift := (slot % groupSlots) * 8
+	g := &t.ctrl[slot/groupSlots]
+	*g = *g&^(0xff<<shift) | uint64(b)<<shift
+}
+
+// probe is the quadratic (triangular) probe sequence over groups, which
+// visits every group of a power-of-two table once. Loops stop after that:
+// an old table that is being migrated may have no empty slot left.
+type probe struct {
+	group, mask, i uint64
+}
+
+func newProbe(hash, mask uint64) probe {
+	return probe{group: h1(hash) & mask, mask: mask}
+}
+
+func (p *probe) next() {
+	p.i++
+	p.group = (p.group + p.i) & p.mask
+}
+
+// insert puts id in the first free slot for hash. The table must have
+// growth left.
+func (t *table) insert(hash uint64, id uint32) {
+	for p := newProbe(hash, t.mask); ; p.next() {
+		if m := matchEmptyOrDeleted(t.ctrl[p.group]); m != 0 {
+			slot := p.group*groupSlots + uint64(bits.TrailingZeros64(m)/8)
+			if t.ctrl[p.group]>>(slot%groupSlots*8)&0xff == ctrlEmpty {
+				t.growthLeft--
+			}
+			t.setCtrl(slot, h2(hash))
+			t.slots[slot] = id
+			t.used++
+			return
+		}
+	}
+}
+
+// remove clears the slot holding id and reports whether it was found.
+func (t *table) remove(hash uint64, id uint32) bool {
+	if t.ctrl == nil {
+		return false
+	}
+	for p := newProbe(hash, t.mask); p.i <= p.mask; p.next() {
+		ctrl := t.ctrl[p.group]
+		for m := matchByte(ctrl, h2(hash)); m != 0; m &= m - 1 {
+			slot := p.group*groupSlots + uint64(bits.TrailingZeros64(m)/8)
+			if t.slots[slot] != id {
+				continue
+			}
+			// A group that still has an empty slot never made a probe go
+			// past it, so the slot can become empty again; otherwise a
+			// tombstone keeps later probes going.
+			if matchEmpty(ctrl) != 0 {
+				t.setCtrl(slot, ctrlEmpty)
+				t.growthLeft++
+			} else {
+				t.setCtrl(slot, ctrlDeleted)
+			}
+			t.used--
+			return true
+		}
+		if matchEmpty(ctrl) != 0 {
+			return false
+		}
+	}
+	return false
+}
+
+// index maps hashes to entry ids. While it resizes, old still holds the
+// groups at and after migrated, and lookups check both tables.
+type index struct {
+	cur      table
+	old      table
+	migrated int
+}
+
+func newIndex(capacity int) index {
+	return index{cur: newTable(capacity)}
+}
+
+func (ix *index) len() int {
+	return ix.cur.used + ix.old.used
+}
+
+// bytes is the memory held by the tables.
+func (ix *index) bytes() int64 {
+	return int64(len(ix.cur.ctrl)+len(ix.old.ctrl))*8 + int64(len(ix.cur.slots)+len(ix.old.slots))*4
+}
+
+// insert adds id for hash, which must not be present, and moves part of a
+// pending resize along. entries supplies the hashes for migration.
+func (ix *index) insert(hash uint64, id uint32, entries []entry) {
+	if ix.cur.growthLeft == 0 {
+		ix.grow(entries)
+	}
+	ix.cur.insert(hash, id)
+	ix.migrate(entries, migrateGroups)
+}
+
+func (ix *index) remove(hash uint64, id uint32, entries []entry) {
+	if !ix.cur.remove(hash, id) {
+		ix.old.remove(hash, id)
+	}
+	ix.migrate(entries, migrateGroups)
+}
+
+// grow starts moving to a table twice the size, or the same size when
+// mostly tombstones used up the room. A resize still in progress is
+// finished first, which the migration pace makes rare.
+func (ix *index) grow(entries []entry) {
+	ix.migrate(entries, len(ix.old.ctrl))
+	capacity := len(ix.cur.slots) * 7 / 8
+	if ix.cur.used >= capacity/2 {
+		capacity *= 2
+	}
+	ix.old = ix.cur
+	ix.cur = newTable(capacity)
+	ix.migrated = 0
+}
+
+func (ix *index) migrate(entries []entry, groups int) {
+	if ix.old.ctrl == nil {
+		return
+	}
+	end := min(ix.migrated+groups, len(ix.old.ctrl))
+	for g := ix.migrated; g < end; g++ {
+		for m := ^ix.old.ctrl[g] & msbs; m != 0; m &= m - 1 {
+			id := ix.old.slots[g*groupSlots+bits.TrailingZeros64(m)/8]
+			ix.cur.insert(entries[id].hash, id)
+			ix.old.used--
+		}
+		// Tombstones rather than empties, so probes for keys still in old
+		// keep going past moved groups.
+		ix.old.ctrl[g] = lsbs * ctrlDeleted
+	}
+	ix.migrated = end
+	if end == len(ix.old.ctrl) {
+		ix.old = table{}
+	}
+}
+
+// scanGroup calls fn with every id in t whose hash has home group g. Such
+// ids sit along g's probe sequence up to the first group with an empty
+// slot, where lookups stop too.
+func (t *table) scanGroup(g uint64, entries []entry, fn func(id uint32)) {
+	if t.ctrl == nil {
+		return
+	}
+	for p := (probe{group: g, mask: t.mask}); p.i <= p.mask; p.next() {
+		ctrl := t.ctrl[p.group]
+		for m := ^ctrl & msbs; m != 0; m &= m - 1 {
+			id := t.slots[p.group*groupSlots+uint64(bits.TrailingZeros64(m)/8)]
+			if h1(entries[id].hash)&t.mask == g {
+				fn(id)
+			}
+		}
+		if matchEmpty(ctrl) != 0 {
+			return
+		}
+	}
+}
+
+// scan calls fn with the ids whose home group is at cursor v and returns
+// the next cursor, 0 after the last group. As in Redis' dictScan, the
+// cursor counts through the group bits reversed, so groups split by a
+// grow between calls are visited together and no id is skipped; ids may
+// come twice when that happens. While resizing, the group of the smaller
+// table is visited with every group of the larger one it splits into.
+func (ix *index) scan(v uint64, entries []entry, fn func(id uint32)) uint64 {
+	if ix.old.ctrl == nil {
+		ix.cur.scanGroup(v&ix.cur.mask, entries, fn)
+		return nextCursor(v, ix.cur.mask)
+	}
+	// old is never larger than cur.
+	m0, m1 := ix.old.mask, ix.cur.mask
+	ix.old.scanGroup(v&m0, entries, fn)
+	for {
+		ix.cur.scanGroup(v&m1, entries, fn)
+		v = nextCursor(v, m1)
+		if v&(m0^m1) == 0 {
+			return v
+		}
+	}
+}
+
+// nextCursor increments the bits of v under mask in reverse order.
+func nextCursor(v, mask uint64) uint64 {
+	v |= ^mask
+	return bits.Reverse64(bits.Reverse64(v) + 1)
+}
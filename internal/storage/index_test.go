@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// has reports whether t holds id for hash, probing like Shard.lookup.
+func (t *table) has(hash uint64, id uint32) bool {
+	if t.ctrl == nil {
+		return false
+	}
+	for p := newProbe(hash, t.mask); p.i <= p.mask; p.next() {
+		ctrl := t.ctrl[p.group]
+		for m := matchByte(ctrl, h2(hash)); m != 0; m &= m - 1 {
+			if t.slots[p.group*groupSlots+uint64(bits.TrailingZeros64(m)/8)] == id {
+				return true
+			}
+		}
+		if matchEmpty(ctrl) != 0 {
+			return false
+		}
+	}
+	return false
+}
+
+// full counts the full slots of t.
+func (t *table) full() int {
+	n := 0
+	for _, ctrl := range t.ctrl {
+		n += bits.OnesCount64(^ctrl & msbs)
+	}
+	return n
+}
+
+// TestIndex inserts and removes ids against a model and checks after every
+// step that each live id is found in one table only, that counts add up
+// and that a resize moves at most migrateGroups groups per write.
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  int
+		// live bounds the ids present at once; removes pick among them.
+		live int
+		// remove is the chance in 100 that a step removes.
+		remove int
+		hash   func(rng *rand.Rand) uint64
+	}{
+		{"grow", 50000, 50000, 0, func(rng *rand.Rand) uint64 { return rng.Uint64() << 6 }},
+		{"grow and shrink", 60000, 20000, 45, func(rng *rand.Rand) uint64 { return rng.Uint64() << 6 }},
+		// Steady size: tombstones, not keys, fill the table and force
+		// rehashes of the same size.
+		{"churn", 100000, 500, 50, func(rng *rand.Rand) uint64 { return rng.Uint64() << 6 }},
+		// One home group for every hash, whatever the table size, and
+		// few control bytes, so probes run long and matches are false.
+		{"collisions", 3000, 3000, 30, func(rng *rand.Rand) uint64 { return uint64(rng.Intn(4))<<57 | uint64(rng.Intn(1<<20))<<30 }},
+	}
+	for _, tt := range tests {
+		rng := rand.New(rand.NewSource(1))
+		ix := newIndex(0)
+		entries := []entry{{}}
+		var live []uint32
+		resizes := 0
+		for op := range tt.ops {
+			migrated, migrating := ix.migrated, ix.old.ctrl != nil
+			if len(live) > 0 && (len(live) >= tt.live || rng.Intn(100) < tt.remove) {
+				k := rng.Intn(len(live))
+				id := live[k]
+				live[k] = live[len(live)-1]
+				live = live[:len(live)-1]
+				ix.remove(entries[id].hash, id, entries)
+				if ix.cur.has(entries[id].hash, id) || ix.old.has(entries[id].hash, id) {
+					t.Fatalf("%s op %d: removed id %d still found", tt.name, op, id)
+				}
+			} else {
+				id := uint32(len(entries))
+				entries = append(entries, entry{hash: tt.hash(rng)})
+				ix.insert(entries[id].hash, id, entries)
+				live = append(live, id)
+			}
+			if ix.old.ctrl != nil && migrating && ix.migrated-migrated > migrateGroups {
+				t.Fatalf("%s op %d: one write migrated %d groups", tt.name, op, ix.migrated-migrated)
+			}
+			if ix.old.ctrl != nil && !migrating {
+				resizes++
+			}
+			if ix.len() != len(live) || ix.cur.full() != ix.cur.used || ix.old.full() != ix.old.used {
+				t.Fatalf("%s op %d: len %d (cur %d/%d full, old %d/%d), want %d",
+					tt.name, op, ix.len(), ix.cur.used, ix.cur.full(), ix.old.used, ix.old.full(), len(live))
+			}
+			if ix.cur.growthLeft < 0 {
+				t.Fatalf("%s op %d: growthLeft %d", tt.name, op, ix.cur.growthLeft)
+			}
+			// Checking every id each step is quadratic; sample instead,
+			// and check them all now and then.
+			check := live
+			if op%1000 != 0 && len(live) > 8 {
+				check = live[len(live)-4:]
+			}
+			for _, id := range check {
+				inCur, inOld := ix.cur.has(entries[id].hash, id), ix.old.has(entries[id].hash, id)
+				if inCur == inOld {
+					t.Fatalf("%s op %d: id %d in cur %v, in old %v", tt.name, op, id, inCur, inOld)
+				}
+			}
+		}
+		if resizes == 0 {
+			t.Errorf("%s: the index never resized", tt.name)
+		}
+	}
+}
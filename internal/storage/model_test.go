@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// TestModel runs random string writes against the storage and a map and
+// compares them after every step and after forced compactions, so slab
+// reuse, record moves and integer encoding all meet the same keys.
+func TestModel(t *testing.T) {
+	tests := []struct {
+		name string
+		keys int
+		ops  int
+		// maxLen bounds the length of written values; past maxSmall they
+		// get slabs of their own.
+		maxLen int
+	}{
+		{"small values", 50, 20000, 64},
+		{"size classes", 200, 20000, 2000},
+		{"large values", 20, 2000, 3 * maxSmall},
+		{"many keys", 5000, 50000, 100},
+	}
+	for _, tt := range tests {
+		rng := rand.New(rand.NewSource(1))
+		s := NewWithCapacity(0)
+		model := make(map[string]string)
+		value := func() string {
+			if rng.Intn(4) == 0 {
+				return strconv.Itoa(rng.Intn(2000) - 1000)
+			}
+			return strings.Repeat(string(rune('a'+rng.Intn(26))), rng.Intn(tt.maxLen+1))
+		}
+		for op := range tt.ops {
+			key := "k" + strconv.Itoa(rng.Intn(tt.keys))
+			hash := xxhash.Sum64String(key)
+			old, exists := model[key]
+			switch rng.Intn(6) {
+			case 0, 1:
+				v := value()
+				if op%2 == 0 {
+					s.SetHashed(hash, key, v)
+				} else {
+					var l Locked
+					s.Lock(hash, &l)
+					l.Set(hash, key, v, 0)
+					l.Unlock()
+				}
+				model[key] = v
+			case 2:
+				s.Atomically([]uint64{hash}, func(l *Locked) { l.Delete(hash, key) })
+				delete(model, key)
+			case 3:
+				tail := value()
+				n, err := s.AppendValueHashed(hash, key, tail, 0)
+				model[key] = old + tail
+				if err != nil || n != len(model[key]) {
+					t.Fatalf("%s op %d: APPEND %s = %d, %v, want %d", tt.name, op, key, n, err, len(model[key]))
+				}
+			case 4:
+				offset, v := rng.Intn(tt.maxLen+1), value()
+				n, err := s.SetRangeHashed(hash, key, offset, v, 0)
+				// An empty value changes nothing, not even the padding.
+				if v != "" {
+					b := []byte(old)
+					if len(b) < offset+len(v) {
+						b = append(b, make([]byte, offset+len(v)-len(b))...)
+					}
+					copy(b[offset:], v)
+					model[key] = string(b)
+				}
+				if err != nil || n != len(model[key]) {
+					t.Fatalf("%s op %d: SETRANGE %s = %d, %v, want %d", tt.name, op, key, n, err, len(model[key]))
+				}
+			case 5:
+				delta := int64(rng.Intn(100) - 50)
+				n, err := s.IncrByHashed(hash, key, delta, 0)
+				cur, perr := strconv.ParseInt(old, 10, 64)
+				switch {
+				case !exists:
+					cur, perr = 0, nil
+				case old != strconv.FormatInt(cur, 10):
+					perr = strconv.ErrSyntax
+				}
+				if (err != nil) != (perr != nil) {
+					t.Fatalf("%s op %d: INCRBY %s (%.20q) = %d, %v", tt.name, op, key, old, n, err)
+				}
+				if err == nil {
+					model[key] = strconv.FormatInt(cur+delta, 10)
+				}
+			}
+			got, ok := s.GetHashed(hash, key)
+			if want, wok := model[key]; ok != wok || got != want {
+				t.Fatalf("%s op %d: GET %s = %.20q, %v, want %.20q, %v", tt.name, op, key, got, ok, want, wok)
+			}
+			if op%(tt.ops/4) == 0 {
+				compactAll(s)
+				checkModel(t, s, model)
+			}
+		}
+		compactAll(s)
+		checkModel(t, s, model)
+		s.Close()
+	}
+}
+
+func compactAll(s Storage) {
+	for _, shard := range s.shards {
+		shard.lock()
+		shard.compactLocked()
+		shard.unlock()
+	}
+}
+
+// checkModel compares every key and a full SCAN with model.
+func checkModel(t *testing.T, s Storage, model map[string]string) {
+	t.Helper()
+	if s.Len() != len(model) {
+		t.Fatalf("Len = %d, want %d", s.Len(), len(model))
+	}
+	for key, want := range model {
+		if got, ok := s.GetHashed(xxhash.Sum64String(key), key); !ok || got != want {
+			t.Fatalf("GET %s = %.20q, %v, want %.20q", key, got, ok, want)
+		}
+	}
+	seen := scanAll(t, s, 100, func(int) {})
+	if len(seen) != len(model) {
+		t.Fatalf("SCAN returned %d keys, want %d", len(seen), len(model))
+	}
+	for key := range seen {
+		if _, ok := model[key]; !ok {
+			t.Fatalf("SCAN returned deleted %s", key)
+		}
+	}
+}
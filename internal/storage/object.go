@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// Values other than strings are Objects, Go structures kept beside the slab
+// arena: their entry holds no value bytes, only valObject in valInfo, and
+// Shard.objects maps its id to the Object. String commands that read see
+// such a key as missing, those that write fail with ErrWrongType.
+
+// ErrWrongType is returned by writes to a key holding another type.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Object is a value kept as a Go structure rather than bytes in the arena.
+// Its methods are called with the shard locked.
+type Object interface {
+	// Type is the name TYPE reports for the key.
+	Type() string
+	// Size approximates the memory the Object holds. Writers call it
+	// before and after every change, so it must not walk the Object.
+	Size() int64
+}
+
+// ViewObjectHashed calls fn with the Object at key under the shard read
+// lock. fn must not change or retain it, nor call into the Storage. It
+// reports whether the key exists and fails with ErrWrongType if it holds
+// a string.
+func (s Storage) ViewObjectHashed(hash uint64, key string, fn func(obj Object)) (bool, error) {
+	shard := s.shardForHash(hash)
+	now := time.Now().UnixNano()
+	shard.rlock()
+	defer shard.mu.RUnlock()
+	obj, ok, err := shard.object(hash, key, now)
+	if ok {
+		fn(obj)
+	}
+	return ok, err
+}
+
+// ViewObjectsHashed calls fn with the Object at each key in order, nil for
+// a missing key or a string, with the shards read-locked together like
+// ViewMultiHashed.
+func (s Storage) ViewObjectsHashed(hashes []uint64, keys []string, fn func(obj Object)) {
+	mask := s.rlockAll(hashes)
+	defer s.runlockAll(mask)
+	now := time.Now().UnixNano()
+	for i, hash := range hashes {
+		obj, _, _ := s.shardForHash(hash).object(hash, keys[i], now)
+		fn(obj)
+	}
+}
+
+// UpdateObjectHashed calls fn with the Object at key, nil if missing, under
+// the shard lock, and stores what fn returns: the same Object after
+// changing it in place, another one to replace it, or nil to remove the
+// key. A key created or replaced gets expireAt, which for a replaced key
+// keeps its TTL if 0. If fn fails nothing is stored and its error is
+// returned; a string at key fails with ErrWrongType without calling fn.
+func (s Storage) UpdateObjectHashed(hash uint64, key string, expireAt int64, fn func(obj Object) (Object, error)) error {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	var obj Object
+	var before int64
+	if id != 0 {
+		if !shard.entries[id].isObject() {
+			return ErrWrongType
+		}
+		obj = shard.objects[id]
+		before = obj.Size()
+	}
+	next, err := fn(obj)
+	if obj != nil {
+		// fn may have changed obj even if it failed or dropped it.
+		shard.bytes += obj.Size() - before
+	}
+	switch {
+	case err != nil || next == obj:
+	case next == nil:
+		shard.removeLocked(id)
+	case obj == nil:
+		shard.insertObjectLocked(hash, key, next, expireAt)
+	default:
+		shard.objects[id] = next
+		shard.bytes += next.Size() - obj.Size()
+		if expireAt != 0 {
+			shard.setExpireLocked(id, expireAt)
+		}
+	}
+	return err
+}
+
+// TypeHashed returns the type of a live key as TYPE names it, "none" if it
+// is missing.
+func (s Storage) TypeHashed(hash uint64, key string) string {
+	shard := s.shardForHash(hash)
+	now := time.Now().UnixNano()
+	shard.rlock()
+	defer shard.mu.RUnlock()
+	return shard.typeOf(hash, key, now)
+}
+
+// Type is Storage.TypeHashed for a locked shard.
+func (l *Locked) Type(hash uint64, key string) string {
+	return l.shard(hash).typeOf(hash, key, l.now)
+}
+
+// MemoryUsageHashed returns the approximate memory used by a live key and
+// its value, the same amount Stats accounts for it.
+func (s Storage) MemoryUsageHashed(hash uint64, key string) (int64, bool) {
+	shard := s.shardForHash(hash)
+	now := time.Now().UnixNano()
+	shard.rlock()
+	defer shard.mu.RUnlock()
+	id := shard.find(hash, key)
+	if id == 0 {
+		return 0, false
+	}
+	e := &shard.entries[id]
+	if e.expireAt != 0 && e.expireAt <= now {
+		return 0, false
+	}
+	size := EntrySize(int(e.keyLen), int(e.valLen()))
+	if e.isObject() {
+		size += shard.objects[id].Size()
+	}
+	return size, true
+}
+
+// object returns the Object at a live key, ErrWrongType if it is a string.
+func (shard *Shard) object(hash uint64, key string, now int64) (Object, bool, error) {
+	id := shard.find(hash, key)
+	if id == 0 {
+		return nil, false, nil
+	}
+	switch e := &shard.entries[id]; {
+	case e.expireAt != 0 && e.expireAt <= now:
+		return nil, false, nil
+	case !e.isObject():
+		return nil, false, ErrWrongType
+	}
+	return shard.objects[id], true, nil
+}
+
+func (shard *Shard) typeOf(hash uint64, key string, now int64) string {
+	id := shard.find(hash, key)
+	if id == 0 {
+		return "none"
+	}
+	if e := &shard.entries[id]; e.expireAt != 0 && e.expireAt <= now {
+		return "none"
+	}
+	return shard.typeName(id)
+}
+
+func (shard *Shard) typeName(id uint32) string {
+	if shard.entries[id].isObject() {
+		return shard.objects[id].Type()
+	}
+	return "string"
+}
+
+// insertObjectLocked adds key holding obj.
+func (shard *Shard) insertObjectLocked(hash uint64, key string, obj Object, expireAt int64) uint32 {
+	id := shard.insertStoredLocked(hash, key, "", valObject, expireAt)
+	shard.objects[id] = obj
+	shard.bytes += obj.Size()
+	return id
+}
+
+// dropObjectLocked forgets the Object of id, whose entry is being removed
+// or overwritten with a string.
+func (shard *Shard) dropObjectLocked(id uint32) {
+	shard.bytes -= shard.objects[id].Size()
+	delete(shard.objects, id)
+	shard.entries[id].valInfo = 0
+}
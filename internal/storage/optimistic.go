@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Reads of a single key can skip the shard lock, so GETs on a shard nobody
+// writes never write shared memory. Writers bump seq once after locking
+// and once before unlocking; a reader loads an even seq, looks the key up
+// and copies the value, and keeps the result only if seq is unchanged.
+//
+// The arrays a reader walks are still written in place, so it may see torn
+// entries or control words. That is safe because nothing the reader follows
+// is a multi-word value another goroutine writes: slice headers come from
+// a readView that is replaced rather than modified, slabs are swapped by
+// atomic pointers, and every id, offset and length is bounds-checked before
+// use. Memory the writer let go of stays valid for as long as the reader
+// holds it, which the GC provides in place of epoch-based reclamation.
+//
+// The race detector cannot tell such a discarded read from a real race,
+// so race builds take the read lock around the lookup (optimistic_race.go)
+// and check seq all the same.
+
+// optimisticTries is how many times a reader retries before it takes the
+// read lock, so a shard under constant writes cannot starve it.
+const optimisticTries = 3
+
+// readView holds the slice headers of a shard's index, entries and slabs.
+// The entries and slabs slices extend to their capacity, so appends that
+// fit do not need a new view.
+type readView struct {
+	cur, old table
+	entries  []entry
+	slabs    []atomic.Pointer[[]byte]
+}
+
+// publish replaces the view if a write reallocated something it holds.
+// Called with the shard locked, before seq turns even.
+func (shard *Shard) publish() {
+	ix, ar := &shard.index, &shard.arena
+	if v := shard.view.Load(); v != nil &&
+		unsafe.SliceData(v.cur.ctrl) == unsafe.SliceData(ix.cur.ctrl) &&
+		unsafe.SliceData(v.old.ctrl) == unsafe.SliceData(ix.old.ctrl) &&
+		unsafe.SliceData(v.entries) == unsafe.SliceData(shard.entries) &&
+		unsafe.SliceData(v.slabs) == unsafe.SliceData(ar.slabs) &&
+		cap(v.entries) == cap(shard.entries) && cap(v.slabs) == cap(ar.slabs) {
+		return
+	}
+	shard.view.Store(&readView{
+		cur:     ix.cur,
+		old:     ix.old,
+		entries: shard.entries[:cap(shard.entries)],
+		slabs:   ar.slabs[:cap(ar.slabs)],
+	})
+}
+
+// readOptimistic calls fn with the value of a live string key without
+// locking. found reports a string, object a live key holding an Object,
+// so a miss needs no second lookup to tell the two apart. ok is false if
+// it could not tell, because writers kept racing with it or the key has
+// expired and must be removed under the lock; fn may have been called
+// with torn bytes then. isInt tells fn the value is 8 raw int bytes.
+func (shard *Shard) readOptimistic(hash uint64, key string, fn func(value []byte, isInt bool)) (found, object, ok bool) {
+	now := time.Now().UnixNano()
+	for range optimisticTries {
+		seq := shard.seq.Load()
+		if seq&1 != 0 {
+			continue
+		}
+		shard.raceRLock()
+		v := shard.view.Load()
+		e, value, hit := v.lookup(&v.cur, hash, key)
+		if !hit && v.old.ctrl != nil {
+			e, value, hit = v.lookup(&v.old, hash, key)
+		}
+		expired := hit && e.expireAt != 0 && e.expireAt <= now
+		object = hit && !expired && e.isObject()
+		switch {
+		case !hit || expired || object:
+		case !e.isInt():
+			fn(value, false)
+		case len(value) == 8:
+			fn(value, true)
+		}
+		shard.raceRUnlock()
+		if shard.seq.Load() != seq {
+			continue
+		}
+		if expired {
+			break
+		}
+		return hit && !object, object, true
+	}
+	shard.readFallbacks.Add(1)
+	return false, false, false
+}
+
+// lookup is Shard.lookup for readers without the lock: it returns a copy
+// of the entry of key found through t and its stored value, checking every
+// index it takes from memory a writer may be changing.
+func (v *readView) lookup(t *table, hash uint64, key string) (entry, []byte, bool) {
+	for p := newProbe(hash, t.mask); p.i <= p.mask; p.next() {
+		ctrl := t.ctrl[p.group]
+		for m := matchByte(ctrl, h2(hash)); m != 0; m &= m - 1 {
+			id := t.slots[p.group*groupSlots+uint64(bits.TrailingZeros64(m)/8)]
+			if int(id) >= len(v.entries) {
+				continue
+			}
+			e := v.entries[id]
+			if e.hash != hash || int(e.keyLen) != len(key) {
+				continue
+			}
+			rec := v.record(e.loc, uint64(e.keyLen)+uint64(e.valLen()))
+			if rec == nil || string(rec[:e.keyLen]) != key {
+				continue
+			}
+			return e, rec[e.keyLen:], true
+		}
+		if matchEmpty(ctrl) != 0 {
+			return entry{}, nil, false
+		}
+	}
+	return entry{}, nil, false
+}
+
+// record returns the n bytes at loc, nil if they are not inside a slab.
+func (v *readView) record(loc, n uint64) []byte {
+	idx := uint64(locSlab(loc))
+	if idx >= uint64(len(v.slabs)) {
+		return nil
+	}
+	slab := v.slabs[idx].Load()
+	if slab == nil {
+		return nil
+	}
+	off := uint64(locOff(loc))
+	if off+n > uint64(len(*slab)) {
+		return nil
+	}
+	return (*slab)[off : off+n : off+n]
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// pattern is a value that checks itself: n copies of the letter n picks,
+// so a torn or moved record reads as something no writer wrote.
+func pattern(n int) string {
+	return strings.Repeat(string(rune('a'+n%26)), n)
+}
+
+func validValue(v string) bool {
+	if n, err := strconv.Atoi(v); err == nil && strconv.Itoa(n) == v {
+		return true
+	}
+	return v == pattern(len(v))
+}
+
+// TestOptimisticReadsUnderWrites reads keys while writers rewrite them at
+// every size class, delete them, grow the shards and compact the arenas.
+// Under -race the lookups hold the read lock, so it checks seq and the
+// read view rather than torn reads.
+func TestOptimisticReadsUnderWrites(t *testing.T) {
+	const keys = 64
+	s := NewWithCapacity(0)
+	defer s.Close()
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for w := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				key := "k" + strconv.Itoa((i*7+w)%keys)
+				hash := xxhash.Sum64String(key)
+				switch i % 5 {
+				case 0:
+					s.SetHashed(hash, key, strconv.Itoa(i))
+				case 1:
+					del(s, key)
+				default:
+					s.SetHashed(hash, key, pattern(i%(3*maxSmall)))
+				}
+				if i%50 == 0 {
+					// Keys the readers never ask for, to grow the index.
+					set(s, "grow"+strconv.Itoa(w)+":"+strconv.Itoa(i))
+				}
+				if i%20000 == 0 {
+					compactAll(s)
+				}
+			}
+		}()
+	}
+	var reads, hits atomic.Int64
+	var bad atomic.Value
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				key := "k" + strconv.Itoa(i%keys)
+				hash := xxhash.Sum64String(key)
+				v, ok := s.GetHashed(hash, key)
+				if i%2 == 1 {
+					var b []byte
+					b, ok, _ = s.AppendHashed(nil, hash, key, func(dst, value []byte) []byte { return append(dst, value...) })
+					v = string(b)
+				}
+				reads.Add(1)
+				if ok {
+					hits.Add(1)
+					if !validValue(v) {
+						bad.Store(key + " = " + v)
+					}
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Second)
+	stop.Store(true)
+	wg.Wait()
+	if v := bad.Load(); v != nil {
+		t.Fatalf("read a value no writer wrote: %.60q", v)
+	}
+	if hits.Load() == 0 {
+		t.Fatalf("%d reads found nothing", reads.Load())
+	}
+	var fallbacks uint64
+	for _, shard := range s.shards {
+		fallbacks += shard.readFallbacks.Load()
+	}
+	t.Logf("%d reads, %d hits, %d fell back to the lock", reads.Load(), hits.Load(), fallbacks)
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// TestReadOptimistic checks when a read may skip the lock: only with seq
+// even and the key live or absent.
+func TestReadOptimistic(t *testing.T) {
+	s := NewWithCapacity(0)
+	defer s.Close()
+	past := time.Now().Add(-time.Second).UnixNano()
+	s.SetHashed(xxhash.Sum64String("s"), "s", "hello")
+	s.SetHashed(xxhash.Sum64String("n"), "n", "-42")
+	s.SetHashedWithExpireAt(xxhash.Sum64String("old"), "old", "v", past)
+	s.ZAddHashed(xxhash.Sum64String("z"), "z", []string{"m"}, []float64{1}, false, false, 0)
+
+	tests := []struct {
+		key string
+		// locked holds the shard's write lock around the read.
+		locked            bool
+		want              string
+		found, object, ok bool
+	}{
+		{"s", false, "hello", true, false, true},
+		{"n", false, "-42", true, false, true},
+		{"missing", false, "", false, false, true},
+		// A sorted set is not a string value, but the same lookup says so.
+		{"z", false, "", false, true, true},
+		{"old", false, "", false, false, false},
+		{"s", true, "", false, false, false},
+	}
+	for _, tt := range tests {
+		hash := xxhash.Sum64String(tt.key)
+		shard := s.shardForHash(hash)
+		if tt.locked {
+			shard.lock()
+		}
+		fallbacks := shard.readFallbacks.Load()
+		var got string
+		found, object, ok := shard.readOptimistic(hash, tt.key, func(v []byte, isInt bool) { got = textOf(v, isInt) })
+		if tt.locked {
+			shard.unlock()
+		}
+		if ok && (got != tt.want || found != tt.found || object != tt.object) || ok != tt.ok {
+			t.Errorf("%s locked=%v: %q, found %v, object %v, ok %v, want %q, %v, %v, %v",
+				tt.key, tt.locked, got, found, object, ok, tt.want, tt.found, tt.object, tt.ok)
+		}
+		if fell := shard.readFallbacks.Load() != fallbacks; fell == tt.ok {
+			t.Errorf("%s locked=%v: fallback counted %v", tt.key, tt.locked, fell)
+		}
+	}
+}
+
+// TestReadViewFollowsGrowth checks that the view readers use is replaced
+// when a write reallocates the index, entries or slabs.
+func TestReadViewFollowsGrowth(t *testing.T) {
+	s := NewWithCapacity(0)
+	defer s.Close()
+	for i := range 20000 {
+		key := "k" + strconv.Itoa(i)
+		s.SetHashed(xxhash.Sum64String(key), key, key+":value")
+	}
+	for i := range 20000 {
+		key := "k" + strconv.Itoa(i)
+		hash := xxhash.Sum64String(key)
+		var got string
+		found, _, ok := s.shardForHash(hash).readOptimistic(hash, key, func(v []byte, isInt bool) { got = textOf(v, isInt) })
+		if !ok || !found || got != key+":value" {
+			t.Fatalf("%s: %q, found %v, ok %v", key, got, found, ok)
+		}
+	}
+}
+
+// textOf renders a value readOptimistic hands over as clients see it.
+func textOf(v []byte, isInt bool) string {
+	if isInt {
+		return string(intText(v))
+	}
+	return string(v)
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+func set(s Storage, key string) {
+	s.SetHashed(xxhash.Sum64String(key), key, "v")
+}
+
+func del(s Storage, key string) {
+	hash := xxhash.Sum64String(key)
+	s.Atomically([]uint64{hash}, func(l *Locked) { l.Delete(hash, key) })
+}
+
+// scanAll runs a whole SCAN, calling between after every call, and returns
+// how often each key came back.
+func scanAll(t *testing.T, s Storage, count int, between func(step int)) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for step := 0; ; step++ {
+		if step > 1<<20 {
+			t.Fatal("scan does not end")
+		}
+		cursor = s.Scan(cursor, count, func(key, typ string) {
+			if typ != "string" {
+				t.Errorf("type of %q = %q", key, typ)
+			}
+			seen[key]++
+		})
+		if cursor == 0 {
+			return seen
+		}
+		between(step)
+	}
+}
+
+func TestScanComplete(t *testing.T) {
+	tests := []struct {
+		keys, count int
+	}{
+		{0, 10},
+		{1, 1},
+		{100, 1},
+		{1000, 10},
+		{20000, 100},
+		{20000, 100000},
+	}
+	for _, tt := range tests {
+		s := New()
+		for i := range tt.keys {
+			set(s, "k"+strconv.Itoa(i))
+		}
+		seen := scanAll(t, s, tt.count, func(int) {})
+		if len(seen) != tt.keys {
+			t.Errorf("%d keys, COUNT %d: scan returned %d", tt.keys, tt.count, len(seen))
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("%d keys, COUNT %d: %q returned %d times without a resize", tt.keys, tt.count, key, n)
+			}
+		}
+		s.Close()
+	}
+}
+
+// TestScanWhileResizing writes between the calls of a SCAN so shards grow
+// and migrate mid-iteration; keys present throughout must all come back.
+func TestScanWhileResizing(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  int
+		count int
+		// resizes is set if between must leave a shard mid-migration.
+		resizes bool
+		// between runs after SCAN call step.
+		between func(s Storage, step int)
+	}{
+		{"grow", 64000, 100, true, func(s Storage, step int) {
+			for i := 0; i < 500 && step < 300; i++ {
+				set(s, "new"+strconv.Itoa(step*500+i))
+			}
+		}},
+		{"churn", 64000, 100, false, func(s Storage, step int) {
+			for i := range 200 {
+				key := "tmp" + strconv.Itoa(step*200+i)
+				set(s, key)
+				del(s, key)
+			}
+		}},
+		{"delete others", 3000, 10, false, func(s Storage, step int) {
+			del(s, "gone"+strconv.Itoa(step))
+		}},
+	}
+	for _, tt := range tests {
+		// No preallocation, so the tables grow as keys arrive.
+		s := NewWithCapacity(0)
+		for i := range tt.keys {
+			set(s, "k"+strconv.Itoa(i))
+			set(s, "gone"+strconv.Itoa(i))
+		}
+		migrating := false
+		seen := scanAll(t, s, tt.count, func(step int) {
+			tt.between(s, step)
+			for _, shard := range s.shards {
+				migrating = migrating || shard.index.old.ctrl != nil
+			}
+		})
+		for i := range tt.keys {
+			if key := "k" + strconv.Itoa(i); seen[key] == 0 {
+				t.Errorf("%s: %q not returned", tt.name, key)
+			}
+		}
+		if tt.resizes && !migrating {
+			t.Errorf("%s: no shard resized during the scan", tt.name)
+		}
+		s.Close()
+	}
+}
+
+func TestScanSkipsExpired(t *testing.T) {
+	s := New()
+	defer s.Close()
+	past := time.Now().Add(-time.Second).UnixNano()
+	for i := range 100 {
+		key := "k" + strconv.Itoa(i)
+		if i%2 == 0 {
+			s.SetHashedWithExpireAt(xxhash.Sum64String(key), key, "v", past)
+		} else {
+			set(s, key)
+		}
+	}
+	seen := scanAll(t, s, 7, func(int) {})
+	if len(seen) != 50 {
+		t.Errorf("scan returned %d keys, want the 50 live ones", len(seen))
+	}
+	for key := range seen {
+		if n, _ := strconv.Atoi(key[1:]); n%2 == 0 {
+			t.Errorf("expired %q returned", key)
+		}
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	// With 8 groups the cursor visits them in bit-reversed order.
+	want := []uint64{4, 2, 6, 1, 5, 3, 7, 0}
+	v := uint64(0)
+	for i, w := range want {
+		if v = nextCursor(v, 7); v != w {
+			t.Fatalf("step %d: cursor %d, want %d", i, v, w)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"encoding/binary"
 	"errors"
+	"math"
+	"math/bits"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -11,37 +15,175 @@ import (
 const ShardCount = 64
 const shardMask uint64 = ShardCount - 1
 
+// entryOverhead approximates the per-key cost of the entry and its index
+// slot, for memory reporting only.
+const entryOverhead = int64(unsafe.Sizeof(entry{})) + 8
+
+// EntrySize returns the approximate memory used by a key of keyLen bytes
+// holding valueLen bytes, the same amount Stats accounts for it.
+func EntrySize(keyLen, valueLen int) int64 {
+	return int64(keyLen+valueLen) + entryOverhead
+}
+
+// Shard holds no Go pointers per key: the index maps a hash to an entry
+// id, entries are a flat slice, and key and value bytes live in the arena.
 type Shard struct {
-	mu      sync.RWMutex
-	entries map[uint64]*entry
+	mu sync.RWMutex
+	// seq is odd while the shard is write-locked, see optimistic.go.
+	seq   atomic.Uint64
+	view  atomic.Pointer[readView]
+	index index
+	// entries[0] is unused so that id 0 can mean "none".
+	entries []entry
+	freeIDs []uint32
+	arena   arena
+	keys    int
+	bytes   int64
+	expired uint64
+	// expiry is a min-heap of the keys with a TTL, see expire.go.
+	expiry []expiry
+	// nextExpire is the earliest expireAt in expiry, MaxInt64 if none.
+	nextExpire atomic.Int64
+	// compact is set once the arena needs compaction.
+	compact atomic.Bool
+	// objects holds the values kept outside the arena by entry id, see
+	// object.go.
+	objects map[uint32]Object
+
+	janitorRuns  uint64
+	janitorNanos uint64
+
+	lockWaits     atomic.Uint64
+	lockWaitNanos atomic.Uint64
+	readFallbacks atomic.Uint64
+}
+
+type ShardStats struct {
+	Keys    int
+	Expires int
+	Bytes   int64
+	Expired uint64
+	// ExpiredStale counts keys past their TTL not reclaimed yet.
+	ExpiredStale int
+
+	// ArenaBytes is the slab memory held by the shard, ArenaFree the part
+	// of it in free lists waiting for reuse or compaction.
+	ArenaBytes  int64
+	ArenaFree   int64
+	Compactions uint64
+
+	JanitorRuns  uint64
+	JanitorNanos uint64
+
+	LockWaits     uint64
+	LockWaitNanos uint64
+	// ReadFallbacks counts optimistic reads that gave up and took the
+	// read lock.
+	ReadFallbacks uint64
 }
 
+// entry is one key. The record at loc holds the key followed by the value,
+// with capacity bytes reserved so values can grow in place.
 type entry struct {
-	key      string
-	value    string
+	hash     uint64
 	expireAt int64
-	next     *entry
+	loc      uint64
+	keyLen   uint32
+	// valInfo is the stored value's length, with valInt set if the value
+	// is an int64 kept as 8 raw bytes rather than its decimal text, or
+	// valObject if the value is an Object kept in Shard.objects.
+	valInfo  uint32
+	capacity uint32
+	// heapPos is the entry's 1-based position in the expiry heap, 0 if it
+	// has no TTL.
+	heapPos uint32
+}
+
+const (
+	valInt    = 1 << 31
+	valObject = 1 << 30
+)
+
+func (e *entry) valLen() uint32 { return e.valInfo &^ (valInt | valObject) }
+func (e *entry) isInt() bool    { return e.valInfo&valInt != 0 }
+func (e *entry) isObject() bool { return e.valInfo&valObject != 0 }
+
+type Storage struct {
+	id     uint64
+	shards []*Shard
+	events *eventHooks
+	cycles *expireCycles
+	stop   chan struct{}
+	done   chan struct{}
+	closed *sync.Once
 }
 
-type Storage []*Shard
+// LatencyHook receives the duration of storage-internal events such as a
+// full janitor cycle ("janitor-cycle"), a single shard lock held by the
+// janitor ("shard-lock") or an arena compaction ("shard-compact").
+type LatencyHook func(event string, elapsed time.Duration)
+
+type eventHooks struct {
+	latency atomic.Pointer[LatencyHook]
+}
 
-var errValueNotInteger = errors.New("ERR value is not an integer or out of range")
-var entryPool = sync.Pool{New: func() any { return &entry{} }}
+var (
+	errValueNotInteger = errors.New("ERR value is not an integer or out of range")
+	errValueNotFloat   = errors.New("ERR value is not a valid float")
+	errNotFinite       = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// ErrOverflow is returned by IncrByHashed when the result would not fit an
+// int64.
+var ErrOverflow = errors.New("ERR increment or decrement would overflow")
+
+// storageIDs orders shard locking between two Storages in MoveHashed.
+var storageIDs atomic.Uint64
 
 func New() Storage {
-	const preallocPerShard = 5_000_000 / ShardCount
-	s := make(Storage, ShardCount)
+	return NewWithCapacity(5_000_000)
+}
+
+// NewWithCapacity preallocates index room for about keys entries across
+// shards. Slabs are allocated as data arrives.
+func NewWithCapacity(keys int) Storage {
+	preallocPerShard := keys / ShardCount
+	s := Storage{
+		id:     storageIDs.Add(1),
+		shards: make([]*Shard, ShardCount),
+		events: &eventHooks{},
+		cycles: &expireCycles{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		closed: &sync.Once{},
+	}
 	for i := 0; i < ShardCount; i++ {
-		s[i] = &Shard{
-			entries: make(map[uint64]*entry, preallocPerShard),
-		}
+		s.shards[i] = newShard(preallocPerShard)
 	}
 	go s.startJanitor()
 	return s
 }
 
+func newShard(capacity int) *Shard {
+	shard := &Shard{
+		index:   newIndex(capacity),
+		entries: make([]entry, 1, capacity+1),
+		arena:   newArena(),
+		objects: make(map[uint32]Object),
+	}
+	shard.nextExpire.Store(math.MaxInt64)
+	shard.publish()
+	return shard
+}
+
+// ShardOf returns the index of the shard holding keys with hash, the same
+// in every Storage.
+func ShardOf(hash uint64) int {
+	return int(hash & shardMask)
+}
+
 func (s Storage) shardForHash(hash uint64) *Shard {
-	return s[int(hash&shardMask)]
+	return s.shards[int(hash&shardMask)]
 }
 
 func (s Storage) SetHashed(hash uint64, key, value string) {
@@ -58,203 +200,754 @@ func (s Storage) SetHashedWithTTLSeconds(hash uint64, key, value string, ttlSeco
 
 func (s Storage) SetHashedWithExpireAt(hash uint64, key, value string, expireAt int64) {
 	shard := s.shardForHash(hash)
-	shard.mu.Lock()
-	prev, ent := shard.findEntry(hash, key)
-	if ent != nil {
-		ent.value = cloneString(value)
-		ent.expireAt = expireAt
-		shard.mu.Unlock()
-		return
-	}
-	_ = prev
-	newEnt := getEntryFromPool(key, value)
-	newEnt.expireAt = expireAt
-	newEnt.next = shard.entries[hash]
-	shard.entries[hash] = newEnt
-	shard.mu.Unlock()
+	shard.lock()
+	shard.setLocked(hash, key, value, expireAt)
+	shard.unlock()
 }
 
+// GetHashed returns a copy of the value. It reads without locking unless
+// a writer holds the shard or the key has expired.
 func (s Storage) GetHashed(hash uint64, key string) (string, bool) {
+	var value string
+	read := func(v []byte, isInt bool) {
+		if isInt {
+			value = strconv.FormatInt(intOf(v), 10)
+		} else {
+			value = string(v)
+		}
+	}
+	if found, _, ok := s.shardForHash(hash).readOptimistic(hash, key, read); ok {
+		return value, found
+	}
+	found, _ := s.viewHashed(hash, key, read)
+	return value, found
+}
+
+// AppendHashed returns fn(dst, value) for a live key, read like GetHashed.
+// fn may be called more than once and must only append to dst; its result
+// is discarded if a writer raced with it. A key holding an Object fails
+// with ErrWrongType, told apart from a missing one by the same lookup.
+func (s Storage) AppendHashed(dst []byte, hash uint64, key string, fn func(dst, value []byte) []byte) ([]byte, bool, error) {
+	mark := len(dst)
+	read := func(v []byte, isInt bool) { dst = appendValue(dst[:mark], v, isInt, fn) }
+	if found, object, ok := s.shardForHash(hash).readOptimistic(hash, key, read); ok {
+		switch {
+		case object:
+			return dst[:mark], false, ErrWrongType
+		case !found:
+			return dst[:mark], false, nil
+		}
+		return dst, true, nil
+	}
+	dst = dst[:mark]
+	found, err := s.viewHashed(hash, key, read)
+	return dst, found, err
+}
+
+// appendValue returns fn(dst, value) for a stored value. An int-encoded
+// one is formatted in dst's spare room, where fn's append cannot reach
+// it, and moved into place after, so GET of an integer allocates nothing.
+func appendValue(dst, raw []byte, isInt bool, fn func(dst, value []byte) []byte) []byte {
+	if !isInt {
+		return fn(dst, raw)
+	}
+	mark := len(dst)
+	dst = strconv.AppendInt(dst, intOf(raw), 10)
+	text := dst[mark:]
+	dst = fn(dst, text)
+	return append(dst[:mark], dst[mark+len(text):]...)
+}
+
+// ViewHashed calls fn with the value of a live string key while holding
+// the shard read lock, and fails with ErrWrongType if the key holds an
+// Object. fn must not retain the slice or call into the Storage.
+func (s Storage) ViewHashed(hash uint64, key string, fn func(value []byte)) (bool, error) {
+	return s.viewHashed(hash, key, func(v []byte, isInt bool) {
+		if isInt {
+			v = intText(v)
+		}
+		fn(v)
+	})
+}
+
+// viewHashed is ViewHashed handing fn int-encoded values raw.
+func (s Storage) viewHashed(hash uint64, key string, fn func(value []byte, isInt bool)) (bool, error) {
 	shard := s.shardForHash(hash)
 	now := time.Now().UnixNano()
 
-	shard.mu.RLock()
-	ent := shard.findEntryRead(hash, key)
-	if ent == nil {
+	shard.rlock()
+	id := shard.find(hash, key)
+	if id == 0 {
 		shard.mu.RUnlock()
-		return "", false
+		return false, nil
 	}
-	if ent.expireAt == 0 || ent.expireAt > now {
-		value := ent.value
+	if e := &shard.entries[id]; e.expireAt == 0 || e.expireAt > now {
+		if e.isObject() {
+			shard.mu.RUnlock()
+			return false, ErrWrongType
+		}
+		fn(shard.stored(e), e.isInt())
 		shard.mu.RUnlock()
-		return value, true
+		return true, nil
 	}
 	shard.mu.RUnlock()
 
-	shard.mu.Lock()
-	prev, ent := shard.findEntry(hash, key)
-	if ent == nil {
-		shard.mu.Unlock()
-		return "", false
+	shard.lock()
+	id = shard.find(hash, key)
+	if id == 0 {
+		shard.unlock()
+		return false, nil
 	}
-	if ent.expireAt != 0 && ent.expireAt <= now {
-		deleteEntryLocked(shard, hash, prev, ent)
-		shard.mu.Unlock()
-		return "", false
+	if e := &shard.entries[id]; e.expireAt != 0 && e.expireAt <= now {
+		shard.removeLocked(id)
+		shard.expired++
+		shard.unlock()
+		return false, nil
 	}
-	value := ent.value
-	shard.mu.Unlock()
-	return value, true
+	if shard.entries[id].isObject() {
+		shard.unlock()
+		return false, ErrWrongType
+	}
+	e := &shard.entries[id]
+	fn(shard.stored(e), e.isInt())
+	shard.unlock()
+	return true, nil
 }
 
-func (s Storage) IncrHashed(hash uint64, key string) (int64, error) {
+// IncrByHashed adds delta to the integer at key, which starts at 0 if
+// missing, and stores the result natively. A non-zero expireAt replaces
+// the key's TTL in the same step.
+func (s Storage) IncrByHashed(hash uint64, key string, delta, expireAt int64) (int64, error) {
 	shard := s.shardForHash(hash)
-	shard.mu.Lock()
+	shard.lock()
+	defer shard.unlock()
 
 	current := int64(0)
-	now := time.Now().UnixNano()
-	prev, ent := shard.findEntry(hash, key)
-	if ent != nil && ent.expireAt != 0 && ent.expireAt <= now {
-		deleteEntryLocked(shard, hash, prev, ent)
-		ent = nil
-	}
-	if ent != nil {
-		parsed, err := strconv.ParseInt(ent.value, 10, 64)
-		if err != nil {
-			shard.mu.Unlock()
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id != 0 {
+		if shard.entries[id].isObject() {
+			return 0, ErrWrongType
+		}
+		n, ok := shard.intValue(&shard.entries[id])
+		if !ok {
 			return 0, errValueNotInteger
 		}
-		current = parsed
+		current = n
+	}
+	if delta > 0 && current > math.MaxInt64-delta || delta < 0 && current < math.MinInt64-delta {
+		return 0, ErrOverflow
 	}
-	current++
-	if ent != nil {
-		ent.value = strconv.FormatInt(current, 10)
+	current += delta
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(current))
+	if id != 0 {
+		shard.storeLocked(id, unsafeString(buf[:]), 8|valInt)
 	} else {
-		newEnt := getEntryFromPool(key, strconv.FormatInt(current, 10))
-		newEnt.next = shard.entries[hash]
-		shard.entries[hash] = newEnt
+		id = shard.insertStoredLocked(hash, key, unsafeString(buf[:]), 8|valInt, 0)
+	}
+	if expireAt != 0 {
+		shard.setExpireLocked(id, expireAt)
 	}
-	shard.mu.Unlock()
 	return current, nil
 }
 
+// IncrByFloatHashed adds delta to the number at key like IncrByHashed and
+// returns the result as stored, in decimal text.
+func (s Storage) IncrByFloatHashed(hash uint64, key string, delta float64, expireAt int64) (string, error) {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	current := 0.0
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id != 0 {
+		e := &shard.entries[id]
+		if e.isObject() {
+			return "", ErrWrongType
+		}
+		if n, ok := shard.intValue(e); ok {
+			current = float64(n)
+		} else {
+			f, err := strconv.ParseFloat(unsafeString(shard.stored(e)), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return "", errValueNotFloat
+			}
+			current = f
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return "", errNotFinite
+	}
+	value := strconv.FormatFloat(current, 'f', -1, 64)
+	if id != 0 {
+		shard.setValueLocked(id, value)
+	} else {
+		id = shard.insertLocked(hash, key, value, 0)
+	}
+	if expireAt != 0 {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return value, nil
+}
+
+// EncodingHashed returns how a live key's value is stored, named like
+// Redis' OBJECT ENCODING: "int", "embstr" and "raw" for short and long
+// strings, "skiplist" for a sorted set and "raw" for other objects.
+func (s Storage) EncodingHashed(hash uint64, key string) (string, bool) {
+	shard := s.shardForHash(hash)
+	now := time.Now().UnixNano()
+	shard.rlock()
+	defer shard.mu.RUnlock()
+	id := shard.find(hash, key)
+	if id == 0 {
+		return "", false
+	}
+	e := &shard.entries[id]
+	switch {
+	case e.expireAt != 0 && e.expireAt <= now:
+		return "", false
+	case e.isObject():
+		if _, ok := shard.objects[id].(*ZSet); ok {
+			return "skiplist", true
+		}
+		return "raw", true
+	case e.isInt():
+		return "int", true
+	case e.valLen() <= embstrLimit:
+		return "embstr", true
+	}
+	return "raw", true
+}
+
+// embstrLimit is the longest string Redis keeps embstr-encoded.
+const embstrLimit = 44
+
 func (s Storage) SetExpireHashed(hash uint64, key string, seconds int64) bool {
 	shard := s.shardForHash(hash)
-	shard.mu.Lock()
-	prev, ent := shard.findEntry(hash, key)
-	if ent == nil {
-		shard.mu.Unlock()
+	shard.lock()
+	defer shard.unlock()
+	id := shard.find(hash, key)
+	if id == 0 {
 		return false
 	}
 	if seconds <= 0 {
-		deleteEntryLocked(shard, hash, prev, ent)
-		shard.mu.Unlock()
+		shard.removeLocked(id)
 		return true
 	}
-	ent.expireAt = time.Now().Add(time.Duration(seconds) * time.Second).UnixNano()
-	shard.mu.Unlock()
+	shard.setExpireLocked(id, time.Now().Add(time.Duration(seconds)*time.Second).UnixNano())
 	return true
 }
 
-func (s Storage) cleanup(scanLimit int) {
+// ExpireAtHashed returns the expiration of a live key in Unix nanoseconds,
+// 0 if it has none, and whether it exists.
+func (s Storage) ExpireAtHashed(hash uint64, key string) (int64, bool) {
+	shard := s.shardForHash(hash)
 	now := time.Now().UnixNano()
-	for _, shard := range s {
-		shard.mu.Lock()
-		shard.cleanupLocked(now, scanLimit)
-		shard.mu.Unlock()
+	shard.rlock()
+	defer shard.mu.RUnlock()
+	id := shard.find(hash, key)
+	if id == 0 {
+		return 0, false
+	}
+	expireAt := shard.entries[id].expireAt
+	if expireAt != 0 && expireAt <= now {
+		return 0, false
 	}
+	return expireAt, true
 }
 
-func (s Storage) startJanitor() {
-	ticker := time.NewTicker(100 * time.Millisecond)
-	for range ticker.C {
-		s.cleanup(100)
+// MoveHashed moves key with its TTL to dst unless dst already holds it.
+// Both shards are locked together, in Storage id order, so the key is
+// never visible in both or neither.
+func (s Storage) MoveHashed(hash uint64, key string, dst Storage) bool {
+	src, to := s.shardForHash(hash), dst.shardForHash(hash)
+	if s.id < dst.id {
+		src.lock()
+		to.lock()
+	} else {
+		to.lock()
+		src.lock()
 	}
+	defer src.unlock()
+	defer to.unlock()
+
+	now := time.Now().UnixNano()
+	id := src.find(hash, key)
+	if id == 0 {
+		return false
+	}
+	e := src.entries[id]
+	if e.expireAt != 0 && e.expireAt <= now {
+		src.removeLocked(id)
+		src.expired++
+		return false
+	}
+	did := to.find(hash, key)
+	if did != 0 {
+		if de := &to.entries[did]; de.expireAt == 0 || de.expireAt > now {
+			return false
+		}
+		to.removeLocked(did)
+		to.expired++
+	}
+	nid := to.insertStoredLocked(hash, key, unsafeString(src.stored(&e)), e.valInfo, e.expireAt)
+	if e.isObject() {
+		obj := src.objects[id]
+		to.objects[nid] = obj
+		to.bytes += obj.Size()
+	}
+	src.removeLocked(id)
+	return true
 }
 
-func (shard *Shard) cleanupLocked(now int64, scanLimit int) {
-	maxScan := scanLimit
-	removed := 0
-	if scanLimit < 1000 {
-		maxScan = scanLimit * 10
+// Len returns the number of keys, including expired ones not yet collected.
+func (s Storage) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		n += shard.keys
+		shard.mu.RUnlock()
 	}
+	return n
+}
 
-	scanned := 0
-	for hash, head := range shard.entries {
-		prev := (*entry)(nil)
-		cur := head
-		for cur != nil {
-			next := cur.next
-			if cur.expireAt != 0 && cur.expireAt <= now {
-				deleteEntryLocked(shard, hash, prev, cur)
-				removed++
-			} else {
-				prev = cur
-			}
-			scanned++
-			if scanned >= scanLimit && removed == 0 {
-				return
-			}
-			if scanned >= maxScan {
-				return
-			}
-			cur = next
+// Flush removes every key. A shard's data is a handful of slabs and a
+// pointer-free index, so dropping them is cheap.
+func (s Storage) Flush() {
+	for _, shard := range s.shards {
+		shard.lock()
+		old := shard.takeLocked()
+		shard.putLocked(emptyShardData())
+		shard.unlock()
+		old.release()
+	}
+}
+
+// FlushAsync empties every shard like Flush, but each shard only swaps in
+// fresh data under its lock; the old index, arenas and Objects are
+// released on a background goroutine, so the caller pays for neither
+// clearing the Objects nor the last reference to the slabs.
+func (s Storage) FlushAsync() {
+	old := make([]shardData, len(s.shards))
+	for i, shard := range s.shards {
+		shard.lock()
+		old[i] = shard.takeLocked()
+		shard.putLocked(emptyShardData())
+		shard.unlock()
+	}
+	go func() {
+		for i := range old {
+			old[i].release()
 		}
+	}()
+}
+
+// SwapContents exchanges the keys of s and t, so that everyone holding
+// either of them sees the other's data from then on, and both stay the
+// same Storage. All shards of both are locked at once, lower id first as
+// in MoveHashed, so multi-key commands never see a half-swapped pair.
+func (s Storage) SwapContents(t Storage) {
+	if s.id == t.id {
+		return
+	}
+	first, second := s, t
+	if t.id < s.id {
+		first, second = t, s
+	}
+	for _, shard := range first.shards {
+		shard.lock()
+	}
+	for _, shard := range second.shards {
+		shard.lock()
+	}
+	for i, a := range s.shards {
+		b := t.shards[i]
+		da, db := a.takeLocked(), b.takeLocked()
+		a.putLocked(db)
+		b.putLocked(da)
+	}
+	for _, shard := range second.shards {
+		shard.unlock()
+	}
+	for _, shard := range first.shards {
+		shard.unlock()
+	}
+}
+
+// shardData is the keys of a shard, which a flush detaches and SWAPDB
+// moves between databases. The shard's counters stay where they are.
+type shardData struct {
+	index   index
+	entries []entry
+	freeIDs []uint32
+	arena   arena
+	keys    int
+	bytes   int64
+	expiry  []expiry
+	objects map[uint32]Object
+}
+
+func emptyShardData() shardData {
+	return shardData{
+		index:   newIndex(0),
+		entries: make([]entry, 1),
+		arena:   newArena(),
+		objects: make(map[uint32]Object),
+	}
+}
+
+func (shard *Shard) takeLocked() shardData {
+	return shardData{
+		index:   shard.index,
+		entries: shard.entries,
+		freeIDs: shard.freeIDs,
+		arena:   shard.arena,
+		keys:    shard.keys,
+		bytes:   shard.bytes,
+		expiry:  shard.expiry,
+		objects: shard.objects,
 	}
 }
 
-func (shard *Shard) findEntry(hash uint64, key string) (*entry, *entry) {
-	cur := shard.entries[hash]
-	var prev *entry
-	for cur != nil {
-		if cur.key == key {
-			return prev, cur
+func (shard *Shard) putLocked(d shardData) {
+	compactions := shard.arena.compactions
+	shard.index = d.index
+	shard.entries = d.entries
+	shard.freeIDs = d.freeIDs
+	shard.arena = d.arena
+	shard.arena.compactions = compactions
+	shard.keys = d.keys
+	shard.bytes = d.bytes
+	shard.expiry = d.expiry
+	shard.objects = d.objects
+	shard.syncNextExpire()
+	shard.compact.Store(shard.arena.needsCompaction())
+}
+
+// release drops detached data. Sorted sets and JSON documents are cleared
+// first, as they are what the GC has to walk; the index, entries and slabs
+// hold no pointers and go with the last reference.
+func (d *shardData) release() {
+	clear(d.objects)
+	*d = shardData{}
+}
+
+// Stats returns per-shard key counts and approximate memory usage.
+func (s Storage) Stats() []ShardStats {
+	stats := make([]ShardStats, len(s.shards))
+	now := time.Now().UnixNano()
+	for i, shard := range s.shards {
+		shard.mu.RLock()
+		stats[i] = ShardStats{
+			Keys:         shard.keys,
+			Expires:      len(shard.expiry),
+			Bytes:        shard.bytes,
+			Expired:      shard.expired,
+			ExpiredStale: shard.dueLocked(now),
+
+			ArenaBytes:  shard.arena.slabBytes,
+			ArenaFree:   shard.arena.freeBytes,
+			Compactions: shard.arena.compactions,
+
+			JanitorRuns:  shard.janitorRuns,
+			JanitorNanos: shard.janitorNanos,
+
+			LockWaits:     shard.lockWaits.Load(),
+			LockWaitNanos: shard.lockWaitNanos.Load(),
+			ReadFallbacks: shard.readFallbacks.Load(),
 		}
-		prev = cur
-		cur = cur.next
+		shard.mu.RUnlock()
+	}
+	return stats
+}
+
+// ResetStats zeroes the cumulative counters reported by Stats.
+func (s Storage) ResetStats() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.expired = 0
+		shard.arena.compactions = 0
+		shard.janitorRuns = 0
+		shard.janitorNanos = 0
+		shard.mu.Unlock()
+		shard.lockWaits.Store(0)
+		shard.lockWaitNanos.Store(0)
+		shard.readFallbacks.Store(0)
 	}
-	return nil, nil
+	s.cycles.cycles.Store(0)
+	s.cycles.fastCycles.Store(0)
+	s.cycles.timeCapped.Store(0)
+	s.cycles.nanos.Store(0)
 }
 
-func (shard *Shard) findEntryRead(hash uint64, key string) *entry {
-	cur := shard.entries[hash]
-	for cur != nil {
-		if cur.key == key {
-			return cur
+// SetLatencyHook installs fn to observe storage-internal latency events.
+func (s Storage) SetLatencyHook(fn LatencyHook) {
+	s.events.latency.Store(&fn)
+}
+
+// Close stops the background janitor and waits for a running cycle to
+// finish. The data stays readable.
+func (s Storage) Close() {
+	s.closed.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// compactLocked copies every live record into a fresh arena, tightly
+// packed and sized to its current length, and drops the old slabs with
+// their free lists.
+func (shard *Shard) compactLocked() {
+	old := shard.arena
+	fresh := newArena()
+	fresh.compactions = old.compactions + 1
+	for id := 1; id < len(shard.entries); id++ {
+		e := &shard.entries[id]
+		if e.loc == freeLoc {
+			continue
 		}
-		cur = cur.next
+		n := e.keyLen + e.valLen()
+		loc, capacity := fresh.alloc(n)
+		copy(fresh.bytes(loc, n), old.bytes(e.loc, n))
+		e.loc, e.capacity = loc, capacity
 	}
-	return nil
+	shard.arena = fresh
+	shard.compact.Store(false)
 }
 
-func getEntryFromPool(key, value string) *entry {
-	ent := entryPool.Get().(*entry)
-	ent.key = cloneString(key)
-	ent.value = cloneString(value)
-	ent.expireAt = 0
-	ent.next = nil
-	return ent
+// lock and rlock only read the clock when the fast TryLock path fails, so
+// uncontended acquisitions cost the same as a bare Lock. Writers must
+// release with unlock, which ends the seq write section lock begins.
+func (shard *Shard) lock() {
+	if !shard.mu.TryLock() {
+		start := time.Now()
+		shard.mu.Lock()
+		shard.recordLockWait(start)
+	}
+	shard.seq.Add(1)
 }
 
-func cloneString(s string) string {
-	b := make([]byte, len(s))
-	copy(b, s)
-	return unsafe.String(unsafe.SliceData(b), len(b))
+func (shard *Shard) unlock() {
+	shard.publish()
+	shard.seq.Add(1)
+	shard.mu.Unlock()
 }
 
-func deleteEntryLocked(shard *Shard, hash uint64, prev, ent *entry) {
-	if prev == nil {
-		if ent.next == nil {
-			delete(shard.entries, hash)
-		} else {
-			shard.entries[hash] = ent.next
+func (shard *Shard) rlock() {
+	if shard.mu.TryRLock() {
+		return
+	}
+	start := time.Now()
+	shard.mu.RLock()
+	shard.recordLockWait(start)
+}
+
+func (shard *Shard) recordLockWait(start time.Time) {
+	shard.lockWaits.Add(1)
+	shard.lockWaitNanos.Add(uint64(time.Since(start)))
+}
+
+// freeLoc marks an entry slot on the free id list.
+const freeLoc = ^uint64(0)
+
+// find returns the id of key, 0 if there is none.
+func (shard *Shard) find(hash uint64, key string) uint32 {
+	if id := shard.lookup(&shard.index.cur, hash, key); id != 0 {
+		return id
+	}
+	if shard.index.old.ctrl != nil {
+		return shard.lookup(&shard.index.old, hash, key)
+	}
+	return 0
+}
+
+func (shard *Shard) lookup(t *table, hash uint64, key string) uint32 {
+	for p := newProbe(hash, t.mask); p.i <= p.mask; p.next() {
+		ctrl := t.ctrl[p.group]
+		for m := matchByte(ctrl, h2(hash)); m != 0; m &= m - 1 {
+			id := t.slots[p.group*groupSlots+uint64(bits.TrailingZeros64(m)/8)]
+			e := &shard.entries[id]
+			if e.hash == hash && int(e.keyLen) == len(key) && string(shard.key(e)) == key {
+				return id
+			}
 		}
+		if matchEmpty(ctrl) != 0 {
+			return 0
+		}
+	}
+	return 0
+}
+
+func (shard *Shard) key(e *entry) []byte {
+	return shard.arena.bytes(e.loc, e.keyLen)
+}
+
+// stored returns the value bytes as kept in the record.
+func (shard *Shard) stored(e *entry) []byte {
+	return shard.arena.bytes(e.loc+uint64(e.keyLen), e.valLen())
+}
+
+// text returns the value as clients see it, formatting an integer.
+func (shard *Shard) text(e *entry) []byte {
+	if e.isInt() {
+		return intText(shard.stored(e))
+	}
+	return shard.stored(e)
+}
+
+// intValue parses the value as an int64 the way INCR accepts it.
+func (shard *Shard) intValue(e *entry) (int64, bool) {
+	if e.isInt() {
+		return intOf(shard.stored(e)), true
+	}
+	return parseInt(unsafeString(shard.stored(e)))
+}
+
+// intOf decodes an int-encoded value.
+func intOf(raw []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(raw))
+}
+
+// intText formats an int-encoded value into a buffer of its own, for the
+// paths that hand it on or store it; reads that only append it to a reply
+// go through appendValue instead.
+func intText(raw []byte) []byte {
+	return strconv.AppendInt(make([]byte, 0, 20), intOf(raw), 10)
+}
+
+// encodeValue returns what is stored for value and its valInfo. Integers in
+// canonical form, so GET returns exactly what was set, are kept as 8 raw
+// bytes in buf, as Redis does for values it can.
+func encodeValue(value string, buf *[8]byte) (string, uint32) {
+	n, ok := parseInt(value)
+	if !ok {
+		return value, uint32(len(value))
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(n))
+	return unsafeString(buf[:]), 8 | valInt
+}
+
+// parseInt accepts an int64 only in canonical form, like Redis'
+// string2ll: no sign other than '-', no leading zeros, no "-0".
+func parseInt(value string) (int64, bool) {
+	if len(value) == 0 || len(value) > 20 || value[0] != '-' && (value[0] < '0' || value[0] > '9') {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	var text [20]byte
+	return n, string(strconv.AppendInt(text[:0], n, 10)) == value
+}
+
+// findLive is find for writers: a key past its TTL is removed first.
+func (shard *Shard) findLive(hash uint64, key string, now int64) uint32 {
+	id := shard.find(hash, key)
+	if id != 0 && shard.entries[id].expireAt != 0 && shard.entries[id].expireAt <= now {
+		shard.removeLocked(id)
+		shard.expired++
+		return 0
+	}
+	return id
+}
+
+// setLocked inserts or overwrites key.
+func (shard *Shard) setLocked(hash uint64, key, value string, expireAt int64) {
+	if id := shard.find(hash, key); id != 0 {
+		shard.setValueLocked(id, value)
+		shard.setExpireLocked(id, expireAt)
+		return
+	}
+	shard.insertLocked(hash, key, value, expireAt)
+}
+
+func (shard *Shard) insertLocked(hash uint64, key, value string, expireAt int64) uint32 {
+	var buf [8]byte
+	stored, info := encodeValue(value, &buf)
+	return shard.insertStoredLocked(hash, key, stored, info, expireAt)
+}
+
+// insertStoredLocked adds key with value already encoded as info says.
+func (shard *Shard) insertStoredLocked(hash uint64, key, stored string, info uint32, expireAt int64) uint32 {
+	var id uint32
+	if n := len(shard.freeIDs); n > 0 {
+		id = shard.freeIDs[n-1]
+		shard.freeIDs = shard.freeIDs[:n-1]
 	} else {
-		prev.next = ent.next
+		shard.entries = append(shard.entries, entry{loc: freeLoc})
+		id = uint32(len(shard.entries) - 1)
 	}
-	ent.key = ""
-	ent.value = ""
-	ent.expireAt = 0
-	ent.next = nil
-	entryPool.Put(ent)
+	n := uint32(len(key) + len(stored))
+	loc, capacity := shard.arena.alloc(n)
+	rec := shard.arena.bytes(loc, n)
+	copy(rec, key)
+	copy(rec[len(key):], stored)
+	shard.entries[id] = entry{
+		hash:     hash,
+		loc:      loc,
+		keyLen:   uint32(len(key)),
+		valInfo:  info,
+		capacity: capacity,
+	}
+	shard.index.insert(hash, id, shard.entries)
+	shard.keys++
+	shard.bytes += int64(n) + entryOverhead
+	shard.setExpireLocked(id, expireAt)
+	return id
+}
+
+// setValueLocked replaces the value, in place if the record has room.
+func (shard *Shard) setValueLocked(id uint32, value string) {
+	var buf [8]byte
+	stored, info := encodeValue(value, &buf)
+	shard.storeLocked(id, stored, info)
+}
+
+func (shard *Shard) storeLocked(id uint32, stored string, info uint32) {
+	e := &shard.entries[id]
+	if e.isObject() {
+		shard.dropObjectLocked(id)
+	}
+	shard.bytes += int64(len(stored)) - int64(e.valLen())
+	n := e.keyLen + uint32(len(stored))
+	if n <= e.capacity {
+		copy(shard.arena.bytes(e.loc+uint64(e.keyLen), uint32(len(stored))), stored)
+		e.valInfo = info
+		return
+	}
+	loc, capacity := shard.arena.alloc(n)
+	rec := shard.arena.bytes(loc, n)
+	copy(rec, shard.key(e))
+	copy(rec[e.keyLen:], stored)
+	shard.releaseLocked(e.loc, e.capacity)
+	e.loc, e.capacity, e.valInfo = loc, capacity, info
+}
+
+func (shard *Shard) removeLocked(id uint32) {
+	e := &shard.entries[id]
+	shard.index.remove(e.hash, id, shard.entries)
+	if e.heapPos != 0 {
+		shard.heapRemove(int(e.heapPos) - 1)
+	}
+	if e.isObject() {
+		shard.dropObjectLocked(id)
+	}
+	shard.keys--
+	shard.bytes -= int64(e.keyLen+e.valLen()) + entryOverhead
+	shard.releaseLocked(e.loc, e.capacity)
+	*e = entry{loc: freeLoc}
+	shard.freeIDs = append(shard.freeIDs, id)
+}
+
+func (shard *Shard) releaseLocked(loc uint64, capacity uint32) {
+	shard.arena.release(loc, capacity)
+	if shard.arena.needsCompaction() && !shard.compact.Load() {
+		shard.compact.Store(true)
+	}
+}
+
+// unsafeString views b as a string without copying. The result must not
+// outlive the bytes, which for slab data means the shard lock.
+func unsafeString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
 }
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// fill stores n keys named prefix0, prefix1, ..., every third with a TTL
+// and every fifth a sorted set.
+func fill(s Storage, prefix string, n int) {
+	expireAt := time.Now().Add(time.Hour).UnixNano()
+	for i := range n {
+		key := prefix + strconv.Itoa(i)
+		hash := xxhash.Sum64String(key)
+		switch {
+		case i%5 == 0:
+			s.ZAddHashed(hash, key, []string{"m"}, []float64{1}, false, false, 0)
+		case i%3 == 0:
+			s.SetHashedWithExpireAt(hash, key, prefix, expireAt)
+		default:
+			s.SetHashed(hash, key, prefix)
+		}
+	}
+}
+
+// holds reports whether s has exactly the n keys fill stored with prefix.
+func holds(t *testing.T, s Storage, prefix string, n int) {
+	t.Helper()
+	if s.Len() != n {
+		t.Fatalf("%d keys, want %d of %s", s.Len(), n, prefix)
+	}
+	for i := range n {
+		key := prefix + strconv.Itoa(i)
+		hash := xxhash.Sum64String(key)
+		typ, ttl := "string", i%3 == 0
+		if i%5 == 0 {
+			typ, ttl = "zset", false
+		}
+		if got := s.TypeHashed(hash, key); got != typ {
+			t.Fatalf("TYPE %s = %s, want %s", key, got, typ)
+		}
+		if at, _ := s.ExpireAtHashed(hash, key); (at != 0) != ttl {
+			t.Fatalf("%s expires at %d, want a TTL: %v", key, at, ttl)
+		}
+	}
+}
+
+func TestSwapContents(t *testing.T) {
+	a, b := NewWithCapacity(0), NewWithCapacity(0)
+	defer a.Close()
+	defer b.Close()
+	fill(a, "a", 1000)
+	fill(b, "b", 10)
+
+	a.SwapContents(b)
+	holds(t, a, "b", 10)
+	holds(t, b, "a", 1000)
+
+	// Both keep working on the data they were given.
+	fill(a, "b", 2000)
+	holds(t, a, "b", 2000)
+	b.SwapContents(a)
+	holds(t, a, "a", 1000)
+	holds(t, b, "b", 2000)
+
+	a.SwapContents(a)
+	holds(t, a, "a", 1000)
+}
+
+func TestFlush(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		s := NewWithCapacity(0)
+		fill(s, "k", 1000)
+		if async {
+			s.FlushAsync()
+		} else {
+			s.Flush()
+		}
+		holds(t, s, "k", 0)
+		for _, sh := range s.Stats() {
+			if sh.Keys != 0 || sh.Bytes != 0 || sh.Expires != 0 {
+				t.Fatalf("async %v: shard left with %+v", async, sh)
+			}
+		}
+		fill(s, "k", 100)
+		holds(t, s, "k", 100)
+		s.Close()
+	}
+}
+
+// appendBulk appends a value the way the server's replies frame it.
+func appendBulk(dst, value []byte) []byte {
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(value)), 10)
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, value...)
+	return append(dst, "\r\n"...)
+}
+
+// TestAppendValue reads int-encoded and text values into a reply buffer,
+// lock-free and under a shard lock, and checks that once the buffer has
+// room neither allocates.
+func TestAppendValue(t *testing.T) {
+	s := NewWithCapacity(0)
+	defer s.Close()
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"12345", "$5\r\n12345\r\n"},
+		{"-9223372036854775808", "$20\r\n-9223372036854775808\r\n"},
+		{"0", "$1\r\n0\r\n"},
+		{"007", "$3\r\n007\r\n"},
+		{"text", "$4\r\ntext\r\n"},
+	}
+	for i, tt := range tests {
+		key := "k" + strconv.Itoa(i)
+		hash := xxhash.Sum64String(key)
+		s.SetHashed(hash, key, tt.value)
+		buf := make([]byte, 0, 64)
+		buf = append(buf, "+prev\r\n"...)
+		var l Locked
+		reads := []struct {
+			name string
+			read func() []byte
+		}{
+			{"AppendHashed", func() []byte {
+				out, _, _ := s.AppendHashed(buf, hash, key, appendBulk)
+				return out
+			}},
+			{"Locked.Append", func() []byte {
+				s.Lock(hash, &l)
+				out, _ := l.Append(buf, hash, key, appendBulk)
+				l.Unlock()
+				return out
+			}},
+		}
+		for _, r := range reads {
+			if got := string(r.read()); got != "+prev\r\n"+tt.want {
+				t.Errorf("%s of %q: %q, want %q", r.name, tt.value, got, tt.want)
+			}
+			if n := testing.AllocsPerRun(100, func() { r.read() }); n != 0 {
+				t.Errorf("%s of %q: %v allocations", r.name, tt.value, n)
+			}
+		}
+		if got, _ := s.GetHashed(hash, key); got != tt.value {
+			t.Errorf("GetHashed: %q, want %q", got, tt.value)
+		}
+	}
+}
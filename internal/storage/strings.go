@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// MaxValueLen is the longest value APPEND and SETRANGE may build, Redis'
+// default proto-max-bulk-len.
+const MaxValueLen = 512 << 20
+
+var errValueTooLarge = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// AppendValueHashed appends tail to the value at key, creating it if
+// missing, and returns the new length. A non-zero expireAt replaces the
+// key's TTL.
+func (s Storage) AppendValueHashed(hash uint64, key, tail string, expireAt int64) (int, error) {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id == 0 {
+		if len(tail) > MaxValueLen {
+			return 0, errValueTooLarge
+		}
+		shard.insertLocked(hash, key, tail, expireAt)
+		return len(tail), nil
+	}
+	if shard.entries[id].isObject() {
+		return 0, ErrWrongType
+	}
+	shard.textLocked(id)
+	old := int(shard.entries[id].valLen())
+	if old+len(tail) > MaxValueLen {
+		return 0, errValueTooLarge
+	}
+	copy(shard.growLocked(id, uint32(old+len(tail)))[old:], tail)
+	if expireAt != 0 {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return old + len(tail), nil
+}
+
+// SetRangeHashed overwrites the value at key from offset on, zero-padding
+// it as needed, and returns the new length. A missing key is only created
+// if value is not empty. A non-zero expireAt replaces the key's TTL.
+func (s Storage) SetRangeHashed(hash uint64, key string, offset int, value string, expireAt int64) (int, error) {
+	if offset+len(value) > MaxValueLen {
+		return 0, errValueTooLarge
+	}
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id == 0 {
+		if len(value) == 0 {
+			return 0, nil
+		}
+		id = shard.insertStoredLocked(hash, key, "", 0, 0)
+	} else if shard.entries[id].isObject() {
+		return 0, ErrWrongType
+	} else {
+		shard.textLocked(id)
+	}
+	n := int(shard.entries[id].valLen())
+	if len(value) == 0 {
+		return n, nil
+	}
+	buf := shard.stored(&shard.entries[id])
+	if end := offset + len(value); end > n {
+		buf = shard.growLocked(id, uint32(end))
+		n = end
+	}
+	copy(buf[offset:], value)
+	if expireAt != 0 {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return n, nil
+}
+
+// EditHashed calls fn with the value of key zero-padded to at least size
+// bytes, creating the key if missing, and fn changes it in place. A
+// non-zero expireAt replaces the key's TTL.
+func (s Storage) EditHashed(hash uint64, key string, size int, expireAt int64, fn func(value []byte)) error {
+	if size > MaxValueLen {
+		return errValueTooLarge
+	}
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id == 0 {
+		id = shard.insertStoredLocked(hash, key, "", 0, 0)
+	} else if shard.entries[id].isObject() {
+		return ErrWrongType
+	} else {
+		shard.textLocked(id)
+	}
+	value := shard.stored(&shard.entries[id])
+	if len(value) < size {
+		value = shard.growLocked(id, uint32(size))
+	}
+	fn(value)
+	if expireAt != 0 {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return nil
+}
+
+// UpdateHashed calls fn with the value of key, nil and false if missing,
+// under the shard lock. fn may edit the value in place and return it,
+// return a new value to store, or return nil to leave the key alone. A
+// written key gets expireAt if it is non-zero. It reports whether fn
+// wrote.
+func (s Storage) UpdateHashed(hash uint64, key string, expireAt int64, fn func(value []byte, exists bool) []byte) (bool, error) {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	var value []byte
+	if id != 0 {
+		if shard.entries[id].isObject() {
+			return false, ErrWrongType
+		}
+		value = shard.text(&shard.entries[id])
+	}
+	next := fn(value, id != 0)
+	switch {
+	case next == nil:
+		return false, nil
+	case id == 0:
+		id = shard.insertLocked(hash, key, unsafeString(next), 0)
+	case shard.entries[id].isInt() || len(next) != len(value) || unsafe.SliceData(next) != unsafe.SliceData(value):
+		shard.setValueLocked(id, unsafeString(next))
+	}
+	if expireAt != 0 {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return true, nil
+}
+
+// GetDelHashed removes key and returns its value.
+func (s Storage) GetDelHashed(hash uint64, key string) (string, bool, error) {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id == 0 {
+		return "", false, nil
+	}
+	if shard.entries[id].isObject() {
+		return "", false, ErrWrongType
+	}
+	value := string(shard.text(&shard.entries[id]))
+	shard.removeLocked(id)
+	return value, true, nil
+}
+
+// GetSetHashed stores value with expireAt, 0 for none, and returns the
+// value it replaced.
+func (s Storage) GetSetHashed(hash uint64, key, value string, expireAt int64) (string, bool, error) {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	if id == 0 {
+		shard.insertLocked(hash, key, value, expireAt)
+		return "", false, nil
+	}
+	if shard.entries[id].isObject() {
+		return "", false, ErrWrongType
+	}
+	old := string(shard.text(&shard.entries[id]))
+	shard.setValueLocked(id, value)
+	shard.setExpireLocked(id, expireAt)
+	return old, true, nil
+}
+
+// GetExHashed returns the value of key and sets its expiration to
+// expireAt, 0 to persist it. A key given an expiration in the past is
+// removed.
+func (s Storage) GetExHashed(hash uint64, key string, expireAt int64) (string, bool, error) {
+	shard := s.shardForHash(hash)
+	now := time.Now().UnixNano()
+	shard.lock()
+	defer shard.unlock()
+	id := shard.findLive(hash, key, now)
+	if id == 0 {
+		return "", false, nil
+	}
+	if shard.entries[id].isObject() {
+		return "", false, ErrWrongType
+	}
+	value := string(shard.text(&shard.entries[id]))
+	if expireAt != 0 && expireAt <= now {
+		shard.removeLocked(id)
+	} else {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return value, true, nil
+}
+
+// SetNXHashed stores value only if key does not exist and reports whether
+// it did.
+func (s Storage) SetNXHashed(hash uint64, key, value string, expireAt int64) bool {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+	if shard.findLive(hash, key, time.Now().UnixNano()) != 0 {
+		return false
+	}
+	shard.insertLocked(hash, key, value, expireAt)
+	return true
+}
+
+// ViewMultiHashed calls fn with the value of each key in order, ok false
+// for a missing one or an Object. The shards involved are read-locked
+// together, each once, so the values are one consistent snapshot.
+func (s Storage) ViewMultiHashed(hashes []uint64, keys []string, fn func(value []byte, ok bool)) {
+	mask := s.rlockAll(hashes)
+	defer s.runlockAll(mask)
+	now := time.Now().UnixNano()
+	for i, hash := range hashes {
+		shard := s.shardForHash(hash)
+		id := shard.find(hash, keys[i])
+		if id == 0 {
+			fn(nil, false)
+			continue
+		}
+		if e := &shard.entries[id]; (e.expireAt == 0 || e.expireAt > now) && !e.isObject() {
+			fn(shard.text(e), true)
+		} else {
+			fn(nil, false)
+		}
+	}
+}
+
+// rlockAll read-locks the shards owning hashes, each once and in shard
+// order, and returns their mask for runlockAll.
+func (s Storage) rlockAll(hashes []uint64) uint64 {
+	var mask uint64
+	for _, hash := range hashes {
+		mask |= 1 << (hash & shardMask)
+	}
+	for i, shard := range s.shards {
+		if mask&(1<<i) != 0 {
+			shard.rlock()
+		}
+	}
+	return mask
+}
+
+func (s Storage) runlockAll(mask uint64) {
+	for i, shard := range s.shards {
+		if mask&(1<<i) != 0 {
+			shard.mu.RUnlock()
+		}
+	}
+}
+
+// textLocked converts an int-encoded value to its text, for commands that
+// edit the bytes.
+func (shard *Shard) textLocked(id uint32) {
+	if e := &shard.entries[id]; e.isInt() {
+		text := intText(shard.stored(e))
+		shard.storeLocked(id, unsafeString(text), uint32(len(text)))
+	}
+}
+
+// growLocked lengthens a text value to n bytes, zero-filling the new ones,
+// and returns it. A record that must move gets spare room like Redis'
+// sds: double up to 1 MB, then 1 MB more, so repeated APPEND or SETRANGE
+// copy the value a logarithmic number of times rather than every call.
+func (shard *Shard) growLocked(id uint32, n uint32) []byte {
+	e := &shard.entries[id]
+	old := e.valLen()
+	shard.bytes += int64(n) - int64(old)
+	if need := e.keyLen + n; need > e.capacity {
+		room := need + min(need, 1<<20)
+		loc, capacity := shard.arena.alloc(room)
+		copy(shard.arena.bytes(loc, e.keyLen+old), shard.arena.bytes(e.loc, e.keyLen+old))
+		shard.releaseLocked(e.loc, e.capacity)
+		e.loc, e.capacity = loc, capacity
+	}
+	e.valInfo = n
+	value := shard.arena.bytes(e.loc+uint64(e.keyLen), n)
+	clear(value[old:])
+	return value
+}
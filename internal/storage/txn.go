@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"math/bits"
+	"time"
+)
+
+// ShardCount must fit the bit mask used by Atomically.
+var _ [64 - ShardCount]struct{}
+
+// Locked gives access to the shards locked by Atomically. Its methods must
+// only be called with hashes passed to Atomically and only inside fn.
+type Locked struct {
+	s    Storage
+	mask uint64
+	now  int64
+}
+
+// Atomically locks the shards owning hashes, in shard order, and runs fn
+// while holding all of them.
+func (s Storage) Atomically(hashes []uint64, fn func(l *Locked)) {
+	var mask uint64
+	for _, hash := range hashes {
+		mask |= 1 << (hash & shardMask)
+	}
+	for i, shard := range s.shards {
+		if mask&(1<<i) != 0 {
+			shard.lock()
+		}
+	}
+	defer func() {
+		for i, shard := range s.shards {
+			if mask&(1<<i) != 0 {
+				shard.unlock()
+			}
+		}
+	}()
+	fn(&Locked{s: s, mask: mask, now: time.Now().UnixNano()})
+}
+
+// Lock locks the one shard owning hash into l, for callers that lock a
+// shard at a time in a hot loop: unlike Atomically it allocates nothing.
+// l must be released with Unlock before it is locked again.
+func (s Storage) Lock(hash uint64, l *Locked) {
+	s.shards[hash&shardMask].lock()
+	*l = Locked{s: s, mask: 1 << (hash & shardMask), now: time.Now().UnixNano()}
+}
+
+// Unlock unlocks the shard locked by Lock.
+func (l *Locked) Unlock() {
+	l.s.shards[bits.TrailingZeros64(l.mask)].unlock()
+	*l = Locked{}
+}
+
+func (l *Locked) shard(hash uint64) *Shard {
+	if l.mask&(1<<(hash&shardMask)) == 0 {
+		panic("storage: shard not locked")
+	}
+	return l.s.shardForHash(hash)
+}
+
+// Now is the time, in Unix nanoseconds, expirations are checked against.
+func (l *Locked) Now() int64 {
+	return l.now
+}
+
+// Get returns a copy of the value and the expiration (0 for none) of a
+// live key holding a string.
+func (l *Locked) Get(hash uint64, key string) (value string, expireAt int64, ok bool) {
+	shard := l.shard(hash)
+	id := shard.find(hash, key)
+	if id == 0 {
+		return "", 0, false
+	}
+	e := &shard.entries[id]
+	if e.expireAt != 0 && e.expireAt <= l.now {
+		shard.removeLocked(id)
+		shard.expired++
+		return "", 0, false
+	}
+	if e.isObject() {
+		return "", 0, false
+	}
+	return string(shard.text(e)), e.expireAt, true
+}
+
+// Append returns fn(dst, value) for a live key, like Storage.AppendHashed
+// but called exactly once.
+func (l *Locked) Append(dst []byte, hash uint64, key string, fn func(dst, value []byte) []byte) ([]byte, bool) {
+	shard := l.shard(hash)
+	id := shard.find(hash, key)
+	if id == 0 {
+		return dst, false
+	}
+	e := &shard.entries[id]
+	if e.expireAt != 0 && e.expireAt <= l.now {
+		shard.removeLocked(id)
+		shard.expired++
+		return dst, false
+	}
+	if e.isObject() {
+		return dst, false
+	}
+	return appendValue(dst, shard.stored(e), e.isInt(), fn), true
+}
+
+// View calls fn with the value of a live string key. fn must not retain
+// it.
+func (l *Locked) View(hash uint64, key string, fn func(value []byte)) bool {
+	shard := l.shard(hash)
+	id := shard.find(hash, key)
+	if id == 0 {
+		return false
+	}
+	e := &shard.entries[id]
+	if e.expireAt != 0 && e.expireAt <= l.now || e.isObject() {
+		return false
+	}
+	fn(shard.text(e))
+	return true
+}
+
+// Exists reports whether key is live.
+func (l *Locked) Exists(hash uint64, key string) bool {
+	shard := l.shard(hash)
+	id := shard.find(hash, key)
+	if id == 0 {
+		return false
+	}
+	e := &shard.entries[id]
+	return e.expireAt == 0 || e.expireAt > l.now
+}
+
+// ExpireAt returns the expiration of a live key, 0 if it has none or is
+// missing.
+func (l *Locked) ExpireAt(hash uint64, key string) int64 {
+	shard := l.shard(hash)
+	id := shard.find(hash, key)
+	if id == 0 {
+		return 0
+	}
+	if e := &shard.entries[id]; e.expireAt > l.now {
+		return e.expireAt
+	}
+	return 0
+}
+
+// Set stores value with an absolute expiration in Unix nanoseconds, 0 for
+// none.
+func (l *Locked) Set(hash uint64, key, value string, expireAt int64) {
+	l.shard(hash).setLocked(hash, key, value, expireAt)
+}
+
+// Delete removes key and reports whether a live key was removed.
+func (l *Locked) Delete(hash uint64, key string) bool {
+	shard := l.shard(hash)
+	id := shard.find(hash, key)
+	if id == 0 {
+		return false
+	}
+	expireAt := shard.entries[id].expireAt
+	live := expireAt == 0 || expireAt > l.now
+	if !live {
+		shard.expired++
+	}
+	shard.removeLocked(id)
+	return live
+}
+
+// Scan returns about count live keys starting at cursor and the cursor to
+// continue from, 0 once every shard was visited. A key present for the
+// whole iteration is returned at least once, see index.scan; a resize in
+// between may repeat some. fn also gets the key's type as TYPE names it.
+//
+// The cursor's low bits are the shard, the rest the index cursor within
+// it. As in Redis, a call visits at most count*10 groups, so a sparse
+// shard returns few or no keys per call rather than walking it all.
+func (s Storage) Scan(cursor uint64, count int, fn func(key, typ string)) uint64 {
+	if count <= 0 {
+		count = 10
+	}
+	shardIdx := int(cursor & shardMask)
+	v := cursor / ShardCount
+	now := time.Now().UnixNano()
+	found := 0
+	visits := count * 10
+	for shardIdx < len(s.shards) && found < count && visits > 0 {
+		shard := s.shards[shardIdx]
+		shard.rlock()
+		for {
+			v = shard.index.scan(v, shard.entries, func(id uint32) {
+				if e := &shard.entries[id]; e.expireAt == 0 || e.expireAt > now {
+					fn(string(shard.key(e)), shard.typeName(id))
+					found++
+				}
+			})
+			visits--
+			if v == 0 || found >= count || visits == 0 {
+				break
+			}
+		}
+		shard.mu.RUnlock()
+		if v == 0 {
+			shardIdx++
+		}
+	}
+	if shardIdx == len(s.shards) {
+		return 0
+	}
+	return v*ShardCount + uint64(shardIdx)
+}
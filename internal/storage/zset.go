@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// zsetBlock is the most members a ZSet block holds before it splits.
+const zsetBlock = 128
+
+// zsetItemOverhead approximates the memory of a member besides its bytes:
+// its map slot and its item in a block.
+const zsetItemOverhead = 64
+
+// ZSet is a sorted set ordered by score, then member. Members are kept in
+// sorted blocks of at most zsetBlock items, so an insert moves at most one
+// block and a range read walks memory in order, like a B-tree with a
+// single level of leaves.
+type ZSet struct {
+	dict   map[string]float64
+	blocks [][]zitem
+	bytes  int64
+}
+
+type zitem struct {
+	score  float64
+	member string
+}
+
+func zless(a, b zitem) bool {
+	return a.score < b.score || a.score == b.score && a.member < b.member
+}
+
+func newZSet() *ZSet {
+	return &ZSet{dict: make(map[string]float64)}
+}
+
+func (z *ZSet) Type() string { return "zset" }
+func (z *ZSet) Size() int64  { return z.bytes }
+
+// Len returns the number of members.
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+// Score returns the score of member.
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// RangeByScore calls fn in order for the members with min <= score < max
+// until fn returns false.
+func (z *ZSet) RangeByScore(min, max float64, fn func(member string, score float64) bool) {
+	b := sort.Search(len(z.blocks), func(i int) bool {
+		blk := z.blocks[i]
+		return blk[len(blk)-1].score >= min
+	})
+	for first := true; b < len(z.blocks); b, first = b+1, false {
+		blk := z.blocks[b]
+		i := 0
+		if first {
+			i = sort.Search(len(blk), func(i int) bool { return blk[i].score >= min })
+		}
+		for ; i < len(blk); i++ {
+			if blk[i].score >= max || !fn(blk[i].member, blk[i].score) {
+				return
+			}
+		}
+	}
+}
+
+// set adds member with score or moves it to score, and reports whether it
+// was added.
+func (z *ZSet) set(member string, score float64) bool {
+	old, exists := z.dict[member]
+	switch {
+	case !exists:
+		member = strings.Clone(member)
+		z.bytes += int64(len(member)) + zsetItemOverhead
+	case old == score:
+		return false
+	default:
+		z.remove(zitem{old, member})
+	}
+	z.dict[member] = score
+	z.insert(zitem{score, member})
+	return !exists
+}
+
+// block returns the index of the block it belongs in: the first whose last
+// item is not less than it, or the last block.
+func (z *ZSet) block(it zitem) int {
+	return sort.Search(len(z.blocks)-1, func(i int) bool {
+		blk := z.blocks[i]
+		return !zless(blk[len(blk)-1], it)
+	})
+}
+
+func (z *ZSet) insert(it zitem) {
+	if len(z.blocks) == 0 {
+		z.blocks = append(z.blocks, make([]zitem, 0, zsetBlock+1))
+	}
+	b := z.block(it)
+	blk := z.blocks[b]
+	i := sort.Search(len(blk), func(i int) bool { return !zless(blk[i], it) })
+	blk = slices.Insert(blk, i, it)
+	if len(blk) > zsetBlock {
+		half := len(blk) / 2
+		tail := append(make([]zitem, 0, zsetBlock+1), blk[half:]...)
+		clear(blk[half:])
+		blk = blk[:half]
+		z.blocks = slices.Insert(z.blocks, b+1, tail)
+	}
+	z.blocks[b] = blk
+}
+
+// remove deletes it, which must be present, and merges a block left small
+// into the next one.
+func (z *ZSet) remove(it zitem) {
+	b := z.block(it)
+	blk := z.blocks[b]
+	i := sort.Search(len(blk), func(i int) bool { return !zless(blk[i], it) })
+	blk = slices.Delete(blk, i, i+1)
+	if b+1 < len(z.blocks) && len(blk)+len(z.blocks[b+1]) <= zsetBlock/2 {
+		blk = append(blk, z.blocks[b+1]...)
+		z.blocks = slices.Delete(z.blocks, b+1, b+2)
+	}
+	if len(blk) == 0 {
+		z.blocks = slices.Delete(z.blocks, b, b+1)
+		return
+	}
+	z.blocks[b] = blk
+}
+
+// ZAddHashed sets the scores of members in the sorted set at key, creating
+// it if missing. With nx only new members are added, with xx only existing
+// ones are updated. It returns how many members were added and how many
+// existing ones changed score. A non-zero expireAt replaces the key's TTL
+// if it was written.
+func (s Storage) ZAddHashed(hash uint64, key string, members []string, scores []float64, nx, xx bool, expireAt int64) (added, changed int, err error) {
+	shard := s.shardForHash(hash)
+	shard.lock()
+	defer shard.unlock()
+
+	id := shard.findLive(hash, key, time.Now().UnixNano())
+	var z *ZSet
+	switch {
+	case id != 0:
+		var ok bool
+		if z, ok = shard.objects[id].(*ZSet); !ok {
+			return 0, 0, ErrWrongType
+		}
+	case xx:
+		return 0, 0, nil
+	default:
+		z = newZSet()
+		id = shard.insertObjectLocked(hash, key, z, 0)
+	}
+	before := z.bytes
+	for i, member := range members {
+		old, exists := z.dict[member]
+		if exists && nx || !exists && xx {
+			continue
+		}
+		if z.set(member, scores[i]) {
+			added++
+		} else if old != scores[i] {
+			changed++
+		}
+	}
+	shard.bytes += z.bytes - before
+	if expireAt != 0 && added+changed > 0 {
+		shard.setExpireLocked(id, expireAt)
+	}
+	return added, changed, nil
+}
+
+// ZViewHashed calls fn with the sorted set at key under the shard read
+// lock. fn must not retain it or call into the Storage. It reports whether
+// the key exists and fails with ErrWrongType if it holds something else.
+func (s Storage) ZViewHashed(hash uint64, key string, fn func(z *ZSet)) (bool, error) {
+	shard := s.shardForHash(hash)
+	now := time.Now().UnixNano()
+	shard.rlock()
+	defer shard.mu.RUnlock()
+	return shard.zview(hash, key, now, fn)
+}
+
+func (shard *Shard) zview(hash uint64, key string, now int64, fn func(z *ZSet)) (bool, error) {
+	obj, ok, err := shard.object(hash, key, now)
+	if !ok || err != nil {
+		return ok, err
+	}
+	z, ok := obj.(*ZSet)
+	if !ok {
+		return false, ErrWrongType
+	}
+	fn(z)
+	return true, nil
+}
+
+// ZView is Storage.ZViewHashed for a locked shard.
+func (l *Locked) ZView(hash uint64, key string, fn func(z *ZSet)) (bool, error) {
+	return l.shard(hash).zview(hash, key, l.now, fn)
+}
+
+// ZStore replaces key with a sorted set of members and scores, or removes
+// it if members is empty. expireAt is as for Set.
+func (l *Locked) ZStore(hash uint64, key string, members []string, scores []float64, expireAt int64) {
+	l.Delete(hash, key)
+	if len(members) == 0 {
+		return
+	}
+	z := newZSet()
+	for i, member := range members {
+		z.set(member, scores[i])
+	}
+	l.shard(hash).insertObjectLocked(hash, key, z, expireAt)
+}
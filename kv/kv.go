@@ -0,0 +1,344 @@
+// Package kv embeds the store in a Go program. A DB can optionally also be
+// served over RESP, so in-process code and remote clients share the same
+// data.
+package kv
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/glob"
+	"github.com/VoolFI71/go-kv-store/internal/server"
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+var (
+	ErrNotFound   = errors.New("kv: key not found")
+	ErrKeyExists  = errors.New("kv: key already exists")
+	ErrNotInteger = errors.New("kv: value is not an integer")
+	ErrOverflow   = errors.New("kv: increment would overflow")
+	ErrWrongType  = errors.New("kv: key holds a value of another type")
+	ErrConflict   = errors.New("kv: transaction conflict, retries exhausted")
+	ErrReadOnly   = errors.New("kv: write in a read-only transaction")
+	ErrClosed     = errors.New("kv: database closed")
+)
+
+// maxTxAttempts bounds how often Update re-runs a conflicting transaction.
+const maxTxAttempts = 16
+
+// Option configures Open.
+type Option func(*options)
+
+type options struct {
+	capacity   int
+	serveAddr  string
+	serveCfg   map[string]string
+	serveReady chan error
+}
+
+// WithCapacity preallocates room for about n keys.
+func WithCapacity(n int) Option {
+	return func(o *options) { o.capacity = n }
+}
+
+// WithRESP also serves the DB over RESP on addr, e.g.
+// "tcp://127.0.0.1:6379", as database 0 of an embedded server. config
+// sets server parameters by name, as in CONFIG SET; keys written over RESP
+// get no default TTL unless config sets "ttl".
+func WithRESP(addr string, config map[string]string) Option {
+	return func(o *options) {
+		o.serveAddr = addr
+		o.serveCfg = config
+	}
+}
+
+// DB is an in-memory key-value store safe for concurrent use.
+type DB struct {
+	st storage.Storage
+
+	mu     sync.RWMutex
+	closed bool
+	stop   context.CancelFunc
+	served chan error
+}
+
+// Open creates a DB and, with WithRESP, starts serving it. It returns once
+// the listener is up.
+func Open(opts ...Option) (*DB, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	db := &DB{st: storage.NewWithCapacity(o.capacity)}
+	if o.serveAddr == "" {
+		return db, nil
+	}
+
+	cfg := map[string]string{"ttl": "0"}
+	for k, v := range o.serveCfg {
+		cfg[k] = v
+	}
+	cfg["addr"] = o.serveAddr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	db.stop = cancel
+	db.served = make(chan error, 1)
+	go func() {
+		db.served <- server.Serve(ctx, db.st, cfg, func() { close(ready) })
+	}()
+	select {
+	case <-ready:
+		return db, nil
+	case err := <-db.served:
+		cancel()
+		db.st.Close()
+		if err == nil {
+			err = errors.New("kv: server stopped during startup")
+		}
+		return nil, err
+	}
+}
+
+// Close stops serving, if enabled, and releases background resources.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	var err error
+	if db.stop != nil {
+		db.stop()
+		err = <-db.served
+	}
+	db.st.Close()
+	return err
+}
+
+func (db *DB) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Get returns the value of key or ErrNotFound.
+func (db *DB) Get(ctx context.Context, key string) (string, error) {
+	if err := db.check(ctx); err != nil {
+		return "", err
+	}
+	value, ok := db.st.GetHashed(xxhash.Sum64String(key), key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// SetOption modifies Set.
+type SetOption func(*setOptions)
+
+type setOptions struct {
+	ttl     time.Duration
+	keepTTL bool
+	nx      bool
+	xx      bool
+}
+
+// WithTTL expires the key after d.
+func WithTTL(d time.Duration) SetOption {
+	return func(o *setOptions) { o.ttl = d }
+}
+
+// KeepTTL keeps the expiration of an existing key.
+func KeepTTL() SetOption {
+	return func(o *setOptions) { o.keepTTL = true }
+}
+
+// IfNotExists only sets a missing key, otherwise Set returns ErrKeyExists.
+func IfNotExists() SetOption {
+	return func(o *setOptions) { o.nx = true }
+}
+
+// IfExists only overwrites an existing key, otherwise Set returns
+// ErrNotFound.
+func IfExists() SetOption {
+	return func(o *setOptions) { o.xx = true }
+}
+
+func applySetOptions(opts []SetOption) setOptions {
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Set stores value under key.
+func (db *DB) Set(ctx context.Context, key, value string, opts ...SetOption) error {
+	if err := db.check(ctx); err != nil {
+		return err
+	}
+	o := applySetOptions(opts)
+	hash := xxhash.Sum64String(key)
+	var err error
+	db.st.Atomically([]uint64{hash}, func(l *storage.Locked) {
+		if err = o.check(l.Exists(hash, key)); err != nil {
+			return
+		}
+		l.Set(hash, key, value, o.expireAt(l.Now(), l.ExpireAt(hash, key)))
+	})
+	return err
+}
+
+func (o setOptions) check(exists bool) error {
+	if o.nx && exists {
+		return ErrKeyExists
+	}
+	if o.xx && !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (o setOptions) expireAt(now, current int64) int64 {
+	switch {
+	case o.keepTTL:
+		return current
+	case o.ttl > 0:
+		return now + int64(o.ttl)
+	}
+	return 0
+}
+
+// Incr adds delta to the integer stored at key, treating a missing key as
+// 0, and returns the new value. The key's TTL is kept.
+func (db *DB) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := db.check(ctx); err != nil {
+		return 0, err
+	}
+	n, err := db.st.IncrByHashed(xxhash.Sum64String(key), key, delta, 0)
+	switch {
+	case err == nil:
+		return n, nil
+	case errors.Is(err, storage.ErrWrongType):
+		return 0, ErrWrongType
+	case errors.Is(err, storage.ErrOverflow):
+		return 0, ErrOverflow
+	}
+	return 0, ErrNotInteger
+}
+
+func incr(value string, exists bool, delta int64) (int64, error) {
+	var n int64
+	if exists {
+		var err error
+		// Like the server, only canonical text counts: no "+1" or "007".
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil || strconv.FormatInt(n, 10) != value {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	return n + delta, nil
+}
+
+// Expire sets the TTL of key; a TTL <= 0 deletes it. It reports whether
+// the key existed.
+func (db *DB) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if err := db.check(ctx); err != nil {
+		return false, err
+	}
+	hash := xxhash.Sum64String(key)
+	var exists bool
+	db.st.Atomically([]uint64{hash}, func(l *storage.Locked) {
+		var value string
+		value, _, exists = l.Get(hash, key)
+		switch {
+		case !exists:
+		case ttl <= 0:
+			l.Delete(hash, key)
+		default:
+			l.Set(hash, key, value, l.Now()+int64(ttl))
+		}
+	})
+	return exists, nil
+}
+
+// TTL returns the remaining time to live of key, 0 if it has none, or
+// ErrNotFound.
+func (db *DB) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := db.check(ctx); err != nil {
+		return 0, err
+	}
+	hash := xxhash.Sum64String(key)
+	var ttl time.Duration
+	err := ErrNotFound
+	db.st.Atomically([]uint64{hash}, func(l *storage.Locked) {
+		if _, expireAt, ok := l.Get(hash, key); ok {
+			err = nil
+			if expireAt != 0 {
+				ttl = time.Duration(expireAt - l.Now())
+			}
+		}
+	})
+	return ttl, err
+}
+
+// Delete removes keys and returns how many existed.
+func (db *DB) Delete(ctx context.Context, keys ...string) (int, error) {
+	if err := db.check(ctx); err != nil {
+		return 0, err
+	}
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxhash.Sum64String(key)
+	}
+	n := 0
+	db.st.Atomically(hashes, func(l *storage.Locked) {
+		for i, key := range keys {
+			if l.Delete(hashes[i], key) {
+				n++
+			}
+		}
+	})
+	return n, nil
+}
+
+// Scan iterates keys matching a Redis glob pattern ("" matches all) in
+// batches of about count. Start with cursor 0 and continue with the
+// returned cursor until it is 0. Keys present for the whole iteration are
+// returned at least once; keys added or removed meanwhile may or may not
+// be.
+func (db *DB) Scan(ctx context.Context, cursor uint64, match string, count int) ([]string, uint64, error) {
+	if err := db.check(ctx); err != nil {
+		return nil, 0, err
+	}
+	var keys []string
+	next := db.st.Scan(cursor, count, func(key, _ string) {
+		if match == "" || glob.Match(match, key) {
+			keys = append(keys, key)
+		}
+	})
+	return keys, next, nil
+}
+
+// Len returns the number of keys, counting expired ones not yet removed.
+func (db *DB) Len() int {
+	return db.st.Len()
+}
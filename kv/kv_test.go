@@ -0,0 +1,216 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/client"
+	"github.com/cespare/xxhash/v2"
+)
+
+// openTest returns a DB holding the string "s" = "abc", the integer
+// "n" = 10, the non-canonical "padded" = "007" and the sorted set "z".
+func openTest(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	ctx := context.Background()
+	if err := db.Set(ctx, "s", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, "n", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, "padded", "007"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.st.ZAddHashed(xxhash.Sum64String("z"), "z", []string{"m"}, []float64{1}, false, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestIncr(t *testing.T) {
+	tests := []struct {
+		key   string
+		delta int64
+		want  int64
+		err   error
+	}{
+		{"n", 5, 15, nil},
+		{"missing", -3, -3, nil},
+		{"s", 1, 0, ErrNotInteger},
+		{"padded", 1, 0, ErrNotInteger},
+		{"z", 1, 0, ErrWrongType},
+		{"n", math.MaxInt64, 0, ErrOverflow},
+	}
+	for _, tt := range tests {
+		db := openTest(t)
+		got, err := db.Incr(context.Background(), tt.key, tt.delta)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Incr(%q, %d) = %d, %v, want %d, %v", tt.key, tt.delta, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestSetConditions(t *testing.T) {
+	tests := []struct {
+		key string
+		opt SetOption
+		err error
+	}{
+		{"missing", IfNotExists(), nil},
+		{"s", IfNotExists(), ErrKeyExists},
+		{"z", IfNotExists(), ErrKeyExists},
+		{"missing", IfExists(), ErrNotFound},
+		{"s", IfExists(), nil},
+		{"z", IfExists(), nil},
+	}
+	for _, tt := range tests {
+		db := openTest(t)
+		ctx := context.Background()
+		if err := db.Set(ctx, tt.key, "v", tt.opt); !errors.Is(err, tt.err) {
+			t.Errorf("Set(%q) = %v, want %v", tt.key, err, tt.err)
+		}
+		if tt.err == nil {
+			if got, err := db.Get(ctx, tt.key); got != "v" || err != nil {
+				t.Errorf("Get(%q) after Set = %q, %v", tt.key, got, err)
+			}
+		}
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		// interfere is how many attempts see the key changed behind them.
+		interfere int
+		attempts  int
+		err       error
+	}{
+		{"none", 0, 1, nil},
+		{"once", 1, 2, nil},
+		{"always", maxTxAttempts, maxTxAttempts, ErrConflict},
+	}
+	for _, tt := range tests {
+		db := openTest(t)
+		ctx := context.Background()
+		attempts := 0
+		err := db.Update(ctx, func(tx *Tx) error {
+			attempts++
+			n, err := tx.Incr("n", 1)
+			if err != nil {
+				return err
+			}
+			if attempts <= tt.interfere {
+				if err := db.Set(ctx, "n", strconv.Itoa(100+attempts)); err != nil {
+					return err
+				}
+			}
+			return tx.Set("copy", strconv.FormatInt(n, 10))
+		})
+		if attempts != tt.attempts || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %d attempts, %v, want %d, %v", tt.name, attempts, err, tt.attempts, tt.err)
+			continue
+		}
+		// The last write wins: the interfering one or the committed Incr.
+		want := strconv.Itoa(100 + tt.interfere + 1)
+		switch {
+		case tt.interfere == 0:
+			want = "11"
+		case tt.err != nil:
+			want = strconv.Itoa(100 + tt.interfere)
+		}
+		if got, _ := db.Get(ctx, "n"); got != want {
+			t.Errorf("%s: n = %q, want %q", tt.name, got, want)
+		}
+		copied, err := db.Get(ctx, "copy")
+		if tt.err == nil && copied != want || tt.err != nil && !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: copy = %q, %v", tt.name, copied, err)
+		}
+	}
+}
+
+func TestTxTypes(t *testing.T) {
+	db := openTest(t)
+	ctx := context.Background()
+	err := db.Update(ctx, func(tx *Tx) error {
+		if _, err := tx.Get("z"); !errors.Is(err, ErrWrongType) {
+			t.Errorf("Get(z) = %v, want ErrWrongType", err)
+		}
+		if _, err := tx.Incr("z", 1); !errors.Is(err, ErrWrongType) {
+			t.Errorf("Incr(z) = %v, want ErrWrongType", err)
+		}
+		if err := tx.Set("z", "v", IfNotExists()); !errors.Is(err, ErrKeyExists) {
+			t.Errorf("Set(z, IfNotExists) = %v, want ErrKeyExists", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(ctx, func(tx *Tx) error { return tx.Set("s", "v") })
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Set in View = %v, want ErrReadOnly", err)
+	}
+}
+
+// TestRESPShared checks that keys written through the DB are seen, with
+// their TTLs, by RESP clients of the same instance, and the other way
+// round for deletes.
+func TestRESPShared(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	db, err := Open(WithRESP("tcp://"+addr, map[string]string{"event-loops": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	c := client.New(client.Options{Addr: addr})
+	t.Cleanup(func() { c.Close() })
+
+	ctx := context.Background()
+	if err := db.Set(ctx, "a", "1", WithTTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		want any
+	}{
+		{[]string{"TTL", "a"}, int64(60)},
+		{[]string{"PTTL", "b"}, int64(-1)},
+		{[]string{"PTTL", "nope"}, int64(-2)},
+		{[]string{"EXISTS", "a", "b", "nope", "a"}, int64(3)},
+		{[]string{"DEL", "a", "nope"}, int64(1)},
+		{[]string{"EXISTS", "a"}, int64(0)},
+	}
+	for _, tt := range tests {
+		if got, err := c.Do(ctx, tt.args...); err != nil || got != tt.want {
+			t.Errorf("%v: %v, %v, want %v", tt.args, got, err, tt.want)
+		}
+	}
+	if _, err := db.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after RESP DEL: %v", err)
+	}
+	if n, err := db.Delete(ctx, "b"); n != 1 || err != nil {
+		t.Fatalf("Delete: %d, %v", n, err)
+	}
+	if got, err := c.Do(ctx, "EXISTS", "b"); got != int64(0) || err != nil {
+		t.Errorf("EXISTS after Delete: %v, %v", got, err)
+	}
+}
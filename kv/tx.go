@@ -0,0 +1,208 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/VoolFI71/go-kv-store/internal/storage"
+	"github.com/cespare/xxhash/v2"
+)
+
+// Tx is an optimistic transaction: reads go to the DB and are remembered,
+// writes are buffered. On commit every touched shard is locked, the reads
+// are checked against the current values and the writes applied together.
+// A Tx must not be used outside the function it was passed to.
+type Tx struct {
+	db       *DB
+	ctx      context.Context
+	readOnly bool
+	reads    map[string]txRead
+	writes   map[string]txWrite
+	order    []string
+}
+
+type txRead struct {
+	value  string
+	exists bool
+}
+
+type txWrite struct {
+	value string
+	// ttl is applied relative to the commit time.
+	ttl     time.Duration
+	keepTTL bool
+	deleted bool
+}
+
+// Update runs fn in a read-write transaction and commits it. If another
+// writer changed a key fn read, fn runs again, up to a bounded number of
+// attempts after which ErrConflict is returned. An error from fn aborts
+// the transaction.
+func (db *DB) Update(ctx context.Context, fn func(tx *Tx) error) error {
+	return db.run(ctx, false, fn)
+}
+
+// View runs fn in a read-only transaction whose reads are consistent with
+// each other.
+func (db *DB) View(ctx context.Context, fn func(tx *Tx) error) error {
+	return db.run(ctx, true, fn)
+}
+
+func (db *DB) run(ctx context.Context, readOnly bool, fn func(tx *Tx) error) error {
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		if err := db.check(ctx); err != nil {
+			return err
+		}
+		tx := &Tx{
+			db:       db,
+			ctx:      ctx,
+			readOnly: readOnly,
+			reads:    make(map[string]txRead),
+			writes:   make(map[string]txWrite),
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if tx.commit() {
+			return nil
+		}
+	}
+	return ErrConflict
+}
+
+func (tx *Tx) commit() bool {
+	if len(tx.reads) == 0 && len(tx.writes) == 0 {
+		return true
+	}
+	hashes := make([]uint64, 0, len(tx.reads)+len(tx.writes))
+	for key := range tx.reads {
+		hashes = append(hashes, xxhash.Sum64String(key))
+	}
+	for _, key := range tx.order {
+		hashes = append(hashes, xxhash.Sum64String(key))
+	}
+	ok := true
+	tx.db.st.Atomically(hashes, func(l *storage.Locked) {
+		for key, r := range tx.reads {
+			hash := xxhash.Sum64String(key)
+			value, _, _ := l.Get(hash, key)
+			if l.Exists(hash, key) != r.exists || value != r.value {
+				ok = false
+				return
+			}
+		}
+		for _, key := range tx.order {
+			hash := xxhash.Sum64String(key)
+			w := tx.writes[key]
+			if w.deleted {
+				l.Delete(hash, key)
+				continue
+			}
+			var expireAt int64
+			switch {
+			case w.keepTTL:
+				expireAt = l.ExpireAt(hash, key)
+			case w.ttl > 0:
+				expireAt = l.Now() + int64(w.ttl)
+			}
+			l.Set(hash, key, w.value, expireAt)
+		}
+	})
+	return ok
+}
+
+// get returns the transaction's view of key, ErrWrongType if it holds
+// something other than a string.
+func (tx *Tx) get(key string) (string, bool, error) {
+	if err := tx.ctx.Err(); err != nil {
+		return "", false, err
+	}
+	if w, ok := tx.writes[key]; ok {
+		return w.value, !w.deleted, nil
+	}
+	if r, ok := tx.reads[key]; ok {
+		return r.value, r.exists, nil
+	}
+	hash := xxhash.Sum64String(key)
+	value, ok := tx.db.st.GetHashed(hash, key)
+	if !ok {
+		if typ := tx.db.st.TypeHashed(hash, key); typ != "none" && typ != "string" {
+			return "", false, ErrWrongType
+		}
+	}
+	tx.reads[key] = txRead{value: value, exists: ok}
+	return value, ok, nil
+}
+
+func (tx *Tx) put(key string, w txWrite) error {
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+	if err := tx.ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+	return nil
+}
+
+// Get returns the value of key as seen by the transaction.
+func (tx *Tx) Get(key string) (string, error) {
+	value, ok, err := tx.get(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Set buffers a write of key. IfNotExists and IfExists are evaluated
+// against the transaction's view and make the key part of its read set.
+func (tx *Tx) Set(key, value string, opts ...SetOption) error {
+	o := applySetOptions(opts)
+	if o.nx || o.xx {
+		_, exists, err := tx.get(key)
+		if errors.Is(err, ErrWrongType) {
+			exists, err = true, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := o.check(exists); err != nil {
+			return err
+		}
+	}
+	return tx.put(key, txWrite{value: value, ttl: o.ttl, keepTTL: o.keepTTL})
+}
+
+// Incr adds delta to the integer at key and returns the new value.
+func (tx *Tx) Incr(key string, delta int64) (int64, error) {
+	value, exists, err := tx.get(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := incr(value, exists, delta)
+	if err != nil {
+		return 0, err
+	}
+	// Keep the TTL of an earlier write in this transaction, or else the
+	// stored one.
+	w, ok := tx.writes[key]
+	if !ok {
+		w = txWrite{keepTTL: true}
+	}
+	w.value = strconv.FormatInt(n, 10)
+	w.deleted = false
+	return n, tx.put(key, w)
+}
+
+// Delete buffers the removal of key.
+func (tx *Tx) Delete(key string) error {
+	return tx.put(key, txWrite{deleted: true})
+}